@@ -0,0 +1,143 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi
+
+import (
+	"errors"
+)
+
+// Sentinel errors, one per error code (ERR_*). The errors returned by the
+// io, function and entropy packages carry a code and match the sentinel
+// error of the code with errors.Is. EG:
+//   if errors.Is(err, kanzi.ErrCRCCheck) { ... }
+// The underlying error (if any) is available with errors.Unwrap and also
+// matches errors.Is/As (EG. io.ErrUnexpectedEOF for a truncated input).
+var (
+	ErrMissingParam       = errors.New("Missing parameter")
+	ErrBlockSize          = errors.New("Invalid block size")
+	ErrInvalidCodec       = errors.New("Invalid or unsupported codec")
+	ErrCreateCompressor   = errors.New("Cannot create compressor")
+	ErrCreateDecompressor = errors.New("Cannot create decompressor")
+	ErrOutputIsDir        = errors.New("Output is a directory")
+	ErrOverwriteFile      = errors.New("Cannot overwrite file")
+	ErrCreateFile         = errors.New("Cannot create file")
+	ErrCreateBitstream    = errors.New("Cannot create bitstream")
+	ErrOpenFile           = errors.New("Cannot open file")
+	ErrReadFile           = errors.New("Cannot read input")
+	ErrWriteFile          = errors.New("Cannot write output")
+	ErrProcessBlock       = errors.New("Cannot process block")
+	ErrCreateCodec        = errors.New("Cannot create codec")
+	ErrInvalidFile        = errors.New("Invalid or corrupted bitstream")
+	ErrStreamVersion      = errors.New("Unsupported bitstream version")
+	ErrCreateStream       = errors.New("Cannot create stream")
+	ErrInvalidParam       = errors.New("Invalid parameter")
+	ErrCRCCheck           = errors.New("Checksum mismatch")
+	ErrCancelled          = errors.New("Operation cancelled")
+	ErrResourceLimit      = errors.New("Resource limit exceeded")
+	ErrBufferTooSmall     = errors.New("Buffer too small")
+	ErrDictionary         = errors.New("Missing or invalid dictionary")
+	ErrAuthentication     = errors.New("Authentication failed")
+	ErrUnknown            = errors.New("Unknown error")
+)
+
+var codeErrors = map[int]error{
+	ERR_MISSING_PARAM:       ErrMissingParam,
+	ERR_BLOCK_SIZE:          ErrBlockSize,
+	ERR_INVALID_CODEC:       ErrInvalidCodec,
+	ERR_CREATE_COMPRESSOR:   ErrCreateCompressor,
+	ERR_CREATE_DECOMPRESSOR: ErrCreateDecompressor,
+	ERR_OUTPUT_IS_DIR:       ErrOutputIsDir,
+	ERR_OVERWRITE_FILE:      ErrOverwriteFile,
+	ERR_CREATE_FILE:         ErrCreateFile,
+	ERR_CREATE_BITSTREAM:    ErrCreateBitstream,
+	ERR_OPEN_FILE:           ErrOpenFile,
+	ERR_READ_FILE:           ErrReadFile,
+	ERR_WRITE_FILE:          ErrWriteFile,
+	ERR_PROCESS_BLOCK:       ErrProcessBlock,
+	ERR_CREATE_CODEC:        ErrCreateCodec,
+	ERR_INVALID_FILE:        ErrInvalidFile,
+	ERR_STREAM_VERSION:      ErrStreamVersion,
+	ERR_CREATE_STREAM:       ErrCreateStream,
+	ERR_INVALID_PARAM:       ErrInvalidParam,
+	ERR_CRC_CHECK:           ErrCRCCheck,
+	ERR_CANCELLED:           ErrCancelled,
+	ERR_RESOURCE_LIMIT:      ErrResourceLimit,
+	ERR_BUFFER_TOO_SMALL:    ErrBufferTooSmall,
+	ERR_DICTIONARY:          ErrDictionary,
+	ERR_AUTHENTICATION:      ErrAuthentication,
+	ERR_UNKNOWN:             ErrUnknown,
+}
+
+// Return the sentinel error of an error code (ErrUnknown if the code is unknown)
+func CodeError(code int) error {
+	if err, prst := codeErrors[code]; prst == true {
+		return err
+	}
+
+	return ErrUnknown
+}
+
+// Return the code of the first error of the chain with a code (see
+// errors.As) or ERR_UNKNOWN. Return 0 if err is nil.
+func ErrorCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var coded interface{ ErrorCode() int }
+
+	if errors.As(err, &coded) == true {
+		return coded.ErrorCode()
+	}
+
+	return ERR_UNKNOWN
+}
+
+// An error with a message, a code (ERR_*) and an optional cause. Returned by
+// the function and entropy packages (the io package returns IOError).
+type Error struct {
+	msg   string
+	code  int
+	cause error
+}
+
+func NewError(msg string, code int) *Error {
+	return &Error{msg: msg, code: code}
+}
+
+// Create an error caused by err (see errors.Unwrap)
+func WrapError(err error, msg string, code int) *Error {
+	return &Error{msg: msg, code: code, cause: err}
+}
+
+// Implement error interface
+func (this *Error) Error() string {
+	return this.msg
+}
+
+func (this *Error) ErrorCode() int {
+	return this.code
+}
+
+// Return the underlying error (if any)
+func (this *Error) Unwrap() error {
+	return this.cause
+}
+
+// Match the sentinel error of the code (see errors.Is)
+func (this *Error) Is(target error) bool {
+	return target == CodeError(this.code)
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kanzi
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestErrorCodes(t *testing.T) {
+	// One distinct sentinel error per code
+	seen := make(map[error]bool)
+
+	for code := ERR_MISSING_PARAM; code <= ERR_AUTHENTICATION; code++ {
+		err := CodeError(code)
+
+		if err == ErrUnknown || seen[err] == true {
+			t.Fatalf("Code %d: invalid sentinel error: %v", code, err)
+		}
+
+		seen[err] = true
+	}
+
+	if CodeError(1000) != ErrUnknown || CodeError(ERR_UNKNOWN) != ErrUnknown {
+		t.Fatalf("Expected ErrUnknown for an unknown code")
+	}
+
+	if ErrorCode(nil) != 0 || ErrorCode(io.EOF) != ERR_UNKNOWN {
+		t.Fatalf("Invalid code for a nil error or an error without code")
+	}
+}
+
+func TestError(t *testing.T) {
+	err := error(WrapError(io.ErrUnexpectedEOF, "Truncated block", ERR_PROCESS_BLOCK))
+	wrapped := fmt.Errorf("context: %w", err)
+
+	if errors.Is(wrapped, ErrProcessBlock) == false || errors.Is(wrapped, ErrCRCCheck) == true {
+		t.Fatalf("The error does not match its sentinel error: %v", err)
+	}
+
+	if errors.Is(wrapped, io.ErrUnexpectedEOF) == false || errors.Unwrap(err) != io.ErrUnexpectedEOF {
+		t.Fatalf("The cause is lost: %v", err)
+	}
+
+	var e *Error
+
+	if errors.As(wrapped, &e) == false || e.Error() != "Truncated block" || ErrorCode(wrapped) != ERR_PROCESS_BLOCK {
+		t.Fatalf("Invalid error: %v", e)
+	}
+
+	if err = NewError("Bad value", ERR_INVALID_PARAM); errors.Unwrap(err) != nil || errors.Is(err, ErrInvalidParam) == false {
+		t.Fatalf("Invalid error without cause: %v", err)
+	}
+}
@@ -30,16 +30,25 @@ const (
 	EVT_COMPRESSION_END       = 6
 	EVT_DECOMPRESSION_END     = 7
 	EVT_AFTER_HEADER_DECODING = 8
+	EVT_BLOCK_DAMAGED         = 9  // recovery mode: damaged block skipped or zero filled
+	EVT_BLOCK_REPAIRED        = 10 // damaged block rebuilt with the parity blocks
+	EVT_PROGRESS              = 11 // bytes processed so far (see NewProgressEvent)
 )
 
 type Event struct {
 	eventType int
 	id        int
 	size      int64
-	hash      uint32
+	offset    int64
+	hash      uint64
 	hashing   bool
 	eventTime time.Time
 	msg       string
+	output    int64 // progress events only
+	total     int64
+	elapsed   time.Duration
+	inOffset  int64 // damaged block events only
+	inSize    int64
 }
 
 func NewEventFromString(evtType, id int, msg string, evtTime time.Time) *Event {
@@ -50,7 +59,7 @@ func NewEventFromString(evtType, id int, msg string, evtTime time.Time) *Event {
 	return &Event{eventType: evtType, id: id, size: 0, msg: msg, eventTime: evtTime}
 }
 
-func NewEvent(evtType, id int, size int64, hash uint32, hashing bool, evtTime time.Time) *Event {
+func NewEvent(evtType, id int, size int64, hash uint64, hashing bool, evtTime time.Time) *Event {
 	if evtTime.IsZero() {
 		evtTime = time.Now()
 	}
@@ -59,6 +68,30 @@ func NewEvent(evtType, id int, size int64, hash uint32, hashing bool, evtTime ti
 		hashing: hashing, eventTime: evtTime}
 }
 
+// Create a damaged block event (recovery mode): range of bytes [offset, offset+size)
+// zero filled in the uncompressed data and range of bytes [inOffset, inOffset+inSize)
+// skipped in the compressed data (inSize is 0 if unknown)
+func NewDamageEvent(id int, offset, size, inOffset, inSize int64, evtTime time.Time) *Event {
+	if evtTime.IsZero() {
+		evtTime = time.Now()
+	}
+
+	return &Event{eventType: EVT_BLOCK_DAMAGED, id: id, offset: offset, size: size,
+		inOffset: inOffset, inSize: inSize, eventTime: evtTime}
+}
+
+// Create a progress event: number of input bytes consumed and output bytes
+// produced so far, size of the input (0 if unknown) and time elapsed since
+// the start of the processing
+func NewProgressEvent(id int, input, output, total int64, elapsed time.Duration, evtTime time.Time) *Event {
+	if evtTime.IsZero() {
+		evtTime = time.Now()
+	}
+
+	return &Event{eventType: EVT_PROGRESS, id: id, size: input, output: output,
+		total: total, elapsed: elapsed, eventTime: evtTime}
+}
+
 func (this *Event) Type() int {
 	return this.eventType
 }
@@ -75,7 +108,11 @@ func (this *Event) Size() int64 {
 	return this.size
 }
 
-func (this *Event) Hash() uint32 {
+func (this *Event) Offset() int64 {
+	return this.offset
+}
+
+func (this *Event) Hash() uint64 {
 	return this.hash
 }
 
@@ -83,6 +120,52 @@ func (this *Event) Hashing() bool {
 	return this.hashing
 }
 
+// Progress events: number of output bytes produced so far (the number of
+// input bytes is the size of the event)
+func (this *Event) Output() int64 {
+	return this.output
+}
+
+// Damaged block events: position of the skipped range in the compressed data
+func (this *Event) InputOffset() int64 {
+	return this.inOffset
+}
+
+// Damaged block events: size of the skipped range in the compressed data
+// (0 if unknown)
+func (this *Event) InputSize() int64 {
+	return this.inSize
+}
+
+// Progress events: size of the input (0 if unknown)
+func (this *Event) Total() int64 {
+	return this.total
+}
+
+// Progress events: number of input bytes processed per second
+func (this *Event) Throughput() float64 {
+	if this.elapsed <= 0 {
+		return 0
+	}
+
+	return float64(this.size) / this.elapsed.Seconds()
+}
+
+// Progress events: estimated time to completion (-1 if unknown)
+func (this *Event) ETA() time.Duration {
+	throughput := this.Throughput()
+
+	if this.total <= 0 || throughput == 0 {
+		return -1
+	}
+
+	if this.size >= this.total {
+		return 0
+	}
+
+	return time.Duration(float64(this.total-this.size) / throughput * float64(time.Second))
+}
+
 func (this *Event) String() string {
 	if len(this.msg) > 0 {
 		return this.msg
@@ -124,6 +207,17 @@ func (this *Event) String() string {
 
 	case EVT_DECOMPRESSION_END:
 		t = "EVT_DECOMPRESSION_END"
+
+	case EVT_BLOCK_DAMAGED:
+		return fmt.Sprintf("{ \"type\":\"BLOCK_DAMAGED\"%s, \"offset\":%d, \"size\":%d, \"inputOffset\":%d, \"inputSize\":%d, \"time\":%d }",
+			id, this.offset, this.size, this.inOffset, this.inSize, this.eventTime.UnixNano()/1000000)
+
+	case EVT_BLOCK_REPAIRED:
+		t = "BLOCK_REPAIRED"
+
+	case EVT_PROGRESS:
+		return fmt.Sprintf("{ \"type\":\"PROGRESS\"%s, \"input\":%d, \"output\":%d, \"total\":%d, \"throughput\":%.0f, \"time\":%d }",
+			id, this.size, this.output, this.total, this.Throughput(), this.eventTime.UnixNano()/1000000)
 	}
 
 	return fmt.Sprintf("{ \"type\":\"%s\"%s, \"size\":%d, \"time\":%d%s }", t, id, this.size,
@@ -35,6 +35,11 @@ const (
 	ERR_CREATE_STREAM       = 17
 	ERR_INVALID_PARAM       = 18
 	ERR_CRC_CHECK           = 19
+	ERR_CANCELLED           = 20
+	ERR_RESOURCE_LIMIT      = 21
+	ERR_BUFFER_TOO_SMALL    = 22
+	ERR_DICTIONARY          = 23
+	ERR_AUTHENTICATION      = 24
 	ERR_UNKNOWN             = 127
 )
 
@@ -128,6 +133,14 @@ type OutputBitStream interface {
 	Written() uint64
 }
 
+// Optional interface of the bitstreams bound to a cancellation context.
+// Codecs that process a whole block in memory call CheckCancelled()
+// periodically.
+type Cancellable interface {
+	// Panic if the processing has been cancelled
+	CheckCancelled()
+}
+
 type Predictor interface {
 	// Update the probability model
 	Update(bit byte)
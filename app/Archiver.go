@@ -0,0 +1,465 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	kanzi "github.com/flanglet/kanzi-go"
+	kio "github.com/flanglet/kanzi-go/io"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Archive mode of the BlockCompressor (--archive option) and archive
+// commands of the BlockDecompressor (--list and --extract options).
+// See kio.ArchiveWriter for the format.
+
+// Number of bytes read at the start of each file to sort the files of a solid
+// archive
+const SOLID_FINGERPRINT_SIZE = 4096
+
+// Name of the archive entry of an input file: the path relative to the input
+// directory, prefixed with the name of the directory (EG. 'src/io/foo.go'
+// for the file '../src/io/foo.go' when the input is '../src').
+func archiveEntryName(fileName, formattedInName string, inputIsDir bool) string {
+	if inputIsDir == false {
+		return filepath.Base(fileName)
+	}
+
+	name := filepath.ToSlash(fileName[len(formattedInName):])
+	root := filepath.Base(formattedInName)
+
+	if root == "." || root == ".." || root == string([]byte{os.PathSeparator}) {
+		return name
+	}
+
+	return filepath.ToSlash(root) + "/" + name
+}
+
+// Write all the input files to one archive. Return exit code, number of bytes
+// read, number of bytes written
+func (this *BlockCompressor) createArchive(goCtx context.Context, files []FileData, formattedInName,
+	formattedOutName string, inputIsDir bool, ctx map[string]interface{}) (int, uint64, uint64) {
+	outputName := formattedOutName
+
+	if len(outputName) == 0 {
+		outputName = strings.TrimSuffix(formattedInName, string([]byte{os.PathSeparator})) + ".knz"
+	}
+
+	printFlag := this.verbosity > 2
+	log.Println("Archive file name set to '"+outputName+"'", printFlag)
+	var output io.WriteCloser
+	absOutputName := ""
+
+	if strings.ToUpper(outputName) == COMP_NONE {
+		output, _ = kio.NewNullOutputStream()
+	} else if strings.ToUpper(outputName) == COMP_STDOUT {
+		output = os.Stdout
+	} else {
+		if fi, err := os.Stat(outputName); err == nil {
+			if fi.IsDir() {
+				fmt.Println("Output must be a file (or 'NONE')")
+				return kanzi.ERR_OUTPUT_IS_DIR, 0, 0
+			}
+
+			if this.overwrite == false {
+				fmt.Printf("File '%v' exists and the 'force' command ", outputName)
+				fmt.Println("line option has not been provided")
+				return kanzi.ERR_OVERWRITE_FILE, 0, 0
+			}
+		}
+
+		var err error
+
+		if output, err = os.Create(outputName); err != nil {
+			fmt.Printf("Cannot open output file '%v' for writing: %v\n", outputName, err)
+			return kanzi.ERR_CREATE_FILE, 0, 0
+		}
+
+		absOutputName, _ = filepath.Abs(outputName)
+
+		defer func() {
+			output.Close()
+		}()
+	}
+
+	if this.solid == true {
+		files = sortBySimilarity(files)
+	} else {
+		sort.Sort(FileCompareByName{data: files})
+	}
+
+	total := int64(0)
+
+	for _, f := range files {
+		total += f.Size
+	}
+
+	ctx["fileSize"] = total
+	ctx["jobs"] = this.jobs
+	ctx["extra"] = this.entropyCodec == "TPAQX"
+	aw, err := kio.NewArchiveWriterWithContext(goCtx, output, ctx)
+
+	if err != nil {
+		fmt.Printf("Cannot create archive: %v\n", err)
+		return kanzi.ErrorCode(err), 0, 0
+	}
+
+	aw.SetSolid(this.solid)
+
+	for _, bl := range this.listeners {
+		aw.Stream().AddListener(bl)
+	}
+
+	var progress *ProgressPrinter
+
+	if this.progress == true {
+		if progress, err = NewProgressPrinter(outputName, os.Stderr); err == nil {
+			aw.Stream().AddListener(progress)
+
+			// Terminate the progress line on error
+			defer progress.Done()
+		}
+	}
+
+	log.Println("\nArchiving "+formattedInName+" ...", this.verbosity > 1)
+	read := uint64(0)
+	before := time.Now()
+
+	for _, f := range files {
+		// Skip the archive itself (EG. overwritten in the input directory)
+		if absPath, _ := filepath.Abs(f.Path); absPath == absOutputName {
+			continue
+		}
+
+		fi, err := os.Stat(f.Path)
+
+		if err != nil {
+			fmt.Printf("Cannot access input file '%v': %v\n", f.Path, err)
+			return kanzi.ERR_OPEN_FILE, read, aw.GetWritten()
+		}
+
+		input, err := os.Open(f.Path)
+
+		if err != nil {
+			fmt.Printf("Cannot open input file '%v': %v\n", f.Path, err)
+			return kanzi.ERR_OPEN_FILE, read, aw.GetWritten()
+		}
+
+		entry := kio.ArchiveEntry{
+			Name:    archiveEntryName(f.Path, formattedInName, inputIsDir),
+			Mode:    fi.Mode(),
+			ModTime: fi.ModTime(),
+		}
+
+		err = aw.Add(entry, input)
+		input.Close()
+
+		if err != nil {
+			fmt.Printf("Cannot add '%v' to the archive: %v\n", f.Path, err)
+			return kanzi.ErrorCode(err), read, aw.GetWritten()
+		}
+
+		entries := aw.Entries()
+		entry = entries[len(entries)-1]
+		read += uint64(entry.Size)
+		log.Println(fmt.Sprintf("Adding %v (%d bytes)", entry.Name, entry.Size), this.verbosity > 1)
+	}
+
+	if err = aw.Close(); err != nil {
+		fmt.Printf("%v\n", err)
+		return kanzi.ErrorCode(err), read, aw.GetWritten()
+	}
+
+	if progress != nil {
+		progress.Done()
+	}
+
+	delta := time.Now().Sub(before).Nanoseconds() / 1000000 // convert to ms
+	msg := fmt.Sprintf("Archiving %v: %d file(s), %v => %v bytes in %d ms", outputName,
+		len(aw.Entries()), read, aw.GetWritten(), delta)
+	log.Println(msg, this.verbosity > 0)
+	return 0, read, aw.GetWritten()
+}
+
+type fileFingerprint struct {
+	file        FileData
+	ext         string
+	fingerprint uint64
+}
+
+type FileCompareBySimilarity struct {
+	data []fileFingerprint
+}
+
+func (this FileCompareBySimilarity) Len() int {
+	return len(this.data)
+}
+
+func (this FileCompareBySimilarity) Swap(i, j int) {
+	this.data[i], this.data[j] = this.data[j], this.data[i]
+}
+
+func (this FileCompareBySimilarity) Less(i, j int) bool {
+	if this.data[i].ext != this.data[j].ext {
+		return this.data[i].ext < this.data[j].ext
+	}
+
+	if this.data[i].fingerprint != this.data[j].fingerprint {
+		return this.data[i].fingerprint < this.data[j].fingerprint
+	}
+
+	return strings.Compare(this.data[i].file.Path, this.data[j].file.Path) < 0
+}
+
+// Order the files of a solid archive so that similar files are next to each
+// other (same extension, then close content fingerprints)
+func sortBySimilarity(files []FileData) []FileData {
+	data := make([]fileFingerprint, len(files))
+	buf := make([]byte, SOLID_FINGERPRINT_SIZE)
+
+	for i, f := range files {
+		data[i].file = f
+		data[i].ext = strings.ToLower(filepath.Ext(f.Path))
+		data[i].fingerprint = computeFingerprint(f.Path, buf)
+	}
+
+	sort.Sort(FileCompareBySimilarity{data: data})
+	res := make([]FileData, len(files))
+
+	for i := range data {
+		res[i] = data[i].file
+	}
+
+	return res
+}
+
+// Return a SimHash of the 4-grams at the start of the file: files with
+// similar contents have fingerprints with many identical bits (the high bits
+// are used to sort). Return 0 if the file cannot be read.
+func computeFingerprint(name string, buf []byte) uint64 {
+	f, err := os.Open(name)
+
+	if err != nil {
+		return 0
+	}
+
+	n, _ := io.ReadFull(f, buf)
+	f.Close()
+
+	if n < 4 {
+		return 0
+	}
+
+	var counts [64]int
+
+	for i := 0; i+4 <= n; i++ {
+		h := uint64(binary.LittleEndian.Uint32(buf[i:])) * 0x9E3779B97F4A7C15
+
+		for b := range counts {
+			counts[b] += int((h>>uint(b))&1)<<1 - 1
+		}
+	}
+
+	res := uint64(0)
+
+	for b := range counts {
+		if counts[b] > 0 {
+			res |= uint64(1) << uint(b)
+		}
+	}
+
+	return res
+}
+
+// List or extract the entries of an archive. Return exit code, number of
+// bytes extracted
+func (this *BlockDecompressor) callArchive(goCtx context.Context) (int, uint64) {
+	if strings.ToUpper(this.inputName) == "STDIN" {
+		fmt.Println("The archive commands require an input file (not 'stdin')")
+		return kanzi.ERR_INVALID_PARAM, 0
+	}
+
+	input, err := os.Open(this.inputName)
+
+	if err != nil {
+		fmt.Printf("Cannot open input file '%v': %v\n", this.inputName, err)
+		return kanzi.ERR_OPEN_FILE, 0
+	}
+
+	defer input.Close()
+	ctx := this.newContext()
+	ctx["jobs"] = this.jobs
+	ar, err := kio.NewArchiveReaderWithContext(goCtx, input, ctx)
+
+	if err != nil {
+		fmt.Printf("Cannot open archive '%v': %v\n", this.inputName, err)
+		return kanzi.ErrorCode(err), 0
+	}
+
+	defer ar.Close()
+
+	if this.list == true {
+		return this.listArchive(ar), 0
+	}
+
+	return this.extractArchive(ar)
+}
+
+// Print the entries of an archive (one line per entry)
+func (this *BlockDecompressor) listArchive(ar *kio.ArchiveReader) int {
+	total := int64(0)
+
+	for _, e := range ar.Entries() {
+		msg := fmt.Sprintf("%v %12d %v %08x %v", e.Mode, e.Size, e.ModTime.Format("2006-01-02 15:04:05"),
+			e.Checksum, e.Name)
+		log.Println(msg, true)
+		total += e.Size
+	}
+
+	msg := fmt.Sprintf("%d file(s), %d bytes", len(ar.Entries()), total)
+	log.Println(msg, this.verbosity > 0)
+	return 0
+}
+
+// Extract all the entries of an archive (or the entry provided with the
+// --extract option) to the output directory. A single entry can also be
+// extracted to 'stdout' or verified with 'none'.
+func (this *BlockDecompressor) extractArchive(ar *kio.ArchiveReader) (int, uint64) {
+	entries := ar.Entries()
+
+	if len(this.entryName) > 0 {
+		entry, found := ar.Lookup(this.entryName)
+
+		if found == false {
+			fmt.Printf("Cannot find entry '%v' in archive '%v'\n", this.entryName, this.inputName)
+			return kanzi.ERR_INVALID_PARAM, 0
+		}
+
+		entries = []kio.ArchiveEntry{entry}
+	}
+
+	outDir := this.outputName
+	special := strings.ToUpper(outDir) == DECOMP_NONE || strings.ToUpper(outDir) == DECOMP_STDOUT
+
+	if len(outDir) == 0 {
+		outDir = "."
+	}
+
+	if strings.ToUpper(outDir) == DECOMP_STDOUT && len(entries) > 1 {
+		fmt.Println("Only one entry can be extracted to STDOUT")
+		return kanzi.ERR_INVALID_PARAM, 0
+	}
+
+	var progress *ProgressPrinter
+
+	if this.progress == true {
+		var err error
+
+		if progress, err = NewProgressPrinter(this.inputName, os.Stderr); err == nil {
+			ar.Stream().AddListener(progress)
+
+			// Terminate the progress line on error
+			defer progress.Done()
+		}
+	}
+
+	read := uint64(0)
+	before := time.Now()
+
+	for _, e := range entries {
+		var output io.Writer
+		var file *os.File
+
+		if special == true {
+			if strings.ToUpper(outDir) == DECOMP_STDOUT {
+				output = os.Stdout
+			} else {
+				output, _ = kio.NewNullOutputStream()
+			}
+		} else {
+			outputName := filepath.Join(outDir, filepath.FromSlash(e.Name))
+
+			if e.Mode.IsDir() == true {
+				if err := os.MkdirAll(outputName, os.ModePerm); err != nil {
+					fmt.Printf("Cannot create directory '%v': %v\n", outputName, err)
+					return kanzi.ERR_CREATE_FILE, read
+				}
+
+				continue
+			}
+
+			if _, err := os.Stat(outputName); err == nil && this.overwrite == false {
+				fmt.Printf("File '%v' exists and the 'force' command ", outputName)
+				fmt.Println("line option has not been provided")
+				return kanzi.ERR_OVERWRITE_FILE, read
+			}
+
+			if err := os.MkdirAll(filepath.Dir(outputName), os.ModePerm); err != nil {
+				fmt.Printf("Cannot create directory '%v': %v\n", filepath.Dir(outputName), err)
+				return kanzi.ERR_CREATE_FILE, read
+			}
+
+			var err error
+
+			if file, err = os.Create(outputName); err != nil {
+				fmt.Printf("Cannot open output file '%v' for writing: %v\n", outputName, err)
+				return kanzi.ERR_CREATE_FILE, read
+			}
+
+			output = file
+		}
+
+		err := ar.Extract(e, output)
+
+		if file != nil {
+			file.Close()
+
+			if err == nil {
+				if err2 := os.Chmod(file.Name(), e.Mode&os.ModePerm); err2 != nil {
+					log.Println(fmt.Sprintf("Warning: cannot restore the permissions of '%v': %v", file.Name(), err2), this.verbosity > 0)
+				}
+
+				if err2 := os.Chtimes(file.Name(), e.ModTime, e.ModTime); err2 != nil {
+					log.Println(fmt.Sprintf("Warning: cannot restore the modification time of '%v': %v", file.Name(), err2), this.verbosity > 0)
+				}
+			}
+		}
+
+		if err != nil {
+			fmt.Printf("Cannot extract '%v': %v\n", e.Name, err)
+			return kanzi.ErrorCode(err), read
+		}
+
+		read += uint64(e.Size)
+		log.Println(fmt.Sprintf("Extracting %v (%d bytes)", e.Name, e.Size), this.verbosity > 1)
+	}
+
+	if progress != nil {
+		progress.Done()
+	}
+
+	delta := time.Now().Sub(before).Nanoseconds() / 1000000 // convert to ms
+	msg := fmt.Sprintf("Extracting %v: %d file(s), %d bytes in %d ms", this.inputName, len(entries), read, delta)
+	log.Println(msg, this.verbosity > 0 && strings.ToUpper(outDir) != DECOMP_STDOUT)
+	return 0, read
+}
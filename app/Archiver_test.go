@@ -0,0 +1,94 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSortBySimilarity(t *testing.T) {
+	dir := t.TempDir()
+	rnd := rand.New(rand.NewSource(171))
+	contents := make([][]byte, 4)
+
+	for i := range contents {
+		contents[i] = make([]byte, 3000)
+		rnd.Read(contents[i])
+	}
+
+	// Files with the same content get the same fingerprint
+	names := []string{"a.txt", "b.go", "c.txt", "d.TXT", "e.go", "f.txt", "g.txt"}
+	data := [][]byte{contents[0], contents[1], contents[2], contents[0], contents[1], contents[3], contents[2]}
+	files := make([]FileData, 0, len(names)+1)
+
+	for i, name := range names {
+		path := filepath.Join(dir, name)
+
+		if err := os.WriteFile(path, data[i], 0644); err != nil {
+			t.Fatalf("Cannot write file: %v", err)
+		}
+
+		files = append(files, FileData{Path: path, Size: int64(len(data[i]))})
+	}
+
+	files = append(files, FileData{Path: filepath.Join(dir, "missing.txt")})
+	sorted := sortBySimilarity(files)
+
+	if len(sorted) != len(files) {
+		t.Fatalf("Expected %d files, got %d", len(files), len(sorted))
+	}
+
+	pos := make(map[string]int)
+
+	for i, f := range sorted {
+		pos[filepath.Base(f.Path)] = i
+	}
+
+	// Grouped by extension (case insensitive), then by fingerprint
+	if pos["b.go"] > 1 || pos["e.go"] > 1 {
+		t.Fatalf("The '.go' files are not first: %v", pos)
+	}
+
+	abs := func(x int) int {
+		if x < 0 {
+			return -x
+		}
+
+		return x
+	}
+
+	if abs(pos["a.txt"]-pos["d.TXT"]) != 1 || abs(pos["c.txt"]-pos["g.txt"]) != 1 {
+		t.Fatalf("Identical files are not next to each other: %v", pos)
+	}
+
+	// A file that cannot be read has fingerprint 0
+	if pos["missing.txt"] != 2 {
+		t.Fatalf("Expected the unreadable file first among the '.txt' files: %v", pos)
+	}
+
+	buf := make([]byte, SOLID_FINGERPRINT_SIZE)
+
+	if fp := computeFingerprint(filepath.Join(dir, "missing.txt"), buf); fp != 0 {
+		t.Fatalf("Expected fingerprint 0, got %x", fp)
+	}
+
+	if computeFingerprint(files[0].Path, buf) == computeFingerprint(files[1].Path, buf) {
+		t.Fatalf("Different files with the same fingerprint")
+	}
+}
@@ -0,0 +1,441 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	kanzi "github.com/flanglet/kanzi-go"
+	kio "github.com/flanglet/kanzi-go/io"
+	"io/ioutil"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	BENCH_SAMPLING_PERIOD = 10 * time.Millisecond // memory sampling
+)
+
+// Benchmark (--bench option): the input files are loaded in memory, then
+// compressed and decompressed with each level (or each transform&codec pair
+// provided) to compare the compression ratios, the throughputs and the peak
+// memory. Each file is a separate stream and each round trip is verified.
+type BenchResult struct {
+	Level          *int    `json:"level,omitempty"` // nil for a transform&codec pair
+	Transform      string  `json:"transform"`
+	Codec          string  `json:"codec"`
+	InputSize      int64   `json:"inputSize"`
+	OutputSize     int64   `json:"outputSize"`
+	Ratio          float64 `json:"ratio"`
+	CompressMBps   float64 `json:"compressMBps"`
+	DecompressMBps float64 `json:"decompressMBps"`
+	PeakMemoryMB   float64 `json:"peakMemoryMB"`
+	Error          string  `json:"error,omitempty"`
+	code           int
+}
+
+// Largest heap size seen while running a configuration (sampled
+// periodically and at the end of each stage)
+type memorySampler struct {
+	baseline uint64
+	peak     uint64
+	mutex    sync.Mutex
+	done     chan bool
+	wg       sync.WaitGroup
+}
+
+func newMemorySampler() *memorySampler {
+	this := &memorySampler{done: make(chan bool)}
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	this.baseline = stats.HeapAlloc
+	this.peak = stats.HeapAlloc
+	this.wg.Add(1)
+
+	go func() {
+		defer this.wg.Done()
+		ticker := time.NewTicker(BENCH_SAMPLING_PERIOD)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				this.sample()
+
+			case <-this.done:
+				return
+			}
+		}
+	}()
+
+	return this
+}
+
+func (this *memorySampler) sample() {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	this.mutex.Lock()
+
+	if stats.HeapAlloc > this.peak {
+		this.peak = stats.HeapAlloc
+	}
+
+	this.mutex.Unlock()
+}
+
+// Stop sampling and return the peak memory above the baseline (in bytes)
+func (this *memorySampler) stop() uint64 {
+	close(this.done)
+	this.wg.Wait()
+	return this.peak - this.baseline
+}
+
+// Options of a run and level (-1 for a transform&codec pair)
+type benchConfig struct {
+	opts  kio.Options
+	level int
+}
+
+// Writer of the compressed data (in memory)
+type benchBuffer struct {
+	bytes.Buffer
+}
+
+func (this *benchBuffer) Close() error {
+	return nil
+}
+
+// Run the benchmark and print the results (table or JSON). Return the exit
+// code of the first configuration that failed or 0.
+func benchmark(argsMap map[string]interface{}) int {
+	runtime.GOMAXPROCS(runtime.NumCPU())
+	inputName := argsMap["inputName"].(string)
+	verbosity := argsMap["verbose"].(uint)
+	jsonOutput := false
+
+	if val, prst := argsMap["json"]; prst == true {
+		jsonOutput = val.(bool)
+	}
+
+	opts := kio.Options{Jobs: argsMap["jobs"].(uint)}
+
+	if opts.Jobs == 0 {
+		opts.Jobs = 1
+	}
+
+	if val, prst := argsMap["block"]; prst == true {
+		opts.BlockSize = val.(uint)
+	}
+
+	if val, prst := argsMap["checksum"]; prst == true {
+		opts.Checksum = val.(bool)
+	}
+
+	if val, prst := argsMap["checksumType"]; prst == true {
+		opts.ChecksumType = val.(string)
+	}
+
+	if val, prst := argsMap["checksumKey"]; prst == true {
+		opts.ChecksumKey = val.([]byte)
+	}
+
+	if val, prst := argsMap["skipBlocks"]; prst == true {
+		opts.SkipBlocks = val.(bool)
+	}
+
+	if val, prst := argsMap["independentBlocks"]; prst == true {
+		opts.IndependentBlocks = val.(bool)
+	}
+
+	configs, err := benchConfigs(argsMap, opts)
+
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return kanzi.ErrorCode(err)
+	}
+
+	if strings.ToUpper(inputName) == "STDIN" {
+		fmt.Println("The benchmark requires input files (not 'stdin')")
+		return kanzi.ERR_INVALID_PARAM
+	}
+
+	files, err := createFileList(inputName, make([]FileData, 0, 256))
+
+	if err != nil {
+		fmt.Printf("Cannot access input file '%v': %v\n", inputName, err)
+		return kanzi.ERR_OPEN_FILE
+	}
+
+	if len(files) == 0 {
+		fmt.Println("Cannot find any file to process")
+		return kanzi.ERR_MISSING_PARAM
+	}
+
+	sort.Sort(FileCompareByName{data: files})
+	inputs := make([][]byte, len(files))
+	total := int64(0)
+
+	for i, f := range files {
+		if inputs[i], err = ioutil.ReadFile(f.Path); err != nil {
+			fmt.Printf("Cannot read input file '%v': %v\n", f.Path, err)
+			return kanzi.ERR_READ_FILE
+		}
+
+		total += int64(len(inputs[i]))
+	}
+
+	blockSize := opts.BlockSize
+
+	if blockSize == 0 {
+		blockSize = kio.DEFAULT_BLOCK_SIZE
+	}
+
+	msg := fmt.Sprintf("%d file(s), %d bytes, block size %d, %d job(s)", len(files), total, blockSize, opts.Jobs)
+	log.Println(msg, verbosity > 0 && jsonOutput == false)
+
+	if jsonOutput == false {
+		log.Println("", verbosity > 0)
+		log.Println("Level  Transform                  Codec       Ratio   Comp MB/s  Decomp MB/s   Peak MB", true)
+	}
+
+	results := make([]BenchResult, 0, len(configs))
+	res := 0
+
+	for _, cfg := range configs {
+		r := runBenchmark(cfg, inputs)
+		results = append(results, r)
+
+		if r.code != 0 && res == 0 {
+			res = r.code
+		}
+
+		if jsonOutput == false {
+			log.Println(formatBenchResult(&r), true)
+		}
+	}
+
+	if jsonOutput == true {
+		buf, _ := json.MarshalIndent(results, "", "  ")
+		log.Println(string(buf), true)
+	}
+
+	return res
+}
+
+// Return the configurations to run: the --bench list (levels and
+// transform&codec pairs separated by commas), the -l option, the -t and -e
+// options or all the levels
+func benchConfigs(argsMap map[string]interface{}, opts kio.Options) ([]benchConfig, error) {
+	items := make([]string, 0)
+
+	if list := argsMap["bench"].(string); len(list) > 0 {
+		items = strings.Split(list, ",")
+	} else if level, prst := argsMap["level"]; prst == true && level.(int) >= 0 {
+		items = append(items, strconv.Itoa(level.(int)))
+	} else if argsMap["transform"] != nil || argsMap["entropy"] != nil {
+		transform, codec := "NONE", "NONE"
+
+		if val, prst := argsMap["transform"]; prst == true {
+			transform = val.(string)
+		}
+
+		if val, prst := argsMap["entropy"]; prst == true {
+			codec = val.(string)
+		}
+
+		items = append(items, transform+"&"+codec)
+	} else {
+		for level := 0; level <= kio.MAX_LEVEL; level++ {
+			items = append(items, strconv.Itoa(level))
+		}
+	}
+
+	configs := make([]benchConfig, len(items))
+
+	for i, item := range items {
+		cfg := opts
+		level := -1
+		item = strings.ToUpper(strings.TrimSpace(item))
+
+		if tokens := strings.Split(item, "&"); len(tokens) == 2 {
+			cfg.Transform = tokens[0]
+			cfg.Codec = tokens[1]
+		} else if n, err := strconv.Atoi(item); err == nil {
+			if cfg.Transform, cfg.Codec, err = kio.GetTransformAndCodec(n); err != nil {
+				return nil, err
+			}
+
+			cfg.Level = n
+			level = n
+		} else {
+			errMsg := fmt.Sprintf("Invalid benchmark configuration: '%v' (level or transform&codec expected)", item)
+			return nil, kio.NewIOError(errMsg, kanzi.ERR_INVALID_PARAM)
+		}
+
+		if err := cfg.Validate(); err != nil {
+			return nil, err
+		}
+
+		configs[i] = benchConfig{opts: cfg, level: level}
+	}
+
+	return configs, nil
+}
+
+// Compress and decompress all the inputs with one configuration
+func runBenchmark(config benchConfig, inputs [][]byte) (res BenchResult) {
+	cfg := config.opts
+
+	if config.level >= 0 {
+		level := config.level
+		res.Level = &level
+	}
+
+	res.Transform = cfg.Transform
+	res.Codec = cfg.Codec
+	maxSize := 0
+
+	for _, data := range inputs {
+		if len(data) > maxSize {
+			maxSize = len(data)
+		}
+	}
+
+	compressed := &benchBuffer{}
+	compressed.Grow(maxSize + maxSize/8 + 1024)
+
+	// One more byte to detect extra decompressed data
+	decompressed := make([]byte, maxSize+1)
+	var compTime, decompTime time.Duration
+	sampler := newMemorySampler()
+
+	defer func() {
+		if r := recover(); r != nil {
+			res.Error = fmt.Sprintf("%v", r)
+			res.code = kanzi.ERR_UNKNOWN
+		}
+
+		res.PeakMemoryMB = float64(sampler.stop()) / float64(1024*1024)
+	}()
+
+	fail := func(err error) BenchResult {
+		res.Error = err.Error()
+		res.code = kanzi.ErrorCode(err)
+		return res
+	}
+
+	for _, data := range inputs {
+		compressed.Reset()
+		opts := cfg
+		opts.FileSize = int64(len(data))
+		before := time.Now()
+		cos, err := kio.NewCompressedOutputStreamWithOptions(compressed, opts)
+
+		if err != nil {
+			return fail(err)
+		}
+
+		if _, err = cos.Write(data); err != nil {
+			return fail(err)
+		}
+
+		sampler.sample()
+
+		if err = cos.Close(); err != nil {
+			return fail(err)
+		}
+
+		compTime += time.Now().Sub(before)
+		res.InputSize += int64(len(data))
+		res.OutputSize += int64(compressed.Len())
+		opts = kio.Options{Jobs: cfg.Jobs, ChecksumKey: cfg.ChecksumKey}
+		before = time.Now()
+		cis, err := kio.NewCompressedInputStreamWithOptions(ioutil.NopCloser(bytes.NewReader(compressed.Bytes())), opts)
+
+		if err != nil {
+			return fail(err)
+		}
+
+		n := 0
+
+		for n < len(decompressed) {
+			read, err := cis.Read(decompressed[n:])
+
+			if err != nil {
+				return fail(err)
+			}
+
+			if read == 0 {
+				break
+			}
+
+			n += read
+		}
+
+		sampler.sample()
+
+		if err = cis.Close(); err != nil {
+			return fail(err)
+		}
+
+		decompTime += time.Now().Sub(before)
+
+		if n != len(data) || bytes.Equal(decompressed[0:n], data) == false {
+			errMsg := fmt.Sprintf("Round trip failed: %d bytes in the input, %d bytes decompressed", len(data), n)
+
+			if n == len(data) {
+				errMsg = fmt.Sprintf("Round trip failed: the decompressed data differs from the input (%d bytes)", n)
+			}
+
+			return fail(kio.NewIOError(errMsg, kanzi.ERR_PROCESS_BLOCK))
+		}
+	}
+
+	if res.InputSize > 0 {
+		res.Ratio = float64(res.OutputSize) / float64(res.InputSize)
+	}
+
+	if compTime > 0 {
+		res.CompressMBps = float64(res.InputSize) / float64(1024*1024) / compTime.Seconds()
+	}
+
+	if decompTime > 0 {
+		res.DecompressMBps = float64(res.InputSize) / float64(1024*1024) / decompTime.Seconds()
+	}
+
+	return res
+}
+
+func formatBenchResult(r *BenchResult) string {
+	level := "    -"
+
+	if r.Level != nil {
+		level = fmt.Sprintf("%5d", *r.Level)
+	}
+
+	if r.code != 0 {
+		return fmt.Sprintf("%s  %-26s %-8s FAILED: %v", level, r.Transform, r.Codec, r.Error)
+	}
+
+	return fmt.Sprintf("%s  %-26s %-8s %8.4f %11.2f %12.2f %9.2f", level, r.Transform, r.Codec,
+		r.Ratio, r.CompressMBps, r.DecompressMBps, r.PeakMemoryMB)
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	kanzi "github.com/flanglet/kanzi-go"
+	kio "github.com/flanglet/kanzi-go/io"
+)
+
+func TestBenchConfigs(t *testing.T) {
+	opts := kio.Options{BlockSize: 64 * 1024, Jobs: 2}
+
+	// All the levels by default
+	configs, err := benchConfigs(map[string]interface{}{"bench": ""}, opts)
+
+	if err != nil || len(configs) != kio.MAX_LEVEL+1 {
+		t.Fatalf("Expected %d configurations, got %d (%v)", kio.MAX_LEVEL+1, len(configs), err)
+	}
+
+	for i, cfg := range configs {
+		transform, codec, _ := kio.GetTransformAndCodec(i)
+
+		if cfg.level != i || cfg.opts.Transform != transform || cfg.opts.Codec != codec || cfg.opts.BlockSize != 64*1024 {
+			t.Fatalf("Level %d: invalid configuration %+v", i, cfg)
+		}
+	}
+
+	if configs, err = benchConfigs(map[string]interface{}{"bench": "2, rolz&ans0"}, opts); err != nil || len(configs) != 2 ||
+		configs[0].level != 2 || configs[1].level != -1 || configs[1].opts.Transform != "ROLZ" || configs[1].opts.Codec != "ANS0" {
+		t.Fatalf("Invalid configurations: %+v (%v)", configs, err)
+	}
+
+	if configs, err = benchConfigs(map[string]interface{}{"bench": "", "level": 5}, opts); err != nil || len(configs) != 1 ||
+		configs[0].level != 5 {
+		t.Fatalf("Invalid configuration for the level option: %+v (%v)", configs, err)
+	}
+
+	if configs, err = benchConfigs(map[string]interface{}{"bench": "", "transform": "BWT"}, opts); err != nil || len(configs) != 1 ||
+		configs[0].opts.Transform != "BWT" || configs[0].opts.Codec != "NONE" {
+		t.Fatalf("Invalid configuration for the transform option: %+v (%v)", configs, err)
+	}
+
+	for _, list := range []string{"foo", "2,", "99", "ROLZ&FOO"} {
+		if _, err = benchConfigs(map[string]interface{}{"bench": list}, opts); err == nil {
+			t.Fatalf("No error for the configuration '%s'", list)
+		}
+	}
+
+	if _, err = benchConfigs(map[string]interface{}{"bench": "foo"}, opts); kanzi.ErrorCode(err) != kanzi.ERR_INVALID_PARAM {
+		t.Fatalf("Expected ERR_INVALID_PARAM, got %v", err)
+	}
+}
+
+func TestRunBenchmark(t *testing.T) {
+	inputs := [][]byte{testContent(300000, 192), testContent(1000, 193), {}}
+	configs, err := benchConfigs(map[string]interface{}{"bench": "0,6,ROLZ&ANS0"}, kio.Options{BlockSize: 64 * 1024, Jobs: 2})
+
+	if err != nil {
+		t.Fatalf("Invalid configurations: %v", err)
+	}
+
+	for _, cfg := range configs {
+		res := runBenchmark(cfg, inputs)
+
+		if res.code != 0 || res.Error != "" {
+			t.Fatalf("%s&%s: unexpected error: %v", res.Transform, res.Codec, res.Error)
+		}
+
+		if res.InputSize != 301000 || res.OutputSize <= 0 || res.CompressMBps <= 0 || res.DecompressMBps <= 0 {
+			t.Fatalf("%s&%s: invalid result %+v", res.Transform, res.Codec, res)
+		}
+
+		// No compression at level 0
+		if (cfg.level == 0 && res.Ratio < 1) || (cfg.level != 0 && res.Ratio >= 0.5) {
+			t.Fatalf("%s&%s: unexpected ratio %v", res.Transform, res.Codec, res.Ratio)
+		}
+
+		if (cfg.level < 0 && res.Level != nil) || (cfg.level >= 0 && (res.Level == nil || *res.Level != cfg.level)) {
+			t.Fatalf("%s&%s: invalid level", res.Transform, res.Codec)
+		}
+
+		if line := formatBenchResult(&res); strings.Contains(line, "FAILED") == true {
+			t.Fatalf("Invalid result line: %s", line)
+		}
+	}
+
+	// The level is omitted for a transform&codec pair, the code is not exported
+	res := runBenchmark(configs[2], inputs[1:2])
+	buf, _ := json.Marshal(&res)
+
+	if strings.Contains(string(buf), `"level"`) == true || strings.Contains(string(buf), `"code"`) == true {
+		t.Fatalf("Invalid JSON: %s", buf)
+	}
+
+	res.code = kanzi.ERR_PROCESS_BLOCK
+	res.Error = "Round trip failed"
+
+	if line := formatBenchResult(&res); strings.Contains(line, "FAILED: Round trip failed") == false {
+		t.Fatalf("Invalid result line: %s", line)
+	}
+}
@@ -16,6 +16,7 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"fmt"
 	kanzi "github.com/flanglet/kanzi-go"
 	"github.com/flanglet/kanzi-go/function"
@@ -40,19 +41,34 @@ const (
 
 // Main block compressor struct
 type BlockCompressor struct {
-	verbosity    uint
-	overwrite    bool
-	checksum     bool
-	skipBlocks   bool
-	inputName    string
-	outputName   string
-	entropyCodec string
-	transform    string
-	blockSize    uint
-	level        int // command line compression level
-	jobs         uint
-	listeners    []kanzi.Listener
-	cpuProf      string
+	verbosity     uint
+	overwrite     bool
+	checksum      bool
+	checksumType  string
+	checksumKey   []byte
+	dictionary    []byte
+	passphrase    string
+	encryptionKey []byte
+	parityGroup   uint
+	parityBlocks  uint
+	chunkSize     uint // content-defined chunking if not 0
+	minChunkSize  uint
+	maxChunkSize  uint
+	skipBlocks    bool
+	blockIndex    bool
+	independent   bool
+	archive       bool // write all the input files to one archive
+	solid         bool // write the files of the archive back to back
+	inputName     string
+	outputName    string
+	entropyCodec  string
+	transform     string
+	blockSize     uint
+	level         int // command line compression level
+	jobs          uint
+	progress      bool // display a progress line on stderr
+	listeners     []kanzi.Listener
+	cpuProf       string
 }
 
 type FileCompressResult struct {
@@ -81,6 +97,31 @@ func NewBlockCompressor(argsMap map[string]interface{}) (*BlockCompressor, error
 		this.skipBlocks = false
 	}
 
+	if blockIndex, prst := argsMap["blockIndex"]; prst == true {
+		this.blockIndex = blockIndex.(bool)
+		delete(argsMap, "blockIndex")
+	} else {
+		this.blockIndex = false
+	}
+
+	if independent, prst := argsMap["independentBlocks"]; prst == true {
+		this.independent = independent.(bool)
+		delete(argsMap, "independentBlocks")
+	} else {
+		this.independent = false
+	}
+
+	if archive, prst := argsMap["archive"]; prst == true {
+		this.archive = archive.(bool)
+		delete(argsMap, "archive")
+	}
+
+	if solid, prst := argsMap["solid"]; prst == true {
+		this.solid = solid.(bool)
+		this.archive = this.archive || this.solid
+		delete(argsMap, "solid")
+	}
+
 	this.inputName = argsMap["inputName"].(string)
 	delete(argsMap, "inputName")
 	this.outputName = argsMap["outputName"].(string)
@@ -89,10 +130,11 @@ func NewBlockCompressor(argsMap map[string]interface{}) (*BlockCompressor, error
 	strCodec := ""
 
 	if this.level >= 0 {
-		tranformAndCodec := getTransformAndCodec(this.level)
-		tokens := strings.Split(tranformAndCodec, "&")
-		strTransf = tokens[0]
-		strCodec = tokens[1]
+		var err error
+
+		if strTransf, strCodec, err = kio.GetTransformAndCodec(this.level); err != nil {
+			return nil, err
+		}
 	} else {
 		if codec, prst := argsMap["entropy"]; prst == true {
 			strCodec = codec.(string)
@@ -135,6 +177,64 @@ func NewBlockCompressor(argsMap map[string]interface{}) (*BlockCompressor, error
 		this.checksum = false
 	}
 
+	if checkType, prst := argsMap["checksumType"]; prst == true {
+		this.checksumType = checkType.(string)
+		delete(argsMap, "checksumType")
+	} else {
+		this.checksumType = "XXH32"
+	}
+
+	if key, prst := argsMap["checksumKey"]; prst == true {
+		this.checksumKey = key.([]byte)
+		delete(argsMap, "checksumKey")
+	}
+
+	if dictName, prst := argsMap["dictionary"]; prst == true {
+		var err error
+
+		if this.dictionary, err = loadDictionary(dictName.(string)); err != nil {
+			return nil, err
+		}
+
+		delete(argsMap, "dictionary")
+	}
+
+	if pass, prst := argsMap["passphrase"]; prst == true {
+		this.passphrase = pass.(string)
+		delete(argsMap, "passphrase")
+	}
+
+	if keyName, prst := argsMap["keyFile"]; prst == true {
+		var err error
+
+		if this.encryptionKey, err = loadEncryptionKey(keyName.(string)); err != nil {
+			return nil, err
+		}
+
+		delete(argsMap, "keyFile")
+	}
+
+	if parity, prst := argsMap["parityBlocks"]; prst == true {
+		this.parityBlocks = parity.(uint)
+		this.parityGroup = argsMap["parityGroupSize"].(uint)
+		delete(argsMap, "parityBlocks")
+		delete(argsMap, "parityGroupSize")
+	}
+
+	if chunk, prst := argsMap["chunkSize"]; prst == true {
+		this.chunkSize = chunk.(uint)
+		this.minChunkSize = argsMap["minChunkSize"].(uint)
+		this.maxChunkSize = argsMap["maxChunkSize"].(uint)
+		delete(argsMap, "chunkSize")
+		delete(argsMap, "minChunkSize")
+		delete(argsMap, "maxChunkSize")
+	}
+
+	if progress, prst := argsMap["progress"]; prst == true {
+		this.progress = progress.(bool)
+		delete(argsMap, "progress")
+	}
+
 	this.verbosity = argsMap["verbose"].(uint)
 	delete(argsMap, "verbose")
 	concurrency := argsMap["jobs"].(uint)
@@ -217,6 +317,12 @@ func fileCompressWorker(tasks <-chan FileCompressTask, cancel <-chan bool, resul
 
 // Return exit code, number of bits written
 func (this *BlockCompressor) Call() (int, uint64) {
+	return this.CallContext(context.Background())
+}
+
+// Same as Call() but stop processing when goCtx is done. In this case, the
+// exit code is ERR_CANCELLED.
+func (this *BlockCompressor) CallContext(goCtx context.Context) (int, uint64) {
 	var err error
 	before := time.Now()
 	files := make([]FileData, 0, 256)
@@ -257,6 +363,39 @@ func (this *BlockCompressor) Call() (int, uint64) {
 	msg = fmt.Sprintf("Checksum set to %t", this.checksum)
 	log.Println(msg, printFlag)
 
+	if this.checksum == true {
+		msg = fmt.Sprintf("Checksum type set to %s", this.checksumType)
+		log.Println(msg, printFlag)
+	}
+
+	msg = fmt.Sprintf("Independent blocks set to %t", this.independent)
+	log.Println(msg, printFlag)
+
+	if len(this.passphrase) > 0 || this.encryptionKey != nil {
+		log.Println("Encryption set to AES-256-GCM", printFlag)
+	}
+
+	if this.archive == true {
+		msg = fmt.Sprintf("Archive set to true (solid %t)", this.solid)
+		log.Println(msg, printFlag)
+	}
+
+	if this.parityBlocks > 0 {
+		msg = fmt.Sprintf("Parity set to %d parity blocks every %d blocks", this.parityBlocks, this.parityGroup)
+		log.Println(msg, printFlag)
+	}
+
+	if this.chunkSize > 0 {
+		if this.minChunkSize > 0 {
+			msg = fmt.Sprintf("Content-defined chunking set to %d bytes on average (min %d, max %d)",
+				this.chunkSize, this.minChunkSize, this.maxChunkSize)
+		} else {
+			msg = fmt.Sprintf("Content-defined chunking set to %d bytes on average", this.chunkSize)
+		}
+
+		log.Println(msg, printFlag)
+	}
+
 	if printFlag == true {
 		w1 := "no"
 
@@ -290,11 +429,16 @@ func (this *BlockCompressor) Call() (int, uint64) {
 	}
 
 	// Limit verbosity level when files are processed concurrently
-	if this.jobs > 1 && nbFiles > 1 && this.verbosity > 1 {
+	if this.jobs > 1 && nbFiles > 1 && this.verbosity > 1 && this.archive == false {
 		log.Println("Warning: limiting verbosity to 1 due to concurrent processing of input files.\n", true)
 		this.verbosity = 1
 	}
 
+	if this.jobs > 1 && nbFiles > 1 && this.progress == true && this.archive == false {
+		log.Println("Warning: no progress line due to concurrent processing of input files.\n", true)
+		this.progress = false
+	}
+
 	if this.verbosity > 2 {
 		if listener, err2 := NewInfoPrinter(this.verbosity, ENCODING, os.Stdout); err2 == nil {
 			this.AddListener(listener)
@@ -327,7 +471,8 @@ func (this *BlockCompressor) Call() (int, uint64) {
 			formattedInName = formattedInName + string([]byte{os.PathSeparator})
 		}
 
-		if len(formattedOutName) > 0 && specialOutput == false {
+		// The output of an archive is a file
+		if len(formattedOutName) > 0 && specialOutput == false && this.archive == false {
 			fi, err = os.Stat(formattedOutName)
 
 			if err != nil {
@@ -359,14 +504,48 @@ func (this *BlockCompressor) Call() (int, uint64) {
 
 	ctx := make(map[string]interface{})
 	ctx["verbosity"] = this.verbosity
+	ctx["progress"] = this.progress
 	ctx["overwrite"] = this.overwrite
 	ctx["skipBlocks"] = this.skipBlocks
 	ctx["blockSize"] = this.blockSize
 	ctx["checksum"] = this.checksum
+	ctx["checksumType"] = this.checksumType
+
+	if this.checksumKey != nil {
+		ctx["checksumKey"] = this.checksumKey
+	}
+
+	if this.dictionary != nil {
+		ctx["dictionary"] = this.dictionary
+	}
+
+	if len(this.passphrase) > 0 {
+		ctx["passphrase"] = this.passphrase
+	}
+
+	if this.encryptionKey != nil {
+		ctx["encryptionKey"] = this.encryptionKey
+	}
+
+	if this.parityBlocks > 0 {
+		ctx["parityGroupSize"] = this.parityGroup
+		ctx["parityBlocks"] = this.parityBlocks
+	}
+
+	if this.chunkSize > 0 {
+		ctx["chunkSize"] = this.chunkSize
+		ctx["minChunkSize"] = this.minChunkSize
+		ctx["maxChunkSize"] = this.maxChunkSize
+	}
+
+	ctx["blockIndex"] = this.blockIndex
+	ctx["independentBlocks"] = this.independent
 	ctx["codec"] = this.entropyCodec
 	ctx["transform"] = this.transform
 
-	if nbFiles == 1 {
+	if this.archive == true {
+		res, read, written = this.createArchive(goCtx, files, formattedInName, formattedOutName, inputIsDir, ctx)
+	} else if nbFiles == 1 {
 		oName := formattedOutName
 		iName := files[0].Path
 
@@ -381,7 +560,7 @@ func (this *BlockCompressor) Call() (int, uint64) {
 		ctx["outputName"] = oName
 		ctx["jobs"] = this.jobs
 		ctx["extra"] = this.entropyCodec == "TPAQX"
-		task := FileCompressTask{ctx: ctx, listeners: this.listeners}
+		task := FileCompressTask{ctx: ctx, listeners: this.listeners, goCtx: goCtx}
 		res, read, written = task.Call()
 	} else {
 		// Create channels for task synchronization
@@ -415,7 +594,7 @@ func (this *BlockCompressor) Call() (int, uint64) {
 			taskCtx["outputName"] = oName
 			taskCtx["jobs"] = jobsPerTask[n]
 			n++
-			task := FileCompressTask{ctx: taskCtx, listeners: this.listeners}
+			task := FileCompressTask{ctx: taskCtx, listeners: this.listeners, goCtx: goCtx}
 
 			// Push task to channel. The workers are the consumers.
 			tasks <- task
@@ -484,46 +663,18 @@ func notifyBCListeners(listeners []kanzi.Listener, evt *kanzi.Event) {
 	}
 }
 
-func getTransformAndCodec(level int) string {
-	switch level {
-	case 0:
-		return "NONE&NONE"
-
-	case 1:
-		return "TEXT+LZ4&HUFFMAN"
-
-	case 2:
-		return "TEXT+ROLZ&NONE"
-
-	case 3:
-		return "TEXT+ROLZX&NONE"
-
-	case 4:
-		return "TEXT+BWT+RANK+ZRLT&ANS0"
-
-	case 5:
-		return "TEXT+BWT+RANK+ZRLT&FPAQ"
-
-	case 6:
-		return "BWT&CM"
-
-	case 7:
-		return "X86+RLT+TEXT&TPAQ"
-
-	case 8:
-		return "X86+RLT+TEXT&TPAQX"
-
-	default:
-		return "Unknown&Unknown"
-	}
-}
-
 type FileCompressTask struct {
 	ctx       map[string]interface{}
 	listeners []kanzi.Listener
+	goCtx     context.Context
 }
 
 func (this *FileCompressTask) Call() (int, uint64, uint64) {
+	// Do not start a new file once cancelled
+	if this.goCtx.Err() != nil {
+		return kanzi.ERR_CANCELLED, 0, 0
+	}
+
 	var msg string
 	verbosity := this.ctx["verbosity"].(uint)
 	inputName := this.ctx["inputName"].(string)
@@ -583,7 +734,7 @@ func (this *FileCompressTask) Call() (int, uint64, uint64) {
 
 	}
 
-	cos, err := kio.NewCompressedOutputStream(output, this.ctx)
+	cos, err := kio.NewCompressedOutputStreamWithContext(this.goCtx, output, this.ctx)
 
 	if err != nil {
 		if ioerr, isIOErr := err.(kio.IOError); isIOErr == true {
@@ -614,12 +765,30 @@ func (this *FileCompressTask) Call() (int, uint64, uint64) {
 		defer func() {
 			input.Close()
 		}()
+
+		// Store the file attributes in the metadata section of the header
+		if fi, err := os.Stat(inputName); err == nil && fi.Mode().IsRegular() {
+			cos.SetMetadataString(kio.METADATA_KEY_NAME, filepath.Base(inputName))
+			cos.SetMetadataInt(kio.METADATA_KEY_MODE, int64(fi.Mode().Perm()))
+			cos.SetMetadataInt(kio.METADATA_KEY_MTIME, fi.ModTime().UnixNano())
+		}
 	}
 
 	for _, bl := range this.listeners {
 		cos.AddListener(bl)
 	}
 
+	var progress *ProgressPrinter
+
+	if this.ctx["progress"].(bool) == true {
+		if progress, err = NewProgressPrinter(inputName, os.Stderr); err == nil {
+			cos.AddListener(progress)
+
+			// Terminate the progress line on error
+			defer progress.Done()
+		}
+	}
+
 	// Encode
 	printFlag = verbosity > 1
 	log.Println("\nEncoding "+inputName+" ...", printFlag)
@@ -646,7 +815,7 @@ func (this *FileCompressTask) Call() (int, uint64, uint64) {
 		read += uint64(length)
 
 		if _, err = cos.Write(buffer[0:length]); err != nil {
-			if ioerr, isIOErr := err.(kio.IOError); isIOErr == true {
+			if ioerr, isIOErr := err.(*kio.IOError); isIOErr == true {
 				fmt.Printf("%s\n", ioerr.Error())
 				return ioerr.ErrorCode(), read, cos.GetWritten()
 			}
@@ -666,7 +835,13 @@ func (this *FileCompressTask) Call() (int, uint64, uint64) {
 
 	// Close streams to ensure all data are flushed
 	// Deferred close is fallback for error paths
-	if err := cos.Close(); err != nil {
+	err = cos.Close()
+
+	if progress != nil {
+		progress.Done()
+	}
+
+	if err != nil {
 		fmt.Printf("%v\n", err)
 		return kanzi.ERR_PROCESS_BLOCK, read, cos.GetWritten()
 	}
@@ -16,6 +16,7 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"fmt"
 	kanzi "github.com/flanglet/kanzi-go"
 	kio "github.com/flanglet/kanzi-go/io"
@@ -25,6 +26,7 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -38,13 +40,27 @@ const (
 
 // Main block decompressor struct
 type BlockDecompressor struct {
-	verbosity  uint
-	overwrite  bool
-	inputName  string
-	outputName string
-	jobs       uint
-	listeners  []kanzi.Listener
-	cpuProf    string
+	verbosity     uint
+	overwrite     bool
+	inputName     string
+	outputName    string
+	jobs          uint
+	key           []byte
+	dictionary    []byte
+	passphrase    string
+	encryptionKey []byte
+	singleFrame   bool
+	recover       bool
+	progress      bool // display a progress line on stderr
+	maxBlock      uint // decoder resource limits (0 means no limit)
+	maxMemory     uint64
+	maxOutput     uint64
+	list          bool   // list the entries of an archive
+	extract       bool   // extract the entries of an archive
+	entryName     string // entry to extract (all entries if empty)
+	test          bool   // decode the input files without output
+	listeners     []kanzi.Listener
+	cpuProf       string
 }
 
 type FileDecompressResult struct {
@@ -72,6 +88,11 @@ func NewBlockDecompressor(argsMap map[string]interface{}) (*BlockDecompressor, e
 	this.verbosity = argsMap["verbose"].(uint)
 	delete(argsMap, "verbose")
 
+	if progress, prst := argsMap["progress"]; prst == true {
+		this.progress = progress.(bool)
+		delete(argsMap, "progress")
+	}
+
 	if concurrency == 0 {
 		this.jobs = DECOMP_DEFAULT_CONCURRENCY
 	} else {
@@ -85,6 +106,77 @@ func NewBlockDecompressor(argsMap map[string]interface{}) (*BlockDecompressor, e
 		this.jobs = concurrency
 	}
 
+	if key, prst := argsMap["checksumKey"]; prst == true {
+		this.key = key.([]byte)
+		delete(argsMap, "checksumKey")
+	}
+
+	if dictName, prst := argsMap["dictionary"]; prst == true {
+		var err error
+
+		if this.dictionary, err = loadDictionary(dictName.(string)); err != nil {
+			return nil, err
+		}
+
+		delete(argsMap, "dictionary")
+	}
+
+	if pass, prst := argsMap["passphrase"]; prst == true {
+		this.passphrase = pass.(string)
+		delete(argsMap, "passphrase")
+	}
+
+	if keyName, prst := argsMap["keyFile"]; prst == true {
+		var err error
+
+		if this.encryptionKey, err = loadEncryptionKey(keyName.(string)); err != nil {
+			return nil, err
+		}
+
+		delete(argsMap, "keyFile")
+	}
+
+	if sf, prst := argsMap["singleFrame"]; prst == true {
+		this.singleFrame = sf.(bool)
+		delete(argsMap, "singleFrame")
+	}
+
+	if rec, prst := argsMap["recover"]; prst == true {
+		this.recover = rec.(bool)
+		delete(argsMap, "recover")
+	}
+
+	if val, prst := argsMap["maxBlockSize"]; prst == true {
+		this.maxBlock = uint(val.(uint64))
+		delete(argsMap, "maxBlockSize")
+	}
+
+	if val, prst := argsMap["maxMemory"]; prst == true {
+		this.maxMemory = val.(uint64)
+		delete(argsMap, "maxMemory")
+	}
+
+	if val, prst := argsMap["maxOutputSize"]; prst == true {
+		this.maxOutput = val.(uint64)
+		delete(argsMap, "maxOutputSize")
+	}
+
+	if list, prst := argsMap["list"]; prst == true {
+		this.list = list.(bool)
+		delete(argsMap, "list")
+	}
+
+	if name, prst := argsMap["extract"]; prst == true {
+		this.extract = true
+		this.entryName = name.(string)
+		delete(argsMap, "extract")
+	}
+
+	if test, prst := argsMap["test"]; prst == true {
+		this.test = test.(bool)
+		delete(argsMap, "test")
+	}
+
 	if prof, prst := argsMap["cpuProf"]; prst == true {
 		this.cpuProf = prof.(string)
 		delete(argsMap, "cpuProf")
@@ -148,6 +240,20 @@ func fileDecompressWorker(tasks <-chan FileDecompressTask, cancel <-chan bool, r
 
 // Return exit code, number of bits written
 func (this *BlockDecompressor) Call() (int, uint64) {
+	return this.CallContext(context.Background())
+}
+
+// Same as Call() but stop processing when goCtx is done. In this case, the
+// exit code is ERR_CANCELLED.
+func (this *BlockDecompressor) CallContext(goCtx context.Context) (int, uint64) {
+	if this.list == true || this.extract == true {
+		return this.callArchive(goCtx)
+	}
+
+	if this.test == true {
+		return this.callTest(goCtx)
+	}
+
 	var err error
 	before := time.Now()
 	files := make([]FileData, 0, 256)
@@ -202,6 +308,11 @@ func (this *BlockDecompressor) Call() (int, uint64) {
 		this.verbosity = 1
 	}
 
+	if this.jobs > 1 && nbFiles > 1 && this.progress == true {
+		log.Println("Warning: no progress line due to concurrent processing of input files.\n", true)
+		this.progress = false
+	}
+
 	if this.verbosity > 2 {
 		if listener, err2 := NewInfoPrinter(this.verbosity, DECODING, os.Stdout); err2 == nil {
 			this.AddListener(listener)
@@ -263,9 +374,7 @@ func (this *BlockDecompressor) Call() (int, uint64) {
 		}
 	}
 
-	ctx := make(map[string]interface{})
-	ctx["verbosity"] = this.verbosity
-	ctx["overwrite"] = this.overwrite
+	ctx := this.newContext()
 
 	if nbFiles == 1 {
 		oName := formattedOutName
@@ -281,7 +390,7 @@ func (this *BlockDecompressor) Call() (int, uint64) {
 		ctx["inputName"] = iName
 		ctx["outputName"] = oName
 		ctx["jobs"] = this.jobs
-		task := FileDecompressTask{ctx: ctx, listeners: this.listeners}
+		task := FileDecompressTask{ctx: ctx, listeners: this.listeners, goCtx: goCtx}
 
 		res, read = task.Call()
 	} else {
@@ -315,7 +424,7 @@ func (this *BlockDecompressor) Call() (int, uint64) {
 			taskCtx["outputName"] = oName
 			taskCtx["jobs"] = jobsPerTask[n]
 			n++
-			task := FileDecompressTask{ctx: taskCtx, listeners: this.listeners}
+			task := FileDecompressTask{ctx: taskCtx, listeners: this.listeners, goCtx: goCtx}
 
 			// Push task to channel. The workers are the consumers.
 			tasks <- task
@@ -365,6 +474,39 @@ func (this *BlockDecompressor) Call() (int, uint64) {
 	return res, read
 }
 
+// Return the context of the decompression tasks (options shared by all the
+// input files)
+func (this *BlockDecompressor) newContext() map[string]interface{} {
+	ctx := make(map[string]interface{})
+	ctx["verbosity"] = this.verbosity
+	ctx["progress"] = this.progress
+	ctx["overwrite"] = this.overwrite
+
+	if this.key != nil {
+		ctx["checksumKey"] = this.key
+	}
+
+	if this.dictionary != nil {
+		ctx["dictionary"] = this.dictionary
+	}
+
+	if len(this.passphrase) > 0 {
+		ctx["passphrase"] = this.passphrase
+	}
+
+	if this.encryptionKey != nil {
+		ctx["encryptionKey"] = this.encryptionKey
+	}
+
+	ctx["singleFrame"] = this.singleFrame
+	ctx["recover"] = this.recover
+
+	ctx["maxBlockSize"] = this.maxBlock
+	ctx["maxMemory"] = this.maxMemory
+	ctx["maxOutputSize"] = this.maxOutput
+	return ctx
+}
+
 func notifyBDListeners(listeners []kanzi.Listener, evt *kanzi.Event) {
 	defer func() {
 		//lint:ignore SA9003 ignore panics in listeners
@@ -381,9 +523,15 @@ func notifyBDListeners(listeners []kanzi.Listener, evt *kanzi.Event) {
 type FileDecompressTask struct {
 	ctx       map[string]interface{}
 	listeners []kanzi.Listener
+	goCtx     context.Context
 }
 
 func (this *FileDecompressTask) Call() (int, uint64) {
+	// Do not start a new file once cancelled
+	if this.goCtx.Err() != nil {
+		return kanzi.ERR_CANCELLED, 0
+	}
+
 	var msg string
 	verbosity := this.ctx["verbosity"].(uint)
 	inputName := this.ctx["inputName"].(string)
@@ -468,7 +616,7 @@ func (this *FileDecompressTask) Call() (int, uint64) {
 		}()
 	}
 
-	cis, err := kio.NewCompressedInputStream(input, this.ctx)
+	cis, err := kio.NewCompressedInputStreamWithContext(this.goCtx, input, this.ctx)
 
 	if err != nil {
 		if err.(*kio.IOError) != nil {
@@ -484,12 +632,27 @@ func (this *FileDecompressTask) Call() (int, uint64) {
 		cis.AddListener(bl)
 	}
 
+	var progress *ProgressPrinter
+
+	if this.ctx["progress"].(bool) == true {
+		if progress, err = NewProgressPrinter(inputName, os.Stderr); err == nil {
+			cis.AddListener(progress)
+
+			// Terminate the progress line on error
+			defer progress.Done()
+		}
+	}
+
+	// Damaged blocks (recovery mode) and blocks rebuilt with the parity blocks
+	damages := &DamageReporter{verbosity: verbosity}
+	cis.AddListener(damages)
+
 	buffer := make([]byte, DECOMP_DEFAULT_BUFFER_SIZE)
 	decoded := len(buffer)
 	before := time.Now()
 
 	// Decode next block
-	for decoded == len(buffer) {
+	for decoded > 0 {
 		if decoded, err = cis.Read(buffer); err != nil {
 			if ioerr, isIOErr := err.(*kio.IOError); isIOErr == true {
 				fmt.Printf("%s\n", ioerr.Message())
@@ -514,11 +677,33 @@ func (this *FileDecompressTask) Call() (int, uint64) {
 
 	// Close streams to ensure all data are flushed
 	// Deferred close is fallback for error paths
-	if err := cis.Close(); err != nil {
+	err = cis.Close()
+
+	if progress != nil {
+		progress.Done()
+	}
+
+	if err != nil {
 		fmt.Printf("%v\n", err)
 		return kanzi.ERR_PROCESS_BLOCK, uint64(read)
 	}
 
+	if damages.blocks > 0 {
+		msg = fmt.Sprintf("Warning: %d damaged block(s) in '%v', %d byte(s) zero filled", damages.blocks, inputName, damages.size)
+		log.Println(msg, verbosity > 0)
+	}
+
+	if repaired := atomic.LoadInt32(&damages.repaired); repaired > 0 {
+		msg = fmt.Sprintf("%d block(s) repaired in '%v'", repaired, inputName)
+		log.Println(msg, verbosity > 0)
+	}
+
+	if file, isFile := output.(*os.File); isFile == true && file != os.Stdout {
+		if md, err := cis.Metadata(); err == nil {
+			restoreFileAttributes(file, md, verbosity)
+		}
+	}
+
 	after := time.Now()
 	delta := after.Sub(before).Nanoseconds() / 1000000 // convert to ms
 	log.Println("", verbosity > 1)
@@ -559,3 +744,59 @@ func (this *FileDecompressTask) Call() (int, uint64) {
 
 	return 0, uint64(read)
 }
+
+// Restore the permission bits and modification time stored in the metadata
+// section of the header (if any)
+func restoreFileAttributes(file *os.File, md *kio.Metadata, verbosity uint) {
+	if mode, found := md.GetInt(kio.METADATA_KEY_MODE); found == true {
+		if err := file.Chmod(os.FileMode(mode) & os.ModePerm); err != nil {
+			log.Println(fmt.Sprintf("Warning: cannot restore the permissions of '%v': %v", file.Name(), err), verbosity > 0)
+		}
+	}
+
+	if mtime, found := md.GetInt(kio.METADATA_KEY_MTIME); found == true {
+		t := time.Unix(0, mtime)
+
+		if err := os.Chtimes(file.Name(), t, t); err != nil {
+			log.Println(fmt.Sprintf("Warning: cannot restore the modification time of '%v': %v", file.Name(), err), verbosity > 0)
+		}
+	}
+}
+
+// Report the blocks damaged during decompression in recovery mode and the
+// blocks rebuilt with the parity blocks
+type DamageReporter struct {
+	verbosity uint
+	blocks    int
+	size      int64
+	repaired  int32 // updated concurrently by the decoding tasks
+}
+
+func (this *DamageReporter) ProcessEvent(evt *kanzi.Event) {
+	if evt.Type() == kanzi.EVT_BLOCK_REPAIRED {
+		atomic.AddInt32(&this.repaired, 1)
+		msg := fmt.Sprintf("Block %d repaired with the parity blocks", evt.Id())
+		log.Println(msg, this.verbosity > 1)
+		return
+	}
+
+	if evt.Type() != kanzi.EVT_BLOCK_DAMAGED {
+		return
+	}
+
+	this.blocks++
+	this.size += evt.Size()
+	var msg string
+
+	if evt.Size() == 0 && evt.InputSize() > 0 {
+		msg = fmt.Sprintf("Warning: block %d is damaged, compressed bytes [%d..%d) skipped at offset %d",
+			evt.Id(), evt.InputOffset(), evt.InputOffset()+evt.InputSize(), evt.Offset())
+	} else if evt.Size() == 0 {
+		msg = fmt.Sprintf("Warning: block %d is damaged, skipped at offset %d", evt.Id(), evt.Offset())
+	} else {
+		msg = fmt.Sprintf("Warning: block %d is damaged, bytes [%d..%d) zero filled", evt.Id(),
+			evt.Offset(), evt.Offset()+evt.Size())
+	}
+
+	log.Println(msg, this.verbosity > 0)
+}
@@ -0,0 +1,210 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	kanzi "github.com/flanglet/kanzi-go"
+	kio "github.com/flanglet/kanzi-go/io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Describe the compressed input files from their headers (see
+// kio.ReadStreamInfo). Nothing is decoded.
+func inspect(argsMap map[string]interface{}) int {
+	inputName := argsMap["inputName"].(string)
+	verbosity := argsMap["verbose"].(uint)
+
+	if strings.ToUpper(inputName) == "STDIN" {
+		return inspectFile(os.Stdin, "stdin", verbosity)
+	}
+
+	files, err := createFileList(inputName, make([]FileData, 0, 256))
+
+	if err != nil {
+		fmt.Printf("Cannot access input file '%v': %v\n", inputName, err)
+		return kanzi.ERR_OPEN_FILE
+	}
+
+	if len(files) == 0 {
+		fmt.Println("Cannot find any file to process")
+		return kanzi.ERR_MISSING_PARAM
+	}
+
+	sort.Sort(FileCompareByName{data: files})
+	res := 0
+
+	for _, f := range files {
+		input, err := os.Open(f.Path)
+
+		if err != nil {
+			fmt.Printf("Cannot open input file '%v': %v\n", f.Path, err)
+			return kanzi.ERR_OPEN_FILE
+		}
+
+		code := inspectFile(input, f.Path, verbosity)
+		input.Close()
+
+		// Report the first failure but describe all the files
+		if code != 0 && res == 0 {
+			res = code
+		}
+	}
+
+	return res
+}
+
+func inspectFile(input *os.File, name string, verbosity uint) int {
+	info, err := kio.ReadStreamInfo(input)
+	log.Println("File: "+name, true)
+
+	if info != nil && info.Version != 0 {
+		printStreamInfo(info, verbosity)
+	}
+
+	if err != nil {
+		fmt.Printf("Cannot read '%v': %v\n", name, err)
+		return kanzi.ErrorCode(err)
+	}
+
+	log.Println("", true)
+	return 0
+}
+
+func printStreamInfo(info *kio.StreamInfo, verbosity uint) {
+	yesNo := func(flag uint) string {
+		if info.Flags&flag != 0 {
+			return "yes"
+		}
+
+		return "no"
+	}
+
+	sizeOrUnknown := func(size int64) string {
+		if size < 0 {
+			return "unknown"
+		}
+
+		return fmt.Sprintf("%d bytes", size)
+	}
+
+	log.Println(fmt.Sprintf("  Bitstream version:  %d", info.Version), true)
+	log.Println(fmt.Sprintf("  Compressed size:    %s", sizeOrUnknown(info.Size)), true)
+	log.Println(fmt.Sprintf("  Original size:      %s", sizeOrUnknown(info.ContentSize)), true)
+	log.Println(fmt.Sprintf("  Transform:          %s", info.Transform), true)
+	log.Println(fmt.Sprintf("  Entropy codec:      %s", info.Codec), true)
+	log.Println(fmt.Sprintf("  Block size:         %d bytes", info.BlockSize), true)
+	log.Println(fmt.Sprintf("  Block checksum:     %s", info.Checksum), true)
+	log.Println(fmt.Sprintf("  Content checksum:   %s", yesNo(kio.HEADER_FLAG_CONTENT_HASH)), true)
+	log.Println(fmt.Sprintf("  Independent blocks: %s", yesNo(kio.HEADER_FLAG_INDEPENDENT)), true)
+	log.Println(fmt.Sprintf("  Block index:        %s", yesNo(kio.HEADER_FLAG_BLOCK_INDEX)), true)
+
+	if info.Flags&kio.HEADER_FLAG_DICTIONARY != 0 {
+		log.Println(fmt.Sprintf("  Dictionary ID:      %08X", info.DictionaryID), true)
+	}
+
+	if info.Encryption != "" {
+		log.Println(fmt.Sprintf("  Encryption:         AES-256-GCM (%s)", strings.ToLower(info.Encryption)), true)
+	}
+
+	if info.ParityGroup > 0 {
+		log.Println(fmt.Sprintf("  Parity:             %d parity blocks every %d blocks", info.ParityBlocks, info.ParityGroup), true)
+	}
+
+	if info.Flags&kio.HEADER_FLAG_METADATA != 0 && info.Encryption != "" && info.Metadata.Len() == 0 {
+		log.Println("  Metadata:           encrypted", true)
+	} else if info.Metadata != nil {
+		for _, k := range info.Metadata.Keys() {
+			log.Println(fmt.Sprintf("  Metadata:           %s = %s", k, formatMetadata(info.Metadata, k)), true)
+		}
+	}
+
+	if info.Blocks == nil {
+		if info.Flags&kio.HEADER_FLAG_BLOCK_INDEX != 0 {
+			log.Println("  Blocks:             unknown (block index not found at the end of the input)", true)
+		} else {
+			log.Println("  Blocks:             unknown (dependent blocks and no block index)", true)
+		}
+
+		return
+	}
+
+	log.Println(fmt.Sprintf("  Blocks:             %d", len(info.Blocks)), true)
+
+	if verbosity == 0 || len(info.Blocks) == 0 {
+		return
+	}
+
+	log.Println("      Block       Offset   Compressed  Mode  Skip       Length     Original", true)
+
+	for _, b := range info.Blocks {
+		original := "-"
+
+		if b.OriginalSize >= 0 {
+			original = fmt.Sprintf("%d", b.OriginalSize)
+		}
+
+		var msg string
+
+		if b.Encrypted == true || b.Damaged == true {
+			msg = fmt.Sprintf("  %9d %12d %12d     -     - %12s %12s", b.Id, b.Offset, b.Size, "-", original)
+		} else {
+			msg = fmt.Sprintf("  %9d %12d %12d  0x%02X  0x%02X %12d %12s", b.Id, b.Offset, b.Size,
+				b.Mode, b.SkipFlags, b.Length, original)
+		}
+
+		if b.IsCopy() == true && b.Encrypted == false && b.Damaged == false {
+			msg += "  copy"
+		}
+
+		if b.Encrypted == true {
+			msg += "  encrypted"
+		}
+
+		if b.Damaged == true {
+			msg += "  damaged"
+		} else if b.Repaired == true {
+			msg += "  repaired"
+		}
+
+		log.Println(msg, true)
+	}
+}
+
+func formatMetadata(md *kio.Metadata, key string) string {
+	if val, found := md.GetInt(key); found == true {
+		if key == kio.METADATA_KEY_MTIME {
+			return time.Unix(0, val).Format("2006-01-02 15:04:05")
+		}
+
+		if key == kio.METADATA_KEY_MODE {
+			return fmt.Sprintf("%v", os.FileMode(val))
+		}
+
+		return fmt.Sprintf("%d", val)
+	}
+
+	if val, found := md.GetString(key); found == true {
+		return "'" + val + "'"
+	}
+
+	val, _ := md.GetBytes(key)
+	return hex.EncodeToString(val)
+}
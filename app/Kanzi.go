@@ -17,16 +17,20 @@ package main
 
 import (
 	"bufio"
+	"encoding/hex"
 	"fmt"
 	kanzi "github.com/flanglet/kanzi-go"
+	kio "github.com/flanglet/kanzi-go/io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
 	"runtime/pprof"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 const (
@@ -62,8 +66,14 @@ func main() {
 
 	if mode == "c" {
 		status = compress(argsMap)
-	} else if mode == "d" {
+	} else if mode == "d" || mode == "l" || mode == "x" || mode == "v" {
 		status = decompress(argsMap)
+	} else if mode == "t" {
+		status = train(argsMap)
+	} else if mode == "i" {
+		status = inspect(argsMap)
+	} else if mode == "b" {
+		status = benchmark(argsMap)
 	} else {
 		println("Missing arguments: try --help or -h")
 	}
@@ -149,12 +159,151 @@ func decompress(argsMap map[string]interface{}) int {
 	return code
 }
 
+// Build a dictionary from the input files (see kio.TrainDictionary)
+func train(argsMap map[string]interface{}) int {
+	inputName := argsMap["inputName"].(string)
+	outputName := argsMap["outputName"].(string)
+	verbosity := argsMap["verbose"].(uint)
+	size := argsMap["dictSize"].(int)
+	overwrite := false
+
+	if force, prst := argsMap["overwrite"]; prst == true {
+		overwrite = force.(bool)
+	}
+
+	if len(outputName) == 0 || strings.ToUpper(outputName) == "NONE" || strings.ToUpper(outputName) == "STDOUT" {
+		fmt.Println("Missing dictionary file name (output), exiting ...")
+		return kanzi.ERR_MISSING_PARAM
+	}
+
+	files, err := createFileList(inputName, make([]FileData, 0, 256))
+
+	if err != nil {
+		fmt.Printf("Cannot access input file '%v': %v\n", inputName, err)
+		return kanzi.ERR_OPEN_FILE
+	}
+
+	if len(files) == 0 {
+		fmt.Println("Cannot find any file to process")
+		return kanzi.ERR_MISSING_PARAM
+	}
+
+	sort.Sort(FileCompareByName{data: files})
+	samples := make([][]byte, 0, len(files))
+	read := int64(0)
+
+	for _, f := range files {
+		buf, err := ioutil.ReadFile(f.Path)
+
+		if err != nil {
+			fmt.Printf("Cannot read input file '%v': %v\n", f.Path, err)
+			return kanzi.ERR_READ_FILE
+		}
+
+		samples = append(samples, buf)
+		read += int64(len(buf))
+	}
+
+	before := time.Now()
+	dict, err := kio.TrainDictionary(samples, size)
+
+	if err != nil {
+		fmt.Printf("Failed to build the dictionary: %v\n", err)
+
+		if ioerr, isIOErr := err.(*kio.IOError); isIOErr == true {
+			return ioerr.ErrorCode()
+		}
+
+		return kanzi.ERR_UNKNOWN
+	}
+
+	if overwrite == false {
+		if _, err := os.Stat(outputName); err == nil {
+			fmt.Printf("File '%v' exists and the 'force' command line option has not been provided\n", outputName)
+			return kanzi.ERR_OVERWRITE_FILE
+		}
+	}
+
+	if err := ioutil.WriteFile(outputName, dict, 0666); err != nil {
+		fmt.Printf("Cannot write dictionary file '%v': %v\n", outputName, err)
+		return kanzi.ERR_WRITE_FILE
+	}
+
+	if verbosity >= 1 {
+		log.Println(fmt.Sprintf("Training samples: %d file(s), %d bytes", len(files), read), true)
+		log.Println(fmt.Sprintf("Dictionary:       %d bytes, ID %08X", len(dict), kio.DictionaryID(dict)), true)
+		log.Println(fmt.Sprintf("Training time:    %d ms", time.Now().Sub(before).Nanoseconds()/1000000), true)
+	}
+
+	return 0
+}
+
+// Load an encryption key file (see the --key-file option): either the raw
+// key or the key in hexadecimal
+func loadEncryptionKey(name string) ([]byte, error) {
+	buf, err := ioutil.ReadFile(name)
+
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read key file '%v': %v", name, err)
+	}
+
+	if len(buf) == kio.ENCRYPTION_KEY_SIZE {
+		return buf, nil
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(string(buf)))
+
+	if err != nil || len(key) != kio.ENCRYPTION_KEY_SIZE {
+		return nil, fmt.Errorf("Invalid key file '%v': %d bytes or %d hexadecimal digits expected",
+			name, kio.ENCRYPTION_KEY_SIZE, 2*kio.ENCRYPTION_KEY_SIZE)
+	}
+
+	return key, nil
+}
+
+// Load a dictionary file (see the --dict option)
+func loadDictionary(name string) ([]byte, error) {
+	dict, err := ioutil.ReadFile(name)
+
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read dictionary file '%v': %v", name, err)
+	}
+
+	if len(dict) == 0 || len(dict) > kio.MAX_DICTIONARY_SIZE {
+		return nil, fmt.Errorf("Invalid dictionary file '%v': the size must be in [1..%d] bytes", name, kio.MAX_DICTIONARY_SIZE)
+	}
+
+	return dict, nil
+}
+
 func processCommandLine(args []string, argsMap map[string]interface{}) {
 	blockSize := -1
 	verbose := 1
 	overwrite := false
 	checksum := false
+	checksumType := ""
+	checksumKey := ""
+	dictName := ""
+	passphrase := ""
+	keyName := ""
+	parityGroupSize := uint(0)
+	parityBlocks := uint(0)
+	chunkSizes := []uint64(nil)
+	dictSize := kio.DEFAULT_DICTIONARY_SIZE
 	skip := false
+	blockIndex := false
+	independent := false
+	archive := false
+	solid := false
+	entryName := ""
+	benchList := ""
+	jsonOutput := false
+	singleFrame := false
+	recovery := false
+	progress := false
+	maxBlockSize := uint64(0)
+	maxMemory := uint64(0)
+	maxOutputSize := uint64(0)
 	inputName := ""
 	outputName := ""
 	codec := ""
@@ -183,23 +332,25 @@ func processCommandLine(args []string, argsMap map[string]interface{}) {
 		}
 
 		// Extract verbosity, output and mode first
-		if arg == "--compress" || arg == "-c" {
-			if mode == "d" {
-				fmt.Println("Both compression and decompression options were provided.")
+		if argMode := getMode(arg); len(argMode) > 0 {
+			if mode != " " && mode != argMode {
+				fmt.Println("Several modes (compression, decompression, training, list, extraction, info, test, benchmark) were provided.")
 				os.Exit(kanzi.ERR_INVALID_PARAM)
 			}
 
-			mode = "c"
+			if argMode == "b" {
+				benchList = strings.TrimPrefix(strings.TrimPrefix(arg, "--bench"), "=")
+			} else if argMode == "x" {
+				entryName = strings.TrimPrefix(strings.TrimPrefix(arg, "--extract"), "=")
+			}
+
+			mode = argMode
 			continue
 		}
 
-		if arg == "--decompress" || arg == "-d" {
-			if mode == "c" {
-				fmt.Println("Both compression and decompression options were provided.")
-				os.Exit(kanzi.ERR_INVALID_PARAM)
-			}
-
-			mode = "d"
+		if arg == "--json" {
+			jsonOutput = true
+			ctx = -1
 			continue
 		}
 
@@ -237,8 +388,8 @@ func processCommandLine(args []string, argsMap map[string]interface{}) {
 		ctx = -1
 	}
 
-	// Overwrite verbosity if the output goes to stdout
-	if strings.ToUpper(outputName) == "STDOUT" {
+	// Overwrite verbosity if the output goes to stdout (or is machine readable)
+	if strings.ToUpper(outputName) == "STDOUT" || (jsonOutput == true && mode == "b") {
 		verbose = 0
 	}
 
@@ -286,10 +437,86 @@ func processCommandLine(args []string, argsMap map[string]interface{}) {
 				log.Println("        <inputName.bak>) or 'none' or 'stdout'. 'stdout' is not valid", true)
 				log.Println("        when the number of jobs is greater than 1.\n", true)
 
+			} else if mode == "t" {
+				log.Println("        mandatory name of the dictionary file.\n", true)
+			} else if mode == "x" {
+				log.Println("        optional name of the destination directory (defaults to the", true)
+				log.Println("        current directory) or 'none' or 'stdout' (single entry only).\n", true)
+			} else if mode == "l" || mode == "v" || mode == "b" {
+				log.Println("        ignored.\n", true)
 			} else {
 				log.Println("        optional name of the output file or 'none' or 'stdout'.\n", true)
 			}
 
+			if mode == "t" {
+				log.Println("   --train", true)
+				log.Println("        build a dictionary from the input files (samples of the data", true)
+				log.Println("        to compress). See the --dict option.\n", true)
+				log.Println("   --dict-size=<size>", true)
+				log.Println("        maximum size of the dictionary (default 64 KB, max 1 MB).\n", true)
+				log.Println("EG. Kanzi --train -i samples -o json.dict --dict-size=32k\n", true)
+				os.Exit(0)
+			}
+
+			if mode == "i" {
+				log.Println("   --info", true)
+				log.Println("        describe the compressed input files from the headers, without", true)
+				log.Println("        decoding: bitstream version, transform, entropy codec, block", true)
+				log.Println("        size, checksum, options and metadata. Each block (offset, size,", true)
+				log.Println("        mode byte, skip flags) is listed when the blocks are independent", true)
+				log.Println("        or the stream has a block index (verbosity > 0).\n", true)
+				log.Println("EG. Kanzi --info -i foo.knz\n", true)
+				os.Exit(0)
+			}
+
+			if mode == "b" {
+				log.Println("   --bench[=<list>]", true)
+				log.Println("        compress and decompress the input files in memory with each", true)
+				log.Println("        configuration of the list (levels or transform&codec pairs", true)
+				log.Println("        separated by commas, EG. 1,4,BWT+RANK&ANS1), the -l option, the", true)
+				log.Println("        -t and -e options or all the levels. Display the compression", true)
+				log.Println("        ratio, the throughputs (MB/s) and the peak memory (MB). Each", true)
+				log.Println("        round trip is verified.\n", true)
+				log.Println("   --json", true)
+				log.Println("        display the results in JSON format.\n", true)
+				log.Println("   -b, --block=<size>", true)
+				log.Println("        size of blocks (default 1 MB).\n", true)
+				log.Println("   -x, --checksum[=<type>]", true)
+				log.Println("        enable block checksum [XXH32|XXH64|MURMUR3|SIPHASH].\n", true)
+				log.Println("   -s, --skip, --independent", true)
+				log.Println("        see the compression options.\n", true)
+				log.Println("   -j, --jobs=<jobs>", true)
+				log.Println("        maximum number of jobs per stream (default is 1).\n", true)
+				log.Println("EG. Kanzi --bench -i corpus -b 4m -j 4\n", true)
+				log.Println("EG. Kanzi --bench=2,TEXT+LZ4&HUFFMAN,BWT&CM -i corpus --json\n", true)
+				os.Exit(0)
+			}
+
+			if mode == " " {
+				log.Println("   --train", true)
+				log.Println("        build a dictionary from sample files (see --train --help)\n", true)
+				log.Println("   --info", true)
+				log.Println("        describe compressed files without decoding (see --info --help)\n", true)
+				log.Println("   --bench[=<list>]", true)
+				log.Println("        compare the levels or transform&codec pairs on the input files", true)
+				log.Println("        (see --bench --help)\n", true)
+			}
+
+			if mode != "c" {
+				log.Println("   --list", true)
+				log.Println("        list the entries of an archive (see --archive): mode, size,", true)
+				log.Println("        modification time, checksum and name.\n", true)
+				log.Println("   --extract[=<entry>]", true)
+				log.Println("        extract all the entries of an archive (or only the entry) to the", true)
+				log.Println("        output directory. The checksum of each entry is verified.\n", true)
+				log.Println("   --test", true)
+				log.Println("        decode the input files (the .knz files of a directory) without", true)
+				log.Println("        writing the output and verify the checksums of the blocks, of the", true)
+				log.Println("        content and of the archive entries. One line per file (OK or", true)
+				log.Println("        FAILED), only the failures with verbosity 0. The exit code is 0", true)
+				log.Println("        if all the files are valid (EG. for cron jobs).\n", true)
+			}
+
 			if mode != "d" {
 				log.Println("   -b, --block=<size>", true)
 				log.Println("        size of blocks, multiple of 16 (default 1 MB, max 1 GB, min 1 KB).\n", true)
@@ -306,12 +533,65 @@ func processCommandLine(args []string, argsMap map[string]interface{}) {
 				log.Println("        transform [None|BWT|BWTS|SNAPPY|LZ4|ROLZ|ROLZX|RLT|ZRLT|MTFT]", true)
 				log.Println("                  [RANK|TEXT|X86]", true)
 				log.Println("        EG: BWT+RANK or BWTS+MTFT (default is BWT+RANK+ZRLT)\n", true)
-				log.Println("   -x, --checksum", true)
-				log.Println("        enable block checksum\n", true)
+				log.Println("   -x, --checksum[=<type>]", true)
+				log.Println("        enable block checksum [XXH32|XXH64|MURMUR3|SIPHASH]", true)
+				log.Println("        (default is XXH32). SIPHASH is a keyed MAC and requires", true)
+				log.Println("        the --checksum-key option.\n", true)
 				log.Println("   -s, --skip", true)
 				log.Println("        copy blocks with high entropy instead of compressing them.\n", true)
+				log.Println("   --index", true)
+				log.Println("        append a block index to the output to allow random access.\n", true)
+				log.Println("   --independent", true)
+				log.Println("        entropy code each block in its own buffer so that all the stages", true)
+				log.Println("        run in parallel (faster with many jobs and slow entropy codecs).\n", true)
+				log.Println("   --parity[=<N>:<K>]", true)
+				log.Println("        write K parity blocks after every N blocks (default 8:2). Up to K", true)
+				log.Println("        damaged blocks per group are rebuilt during decompression.\n", true)
+				log.Println("   --archive", true)
+				log.Println("        write all the input files to one archive (defaults to", true)
+				log.Println("        <inputName.knz>) with their relative paths, sizes, modes, times", true)
+				log.Println("        and checksums. See the --list and --extract options.\n", true)
+				log.Println("   --solid", true)
+				log.Println("        write an archive (see --archive) with the files back to back,", true)
+				log.Println("        sorted by extension and similarity of the content. Better ratio", true)
+				log.Println("        for many small files, slower extraction of a single file.\n", true)
+				log.Println("   --chunk[=<avg>[:<min>:<max>]]", true)
+				log.Println("        cut the blocks where the content matches (rolling hash) instead of", true)
+				log.Println("        every block size bytes, so that an insertion only changes the", true)
+				log.Println("        nearby blocks (default average is 64K, min avg/4, max 4*avg).\n", true)
+			}
+
+			if mode != "c" {
+				log.Println("   --single-frame", true)
+				log.Println("        only decode the first stream of the input and fail if other data", true)
+				log.Println("        follows (by default, concatenated streams are decoded one after", true)
+				log.Println("        the other).\n", true)
+				log.Println("   --recover", true)
+				log.Println("        zero fill (or skip) damaged blocks instead of stopping. Decoding", true)
+				log.Println("        resumes after a damaged block if the input has a block index.\n", true)
+				log.Println("   --max-block=<size>", true)
+				log.Println("        reject inputs with a block size above the limit.\n", true)
+				log.Println("   --max-memory=<size>", true)
+				log.Println("        limit the memory used by the decoder. Fewer jobs are started if", true)
+				log.Println("        needed, inputs that cannot be decoded within the limit are rejected.\n", true)
+				log.Println("   --max-output=<size>", true)
+				log.Println("        stop decoding when the decompressed size exceeds the limit.\n", true)
 			}
 
+			log.Println("   --dict=<dictName>", true)
+			log.Println("        pre-shared dictionary (see --train). The same dictionary must be", true)
+			log.Println("        provided to compress and decompress. Useful for small files.\n", true)
+			log.Println("   --passphrase=<passphrase>", true)
+			log.Println("        encrypt the blocks (AES-256-GCM) with a key derived from the", true)
+			log.Println("        passphrase. The passphrase is visible in the list of processes,", true)
+			log.Println("        --key-file is safer.\n", true)
+			log.Println("   --key-file=<keyName>", true)
+			log.Println("        encrypt the blocks (AES-256-GCM) with the key of the file", true)
+			log.Println("        (32 bytes or 64 hexadecimal digits).\n", true)
+			log.Println("   --checksum-key=<key>", true)
+			log.Println("        key of the SIPHASH block checksum (32 hexadecimal digits)\n", true)
+			log.Println("   --progress", true)
+			log.Println("        display a progress line (on stderr) while processing the files.\n", true)
 			log.Println("   -j, --jobs=<jobs>", true)
 			log.Println("        maximum number of jobs the program may start concurrently", true)
 			log.Println("        (default is 1, maximum is 64).\n", true)
@@ -322,17 +602,20 @@ func processCommandLine(args []string, argsMap map[string]interface{}) {
 				log.Println("EG. Kanzi -c -i foo.txt -f -t BWT+MTFT+ZRLT -b 4m -e FPAQ -v 3 -j 4\n", true)
 				log.Println("EG. Kanzi --compress --input=foo.txt --output=foo.knz --block=4m --force", true)
 				log.Println("          --transform=BWT+MTFT+ZRLT --entropy=FPAQ --verbose=3 --jobs=4\n", true)
+				log.Println("EG. Kanzi -c --archive -i src -o src.knz -l 4 -j 4\n", true)
 			}
 
 			if mode != "c" {
 				log.Println("EG. Kanzi -d -i foo.knz -f -v 2 -j 2\n", true)
+				log.Println("EG. Kanzi --extract=src/io/foo.go -i src.knz -o /tmp\n", true)
+				log.Println("EG. Kanzi --test -i backups -j 4 -v 0\n", true)
 				log.Println("EG. Kanzi --decompress --input=foo.knz --force --verbose=2 --jobs=2\n", true)
 			}
 
 			os.Exit(0)
 		}
 
-		if arg == "--compress" || arg == "-c" || arg == "--decompress" || arg == "-d" {
+		if len(getMode(arg)) > 0 || arg == "--json" {
 			if ctx != -1 {
 				log.Println("Warning: ignoring option ["+CMD_LINE_ARGS[ctx]+"] with no value.", verbose > 0)
 			}
@@ -361,6 +644,139 @@ func processCommandLine(args []string, argsMap map[string]interface{}) {
 			continue
 		}
 
+		if arg == "--independent" {
+			if ctx != -1 {
+				log.Println("Warning: ignoring option ["+CMD_LINE_ARGS[ctx]+"] with no value.", verbose > 0)
+			}
+
+			independent = true
+			ctx = -1
+			continue
+		}
+
+		if arg == "--parity" || strings.HasPrefix(arg, "--parity=") {
+			if ctx != -1 {
+				log.Println("Warning: ignoring option ["+CMD_LINE_ARGS[ctx]+"] with no value.", verbose > 0)
+			}
+
+			parityGroupSize = kio.DEFAULT_PARITY_GROUP_SIZE
+			parityBlocks = kio.DEFAULT_PARITY_BLOCKS
+
+			if arg != "--parity" {
+				tokens := strings.Split(strings.TrimPrefix(arg, "--parity="), ":")
+				n, err := strconv.Atoi(tokens[0])
+				k := kio.DEFAULT_PARITY_BLOCKS
+
+				if err == nil && len(tokens) == 2 {
+					k, err = strconv.Atoi(tokens[1])
+				}
+
+				if err != nil || len(tokens) > 2 || n < 1 || n > kio.MAX_PARITY_GROUP_SIZE ||
+					k < 1 || k > kio.MAX_PARITY_BLOCKS {
+					fmt.Printf("Invalid parity ratio provided on command line: %v (N:K expected, N in [1..%d], K in [1..%d])\n",
+						arg, kio.MAX_PARITY_GROUP_SIZE, kio.MAX_PARITY_BLOCKS)
+					os.Exit(kanzi.ERR_INVALID_PARAM)
+				}
+
+				parityGroupSize = uint(n)
+				parityBlocks = uint(k)
+			}
+
+			ctx = -1
+			continue
+		}
+
+		if arg == "--chunk" || strings.HasPrefix(arg, "--chunk=") {
+			if ctx != -1 {
+				log.Println("Warning: ignoring option ["+CMD_LINE_ARGS[ctx]+"] with no value.", verbose > 0)
+			}
+
+			chunkSizes = []uint64{kio.DEFAULT_CHUNK_SIZE, 0, 0}
+
+			if arg != "--chunk" {
+				tokens := strings.Split(strings.TrimPrefix(arg, "--chunk="), ":")
+
+				if len(tokens) != 1 && len(tokens) != 3 {
+					fmt.Printf("Invalid chunk sizes provided on command line: %v (avg or avg:min:max expected)\n", arg)
+					os.Exit(kanzi.ERR_INVALID_PARAM)
+				}
+
+				for i := range tokens {
+					size, err := parseSize(tokens[i])
+
+					if err != nil || size == 0 || size > kio.MAX_BITSTREAM_BLOCK_SIZE {
+						fmt.Printf("Invalid chunk sizes provided on command line: %v (avg or avg:min:max expected)\n", arg)
+						os.Exit(kanzi.ERR_INVALID_PARAM)
+					}
+
+					chunkSizes[i] = size
+				}
+			}
+
+			ctx = -1
+			continue
+		}
+
+		if arg == "--progress" {
+			if ctx != -1 {
+				log.Println("Warning: ignoring option ["+CMD_LINE_ARGS[ctx]+"] with no value.", verbose > 0)
+			}
+
+			progress = true
+			ctx = -1
+			continue
+		}
+
+		if arg == "--archive" {
+			if ctx != -1 {
+				log.Println("Warning: ignoring option ["+CMD_LINE_ARGS[ctx]+"] with no value.", verbose > 0)
+			}
+
+			archive = true
+			ctx = -1
+			continue
+		}
+
+		if arg == "--solid" {
+			if ctx != -1 {
+				log.Println("Warning: ignoring option ["+CMD_LINE_ARGS[ctx]+"] with no value.", verbose > 0)
+			}
+
+			solid = true
+			ctx = -1
+			continue
+		}
+
+		if arg == "--index" {
+			if ctx != -1 {
+				log.Println("Warning: ignoring option ["+CMD_LINE_ARGS[ctx]+"] with no value.", verbose > 0)
+			}
+
+			blockIndex = true
+			ctx = -1
+			continue
+		}
+
+		if arg == "--recover" {
+			if ctx != -1 {
+				log.Println("Warning: ignoring option ["+CMD_LINE_ARGS[ctx]+"] with no value.", verbose > 0)
+			}
+
+			recovery = true
+			ctx = -1
+			continue
+		}
+
+		if arg == "--single-frame" {
+			if ctx != -1 {
+				log.Println("Warning: ignoring option ["+CMD_LINE_ARGS[ctx]+"] with no value.", verbose > 0)
+			}
+
+			singleFrame = true
+			ctx = -1
+			continue
+		}
+
 		if arg == "--checksum" || arg == "-x" {
 			if ctx != -1 {
 				log.Println("Warning: ignoring option ["+CMD_LINE_ARGS[ctx]+"] with no value.", verbose > 0)
@@ -371,6 +787,101 @@ func processCommandLine(args []string, argsMap map[string]interface{}) {
 			continue
 		}
 
+		if strings.HasPrefix(arg, "--checksum=") {
+			if ctx != -1 {
+				log.Println("Warning: ignoring option ["+CMD_LINE_ARGS[ctx]+"] with no value.", verbose > 0)
+			}
+
+			checksumType = strings.ToUpper(strings.TrimPrefix(arg, "--checksum="))
+			checksum = true
+			ctx = -1
+			continue
+		}
+
+		if strings.HasPrefix(arg, "--checksum-key=") {
+			if ctx != -1 {
+				log.Println("Warning: ignoring option ["+CMD_LINE_ARGS[ctx]+"] with no value.", verbose > 0)
+			}
+
+			checksumKey = strings.TrimPrefix(arg, "--checksum-key=")
+			ctx = -1
+			continue
+		}
+
+		if strings.HasPrefix(arg, "--dict=") {
+			if ctx != -1 {
+				log.Println("Warning: ignoring option ["+CMD_LINE_ARGS[ctx]+"] with no value.", verbose > 0)
+			}
+
+			dictName = strings.TrimSpace(strings.TrimPrefix(arg, "--dict="))
+			ctx = -1
+			continue
+		}
+
+		if strings.HasPrefix(arg, "--passphrase=") {
+			if ctx != -1 {
+				log.Println("Warning: ignoring option ["+CMD_LINE_ARGS[ctx]+"] with no value.", verbose > 0)
+			}
+
+			passphrase = strings.TrimPrefix(arg, "--passphrase=")
+			ctx = -1
+			continue
+		}
+
+		if strings.HasPrefix(arg, "--key-file=") {
+			if ctx != -1 {
+				log.Println("Warning: ignoring option ["+CMD_LINE_ARGS[ctx]+"] with no value.", verbose > 0)
+			}
+
+			keyName = strings.TrimSpace(strings.TrimPrefix(arg, "--key-file="))
+			ctx = -1
+			continue
+		}
+
+		if strings.HasPrefix(arg, "--dict-size=") {
+			if ctx != -1 {
+				log.Println("Warning: ignoring option ["+CMD_LINE_ARGS[ctx]+"] with no value.", verbose > 0)
+			}
+
+			size, err := parseSize(strings.TrimPrefix(arg, "--dict-size="))
+
+			if err != nil || size < kio.MIN_DICTIONARY_SIZE || size > kio.MAX_DICTIONARY_SIZE {
+				fmt.Printf("Invalid dictionary size provided on command line: %v\n", arg)
+				os.Exit(kanzi.ERR_INVALID_PARAM)
+			}
+
+			dictSize = int(size)
+			ctx = -1
+			continue
+		}
+
+		if strings.HasPrefix(arg, "--max-block=") || strings.HasPrefix(arg, "--max-memory=") ||
+			strings.HasPrefix(arg, "--max-output=") {
+			if ctx != -1 {
+				log.Println("Warning: ignoring option ["+CMD_LINE_ARGS[ctx]+"] with no value.", verbose > 0)
+			}
+
+			idx := strings.Index(arg, "=")
+			limit, err := parseSize(arg[idx+1:])
+
+			if err != nil || limit == 0 {
+				fmt.Printf("Invalid limit provided on command line: %v\n", arg)
+				os.Exit(kanzi.ERR_INVALID_PARAM)
+			}
+
+			switch arg[0:idx] {
+			case "--max-block":
+				maxBlockSize = limit
+			case "--max-memory":
+				maxMemory = limit
+			default:
+				maxOutputSize = limit
+			}
+
+			ctx = -1
+			continue
+		}
+
 		if ctx == -1 {
 			idx := -1
 
@@ -561,7 +1072,7 @@ func processCommandLine(args []string, argsMap map[string]interface{}) {
 	argsMap["inputName"] = inputName
 	argsMap["outputName"] = outputName
 
-	if mode == "c" {
+	if mode == "c" || mode == "b" {
 		argsMap["level"] = level
 	}
 
@@ -577,10 +1088,145 @@ func processCommandLine(args []string, argsMap map[string]interface{}) {
 		argsMap["checksum"] = checksum
 	}
 
+	if len(checksumType) > 0 {
+		argsMap["checksumType"] = checksumType
+	}
+
+	if len(checksumKey) > 0 {
+		key, err := hex.DecodeString(checksumKey)
+
+		if err != nil || len(key) != kio.SIPHASH_KEY_SIZE {
+			fmt.Printf("Invalid checksum key provided on command line (%d hexadecimal digits expected)\n", 2*kio.SIPHASH_KEY_SIZE)
+			os.Exit(kanzi.ERR_INVALID_PARAM)
+		}
+
+		argsMap["checksumKey"] = key
+	}
+
+	if len(dictName) > 0 {
+		if mode == "t" {
+			log.Println("Warning: ignoring option [--dict] in training mode", verbose > 0)
+		} else {
+			argsMap["dictionary"] = dictName
+		}
+	}
+
+	if mode == "t" {
+		argsMap["dictSize"] = dictSize
+	}
+
+	if len(passphrase) > 0 && len(keyName) > 0 {
+		fmt.Println("Both passphrase and key file options were provided.")
+		os.Exit(kanzi.ERR_INVALID_PARAM)
+	}
+
+	if len(passphrase) > 0 {
+		argsMap["passphrase"] = passphrase
+	}
+
+	if len(keyName) > 0 {
+		argsMap["keyFile"] = keyName
+	}
+
 	if skip == true {
 		argsMap["skipBlocks"] = skip
 	}
 
+	if parityBlocks > 0 {
+		if mode == "c" {
+			argsMap["parityGroupSize"] = parityGroupSize
+			argsMap["parityBlocks"] = parityBlocks
+		} else {
+			log.Println("Warning: ignoring option [--parity] (compression only)", verbose > 0)
+		}
+	}
+
+	if chunkSizes != nil {
+		if mode == "c" {
+			argsMap["chunkSize"] = uint(chunkSizes[0])
+			argsMap["minChunkSize"] = uint(chunkSizes[1])
+			argsMap["maxChunkSize"] = uint(chunkSizes[2])
+		} else {
+			log.Println("Warning: ignoring option [--chunk] (compression only)", verbose > 0)
+		}
+	}
+
+	if archive == true {
+		if mode == "c" {
+			argsMap["archive"] = archive
+		} else {
+			log.Println("Warning: ignoring option [--archive] (compression only)", verbose > 0)
+		}
+	}
+
+	if solid == true {
+		if mode == "c" {
+			argsMap["solid"] = solid
+		} else {
+			log.Println("Warning: ignoring option [--solid] (compression only)", verbose > 0)
+		}
+	}
+
+	if mode == "l" {
+		argsMap["list"] = true
+	}
+
+	if mode == "x" {
+		argsMap["extract"] = entryName
+	}
+
+	if blockIndex == true {
+		argsMap["blockIndex"] = blockIndex
+	}
+
+	if independent == true {
+		argsMap["independentBlocks"] = independent
+	}
+
+	if singleFrame == true {
+		argsMap["singleFrame"] = singleFrame
+	}
+
+	if mode == "v" {
+		argsMap["test"] = true
+	}
+
+	if mode == "b" {
+		argsMap["bench"] = benchList
+	}
+
+	if jsonOutput == true {
+		if mode == "b" {
+			argsMap["json"] = jsonOutput
+		} else {
+			log.Println("Warning: ignoring option [--json] (benchmark only)", verbose > 0)
+		}
+	}
+
+	if recovery == true {
+		if mode == "v" {
+			log.Println("Warning: ignoring option [--recover] in test mode", verbose > 0)
+		} else {
+			argsMap["recover"] = recovery
+		}
+	}
+
+	if progress == true {
+		argsMap["progress"] = progress
+	}
+
+	if maxBlockSize > 0 {
+		argsMap["maxBlockSize"] = maxBlockSize
+	}
+
+	if maxMemory > 0 {
+		argsMap["maxMemory"] = maxMemory
+	}
+
+	if maxOutputSize > 0 {
+		argsMap["maxOutputSize"] = maxOutputSize
+	}
+
 	argsMap["jobs"] = uint(tasks)
 
 	if len(cpuProf) > 0 {
@@ -588,6 +1234,62 @@ func processCommandLine(args []string, argsMap map[string]interface{}) {
 	}
 }
 
+// Return the mode selected by a command line argument (empty if the argument
+// does not select a mode)
+func getMode(arg string) string {
+	switch {
+	case arg == "--compress" || arg == "-c":
+		return "c"
+
+	case arg == "--decompress" || arg == "-d":
+		return "d"
+
+	case arg == "--train":
+		return "t"
+
+	case arg == "--info":
+		return "i"
+
+	case arg == "--test":
+		return "v"
+
+	case arg == "--list":
+		return "l"
+
+	case arg == "--bench" || strings.HasPrefix(arg, "--bench="):
+		return "b"
+
+	case arg == "--extract" || strings.HasPrefix(arg, "--extract="):
+		return "x"
+	}
+
+	return ""
+}
+
+// Parse a size with an optional K, M or G suffix
+func parseSize(str string) (uint64, error) {
+	str = strings.ToUpper(str)
+	scale := uint64(1)
+
+	if len(str) > 0 {
+		switch str[len(str)-1] {
+		case 'K':
+			scale = 1024
+		case 'M':
+			scale = 1024 * 1024
+		case 'G':
+			scale = 1024 * 1024 * 1024
+		}
+
+		if scale > 1 {
+			str = str[0 : len(str)-1]
+		}
+	}
+
+	val, err := strconv.ParseUint(str, 10, 64)
+	return scale * val, err
+}
+
 type FileData struct {
 	Path string
 	Size int64
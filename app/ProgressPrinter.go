@@ -0,0 +1,139 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	kanzi "github.com/flanglet/kanzi-go"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An implementation of Listener to display a live progress line (progress
+// option of the BlockCompressor/BlockDecompressor). The line is redrawn in
+// place, at most every PROGRESS_REFRESH_INTERVAL.
+
+const PROGRESS_REFRESH_INTERVAL = 200 * time.Millisecond
+
+type ProgressPrinter struct {
+	writer    io.Writer
+	name      string
+	last      *kanzi.Event // last progress event (displayed by Done)
+	lastTime  time.Time    // time of the last display
+	lineWidth int
+	lock      sync.Mutex
+}
+
+func NewProgressPrinter(name string, writer io.Writer) (*ProgressPrinter, error) {
+	if writer == nil {
+		return nil, errors.New("Invalid null writer parameter")
+	}
+
+	this := new(ProgressPrinter)
+	this.writer = writer
+	this.name = name
+	return this, nil
+}
+
+func (this *ProgressPrinter) ProcessEvent(evt *kanzi.Event) {
+	if evt.Type() != kanzi.EVT_PROGRESS {
+		return
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.last = evt
+
+	if evt.Time().Sub(this.lastTime) >= PROGRESS_REFRESH_INTERVAL {
+		this.display(evt)
+	}
+}
+
+// Display the last progress event and terminate the line
+func (this *ProgressPrinter) Done() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.last == nil {
+		return
+	}
+
+	this.display(this.last)
+	fmt.Fprintln(this.writer)
+	this.last = nil
+}
+
+func (this *ProgressPrinter) display(evt *kanzi.Event) {
+	line := fmt.Sprintf("%s: ", this.name)
+
+	if evt.Total() > 0 {
+		percent := evt.Size() * 100 / evt.Total()
+
+		if percent > 100 {
+			percent = 100
+		}
+
+		line += fmt.Sprintf("%3d%% %s / %s", percent, formatSize(evt.Size()), formatSize(evt.Total()))
+	} else {
+		line += formatSize(evt.Size())
+	}
+
+	line += fmt.Sprintf(" => %s, %s/s", formatSize(evt.Output()), formatSize(int64(evt.Throughput())))
+
+	if eta := evt.ETA(); eta >= 0 {
+		line += fmt.Sprintf(", ETA %s", formatDuration(eta))
+	}
+
+	// Erase the end of the previous line (if longer)
+	width := len(line)
+
+	if width < this.lineWidth {
+		line += strings.Repeat(" ", this.lineWidth-width)
+	}
+
+	this.lineWidth = width
+	this.lastTime = evt.Time()
+	fmt.Fprint(this.writer, "\r"+line)
+}
+
+func formatSize(size int64) string {
+	if size < 1024 {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	if size < 1024*1024 {
+		return fmt.Sprintf("%.1f KB", float64(size)/1024)
+	}
+
+	if size < 1024*1024*1024 {
+		return fmt.Sprintf("%.1f MB", float64(size)/(1024*1024))
+	}
+
+	return fmt.Sprintf("%.2f GB", float64(size)/(1024*1024*1024))
+}
+
+func formatDuration(d time.Duration) string {
+	s := int64(d.Seconds() + 0.5)
+
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, (s/60)%60, s%60)
+	}
+
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
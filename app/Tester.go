@@ -0,0 +1,334 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	kanzi "github.com/flanglet/kanzi-go"
+	kio "github.com/flanglet/kanzi-go/io"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	TEST_FILE_EXTENSION = ".knz" // files tested when the input is a directory
+)
+
+// Integrity test (--test option): the input files are fully decoded but
+// nothing is written. The decoder verifies the block checksums, the content
+// checksum and the block index (if present). The checksums of the entries
+// of an archive are verified as well.
+type FileTestResult struct {
+	code     int
+	msg      string // message of the error (if any)
+	blockId  int    // block of the error (0 if unknown)
+	offset   int64  // offset of the error in the input (-1 if unknown)
+	read     uint64 // compressed size
+	written  uint64 // decompressed size
+	entries  int    // number of archive entries verified
+	repaired int32  // number of blocks rebuilt with the parity blocks
+}
+
+type FileTestTask struct {
+	ctx       map[string]interface{}
+	listeners []kanzi.Listener
+	goCtx     context.Context
+}
+
+// Test the input files (in parallel if there are several jobs) and print
+// one line per file. Only the failures are printed if the verbosity is 0.
+// Return the exit code of the first file that failed (in the order of the
+// names) or 0.
+func (this *BlockDecompressor) callTest(goCtx context.Context) (int, uint64) {
+	before := time.Now()
+	var files []FileData
+
+	if strings.ToUpper(this.inputName) == "STDIN" {
+		files = []FileData{{Path: "stdin"}}
+	} else {
+		fi, err := os.Stat(this.inputName)
+
+		if err != nil {
+			fmt.Printf("Cannot access input file '%v': %v\n", this.inputName, err)
+			return kanzi.ERR_OPEN_FILE, 0
+		}
+
+		if files, err = createFileList(this.inputName, make([]FileData, 0, 256)); err != nil {
+			fmt.Printf("Cannot access input file '%v': %v\n", this.inputName, err)
+			return kanzi.ERR_OPEN_FILE, 0
+		}
+
+		if fi.IsDir() == true {
+			// Skip the files that are not compressed
+			n := 0
+
+			for _, f := range files {
+				if strings.HasSuffix(f.Path, TEST_FILE_EXTENSION) == true {
+					files[n] = f
+					n++
+				}
+			}
+
+			files = files[0:n]
+		}
+	}
+
+	nbFiles := len(files)
+
+	if nbFiles == 0 {
+		fmt.Printf("Cannot find any file to test in '%v'\n", this.inputName)
+		return kanzi.ERR_OPEN_FILE, 0
+	}
+
+	sort.Sort(FileCompareByName{data: files})
+	ctx := this.newContext()
+
+	if nbFiles > 1 {
+		// Concurrent processing of the files: no progress line, no details
+		ctx["progress"] = false
+		ctx["verbosity"] = uint(0)
+	}
+
+	jobs := this.jobs
+
+	if jobs > uint(nbFiles) {
+		jobs = uint(nbFiles)
+	}
+
+	jobsPerTask := kanzi.ComputeJobsPerTask(make([]uint, nbFiles), this.jobs, uint(nbFiles))
+	tasks := make(chan int, nbFiles)
+	results := make([]FileTestResult, nbFiles)
+
+	for i := range files {
+		tasks <- i
+	}
+
+	close(tasks)
+	var wg sync.WaitGroup
+
+	// Each worker tests several files sequentially
+	for j := uint(0); j < jobs; j++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range tasks {
+				taskCtx := make(map[string]interface{})
+
+				for k, v := range ctx {
+					taskCtx[k] = v
+				}
+
+				taskCtx["inputName"] = files[i].Path
+				taskCtx["jobs"] = jobsPerTask[i]
+				task := FileTestTask{ctx: taskCtx, listeners: this.listeners, goCtx: goCtx}
+				results[i] = task.Call()
+			}
+		}()
+	}
+
+	wg.Wait()
+	res := 0
+	failed := 0
+	written := uint64(0)
+
+	for i, r := range results {
+		written += r.written
+
+		if r.code == 0 {
+			msg := fmt.Sprintf("%v: OK", files[i].Path)
+
+			if r.entries > 0 {
+				msg += fmt.Sprintf(" (archive: %d file(s))", r.entries)
+			}
+
+			if r.repaired > 0 {
+				msg += fmt.Sprintf(" (%d block(s) repaired with the parity blocks)", r.repaired)
+			}
+
+			if this.verbosity > 1 {
+				msg += fmt.Sprintf(" %d => %d bytes", r.read, r.written)
+			}
+
+			log.Println(msg, this.verbosity > 0)
+			continue
+		}
+
+		failed++
+
+		if res == 0 {
+			res = r.code
+		}
+
+		msg := fmt.Sprintf("%v: FAILED", files[i].Path)
+
+		if r.blockId > 0 {
+			msg += fmt.Sprintf(" at block %d", r.blockId)
+		}
+
+		if r.offset >= 0 {
+			msg += fmt.Sprintf(" (offset %d)", r.offset)
+		}
+
+		msg += fmt.Sprintf(": %v (code %d)", r.msg, r.code)
+		log.Println(msg, true)
+	}
+
+	delta := time.Now().Sub(before).Nanoseconds() / 1000000 // convert to ms
+	msg := fmt.Sprintf("%d file(s) tested, %d failed in %d ms", nbFiles, failed, delta)
+	log.Println(msg, this.verbosity > 0)
+	return res, written
+}
+
+// Decode one file and discard the output
+func (this *FileTestTask) Call() (res FileTestResult) {
+	res.offset = -1
+	inputName := this.ctx["inputName"].(string)
+	verbosity := this.ctx["verbosity"].(uint)
+
+	fail := func(err error) FileTestResult {
+		res.code = kanzi.ErrorCode(err)
+		res.msg = err.Error()
+		var ioerr *kio.IOError
+
+		if errors.As(err, &ioerr) == true {
+			res.msg = ioerr.Message()
+			res.blockId = ioerr.BlockId()
+			res.offset = ioerr.Offset()
+		}
+
+		return res
+	}
+
+	// Do not start a new file once cancelled
+	if this.goCtx.Err() != nil {
+		return fail(kio.NewIOError("Cancelled", kanzi.ERR_CANCELLED))
+	}
+
+	var input io.ReadCloser
+
+	if strings.ToUpper(inputName) == "STDIN" {
+		input = os.Stdin
+	} else {
+		var err error
+
+		if input, err = os.Open(inputName); err != nil {
+			return fail(kio.WrapIOError(err, "Cannot open input file", kanzi.ERR_OPEN_FILE))
+		}
+
+		defer input.Close()
+	}
+
+	log.Println("\nTesting "+inputName+" ...", verbosity > 1)
+	cis, err := kio.NewCompressedInputStreamWithContext(this.goCtx, input, this.ctx)
+
+	if err != nil {
+		return fail(err)
+	}
+
+	defer cis.Close()
+
+	for _, bl := range this.listeners {
+		cis.AddListener(bl)
+	}
+
+	if this.ctx["progress"].(bool) == true {
+		if progress, err := NewProgressPrinter(inputName, os.Stderr); err == nil {
+			cis.AddListener(progress)
+			defer progress.Done()
+		}
+	}
+
+	damages := &DamageReporter{verbosity: verbosity}
+	cis.AddListener(damages)
+	md, err := cis.Metadata()
+
+	if err != nil {
+		return fail(err)
+	}
+
+	var verifier *kio.ArchiveVerifier
+
+	// The entries of an archive are located with the central directory
+	// (random access, the input must be a file)
+	if _, isArchive := md.GetInt(kio.METADATA_KEY_ARCHIVE); isArchive == true && input != os.Stdin {
+		dirInput, err := os.Open(inputName)
+
+		if err != nil {
+			return fail(kio.WrapIOError(err, "Cannot open input file", kanzi.ERR_OPEN_FILE))
+		}
+
+		ar, err := kio.NewArchiveReaderWithContext(this.goCtx, dirInput, this.ctx)
+
+		if err != nil {
+			dirInput.Close()
+			return fail(err)
+		}
+
+		verifier = kio.NewArchiveVerifier(ar.Entries())
+		ar.Close()
+		dirInput.Close()
+	}
+
+	buffer := make([]byte, DECOMP_DEFAULT_BUFFER_SIZE)
+
+	for {
+		decoded, err := cis.Read(buffer)
+
+		if decoded > 0 {
+			if verifier != nil {
+				if _, err := verifier.Write(buffer[0:decoded]); err != nil {
+					return fail(err)
+				}
+			}
+
+			res.written += uint64(decoded)
+		}
+
+		if err != nil {
+			return fail(err)
+		}
+
+		if decoded == 0 {
+			break
+		}
+	}
+
+	res.read = cis.GetRead()
+
+	if err = cis.Close(); err != nil {
+		return fail(err)
+	}
+
+	if verifier != nil {
+		if err = verifier.Close(); err != nil {
+			return fail(err)
+		}
+
+		res.entries = verifier.Verified()
+	}
+
+	res.repaired = atomic.LoadInt32(&damages.repaired)
+	return res
+}
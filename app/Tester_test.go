@@ -0,0 +1,177 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	kanzi "github.com/flanglet/kanzi-go"
+	kio "github.com/flanglet/kanzi-go/io"
+)
+
+// Compressible data
+func testContent(size int, seed int64) []byte {
+	words := []string{"kanzi", "block", "stream", "entropy", "transform", "\n", " "}
+	rnd := rand.New(rand.NewSource(seed))
+	buf := make([]byte, 0, size+16)
+
+	for len(buf) < size {
+		buf = append(buf, words[rnd.Intn(len(words))]...)
+	}
+
+	return buf[0:size]
+}
+
+// Write a compressed file
+func writeCompressed(t *testing.T, name string, data []byte) {
+	t.Helper()
+	f, err := os.Create(name)
+
+	if err != nil {
+		t.Fatalf("Cannot create file: %v", err)
+	}
+
+	cos, err := kio.NewCompressedOutputStreamWithOptions(f, kio.Options{Level: 2, BlockSize: 16 * 1024, Checksum: true})
+
+	if err != nil {
+		t.Fatalf("Cannot create compressed stream: %v", err)
+	}
+
+	if _, err = cos.Write(data); err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+
+	if err = cos.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+}
+
+// Write an archive
+func writeTestArchive(t *testing.T, name string, files [][]byte) {
+	t.Helper()
+	f, err := os.Create(name)
+
+	if err != nil {
+		t.Fatalf("Cannot create file: %v", err)
+	}
+
+	aw, err := kio.NewArchiveWriterWithOptions(f, kio.Options{Level: 1})
+
+	if err != nil {
+		t.Fatalf("Cannot create archive: %v", err)
+	}
+
+	for i, data := range files {
+		entry := kio.ArchiveEntry{Name: filepath.Join("dir", string(rune('a'+i))), Mode: 0644, ModTime: time.Now()}
+
+		if err = aw.Add(entry, bytes.NewReader(data)); err != nil {
+			t.Fatalf("Cannot add entry: %v", err)
+		}
+	}
+
+	if err = aw.Close(); err != nil {
+		t.Fatalf("Cannot close archive: %v", err)
+	}
+}
+
+func testFile(name string) FileTestResult {
+	ctx := map[string]interface{}{"inputName": name, "jobs": uint(2), "verbosity": uint(0), "progress": false}
+	task := FileTestTask{ctx: ctx, goCtx: context.Background()}
+	return task.Call()
+}
+
+func newTester(t *testing.T, input string) *BlockDecompressor {
+	t.Helper()
+	argsMap := map[string]interface{}{"inputName": input, "outputName": "none", "jobs": uint(4),
+		"verbose": uint(0), "test": true}
+	bd, err := NewBlockDecompressor(argsMap)
+
+	if err != nil {
+		t.Fatalf("Cannot create decompressor: %v", err)
+	}
+
+	return bd
+}
+
+func TestIntegrity(t *testing.T) {
+	dir := t.TempDir()
+	data := testContent(100000, 191)
+	writeCompressed(t, filepath.Join(dir, "a.knz"), data)
+	writeCompressed(t, filepath.Join(dir, "b.knz"), data[0:5000])
+	writeTestArchive(t, filepath.Join(dir, "c.knz"), [][]byte{data[0:30000], data[30000:31000], {}})
+
+	// Not compressed, skipped
+	if err := os.WriteFile(filepath.Join(dir, "d.txt"), data, 0644); err != nil {
+		t.Fatalf("Cannot write file: %v", err)
+	}
+
+	if res := testFile(filepath.Join(dir, "a.knz")); res.code != 0 || res.written != uint64(len(data)) || res.read == 0 {
+		t.Fatalf("Valid file: unexpected result %+v", res)
+	}
+
+	if res := testFile(filepath.Join(dir, "c.knz")); res.code != 0 || res.entries != 3 {
+		t.Fatalf("Valid archive: unexpected result %+v", res)
+	}
+
+	if code, written := newTester(t, dir).callTest(context.Background()); code != 0 || written < uint64(len(data)+5000) {
+		t.Fatalf("Valid files: unexpected result: code %d, %d bytes", code, written)
+	}
+
+	// Damaged block
+	name := filepath.Join(dir, "b.knz")
+	stream, _ := os.ReadFile(filepath.Join(dir, "a.knz"))
+	stream[len(stream)/2] ^= 0x10
+
+	if err := os.WriteFile(name, stream, 0644); err != nil {
+		t.Fatalf("Cannot write file: %v", err)
+	}
+
+	if res := testFile(name); res.code == 0 || res.blockId <= 1 || res.offset <= 0 {
+		t.Fatalf("Damaged file: unexpected result %+v", res)
+	}
+
+	if res := testFile(filepath.Join(dir, "missing.knz")); res.code != kanzi.ERR_OPEN_FILE {
+		t.Fatalf("Missing file: expected ERR_OPEN_FILE, got %+v", res)
+	}
+
+	// The first failure gives the exit code
+	if code, _ := newTester(t, dir).callTest(context.Background()); code == 0 {
+		t.Fatalf("Damaged file: expected an error code")
+	}
+
+	if code, _ := newTester(t, filepath.Join(dir, "d.txt")).callTest(context.Background()); code == 0 {
+		t.Fatalf("File not compressed: expected an error code")
+	}
+
+	if code, _ := newTester(t, t.TempDir()).callTest(context.Background()); code != kanzi.ERR_OPEN_FILE {
+		t.Fatalf("No file to test: expected ERR_OPEN_FILE, got %d", code)
+	}
+
+	// Cancelled before the start
+	goCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	task := FileTestTask{ctx: map[string]interface{}{"inputName": name, "verbosity": uint(0)}, goCtx: goCtx}
+
+	if res := task.Call(); res.code != kanzi.ERR_CANCELLED {
+		t.Fatalf("Cancelled: expected ERR_CANCELLED, got %+v", res)
+	}
+}
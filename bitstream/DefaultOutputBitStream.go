@@ -269,6 +269,24 @@ func (this *DefaultOutputBitStream) flush() error {
 	return nil
 }
 
+// Write all the complete bytes cached in memory to the underlying stream.
+// The bits of the last byte (if incomplete) remain in memory.
+func (this *DefaultOutputBitStream) Flush() error {
+	if this.Closed() {
+		return errors.New("Stream closed")
+	}
+
+	// Move the complete bytes of 'current' to the buffer
+	for this.availBits <= 56 {
+		this.buffer[this.position] = byte(this.current >> 56)
+		this.position++
+		this.current <<= 8
+		this.availBits += 8
+	}
+
+	return this.flush()
+}
+
 func (this *DefaultOutputBitStream) Close() (bool, error) {
 	if this.Closed() {
 		return true, nil
@@ -278,10 +296,14 @@ func (this *DefaultOutputBitStream) Close() (bool, error) {
 	savedPosition := this.position
 	savedCurrent := this.current
 
-	// Push last bytes (the very last byte may be incomplete)
-	size := int((64-this.availBits)+7) >> 3
-	this.pushCurrent()
-	this.position -= (8 - size)
+	// Push last bytes (the very last byte may be incomplete). Do not push
+	// the whole 64 bits: a full buffer would be flushed with the padding.
+	for this.availBits < 64 {
+		this.buffer[this.position] = byte(this.current >> 56)
+		this.position++
+		this.current <<= 8
+		this.availBits += 8
+	}
 
 	if err := this.flush(); err != nil {
 		// Revert fields to allow subsequent attempts in case of transient failure
@@ -0,0 +1,123 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package compress
+
+import (
+	kanzi "github.com/flanglet/kanzi-go"
+	kio "github.com/flanglet/kanzi-go/io"
+	"io"
+)
+
+// Reader decompresses the data read from the underlying reader (see
+// NewReader). Read returns io.EOF at the end of the data. The underlying
+// reader is not closed by Close.
+type Reader struct {
+	cis  *kio.CompressedInputStream
+	opts kio.Options
+	err  error
+}
+
+// Return a Reader with the default decoding options
+func NewReader(r io.Reader) (*Reader, error) {
+	return NewReaderOptions(r, kio.Options{})
+}
+
+// Return a Reader with the provided decoding options (EG. jobs or resource
+// limits)
+func NewReaderOptions(r io.Reader, opts kio.Options) (*Reader, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	z := &Reader{opts: opts}
+
+	if err := z.Reset(r); err != nil {
+		return nil, err
+	}
+
+	return z, nil
+}
+
+// Discard the state of the Reader and make it read from r with the same
+// options. The memory allocated by the previous stream is reused.
+func (this *Reader) Reset(r io.Reader) error {
+	if r == nil {
+		this.err = kio.NewIOError("Invalid null reader parameter", kanzi.ERR_CREATE_STREAM)
+		return this.err
+	}
+
+	if this.cis != nil {
+		this.cis.Close()
+		this.err = this.cis.Reset(&readCloser{r: r})
+		return this.err
+	}
+
+	this.cis, this.err = kio.NewCompressedInputStreamWithOptions(&readCloser{r: r}, this.opts)
+	return this.err
+}
+
+func (this *Reader) Read(p []byte) (int, error) {
+	if this.err != nil {
+		return 0, this.err
+	}
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	n, err := this.cis.Read(p)
+
+	if err != nil {
+		this.err = err
+		return n, err
+	}
+
+	// The stream returns 0 bytes at the end of the data
+	if n == 0 {
+		this.err = io.EOF
+	}
+
+	return n, this.err
+}
+
+// Release the resources of the Reader (except the memory kept for Reset).
+// Close does not close the underlying reader.
+func (this *Reader) Close() error {
+	if this.cis == nil {
+		return nil
+	}
+
+	err := this.cis.Close()
+
+	if this.err == nil || this.err == io.EOF {
+		this.err = kio.NewIOError("Stream closed", kanzi.ERR_READ_FILE)
+	}
+
+	return err
+}
+
+// Adapt an io.Reader to the compressed stream. Close is a no-op.
+type readCloser struct {
+	r io.Reader
+}
+
+func (this *readCloser) Read(p []byte) (int, error) {
+	return this.r.Read(p)
+}
+
+func (this *readCloser) Close() error {
+	return nil
+}
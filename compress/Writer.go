@@ -0,0 +1,171 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package compress provides a Writer and a Reader with the signatures of the
+// standard compress/* packages (EG. compress/gzip) on top of the compressed
+// streams of package io. The levels are the levels of the command line.
+package compress
+
+import (
+	"fmt"
+	kanzi "github.com/flanglet/kanzi-go"
+	kio "github.com/flanglet/kanzi-go/io"
+	"io"
+)
+
+const (
+	NoCompression      = 0
+	BestSpeed          = 1
+	BestCompression    = kio.MAX_LEVEL
+	DefaultCompression = -1 // BWT+RANK+ZRLT & ANS0, as the command line
+)
+
+var errClosed = kio.NewIOError("Stream closed", kanzi.ERR_WRITE_FILE)
+
+// Writer compresses the data written to it (see NewWriter). The underlying
+// writer is not closed by Close.
+type Writer struct {
+	cos  *kio.CompressedOutputStream
+	opts kio.Options
+	err  error
+}
+
+// Return a Writer with the default compression level
+func NewWriter(w io.Writer) *Writer {
+	z, _ := NewWriterLevel(w, DefaultCompression)
+	return z
+}
+
+// Return a Writer with the provided compression level, either
+// DefaultCompression or in [NoCompression..BestCompression]
+func NewWriterLevel(w io.Writer, level int) (*Writer, error) {
+	if level < DefaultCompression || level > BestCompression {
+		errMsg := fmt.Sprintf("Invalid compression level: %d (must be in [%d..%d])",
+			level, DefaultCompression, BestCompression)
+		return nil, kio.NewIOError(errMsg, kanzi.ERR_INVALID_PARAM)
+	}
+
+	opts := kio.Options{Level: level}
+
+	if level == DefaultCompression {
+		opts = kio.Options{Transform: "BWT+RANK+ZRLT", Codec: "ANS0"}
+	}
+
+	return NewWriterOptions(w, opts)
+}
+
+// Return a Writer with the provided stream options (EG. jobs or checksum)
+func NewWriterOptions(w io.Writer, opts kio.Options) (*Writer, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	z := &Writer{opts: opts}
+	z.Reset(w)
+
+	if z.err != nil {
+		return nil, z.err
+	}
+
+	return z, nil
+}
+
+// Discard the state of the Writer and make it write to w with the same
+// options (the current stream is not closed). The memory allocated by the
+// previous stream is reused.
+func (this *Writer) Reset(w io.Writer) {
+	this.err = nil
+
+	if w == nil {
+		this.err = kio.NewIOError("Invalid null writer parameter", kanzi.ERR_CREATE_STREAM)
+		return
+	}
+
+	if this.cos != nil {
+		this.err = this.cos.Reset(&writeCloser{w: w})
+		return
+	}
+
+	this.cos, this.err = kio.NewCompressedOutputStreamWithOptions(&writeCloser{w: w}, this.opts)
+}
+
+func (this *Writer) Write(p []byte) (int, error) {
+	if this.err != nil {
+		return 0, this.err
+	}
+
+	n, err := this.cos.Write(p)
+
+	if err != nil {
+		this.err = err
+	}
+
+	return n, err
+}
+
+// Write the pending data to the underlying writer (see
+// CompressedOutputStream.Flush)
+func (this *Writer) Flush() error {
+	if this.err != nil {
+		return this.err
+	}
+
+	if err := this.cos.Flush(); err != nil {
+		this.err = err
+	}
+
+	return this.err
+}
+
+// Write the pending data and the end of the stream
+func (this *Writer) Close() error {
+	if this.err == errClosed {
+		return nil
+	}
+
+	if this.err != nil {
+		return this.err
+	}
+
+	if err := this.cos.Close(); err != nil {
+		this.err = err
+		return err
+	}
+
+	this.err = errClosed
+	return nil
+}
+
+// Adapt an io.Writer to the compressed stream. Close is a no-op.
+type writeCloser struct {
+	w io.Writer
+}
+
+func (this *writeCloser) Write(p []byte) (int, error) {
+	return this.w.Write(p)
+}
+
+// Flush the underlying writer if possible (EG. bufio.Writer)
+func (this *writeCloser) Flush() error {
+	if f, isFlusher := this.w.(interface{ Flush() error }); isFlusher == true {
+		return f.Flush()
+	}
+
+	return nil
+}
+
+func (this *writeCloser) Close() error {
+	return nil
+}
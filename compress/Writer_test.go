@@ -0,0 +1,196 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package compress
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	kanzi "github.com/flanglet/kanzi-go"
+	kio "github.com/flanglet/kanzi-go/io"
+)
+
+func testData(size int) []byte {
+	text := "The quick brown fox jumps over the lazy dog. 0123456789\n"
+	return []byte(strings.Repeat(text, size/len(text)+1)[0:size])
+}
+
+func TestWriterLevels(t *testing.T) {
+	data := testData(100000)
+
+	for level := DefaultCompression; level <= BestCompression; level++ {
+		var buf bytes.Buffer
+		w, err := NewWriterLevel(&buf, level)
+
+		if err != nil {
+			t.Fatalf("Level %d: cannot create writer: %v", level, err)
+		}
+
+		if _, err = io.Copy(w, bytes.NewReader(data)); err != nil {
+			t.Fatalf("Level %d: write error: %v", level, err)
+		}
+
+		if err = w.Close(); err != nil {
+			t.Fatalf("Level %d: close error: %v", level, err)
+		}
+
+		// Close can be called several times, Write fails after Close
+		if err = w.Close(); err != nil {
+			t.Fatalf("Level %d: second close error: %v", level, err)
+		}
+
+		if _, err = w.Write(data); err == nil {
+			t.Fatalf("Level %d: no error for a write after close", level)
+		}
+
+		// A bytes.Buffer is not seekable
+		r, err := NewReader(&buf)
+
+		if err != nil {
+			t.Fatalf("Level %d: cannot create reader: %v", level, err)
+		}
+
+		output, err := io.ReadAll(r)
+
+		if err != nil || bytes.Equal(output, data) == false {
+			t.Fatalf("Level %d: round trip failed: %v", level, err)
+		}
+
+		// io.EOF is returned until Reset
+		if n, err := r.Read(make([]byte, 10)); n != 0 || err != io.EOF {
+			t.Fatalf("Level %d: expected io.EOF, got %d bytes, %v", level, n, err)
+		}
+
+		r.Close()
+	}
+
+	for _, level := range []int{DefaultCompression - 1, BestCompression + 1} {
+		if _, err := NewWriterLevel(&bytes.Buffer{}, level); kanzi.ErrorCode(err) != kanzi.ERR_INVALID_PARAM {
+			t.Fatalf("Level %d: expected ERR_INVALID_PARAM, got %v", level, err)
+		}
+	}
+}
+
+func TestWriterReset(t *testing.T) {
+	inputs := [][]byte{testData(70000), testData(10), nil, testData(300000)}
+	w, err := NewWriterOptions(&bytes.Buffer{}, kio.Options{Level: 3, BlockSize: 64 * 1024, Jobs: 2, Checksum: true})
+
+	if err != nil {
+		t.Fatalf("Cannot create writer: %v", err)
+	}
+
+	r, err := NewReaderOptions(bytes.NewReader(nil), kio.Options{Jobs: 2})
+
+	if err != nil {
+		t.Fatalf("Cannot create reader: %v", err)
+	}
+
+	for i, data := range inputs {
+		var buf bytes.Buffer
+		w.Reset(&buf)
+
+		if _, err = w.Write(data); err == nil {
+			err = w.Close()
+		}
+
+		if err != nil {
+			t.Fatalf("Input %d: compression error: %v", i, err)
+		}
+
+		if err = r.Reset(&buf); err != nil {
+			t.Fatalf("Input %d: reset error: %v", i, err)
+		}
+
+		if output, err := io.ReadAll(r); err != nil || bytes.Equal(output, data) == false {
+			t.Fatalf("Input %d: round trip failed: %v", i, err)
+		}
+	}
+
+	w.Reset(nil)
+
+	if _, err = w.Write(inputs[0]); kanzi.ErrorCode(err) != kanzi.ERR_CREATE_STREAM {
+		t.Fatalf("Nil writer: expected ERR_CREATE_STREAM, got %v", err)
+	}
+
+	if err = r.Reset(nil); kanzi.ErrorCode(err) != kanzi.ERR_CREATE_STREAM {
+		t.Fatalf("Nil reader: expected ERR_CREATE_STREAM, got %v", err)
+	}
+}
+
+func TestWriterFlush(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	data := testData(5000)
+
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush error: %v", err)
+	}
+
+	// The data written so far can be decoded before Close
+	r, err := NewReader(bytes.NewReader(buf.Bytes()))
+
+	if err != nil {
+		t.Fatalf("Cannot create reader: %v", err)
+	}
+
+	output := make([]byte, len(data))
+
+	if _, err = io.ReadFull(r, output); err != nil || bytes.Equal(output, data) == false {
+		t.Fatalf("Flushed data not decoded: %v", err)
+	}
+
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+}
+
+func TestReaderCorrupted(t *testing.T) {
+	var buf bytes.Buffer
+	w, _ := NewWriterOptions(&buf, kio.Options{Level: 2, Checksum: true})
+	w.Write(testData(100000))
+	w.Close()
+	compressed := buf.Bytes()
+	compressed[len(compressed)/2] ^= 0x20
+
+	r, err := NewReader(bytes.NewReader(compressed))
+
+	if err != nil {
+		t.Fatalf("Cannot create reader: %v", err)
+	}
+
+	if _, err = io.ReadAll(r); err == nil || err == io.EOF {
+		t.Fatalf("No error for a corrupted stream")
+	}
+
+	// The error is sticky
+	if _, err2 := r.Read(make([]byte, 10)); err2 != err {
+		t.Fatalf("Expected the same error, got %v", err2)
+	}
+
+	if r, err = NewReader(strings.NewReader("not a kanzi stream")); err != nil {
+		t.Fatalf("Cannot create reader: %v", err)
+	}
+
+	if _, err = io.ReadAll(r); kanzi.ErrorCode(err) != kanzi.ERR_INVALID_FILE {
+		t.Fatalf("Invalid stream: expected ERR_INVALID_FILE, got %v", err)
+	}
+}
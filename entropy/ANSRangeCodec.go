@@ -16,7 +16,6 @@ limitations under the License.
 package entropy
 
 import (
-	"errors"
 	"fmt"
 	kanzi "github.com/flanglet/kanzi-go"
 )
@@ -55,11 +54,11 @@ type ANSRangeEncoder struct {
 // chunkSize = 0 means 'use input buffer length' during decoding
 func NewANSRangeEncoder(bs kanzi.OutputBitStream, args ...uint) (*ANSRangeEncoder, error) {
 	if bs == nil {
-		return nil, errors.New("Invalid null bitstream parameter")
+		return nil, kanzi.NewError("Invalid null bitstream parameter", kanzi.ERR_INVALID_PARAM)
 	}
 
 	if len(args) > 3 {
-		return nil, errors.New("At most order, chunk size and log range can be provided")
+		return nil, kanzi.NewError("At most order, chunk size and log range can be provided", kanzi.ERR_INVALID_PARAM)
 	}
 
 	chkSize := DEFAULT_ANS0_CHUNK_SIZE
@@ -81,19 +80,19 @@ func NewANSRangeEncoder(bs kanzi.OutputBitStream, args ...uint) (*ANSRangeEncode
 	}
 
 	if order != 0 && order != 1 {
-		return nil, errors.New("The order must be 0 or 1")
+		return nil, kanzi.NewError("The order must be 0 or 1", kanzi.ERR_INVALID_PARAM)
 	}
 
 	if chkSize != 0 && chkSize < 1024 {
-		return nil, errors.New("The chunk size must be at least 1024")
+		return nil, kanzi.NewError("The chunk size must be at least 1024", kanzi.ERR_INVALID_PARAM)
 	}
 
 	if chkSize > ANS_MAX_CHUNK_SIZE {
-		return nil, errors.New("The chunk size must be at most 2^27")
+		return nil, kanzi.NewError("The chunk size must be at most 2^27", kanzi.ERR_INVALID_PARAM)
 	}
 
 	if logRange < 8 || logRange > 16 {
-		return nil, fmt.Errorf("Invalid range: %v (must be in [8..16])", logRange)
+		return nil, kanzi.NewError(fmt.Sprintf("Invalid range: %v (must be in [8..16])", logRange), kanzi.ERR_INVALID_PARAM)
 	}
 
 	this := new(ANSRangeEncoder)
@@ -203,7 +202,7 @@ func (this *ANSRangeEncoder) encodeHeader(alphabetSize int, alphabet []int, freq
 // Dynamically compute the frequencies for every chunk of data in the block
 func (this *ANSRangeEncoder) Encode(block []byte) (int, error) {
 	if block == nil {
-		return 0, errors.New("Invalid null block parameter")
+		return 0, kanzi.NewError("Invalid null block parameter", kanzi.ERR_INVALID_PARAM)
 	}
 
 	if len(block) == 0 {
@@ -432,11 +431,11 @@ type ANSRangeDecoder struct {
 // chunkSize = 0 means 'use input buffer length' during decoding
 func NewANSRangeDecoder(bs kanzi.InputBitStream, args ...uint) (*ANSRangeDecoder, error) {
 	if bs == nil {
-		return nil, errors.New("Invalid null bitstream parameter")
+		return nil, kanzi.NewError("Invalid null bitstream parameter", kanzi.ERR_INVALID_PARAM)
 	}
 
 	if len(args) > 2 {
-		return nil, errors.New("At most order and chunk size can be provided")
+		return nil, kanzi.NewError("At most order and chunk size can be provided", kanzi.ERR_INVALID_PARAM)
 	}
 
 	chkSize := DEFAULT_ANS0_CHUNK_SIZE
@@ -453,15 +452,15 @@ func NewANSRangeDecoder(bs kanzi.InputBitStream, args ...uint) (*ANSRangeDecoder
 	}
 
 	if order != 0 && order != 1 {
-		return nil, errors.New("The order must be 0 or 1")
+		return nil, kanzi.NewError("The order must be 0 or 1", kanzi.ERR_INVALID_PARAM)
 	}
 
 	if chkSize != 0 && chkSize < 1024 {
-		return nil, errors.New("The chunk size must be at least 1024")
+		return nil, kanzi.NewError("The chunk size must be at least 1024", kanzi.ERR_INVALID_PARAM)
 	}
 
 	if chkSize > ANS_MAX_CHUNK_SIZE {
-		return nil, errors.New("The chunk size must be at most 2^27")
+		return nil, kanzi.NewError("The chunk size must be at most 2^27", kanzi.ERR_INVALID_PARAM)
 	}
 
 	this := new(ANSRangeDecoder)
@@ -525,7 +524,7 @@ func (this *ANSRangeDecoder) decodeHeader(frequencies []int) (int, error) {
 			logMax := uint(1 + this.bitstream.ReadBits(llr))
 
 			if 1<<logMax > scale {
-				err := fmt.Errorf("Invalid bitstream: incorrect frequency size %v in ANS range decoder", logMax)
+				err := kanzi.NewError(fmt.Sprintf("Invalid bitstream: incorrect frequency size %v in ANS range decoder", logMax), kanzi.ERR_INVALID_FILE)
 				return alphabetSize, err
 			}
 
@@ -540,7 +539,7 @@ func (this *ANSRangeDecoder) decodeHeader(frequencies []int) (int, error) {
 				freq := int(this.bitstream.ReadBits(logMax))
 
 				if freq <= 0 || freq >= scale {
-					err := fmt.Errorf("Invalid bitstream: incorrect frequency %v for symbol '%v' in ANS range decoder", freq, alphabet[j])
+					err := kanzi.NewError(fmt.Sprintf("Invalid bitstream: incorrect frequency %v for symbol '%v' in ANS range decoder", freq, alphabet[j]), kanzi.ERR_INVALID_FILE)
 					return alphabetSize, err
 				}
 
@@ -551,7 +550,7 @@ func (this *ANSRangeDecoder) decodeHeader(frequencies []int) (int, error) {
 
 		// Infer first frequency
 		if scale <= sum {
-			err := fmt.Errorf("Invalid bitstream: incorrect frequency %v for symbol '%v' in ANS range decoder", frequencies[alphabet[0]], this.alphabet[0])
+			err := kanzi.NewError(fmt.Sprintf("Invalid bitstream: incorrect frequency %v for symbol '%v' in ANS range decoder", frequencies[alphabet[0]], this.alphabet[0]), kanzi.ERR_INVALID_FILE)
 			return alphabetSize, err
 		}
 
@@ -582,7 +581,7 @@ func (this *ANSRangeDecoder) decodeHeader(frequencies []int) (int, error) {
 
 func (this *ANSRangeDecoder) Decode(block []byte) (int, error) {
 	if block == nil {
-		return 0, errors.New("Invalid null block parameter")
+		return 0, kanzi.NewError("Invalid null block parameter", kanzi.ERR_INVALID_PARAM)
 	}
 
 	if len(block) == 0 {
@@ -37,16 +37,21 @@ func newLogisticAdaptiveProbMap(n, rate uint) (*LogisticAdaptiveProbMap, error)
 	this := &LogisticAdaptiveProbMap{}
 	this.data = make([]int32, n*33)
 	this.rate = rate
+	this.reset()
+	return this, nil
+}
+
+// Restore the initial probabilities
+func (this *LogisticAdaptiveProbMap) reset() {
+	this.index = 0
 
 	for j := 0; j <= 32; j++ {
 		this.data[j] = int32(kanzi.Squash((j-16)<<7) << 4)
 	}
 
-	for i := uint(1); i < n; i++ {
-		copy(this.data[i*33:(i+1)*33], this.data[0:33])
+	for i := 33; i < len(this.data); i += 33 {
+		copy(this.data[i:i+33], this.data[0:33])
 	}
-
-	return this, nil
 }
 
 // Return improved prediction given current bit, prediction and context
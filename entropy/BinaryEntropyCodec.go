@@ -17,7 +17,6 @@ package entropy
 
 import (
 	"encoding/binary"
-	"errors"
 	kanzi "github.com/flanglet/kanzi-go"
 )
 
@@ -30,22 +29,23 @@ const (
 )
 
 type BinaryEntropyEncoder struct {
-	predictor kanzi.Predictor
-	low       uint64
-	high      uint64
-	bitstream kanzi.OutputBitStream
-	disposed  bool
-	buffer    []byte
-	index     int
+	predictor   kanzi.Predictor
+	low         uint64
+	high        uint64
+	bitstream   kanzi.OutputBitStream
+	cancellable kanzi.Cancellable
+	disposed    bool
+	buffer      []byte
+	index       int
 }
 
 func NewBinaryEntropyEncoder(bs kanzi.OutputBitStream, predictor kanzi.Predictor) (*BinaryEntropyEncoder, error) {
 	if bs == nil {
-		return nil, errors.New("Invalid null bitstream parameter")
+		return nil, kanzi.NewError("Invalid null bitstream parameter", kanzi.ERR_INVALID_PARAM)
 	}
 
 	if predictor == nil {
-		return nil, errors.New("Invalid null predictor parameter")
+		return nil, kanzi.NewError("Invalid null predictor parameter", kanzi.ERR_INVALID_PARAM)
 	}
 
 	this := new(BinaryEntropyEncoder)
@@ -53,6 +53,7 @@ func NewBinaryEntropyEncoder(bs kanzi.OutputBitStream, predictor kanzi.Predictor
 	this.low = 0
 	this.high = BINARY_ENTROPY_TOP
 	this.bitstream = bs
+	this.cancellable, _ = bs.(kanzi.Cancellable)
 	this.buffer = make([]byte, 0)
 	this.index = 0
 	return this, nil
@@ -92,7 +93,7 @@ func (this *BinaryEntropyEncoder) Encode(block []byte) (int, error) {
 	count := len(block)
 
 	if count > 1<<30 {
-		return -1, errors.New("Invalid block size parameter (max is 1<<30)")
+		return -1, kanzi.NewError("Invalid block size parameter (max is 1<<30)", kanzi.ERR_INVALID_PARAM)
 	}
 
 	startChunk := 0
@@ -129,6 +130,11 @@ func (this *BinaryEntropyEncoder) Encode(block []byte) (int, error) {
 
 		for i := range buf {
 			this.EncodeByte(buf[i])
+
+			// Check for cancellation every 64 KB (panic if cancelled)
+			if i&0xFFFF == 0xFFFF && this.cancellable != nil {
+				this.cancellable.CheckCancelled()
+			}
 		}
 
 		WriteVarInt(this.bitstream, this.index)
@@ -170,17 +176,18 @@ type BinaryEntropyDecoder struct {
 	current     uint64
 	initialized bool
 	bitstream   kanzi.InputBitStream
+	cancellable kanzi.Cancellable
 	buffer      []byte
 	index       int
 }
 
 func NewBinaryEntropyDecoder(bs kanzi.InputBitStream, predictor kanzi.Predictor) (*BinaryEntropyDecoder, error) {
 	if bs == nil {
-		return nil, errors.New("Invalid null bitstream parameter")
+		return nil, kanzi.NewError("Invalid null bitstream parameter", kanzi.ERR_INVALID_PARAM)
 	}
 
 	if predictor == nil {
-		return nil, errors.New("Invalid null predictor parameter")
+		return nil, kanzi.NewError("Invalid null predictor parameter", kanzi.ERR_INVALID_PARAM)
 	}
 
 	// Defer stream reading. We are creating the object, we should not do any I/O
@@ -189,6 +196,7 @@ func NewBinaryEntropyDecoder(bs kanzi.InputBitStream, predictor kanzi.Predictor)
 	this.low = 0
 	this.high = BINARY_ENTROPY_TOP
 	this.bitstream = bs
+	this.cancellable, _ = bs.(kanzi.Cancellable)
 	this.buffer = make([]byte, 0)
 	this.index = 0
 	return this, nil
@@ -255,7 +263,7 @@ func (this *BinaryEntropyDecoder) Decode(block []byte) (int, error) {
 	count := len(block)
 
 	if count > 1<<30 {
-		return -1, errors.New("Invalid block size parameter (max is 1<<30)")
+		return -1, kanzi.NewError("Invalid block size parameter (max is 1<<30)", kanzi.ERR_INVALID_PARAM)
 	}
 
 	startChunk := 0
@@ -297,6 +305,11 @@ func (this *BinaryEntropyDecoder) Decode(block []byte) (int, error) {
 
 		for i := range buf {
 			buf[i] = this.DecodeByte()
+
+			// Check for cancellation every 64 KB (panic if cancelled)
+			if i&0xFFFF == 0xFFFF && this.cancellable != nil {
+				this.cancellable.CheckCancelled()
+			}
 		}
 
 		startChunk += chunkSize
@@ -37,16 +37,27 @@ type CMPredictor struct {
 
 func NewCMPredictor() (*CMPredictor, error) {
 	this := new(CMPredictor)
-	this.ctx = 1
-	this.run = 1
-	this.runMask = 0
-	this.idx = 8
 
 	for i := 0; i < 256; i++ {
 		this.counter1[i] = make([]int32, 257)
 		this.counter2[i+i] = make([]int32, 17)
 		this.counter2[i+i+1] = make([]int32, 17)
+	}
+
+	this.reset()
+	return this, nil
+}
 
+// Restore the initial state of the model
+func (this *CMPredictor) reset() {
+	this.c1 = 0
+	this.c2 = 0
+	this.ctx = 1
+	this.run = 1
+	this.runMask = 0
+	this.idx = 8
+
+	for i := 0; i < 256; i++ {
 		for j := 0; j <= 256; j++ {
 			this.counter1[i][j] = 32768
 		}
@@ -62,7 +73,6 @@ func NewCMPredictor() (*CMPredictor, error) {
 
 	pc1 := this.counter1[this.ctx]
 	this.p = int(13*pc1[256]+14*pc1[this.c1]+5*pc1[this.c2]) >> 5
-	return this, nil
 }
 
 // Update the probability model
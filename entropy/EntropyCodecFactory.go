@@ -70,7 +70,7 @@ func NewEntropyDecoder(ibs kanzi.InputBitStream, ctx map[string]interface{},
 		return NewNullEntropyDecoder(ibs)
 
 	default:
-		return nil, fmt.Errorf("Unsupported entropy codec type: '%c'", entropyType)
+		return nil, kanzi.NewError(fmt.Sprintf("Unsupported entropy codec type: '%c'", entropyType), kanzi.ERR_INVALID_CODEC)
 	}
 }
 
@@ -110,7 +110,74 @@ func NewEntropyEncoder(obs kanzi.OutputBitStream, ctx map[string]interface{},
 		return NewNullEntropyEncoder(obs)
 
 	default:
-		return nil, fmt.Errorf("Unsupported entropy codec type: '%c'", entropyType)
+		return nil, kanzi.NewError(fmt.Sprintf("Unsupported entropy codec type: '%c'", entropyType), kanzi.ERR_INVALID_CODEC)
+	}
+}
+
+// Return an estimate of the memory (in bytes) allocated by an entropy decoder
+// for a stream with the provided block size. Used to enforce memory limits
+// before decoding.
+func EstimateDecoderMemory(entropyType uint32, blockSize uint) uint64 {
+	size := uint64(blockSize)
+
+	switch entropyType {
+
+	case TPAQ_TYPE, TPAQX_TYPE:
+		// See NewTPAQPredictor
+		statesSize := uint64(1 << 26)
+		hashSize := uint64(TPAQ_HASH_SIZE)
+
+		if blockSize >= 64*1024*1024 {
+			statesSize = 1 << 29
+		} else if blockSize >= 16*1024*1024 {
+			statesSize = 1 << 28
+		} else if blockSize >= 1024*1024 {
+			statesSize = 1 << 27
+		}
+
+		if entropyType == TPAQX_TYPE {
+			statesSize <<= 1
+			hashSize <<= 2
+		}
+
+		// States, small states, hashes, buffer, mixers and chunk buffer
+		return statesSize + (1 << 16) + (1 << 24) + 4*hashSize + TPAQ_BUFFER_SIZE +
+			(8 << 20) + (size*9)>>3
+
+	case CM_TYPE, FPAQ_TYPE:
+		return (1 << 20) + (size*9)>>3
+
+	case NONE_TYPE:
+		return 0
+
+	default:
+		// Chunk buffers and tables
+		return size + (1 << 20)
+	}
+}
+
+// Return the max size (in bytes) of the output of an entropy encoder for an
+// input of srcLen bytes (incompressible data included)
+func MaxEncodedLen(entropyType uint32, srcLen int) int {
+	switch entropyType {
+
+	case NONE_TYPE:
+		return srcLen
+
+	case ANS1_TYPE:
+		// Up to 256 frequency tables per chunk: small inputs can expand a lot
+		chunkSize := int(DEFAULT_ANS0_CHUNK_SIZE << 8)
+		overhead := (1 + srcLen/chunkSize) * 256 * 768
+
+		if overhead > 3*srcLen {
+			overhead = 3 * srcLen
+		}
+
+		return srcLen + overhead + 1024
+
+	default:
+		// Frequency tables or model overhead for incompressible data
+		return srcLen + srcLen/32 + 1024
 	}
 }
 
@@ -145,7 +212,7 @@ func GetName(entropyType uint32) string {
 		return "NONE"
 
 	default:
-		panic(fmt.Errorf("Unsupported entropy codec type: '%c'", entropyType))
+		panic(kanzi.NewError(fmt.Sprintf("Unsupported entropy codec type: '%c'", entropyType), kanzi.ERR_INVALID_CODEC))
 	}
 }
 
@@ -180,6 +247,6 @@ func GetType(entropyName string) uint32 {
 		return NONE_TYPE
 
 	default:
-		panic(fmt.Errorf("Unsupported entropy codec type: '%s'", entropyName))
+		panic(kanzi.NewError(fmt.Sprintf("Unsupported entropy codec type: '%s'", entropyName), kanzi.ERR_INVALID_CODEC))
 	}
 }
@@ -291,7 +291,7 @@ func DecodeAlphabet(ibs kanzi.InputBitStream, alphabet []int) (int, error) {
 		}
 
 		if alphabetSize > len(alphabet) {
-			return alphabetSize, fmt.Errorf("Invalid bitstream: incorrect alphabet size: %v", alphabetSize)
+			return alphabetSize, kanzi.NewError(fmt.Sprintf("Invalid bitstream: incorrect alphabet size: %v", alphabetSize), kanzi.ERR_INVALID_FILE)
 		}
 
 		// Full alphabet
@@ -342,7 +342,7 @@ func DecodeAlphabet(ibs kanzi.InputBitStream, alphabet []int) (int, error) {
 		alphabetSize := 1 << uint(ibs.ReadBits(5))
 
 		if alphabetSize > len(alphabet) {
-			return alphabetSize, fmt.Errorf("Invalid bitstream: incorrect alphabet size: %v", alphabetSize)
+			return alphabetSize, kanzi.NewError(fmt.Sprintf("Invalid bitstream: incorrect alphabet size: %v", alphabetSize), kanzi.ERR_INVALID_FILE)
 		}
 
 		// Read missing symbols
@@ -435,11 +435,11 @@ func ComputeFirstOrderEntropy1024(block []byte, histo []int) int {
 // The alphabet and freqs parameters are updated
 func (this *EntropyUtils) NormalizeFrequencies(freqs []int, alphabet []int, totalFreq, scale int) (int, error) {
 	if len(alphabet) > 1<<8 {
-		return 0, fmt.Errorf("Invalid alphabet size parameter: %v (must be less than or equal to 256)", len(alphabet))
+		return 0, kanzi.NewError(fmt.Sprintf("Invalid alphabet size parameter: %v (must be less than or equal to 256)", len(alphabet)), kanzi.ERR_INVALID_PARAM)
 	}
 
 	if scale < 1<<8 || scale > 1<<16 {
-		return 0, fmt.Errorf("Invalid range parameter: %v (must be in [256..65536])", scale)
+		return 0, kanzi.NewError(fmt.Sprintf("Invalid range parameter: %v (must be in [256..65536])", scale), kanzi.ERR_INVALID_PARAM)
 	}
 
 	if len(alphabet) == 0 || totalFreq == 0 {
@@ -565,7 +565,7 @@ func (this *EntropyUtils) NormalizeFrequencies(freqs []int, alphabet []int, tota
 
 func WriteVarInt(bs kanzi.OutputBitStream, value int) int {
 	if bs == nil {
-		panic("Invalid null bitstream parameter")
+		panic(kanzi.NewError("Invalid null bitstream parameter", kanzi.ERR_INVALID_PARAM))
 	}
 
 	res := 0
@@ -582,7 +582,7 @@ func WriteVarInt(bs kanzi.OutputBitStream, value int) int {
 
 func ReadVarInt(bs kanzi.InputBitStream) int {
 	if bs == nil {
-		panic("Invalid null bitstream parameter")
+		panic(kanzi.NewError("Invalid null bitstream parameter", kanzi.ERR_INVALID_PARAM))
 	}
 
 	val := int(bs.ReadBits(8))
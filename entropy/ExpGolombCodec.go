@@ -16,7 +16,6 @@ limitations under the License.
 package entropy
 
 import (
-	"errors"
 	kanzi "github.com/flanglet/kanzi-go"
 )
 
@@ -72,7 +71,7 @@ type ExpGolombEncoder struct {
 // Example: -1 is better compressed as int8 (1 followed by '-') than as byte (-1 & 255 = 255)
 func NewExpGolombEncoder(bs kanzi.OutputBitStream, sgn bool) (*ExpGolombEncoder, error) {
 	if bs == nil {
-		return nil, errors.New("Invalid null bitstream parameter")
+		return nil, kanzi.NewError("Invalid null bitstream parameter", kanzi.ERR_INVALID_PARAM)
 	}
 
 	this := new(ExpGolombEncoder)
@@ -125,7 +124,7 @@ type ExpGolombDecoder struct {
 // If sgn is true, the extracted value is treated as an int8
 func NewExpGolombDecoder(bs kanzi.InputBitStream, sgn bool) (*ExpGolombDecoder, error) {
 	if bs == nil {
-		return nil, errors.New("Invalid null bitstream parameter")
+		return nil, kanzi.NewError("Invalid null bitstream parameter", kanzi.ERR_INVALID_PARAM)
 	}
 
 	this := new(ExpGolombDecoder)
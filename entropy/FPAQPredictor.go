@@ -29,13 +29,17 @@ type FPAQPredictor struct {
 
 func NewFPAQPredictor() (*FPAQPredictor, error) {
 	this := new(FPAQPredictor)
+	this.reset()
+	return this, nil
+}
+
+// Restore the initial probabilities
+func (this *FPAQPredictor) reset() {
 	this.ctxIdx = 1
 
 	for i := range this.probs {
 		this.probs[i] = PSCALE >> 1
 	}
-
-	return this, nil
 }
 
 // Update the probability model
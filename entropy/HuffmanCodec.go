@@ -16,7 +16,6 @@ limitations under the License.
 package entropy
 
 import (
-	"errors"
 	"fmt"
 	kanzi "github.com/flanglet/kanzi-go"
 	"sort"
@@ -144,11 +143,11 @@ type HuffmanEncoder struct {
 // The default chunk size is 65536 bytes.
 func NewHuffmanEncoder(bs kanzi.OutputBitStream, args ...uint) (*HuffmanEncoder, error) {
 	if bs == nil {
-		return nil, errors.New("Invalid null bitstream parameter")
+		return nil, kanzi.NewError("Invalid null bitstream parameter", kanzi.ERR_INVALID_PARAM)
 	}
 
 	if len(args) > 1 {
-		return nil, errors.New("At most one chunk size can be provided")
+		return nil, kanzi.NewError("At most one chunk size can be provided", kanzi.ERR_INVALID_PARAM)
 	}
 
 	chkSize := HUF_DEFAULT_CHUNK_SIZE
@@ -158,11 +157,11 @@ func NewHuffmanEncoder(bs kanzi.OutputBitStream, args ...uint) (*HuffmanEncoder,
 	}
 
 	if chkSize != 0 && chkSize < 1024 {
-		return nil, errors.New("The chunk size must be at least 1024")
+		return nil, kanzi.NewError("The chunk size must be at least 1024", kanzi.ERR_INVALID_PARAM)
 	}
 
 	if chkSize > 1<<30 {
-		return nil, errors.New("The chunk size must be at most 2^30")
+		return nil, kanzi.NewError("The chunk size must be at most 2^30", kanzi.ERR_INVALID_PARAM)
 	}
 
 	this := new(HuffmanEncoder)
@@ -183,7 +182,7 @@ func NewHuffmanEncoder(bs kanzi.OutputBitStream, args ...uint) (*HuffmanEncoder,
 // Rebuild Huffman codes
 func (this *HuffmanEncoder) updateFrequencies(frequencies []uint) (int, error) {
 	if frequencies == nil || len(frequencies) != 256 {
-		return 0, errors.New("Invalid frequencies parameter")
+		return 0, kanzi.NewError("Invalid frequencies parameter", kanzi.ERR_INVALID_PARAM)
 	}
 
 	count := 0
@@ -228,7 +227,7 @@ func (this *HuffmanEncoder) updateFrequencies(frequencies []uint) (int, error) {
 
 	// Create canonical codes
 	if generateCanonicalCodes(sizes[:], this.codes[:], this.sranks[0:count]) < 0 {
-		return count, fmt.Errorf("Could not generate codes: max code length (%v bits) exceeded", HUF_MAX_SYMBOL_SIZE)
+		return count, kanzi.NewError(fmt.Sprintf("Could not generate codes: max code length (%v bits) exceeded", HUF_MAX_SYMBOL_SIZE), kanzi.ERR_PROCESS_BLOCK)
 	}
 
 	// Pack size and code (size <= HUF_MAX_SYMBOL_SIZE bits)
@@ -263,7 +262,7 @@ func (this *HuffmanEncoder) computeCodeLengths(frequencies []uint, sizes []byte,
 		codeLen := byte(buf[i])
 
 		if codeLen == 0 || codeLen > HUF_MAX_SYMBOL_SIZE {
-			err = fmt.Errorf("Could not generate codes: max code length (%v bits) exceeded", HUF_MAX_SYMBOL_SIZE)
+			err = kanzi.NewError(fmt.Sprintf("Could not generate codes: max code length (%v bits) exceeded", HUF_MAX_SYMBOL_SIZE), kanzi.ERR_PROCESS_BLOCK)
 			break
 		}
 
@@ -330,7 +329,7 @@ func computeInPlaceSizesPhase2(data []uint) {
 // Dynamically compute the frequencies for every chunk of data in the block
 func (this *HuffmanEncoder) Encode(block []byte) (int, error) {
 	if block == nil {
-		return 0, errors.New("Invalid null block parameter")
+		return 0, kanzi.NewError("Invalid null block parameter", kanzi.ERR_INVALID_PARAM)
 	}
 
 	if len(block) == 0 {
@@ -440,11 +439,11 @@ type HuffmanDecoder struct {
 // The default chunk size is 65536 bytes.
 func NewHuffmanDecoder(bs kanzi.InputBitStream, args ...uint) (*HuffmanDecoder, error) {
 	if bs == nil {
-		return nil, errors.New("Invalid null bitstream parameter")
+		return nil, kanzi.NewError("Invalid null bitstream parameter", kanzi.ERR_INVALID_PARAM)
 	}
 
 	if len(args) > 1 {
-		return nil, errors.New("At most one chunk size can be provided")
+		return nil, kanzi.NewError("At most one chunk size can be provided", kanzi.ERR_INVALID_PARAM)
 	}
 
 	chkSize := HUF_DEFAULT_CHUNK_SIZE
@@ -454,11 +453,11 @@ func NewHuffmanDecoder(bs kanzi.InputBitStream, args ...uint) (*HuffmanDecoder,
 	}
 
 	if chkSize != 0 && chkSize < 1024 {
-		return nil, errors.New("The chunk size must be at least 1024")
+		return nil, kanzi.NewError("The chunk size must be at least 1024", kanzi.ERR_INVALID_PARAM)
 	}
 
 	if chkSize > 1<<30 {
-		return nil, errors.New("The chunk size must be at most 2^30")
+		return nil, kanzi.NewError("The chunk size must be at most 2^30", kanzi.ERR_INVALID_PARAM)
 	}
 
 	this := new(HuffmanDecoder)
@@ -504,14 +503,14 @@ func (this *HuffmanDecoder) ReadLengths() (int, error) {
 		r := rr[i]
 
 		if r > len(this.codes) {
-			return 0, fmt.Errorf("Invalid bitstream: incorrect Huffman symbol %v", r)
+			return 0, kanzi.NewError(fmt.Sprintf("Invalid bitstream: incorrect Huffman symbol %v", r), kanzi.ERR_INVALID_FILE)
 		}
 
 		this.codes[r] = 0
 		currSize = prevSize + int8(egdec.DecodeByte())
 
 		if currSize <= 0 || currSize > HUF_MAX_SYMBOL_SIZE {
-			return 0, fmt.Errorf("Invalid bitstream: incorrect size %v for Huffman symbol %v", currSize, i)
+			return 0, kanzi.NewError(fmt.Sprintf("Invalid bitstream: incorrect size %v for Huffman symbol %v", currSize, i), kanzi.ERR_INVALID_FILE)
 		}
 
 		if this.minCodeLen > currSize {
@@ -528,7 +527,7 @@ func (this *HuffmanDecoder) ReadLengths() (int, error) {
 
 	// Create canonical codes
 	if generateCanonicalCodes(this.sizes[:], this.codes[:], this.ranks[0:count]) < 0 {
-		return 0, errors.New("Could not generate codes: max code length (24 bits) exceeded")
+		return 0, kanzi.NewError("Could not generate codes: max code length (24 bits) exceeded", kanzi.ERR_PROCESS_BLOCK)
 	}
 
 	// Build decoding tables
@@ -590,7 +589,7 @@ func (this *HuffmanDecoder) buildDecodingTables(count int) {
 // Use fastDecodeByte until the near end of chunk or block.
 func (this *HuffmanDecoder) Decode(block []byte) (int, error) {
 	if block == nil {
-		return 0, errors.New("Invalid null block parameter")
+		return 0, kanzi.NewError("Invalid null block parameter", kanzi.ERR_INVALID_PARAM)
 	}
 
 	if len(block) == 0 {
@@ -598,7 +597,7 @@ func (this *HuffmanDecoder) Decode(block []byte) (int, error) {
 	}
 
 	if this.minCodeLen == 0 {
-		return 0, errors.New("Invalid minimum code length: 0")
+		return 0, kanzi.NewError("Invalid minimum code length: 0", kanzi.ERR_INVALID_FILE)
 	}
 
 	end := len(block)
@@ -630,6 +629,11 @@ func (this *HuffmanDecoder) Decode(block []byte) (int, error) {
 
 		endChunk8 := (endChunk - endPaddingSize) & -8
 
+		if endChunk8 < startChunk {
+			// Small chunk: regular decoding only
+			endChunk8 = startChunk
+		}
+
 		for i := startChunk; i < endChunk8; i += 8 {
 			// Fast decoding (read HUF_DECODING_BATCH_SIZE bits at a time)
 			block[i] = this.fastDecodeByte()
@@ -677,7 +681,7 @@ func (this *HuffmanDecoder) slowDecodeByte(code int, codeLen uint) byte {
 		}
 	}
 
-	panic(errors.New("Invalid bitstream: incorrect Huffman code"))
+	panic(kanzi.NewError("Invalid bitstream: incorrect Huffman code", kanzi.ERR_INVALID_FILE))
 }
 
 // 64 bits must be available in the bitstream
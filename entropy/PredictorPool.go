@@ -0,0 +1,118 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package entropy
+
+import (
+	kanzi "github.com/flanglet/kanzi-go"
+)
+
+// PredictorPool keeps the predictors of the binary entropy codecs between
+// blocks. A predictor is reset (instead of reallocated) when the next block
+// requires the same tables, which matters for TPAQ (up to 2^29 states).
+// A pool must not be shared by concurrent tasks. A nil pool is valid and
+// allocates new predictors for each call.
+type PredictorPool struct {
+	fpaq *FPAQPredictor
+	cm   *CMPredictor
+	tpaq *TPAQPredictor
+}
+
+func NewPredictorPool() *PredictorPool {
+	return &PredictorPool{}
+}
+
+// Same as NewEntropyEncoder but the predictor comes from the pool
+func (this *PredictorPool) NewEntropyEncoder(obs kanzi.OutputBitStream, ctx map[string]interface{},
+	entropyType uint32) (kanzi.EntropyEncoder, error) {
+	if this == nil {
+		return NewEntropyEncoder(obs, ctx, entropyType)
+	}
+
+	predictor, err := this.getPredictor(ctx, entropyType)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if predictor == nil {
+		return NewEntropyEncoder(obs, ctx, entropyType)
+	}
+
+	return NewBinaryEntropyEncoder(obs, predictor)
+}
+
+// Same as NewEntropyDecoder but the predictor comes from the pool
+func (this *PredictorPool) NewEntropyDecoder(ibs kanzi.InputBitStream, ctx map[string]interface{},
+	entropyType uint32) (kanzi.EntropyDecoder, error) {
+	if this == nil {
+		return NewEntropyDecoder(ibs, ctx, entropyType)
+	}
+
+	predictor, err := this.getPredictor(ctx, entropyType)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if predictor == nil {
+		return NewEntropyDecoder(ibs, ctx, entropyType)
+	}
+
+	return NewBinaryEntropyDecoder(ibs, predictor)
+}
+
+// Return a predictor in its initial state or nil if the codec does not use
+// a predictor
+func (this *PredictorPool) getPredictor(ctx map[string]interface{}, entropyType uint32) (kanzi.Predictor, error) {
+	switch entropyType {
+
+	case FPAQ_TYPE:
+		if this.fpaq == nil {
+			this.fpaq, _ = NewFPAQPredictor()
+		} else {
+			this.fpaq.reset()
+		}
+
+		return this.fpaq, nil
+
+	case CM_TYPE:
+		if this.cm == nil {
+			this.cm, _ = NewCMPredictor()
+		} else {
+			this.cm.reset()
+		}
+
+		return this.cm, nil
+
+	case TPAQ_TYPE, TPAQX_TYPE:
+		if this.tpaq == nil || this.tpaq.reset(&ctx) == false {
+			// Release the old tables before allocating the new ones
+			this.tpaq = nil
+			predictor, err := NewTPAQPredictor(&ctx)
+
+			if err != nil {
+				return nil, err
+			}
+
+			this.tpaq = predictor
+		}
+
+		return this.tpaq, nil
+
+	default:
+		return nil, nil
+	}
+}
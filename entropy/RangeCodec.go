@@ -16,7 +16,6 @@ limitations under the License.
 package entropy
 
 import (
-	"errors"
 	"fmt"
 	kanzi "github.com/flanglet/kanzi-go"
 )
@@ -55,11 +54,11 @@ type RangeEncoder struct {
 // The default chunk size is 65536 bytes.
 func NewRangeEncoder(bs kanzi.OutputBitStream, args ...uint) (*RangeEncoder, error) {
 	if bs == nil {
-		return nil, errors.New("Invalid null bitstream parameter")
+		return nil, kanzi.NewError("Invalid null bitstream parameter", kanzi.ERR_INVALID_PARAM)
 	}
 
 	if len(args) > 2 {
-		return nil, errors.New("At most one chunk size and one log range can be provided")
+		return nil, kanzi.NewError("At most one chunk size and one log range can be provided", kanzi.ERR_INVALID_PARAM)
 	}
 
 	chkSize := DEFAULT_RANGE_CHUNK_SIZE
@@ -71,15 +70,15 @@ func NewRangeEncoder(bs kanzi.OutputBitStream, args ...uint) (*RangeEncoder, err
 	}
 
 	if chkSize != 0 && chkSize < 1024 {
-		return nil, errors.New("The chunk size must be at least 1024")
+		return nil, kanzi.NewError("The chunk size must be at least 1024", kanzi.ERR_INVALID_PARAM)
 	}
 
 	if chkSize > 1<<30 {
-		return nil, errors.New("The chunk size must be at most 2^30")
+		return nil, kanzi.NewError("The chunk size must be at most 2^30", kanzi.ERR_INVALID_PARAM)
 	}
 
 	if logRange < 8 || logRange > 16 {
-		return nil, fmt.Errorf("Invalid range parameter: %v (must be in [8..16])", logRange)
+		return nil, kanzi.NewError(fmt.Sprintf("Invalid range parameter: %v (must be in [8..16])", logRange), kanzi.ERR_INVALID_PARAM)
 	}
 
 	this := new(RangeEncoder)
@@ -96,7 +95,7 @@ func NewRangeEncoder(bs kanzi.OutputBitStream, args ...uint) (*RangeEncoder, err
 
 func (this *RangeEncoder) updateFrequencies(frequencies []int, size int, lr uint) (int, error) {
 	if frequencies == nil || len(frequencies) != 256 {
-		return 0, errors.New("Invalid frequencies parameter")
+		return 0, kanzi.NewError("Invalid frequencies parameter", kanzi.ERR_INVALID_PARAM)
 	}
 
 	alphabetSize, err := this.eu.NormalizeFrequencies(frequencies, this.alphabet[:], size, 1<<lr)
@@ -172,7 +171,7 @@ func (this *RangeEncoder) encodeHeader(alphabetSize int, alphabet []int, frequen
 
 func (this *RangeEncoder) Encode(block []byte) (int, error) {
 	if block == nil {
-		return 0, errors.New("Invalid null block parameter")
+		return 0, kanzi.NewError("Invalid null block parameter", kanzi.ERR_INVALID_PARAM)
 	}
 
 	if len(block) == 0 {
@@ -293,11 +292,11 @@ type RangeDecoder struct {
 // The default chunk size is 65536 bytes.
 func NewRangeDecoder(bs kanzi.InputBitStream, args ...uint) (*RangeDecoder, error) {
 	if bs == nil {
-		return nil, errors.New("Invalid null bitstream parameter")
+		return nil, kanzi.NewError("Invalid null bitstream parameter", kanzi.ERR_INVALID_PARAM)
 	}
 
 	if len(args) > 1 {
-		return nil, errors.New("At most one chunk size can be provided")
+		return nil, kanzi.NewError("At most one chunk size can be provided", kanzi.ERR_INVALID_PARAM)
 	}
 
 	chkSize := DEFAULT_RANGE_CHUNK_SIZE
@@ -307,11 +306,11 @@ func NewRangeDecoder(bs kanzi.InputBitStream, args ...uint) (*RangeDecoder, erro
 	}
 
 	if chkSize != 0 && chkSize < 1024 {
-		return nil, errors.New("The chunk size must be at least 1024")
+		return nil, kanzi.NewError("The chunk size must be at least 1024", kanzi.ERR_INVALID_PARAM)
 	}
 
 	if chkSize > 1<<30 {
-		return nil, errors.New("The chunk size must be at most 2^30")
+		return nil, kanzi.NewError("The chunk size must be at most 2^30", kanzi.ERR_INVALID_PARAM)
 	}
 
 	this := new(RangeDecoder)
@@ -367,7 +366,7 @@ func (this *RangeDecoder) decodeHeader(frequencies []int) (int, error) {
 			val := int(this.bitstream.ReadBits(logMax))
 
 			if val <= 0 || val >= scale {
-				err := fmt.Errorf("Invalid bitstream: incorrect frequency %v  for symbol '%v' in range decoder", val, this.alphabet[j])
+				err := kanzi.NewError(fmt.Sprintf("Invalid bitstream: incorrect frequency %v  for symbol '%v' in range decoder", val, this.alphabet[j]), kanzi.ERR_INVALID_FILE)
 				return alphabetSize, err
 			}
 
@@ -378,7 +377,7 @@ func (this *RangeDecoder) decodeHeader(frequencies []int) (int, error) {
 
 	// Infer first frequency
 	if scale <= sum {
-		err := fmt.Errorf("Invalid bitstream: incorrect frequency %v  for symbol '%v' in range decoder", frequencies[this.alphabet[0]], this.alphabet[0])
+		err := kanzi.NewError(fmt.Sprintf("Invalid bitstream: incorrect frequency %v  for symbol '%v' in range decoder", frequencies[this.alphabet[0]], this.alphabet[0]), kanzi.ERR_INVALID_FILE)
 		return alphabetSize, err
 	}
 
@@ -406,7 +405,7 @@ func (this *RangeDecoder) decodeHeader(frequencies []int) (int, error) {
 // Reset frequency stats for each chunk of data in the block
 func (this *RangeDecoder) Decode(block []byte) (int, error) {
 	if block == nil {
-		return 0, errors.New("Invalid null block parameter")
+		return 0, kanzi.NewError("Invalid null block parameter", kanzi.ERR_INVALID_PARAM)
 	}
 
 	end := len(block)
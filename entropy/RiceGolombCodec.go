@@ -16,7 +16,6 @@ limitations under the License.
 package entropy
 
 import (
-	"errors"
 	"fmt"
 	kanzi "github.com/flanglet/kanzi-go"
 )
@@ -33,11 +32,11 @@ type RiceGolombEncoder struct {
 // Example: -1 is better compressed as int8 (1 followed by -) than as byte (-1 & 255 = 255)
 func NewRiceGolombEncoder(bs kanzi.OutputBitStream, sgn bool, logBase uint) (*RiceGolombEncoder, error) {
 	if bs == nil {
-		return nil, errors.New("Invalid null bitstream parameter")
+		return nil, kanzi.NewError("Invalid null bitstream parameter", kanzi.ERR_INVALID_PARAM)
 	}
 
 	if logBase < 1 || logBase > 12 {
-		return nil, fmt.Errorf("Invalid logBase '%v' value (must be in [1..12])", logBase)
+		return nil, kanzi.NewError(fmt.Sprintf("Invalid logBase '%v' value (must be in [1..12])", logBase), kanzi.ERR_INVALID_PARAM)
 	}
 
 	this := new(RiceGolombEncoder)
@@ -104,11 +103,11 @@ type RiceGolombDecoder struct {
 // If sgn is true, the extracted value is treated as an int8
 func NewRiceGolombDecoder(bs kanzi.InputBitStream, sgn bool, logBase uint) (*RiceGolombDecoder, error) {
 	if bs == nil {
-		return nil, errors.New("Invalid null bitstream parameter")
+		return nil, kanzi.NewError("Invalid null bitstream parameter", kanzi.ERR_INVALID_PARAM)
 	}
 
 	if logBase < 1 || logBase > 12 {
-		return nil, errors.New("Invalid logBase value (must be in [1..12])")
+		return nil, kanzi.NewError("Invalid logBase value (must be in [1..12])", kanzi.ERR_INVALID_PARAM)
 	}
 
 	this := new(RiceGolombDecoder)
@@ -191,10 +191,35 @@ type TPAQPredictor struct {
 
 func NewTPAQPredictor(ctx *map[string]interface{}) (*TPAQPredictor, error) {
 	this := new(TPAQPredictor)
+	statesSize, mixersSize, hashSize, extra := getTPAQParams(ctx)
+	this.extra = extra
+	this.mixers = make([]TPAQMixer, mixersSize)
+	this.bigStatesMap = make([]uint8, statesSize)
+	this.smallStatesMap0 = make([]uint8, 1<<16)
+	this.smallStatesMap1 = make([]uint8, 1<<24)
+	this.hashes = make([]int32, hashSize)
+	this.buffer = make([]int8, TPAQ_BUFFER_SIZE)
+	var err error
+
+	if this.extra == true {
+		this.sse0, err = newLogisticAdaptiveProbMap(256, 7)
+
+		if err == nil {
+			this.sse1, err = newLogisticAdaptiveProbMap(65536, 7)
+		}
+	}
+
+	this.init()
+	return this, err
+}
+
+// Return the sizes of the states table, mixers and hash table and the
+// extra mode flag for the provided context
+func getTPAQParams(ctx *map[string]interface{}) (int, int, int, bool) {
 	statesSize := 1 << 28
 	mixersSize := 1 << 12
 	hashSize := TPAQ_HASH_SIZE
-	this.extra = false
+	extra := false
 	extraMem := uint(0)
 
 	if ctx != nil {
@@ -202,10 +227,10 @@ func NewTPAQPredictor(ctx *map[string]interface{}) (*TPAQPredictor, error) {
 		// and add second SSE
 		if _, containsKey := (*ctx)["codec"]; containsKey {
 			codec := (*ctx)["codec"].(string)
-			this.extra = codec == "TPAQX"
+			extra = codec == "TPAQX"
 		}
 
-		if this.extra == true {
+		if extra == true {
 			extraMem = 1
 		}
 
@@ -243,9 +268,11 @@ func NewTPAQPredictor(ctx *map[string]interface{}) (*TPAQPredictor, error) {
 
 	statesSize <<= extraMem
 	hashSize <<= (2 * extraMem)
+	return statesSize, mixersSize, hashSize, extra
+}
 
-	this.mixers = make([]TPAQMixer, mixersSize)
-
+// Set the initial state of the model (the tables are expected to be zeroed)
+func (this *TPAQPredictor) init() {
 	for i := range this.mixers {
 		this.mixers[i].init()
 	}
@@ -253,14 +280,24 @@ func NewTPAQPredictor(ctx *map[string]interface{}) (*TPAQPredictor, error) {
 	this.mixer = &this.mixers[0]
 	this.pr = 2048
 	this.c0 = 1
-	this.bigStatesMap = make([]uint8, statesSize)
-	this.smallStatesMap0 = make([]uint8, 1<<16)
-	this.smallStatesMap1 = make([]uint8, 1<<24)
-	this.hashes = make([]int32, hashSize)
-	this.buffer = make([]int8, TPAQ_BUFFER_SIZE)
-	this.statesMask = int32(statesSize - 1)
-	this.mixersMask = int32(mixersSize - 1)
-	this.hashMask = int32(hashSize - 1)
+	this.c4 = 0
+	this.c8 = 0
+	this.bpos = 0
+	this.pos = 0
+	this.binCount = 0
+	this.matchLen = 0
+	this.matchPos = 0
+	this.hash = 0
+	this.ctx0 = 0
+	this.ctx1 = 0
+	this.ctx2 = 0
+	this.ctx3 = 0
+	this.ctx4 = 0
+	this.ctx5 = 0
+	this.ctx6 = 0
+	this.statesMask = int32(len(this.bigStatesMap) - 1)
+	this.mixersMask = int32(len(this.mixers) - 1)
+	this.hashMask = int32(len(this.hashes) - 1)
 	this.cp0 = &this.smallStatesMap0[0]
 	this.cp1 = &this.smallStatesMap1[0]
 	this.cp2 = &this.bigStatesMap[0]
@@ -268,18 +305,53 @@ func NewTPAQPredictor(ctx *map[string]interface{}) (*TPAQPredictor, error) {
 	this.cp4 = &this.bigStatesMap[0]
 	this.cp5 = &this.bigStatesMap[0]
 	this.cp6 = &this.bigStatesMap[0]
+}
 
-	var err error
+// Clear the model to make the predictor reusable for another block. Only
+// the mixers are reallocated (if the block size requires a different number
+// of mixers). Return false if the context requires different tables.
+func (this *TPAQPredictor) reset(ctx *map[string]interface{}) bool {
+	statesSize, mixersSize, hashSize, extra := getTPAQParams(ctx)
 
-	if this.extra == true {
-		this.sse0, err = newLogisticAdaptiveProbMap(256, 7)
+	if statesSize != len(this.bigStatesMap) || hashSize != len(this.hashes) || extra != this.extra {
+		return false
+	}
 
-		if err == nil {
-			this.sse1, err = newLogisticAdaptiveProbMap(65536, 7)
+	if mixersSize != len(this.mixers) {
+		this.mixers = make([]TPAQMixer, mixersSize)
+	} else {
+		for i := range this.mixers {
+			this.mixers[i] = TPAQMixer{}
 		}
 	}
 
-	return this, err
+	for i := range this.bigStatesMap {
+		this.bigStatesMap[i] = 0
+	}
+
+	for i := range this.smallStatesMap0 {
+		this.smallStatesMap0[i] = 0
+	}
+
+	for i := range this.smallStatesMap1 {
+		this.smallStatesMap1[i] = 0
+	}
+
+	for i := range this.hashes {
+		this.hashes[i] = 0
+	}
+
+	for i := range this.buffer {
+		this.buffer[i] = 0
+	}
+
+	if this.extra == true {
+		this.sse0.reset()
+		this.sse1.reset()
+	}
+
+	this.init()
+	return true
 }
 
 // Update the probability model
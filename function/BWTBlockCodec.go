@@ -16,8 +16,8 @@ limitations under the License.
 package function
 
 import (
-	"errors"
 	"fmt"
+	kanzi "github.com/flanglet/kanzi-go"
 	"github.com/flanglet/kanzi-go/transform"
 )
 
@@ -39,7 +39,8 @@ const (
 //   primary index: remaining bits (up to 3 bytes)
 
 type BWTBlockCodec struct {
-	bwt *transform.BWT
+	bwt  *transform.BWT
+	jobs uint
 }
 
 func NewBWTBlockCodec(ctx *map[string]interface{}) (*BWTBlockCodec, error) {
@@ -47,23 +48,40 @@ func NewBWTBlockCodec(ctx *map[string]interface{}) (*BWTBlockCodec, error) {
 	this := new(BWTBlockCodec)
 	var err error
 	this.bwt, err = transform.NewBWTWithCtx(ctx)
+	this.jobs = getBWTJobs(ctx)
 	return this, err
 }
 
+func getBWTJobs(ctx *map[string]interface{}) uint {
+	if ctx != nil {
+		if val, containsKey := (*ctx)["jobs"]; containsKey {
+			return val.(uint)
+		}
+	}
+
+	return 1
+}
+
+// The BWT keeps its buffers between blocks. Return false if the number of
+// jobs differs.
+func (this *BWTBlockCodec) reset(ctx *map[string]interface{}) bool {
+	return getBWTJobs(ctx) == this.jobs
+}
+
 func (this *BWTBlockCodec) Forward(src, dst []byte) (uint, uint, error) {
 	if len(src) == 0 {
 		return 0, 0, nil
 	}
 
 	if &src[0] == &dst[0] {
-		return 0, 0, errors.New("Input and output buffers cannot be equal")
+		return 0, 0, kanzi.NewError("Input and output buffers cannot be equal", kanzi.ERR_INVALID_PARAM)
 	}
 
 	blockSize := len(src)
 
 	if len(dst) < this.MaxEncodedLen(blockSize) {
-		return 0, 0, fmt.Errorf("Output buffer is too small - size: %d, required %d",
-			len(dst), this.MaxEncodedLen(blockSize))
+		return 0, 0, kanzi.NewError(fmt.Sprintf("Output buffer is too small - size: %d, required %d",
+			len(dst), this.MaxEncodedLen(blockSize)), kanzi.ERR_BUFFER_TOO_SMALL)
 	}
 
 	chunks := transform.GetBWTChunks(blockSize)
@@ -140,7 +158,7 @@ func (this *BWTBlockCodec) Inverse(src, dst []byte) (uint, uint, error) {
 	}
 
 	if &src[0] == &dst[0] {
-		return 0, 0, errors.New("Input and output buffers cannot be equal")
+		return 0, 0, kanzi.NewError("Input and output buffers cannot be equal", kanzi.ERR_INVALID_PARAM)
 	}
 
 	srcIdx := uint(0)
@@ -154,7 +172,7 @@ func (this *BWTBlockCodec) Inverse(src, dst []byte) (uint, uint, error) {
 		pIndexSizeBytes := 1 + ((blockMode >> 6) & 0x03)
 
 		if blockSize < pIndexSizeBytes {
-			return 0, 0, errors.New("Invalid compressed length in bitstream")
+			return 0, 0, kanzi.NewError("Invalid compressed length in bitstream", kanzi.ERR_INVALID_FILE)
 		}
 
 		blockSize -= pIndexSizeBytes
@@ -169,7 +187,7 @@ func (this *BWTBlockCodec) Inverse(src, dst []byte) (uint, uint, error) {
 		}
 
 		if this.bwt.SetPrimaryIndex(i, primaryIndex) == false {
-			return 0, 0, errors.New("Invalid primary index in bitstream")
+			return 0, 0, kanzi.NewError("Invalid primary index in bitstream", kanzi.ERR_INVALID_FILE)
 		}
 	}
 
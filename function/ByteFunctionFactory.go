@@ -44,6 +44,15 @@ const (
 )
 
 func NewByteFunction(ctx *map[string]interface{}, functionType uint64) (*ByteTransformSequence, error) {
+	return newByteFunction(ctx, functionType, func(idx int, t uint64) (kanzi.ByteTransform, error) {
+		return newByteFunctionToken(ctx, t)
+	})
+}
+
+// Build the sequence of transforms. The idx-th transform (of type t) is
+// provided by newToken.
+func newByteFunction(ctx *map[string]interface{}, functionType uint64,
+	newToken func(idx int, t uint64) (kanzi.ByteTransform, error)) (*ByteTransformSequence, error) {
 	nbtr := 0
 
 	// Several transforms
@@ -66,7 +75,7 @@ func NewByteFunction(ctx *map[string]interface{}, functionType uint64) (*ByteTra
 		t := (functionType >> (BFF_MAX_SHIFT - BFF_ONE_SHIFT*uint(i))) & BFF_MASK
 
 		if t != NONE_TYPE || i == 0 {
-			if transforms[nbtr], err = newByteFunctionToken(ctx, t); err != nil {
+			if transforms[nbtr], err = newToken(nbtr, t); err != nil {
 				return nil, err
 			}
 		}
@@ -84,7 +93,7 @@ func newByteFunctionToken(ctx *map[string]interface{}, functionType uint64) (kan
 		return NewSnappyCodec()
 
 	case LZ4_TYPE:
-		return NewLZ4Codec()
+		return NewLZ4CodecWithCtx(ctx)
 
 	case ROLZ_TYPE:
 		return NewROLZCodecWithCtx(ctx)
@@ -111,19 +120,7 @@ func newByteFunctionToken(ctx *map[string]interface{}, functionType uint64) (kan
 		return transform.NewSBRT(transform.SBRT_MODE_RANK)
 
 	case DICT_TYPE:
-		textCodecType := 1
-
-		if val, containsKey := (*ctx)["codec"]; containsKey {
-			entropyType := strings.ToUpper(val.(string))
-
-			// Select text encoding based on entropy codec.
-			if entropyType == "NONE" || entropyType == "ANS0" ||
-				entropyType == "HUFFMAN" || entropyType == "RANGE" {
-				textCodecType = 2
-			}
-		}
-
-		(*ctx)["textcodec"] = textCodecType
+		selectTextCodec(ctx)
 		return NewTextCodecWithCtx(ctx)
 
 	case X86_TYPE:
@@ -133,8 +130,96 @@ func newByteFunctionToken(ctx *map[string]interface{}, functionType uint64) (kan
 		return NewNullFunction()
 
 	default:
-		return nil, fmt.Errorf("Unknown transform type: '%v'", functionType)
+		return nil, kanzi.NewError(fmt.Sprintf("Unknown transform type: '%v'", functionType), kanzi.ERR_INVALID_CODEC)
+	}
+}
+
+// Select text encoding based on entropy codec (see TextCodec)
+func selectTextCodec(ctx *map[string]interface{}) {
+	textCodecType := 1
+
+	if val, containsKey := (*ctx)["codec"]; containsKey {
+		entropyType := strings.ToUpper(val.(string))
+
+		if entropyType == "NONE" || entropyType == "ANS0" ||
+			entropyType == "HUFFMAN" || entropyType == "RANGE" {
+			textCodecType = 2
+		}
 	}
+
+	(*ctx)["textcodec"] = textCodecType
+}
+
+// Return the pre-shared dictionary of the context (nil if none). The
+// dictionary primes the TextCodec word dictionary and the history window
+// of the LZ codecs. It must be the same for the forward and inverse
+// transforms.
+func getDictionary(ctx *map[string]interface{}) []byte {
+	if ctx == nil {
+		return nil
+	}
+
+	if val, containsKey := (*ctx)["dictionary"]; containsKey {
+		if dict, isBytes := val.([]byte); isBytes == true && len(dict) > 0 {
+			return dict
+		}
+	}
+
+	return nil
+}
+
+// Return the last bytes (at most maxSize) of the dictionary
+func dictionaryTail(dict []byte, maxSize int) []byte {
+	if len(dict) > maxSize {
+		return dict[len(dict)-maxSize:]
+	}
+
+	return dict
+}
+
+// Return true if both slices are the same dictionary (same memory)
+func sameDictionary(dict1, dict2 []byte) bool {
+	if len(dict1) != len(dict2) {
+		return false
+	}
+
+	return len(dict1) == 0 || &dict1[0] == &dict2[0]
+}
+
+// Return an estimate of the memory (in bytes) allocated by the inverse
+// transforms for a stream with the provided block size. Used to enforce
+// memory limits before decoding.
+func EstimateInverseMemory(functionType uint64, blockSize uint) uint64 {
+	size := uint64(blockSize)
+	res := uint64(0)
+
+	for i := uint(0); i < 8; i++ {
+		t := (functionType >> (BFF_MAX_SHIFT - BFF_ONE_SHIFT*i)) & BFF_MASK
+
+		if t == NONE_TYPE {
+			continue
+		}
+
+		switch t {
+
+		case BWT_TYPE:
+			res += 5 * size
+
+		case BWTS_TYPE:
+			res += 8 * size
+
+		case ROLZ_TYPE, ROLZX_TYPE:
+			res += 2*size + 4*(ROLZ_HASH_SIZE<<ROLZ_LOG_POS_CHECKS)
+
+		case DICT_TYPE:
+			res += size + (32 << 20)
+
+		default:
+			res += size
+		}
+	}
+
+	return res
 }
 
 func GetName(functionType uint64) string {
@@ -206,7 +291,7 @@ func getByteFunctionNameToken(functionType uint64) string {
 		return "NONE"
 
 	default:
-		panic(fmt.Errorf("Unknown transform type: '%v'", functionType))
+		panic(kanzi.NewError(fmt.Sprintf("Unknown transform type: '%v'", functionType), kanzi.ERR_INVALID_CODEC))
 	}
 }
 
@@ -219,11 +304,11 @@ func GetType(name string) uint64 {
 	tokens := strings.Split(name, "+")
 
 	if len(tokens) == 0 {
-		panic(fmt.Errorf("Unknown transform type: '%v'", name))
+		panic(kanzi.NewError(fmt.Sprintf("Unknown transform type: '%v'", name), kanzi.ERR_INVALID_CODEC))
 	}
 
 	if len(tokens) > 8 {
-		panic(fmt.Errorf("Only 8 transforms allowed: '%v'", name))
+		panic(kanzi.NewError(fmt.Sprintf("Only 8 transforms allowed: '%v'", name), kanzi.ERR_INVALID_CODEC))
 	}
 
 	res := uint64(0)
@@ -287,6 +372,6 @@ func getByteFunctionTypeToken(name string) uint64 {
 		return NONE_TYPE
 
 	default:
-		panic(fmt.Errorf("Unknown transform type: '%v'", name))
+		panic(kanzi.NewError(fmt.Sprintf("Unknown transform type: '%v'", name), kanzi.ERR_INVALID_CODEC))
 	}
 }
@@ -0,0 +1,72 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package function
+
+import (
+	kanzi "github.com/flanglet/kanzi-go"
+)
+
+// Implemented by the transforms that can be reused for another block.
+// reset returns false if the context requires a new instance.
+type resettable interface {
+	reset(ctx *map[string]interface{}) bool
+}
+
+// ByteFunctionPool keeps the transforms of a sequence between blocks to
+// avoid the reallocation of their tables (EG. hash maps, dictionaries).
+// A pool must not be shared by concurrent tasks. A nil pool is valid and
+// allocates new transforms for each call.
+type ByteFunctionPool struct {
+	types      [8]uint64
+	transforms [8]kanzi.ByteTransform
+}
+
+func NewByteFunctionPool() *ByteFunctionPool {
+	return &ByteFunctionPool{}
+}
+
+// Same as NewByteFunction but the transforms come from the pool
+func (this *ByteFunctionPool) NewByteFunction(ctx *map[string]interface{}, functionType uint64) (*ByteTransformSequence, error) {
+	// Do not evict the pooled transforms for a copy block
+	if this == nil || functionType == NONE_TYPE {
+		return NewByteFunction(ctx, functionType)
+	}
+
+	return newByteFunction(ctx, functionType, func(idx int, t uint64) (kanzi.ByteTransform, error) {
+		if t == DICT_TYPE {
+			selectTextCodec(ctx)
+		}
+
+		if this.transforms[idx] != nil && this.types[idx] == t {
+			if r, isResettable := this.transforms[idx].(resettable); isResettable == true {
+				if r.reset(ctx) == true {
+					return this.transforms[idx], nil
+				}
+			}
+		}
+
+		// Release the old transform before allocating a new one
+		this.transforms[idx] = nil
+		res, err := newByteFunctionToken(ctx, t)
+
+		if err == nil {
+			this.types[idx] = t
+			this.transforms[idx] = res
+		}
+
+		return res, err
+	})
+}
@@ -16,7 +16,6 @@ limitations under the License.
 package function
 
 import (
-	"errors"
 	kanzi "github.com/flanglet/kanzi-go"
 )
 
@@ -32,11 +31,11 @@ type ByteTransformSequence struct {
 
 func NewByteTransformSequence(transforms []kanzi.ByteTransform) (*ByteTransformSequence, error) {
 	if transforms == nil {
-		return nil, errors.New("Invalid null transforms parameter")
+		return nil, kanzi.NewError("Invalid null transforms parameter", kanzi.ERR_INVALID_PARAM)
 	}
 
 	if len(transforms) == 0 || len(transforms) > 8 {
-		return nil, errors.New("Only 1 to 8 transforms allowed")
+		return nil, kanzi.NewError("Only 1 to 8 transforms allowed", kanzi.ERR_INVALID_PARAM)
 	}
 
 	this := new(ByteTransformSequence)
@@ -17,7 +17,6 @@ package function
 
 import (
 	"encoding/binary"
-	"errors"
 	"fmt"
 	kanzi "github.com/flanglet/kanzi-go"
 )
@@ -48,10 +47,13 @@ const (
 	ACCELERATION    = 1
 	SKIP_TRIGGER    = 6
 	SEARCH_MATCH_NB = ACCELERATION << SKIP_TRIGGER
+	LZ4_DICT_SIZE   = MAX_DISTANCE // max size of the dictionary prefix
 )
 
 type LZ4Codec struct {
-	buffer []int32
+	buffer     []int32
+	dictionary []byte // tail of the pre-shared dictionary (if any)
+	window     []byte // dictionary + block
 }
 
 func NewLZ4Codec() (*LZ4Codec, error) {
@@ -60,6 +62,36 @@ func NewLZ4Codec() (*LZ4Codec, error) {
 	return this, nil
 }
 
+// The pre-shared dictionary of the context (if any) is used as a prefix
+// of each block: the matches can refer to the dictionary.
+func NewLZ4CodecWithCtx(ctx *map[string]interface{}) (*LZ4Codec, error) {
+	this, err := NewLZ4Codec()
+
+	if err == nil {
+		this.dictionary = dictionaryTail(getDictionary(ctx), LZ4_DICT_SIZE)
+	}
+
+	return this, err
+}
+
+// The hash table is cleared by Forward: only the dictionary may change
+func (this *LZ4Codec) reset(ctx *map[string]interface{}) bool {
+	this.dictionary = dictionaryTail(getDictionary(ctx), LZ4_DICT_SIZE)
+	return true
+}
+
+// Return a buffer with the dictionary followed by size bytes
+func (this *LZ4Codec) getWindow(size int) []byte {
+	n := len(this.dictionary) + size
+
+	if cap(this.window) < n {
+		this.window = make([]byte, n)
+	}
+
+	copy(this.window, this.dictionary)
+	return this.window[0:n]
+}
+
 func writeLength(buf []byte, length int) int {
 	idx := 0
 
@@ -103,30 +135,39 @@ func (this *LZ4Codec) Forward(src, dst []byte) (uint, uint, error) {
 	}
 
 	if &src[0] == &dst[0] {
-		return 0, 0, errors.New("Input and output buffers cannot be equal")
+		return 0, 0, kanzi.NewError("Input and output buffers cannot be equal", kanzi.ERR_INVALID_PARAM)
 	}
 
 	count := len(src)
 
 	if n := this.MaxEncodedLen(count); len(dst) < n {
-		return 0, 0, fmt.Errorf("Output buffer is too small - size: %d, required %d", len(dst), n)
+		return 0, 0, kanzi.NewError(fmt.Sprintf("Output buffer is too small - size: %d, required %d", len(dst), n), kanzi.ERR_BUFFER_TOO_SMALL)
+	}
+
+	// Prepend the dictionary (if any) to the block
+	start := len(this.dictionary)
+
+	if start > 0 {
+		window := this.getWindow(count)
+		copy(window[start:], src)
+		src = window
 	}
 
 	var hashLog uint
 
-	if count < LZ4_64K_LIMIT {
+	if len(src) < LZ4_64K_LIMIT {
 		hashLog = HASH_LOG_64K
 	} else {
 		hashLog = HASH_LOG
 	}
 
 	hashShift := 32 - hashLog
-	srcEnd := count
+	srcEnd := len(src)
 	matchLimit := srcEnd - LAST_LITERALS
 	mfLimit := srcEnd - MF_LIMIT
-	srcIdx := 0
+	srcIdx := start
 	dstIdx := 0
-	anchor := 0
+	anchor := start
 
 	if count > MIN_LENGTH {
 		table := this.buffer[0 : 1<<hashLog]
@@ -135,6 +176,11 @@ func (this *LZ4Codec) Forward(src, dst []byte) (uint, uint, error) {
 			table[i] = 0
 		}
 
+		// Register the positions of the dictionary
+		for i := 0; i < start; i++ {
+			table[(binary.LittleEndian.Uint32(src[i:])*LZ4_HASH_SEED)>>hashShift] = int32(i)
+		}
+
 		// First byte
 		h32 := (binary.LittleEndian.Uint32(src[srcIdx:]) * LZ4_HASH_SEED) >> hashShift
 		table[h32] = int32(srcIdx)
@@ -155,7 +201,7 @@ func (this *LZ4Codec) Forward(src, dst []byte) (uint, uint, error) {
 				if fwdIdx > mfLimit {
 					// Encode last literals
 					dstIdx += writeLastLiterals(src[anchor:srcEnd], dst[dstIdx:])
-					return uint(srcEnd), uint(dstIdx), error(nil)
+					return uint(count), uint(dstIdx), error(nil)
 				}
 
 				step = searchMatchNb >> SKIP_STRENGTH
@@ -222,7 +268,7 @@ func (this *LZ4Codec) Forward(src, dst []byte) (uint, uint, error) {
 
 				if srcIdx > mfLimit {
 					dstIdx += writeLastLiterals(src[anchor:srcEnd], dst[dstIdx:])
-					return uint(srcEnd), uint(dstIdx), error(nil)
+					return uint(count), uint(dstIdx), error(nil)
 				}
 
 				// Fill table
@@ -251,7 +297,7 @@ func (this *LZ4Codec) Forward(src, dst []byte) (uint, uint, error) {
 
 	// Encode last literals
 	dstIdx += writeLastLiterals(src[anchor:srcEnd], dst[dstIdx:])
-	return uint(srcEnd), uint(dstIdx), error(nil)
+	return uint(count), uint(dstIdx), error(nil)
 }
 
 // Reads same byte input as LZ4_decompress_generic in LZ4 r131 (7/15)
@@ -262,14 +308,22 @@ func (this *LZ4Codec) Inverse(src, dst []byte) (uint, uint, error) {
 	}
 
 	if &src[0] == &dst[0] {
-		return 0, 0, errors.New("Input and output buffers cannot be equal")
+		return 0, 0, kanzi.NewError("Input and output buffers cannot be equal", kanzi.ERR_INVALID_PARAM)
+	}
+
+	// Decode after the dictionary (if any) to let the matches refer to it
+	start := len(this.dictionary)
+	output := dst
+
+	if start > 0 {
+		dst = this.getWindow(len(output))
 	}
 
 	count := len(src)
 	srcEnd := count - COPY_LENGTH
 	dstEnd := len(dst) - COPY_LENGTH
 	srcIdx := 0
-	dstIdx := 0
+	dstIdx := start
 
 	for {
 		// Get literal length
@@ -287,7 +341,7 @@ func (this *LZ4Codec) Inverse(src, dst []byte) (uint, uint, error) {
 			srcIdx++
 
 			if length > MAX_LENGTH {
-				return 0, 0, fmt.Errorf("Invalid length decoded: %d", length)
+				return 0, 0, kanzi.NewError(fmt.Sprintf("Invalid length decoded: %d", length), kanzi.ERR_INVALID_FILE)
 			}
 		}
 
@@ -334,7 +388,7 @@ func (this *LZ4Codec) Inverse(src, dst []byte) (uint, uint, error) {
 			}
 
 			if length > MAX_LENGTH || srcIdx == count {
-				return 0, 0, fmt.Errorf("Invalid length decoded: %d", length)
+				return 0, 0, kanzi.NewError(fmt.Sprintf("Invalid length decoded: %d", length), kanzi.ERR_INVALID_FILE)
 			}
 		}
 
@@ -384,7 +438,11 @@ func (this *LZ4Codec) Inverse(src, dst []byte) (uint, uint, error) {
 		dstIdx = cpy
 	}
 
-	return uint(srcIdx), uint(dstIdx), nil
+	if start > 0 {
+		copy(output, dst[start:dstIdx])
+	}
+
+	return uint(srcIdx), uint(dstIdx - start), nil
 }
 
 func (this LZ4Codec) MaxEncodedLen(srcLen int) int {
@@ -16,7 +16,7 @@ limitations under the License.
 package function
 
 import (
-	"errors"
+	kanzi "github.com/flanglet/kanzi-go"
 )
 
 type NullFunction struct {
@@ -27,13 +27,18 @@ func NewNullFunction() (*NullFunction, error) {
 	return this, nil
 }
 
+// Stateless: nothing to do
+func (this *NullFunction) reset(ctx *map[string]interface{}) bool {
+	return true
+}
+
 func doCopy(src, dst []byte) (uint, uint, error) {
 	if len(src) == 0 {
 		return 0, 0, nil
 	}
 
 	if len(src) > len(dst) {
-		return uint(0), uint(0), errors.New("Destination buffer too small")
+		return uint(0), uint(0), kanzi.NewError("Destination buffer too small", kanzi.ERR_BUFFER_TOO_SMALL)
 	}
 
 	if &src[0] != &dst[0] {
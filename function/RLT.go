@@ -24,8 +24,8 @@ package function
 // 4098 <= runLen < 65536+4098 -> 3 bytes
 
 import (
-	"errors"
 	"fmt"
+	kanzi "github.com/flanglet/kanzi-go"
 )
 
 const (
@@ -40,7 +40,7 @@ type RLT struct {
 
 func NewRLT(threshold uint) (*RLT, error) {
 	if threshold < 2 {
-		return nil, errors.New("Invalid run threshold parameter (must be at least 2)")
+		return nil, kanzi.NewError("Invalid run threshold parameter (must be at least 2)", kanzi.ERR_INVALID_PARAM)
 	}
 
 	this := new(RLT)
@@ -48,6 +48,11 @@ func NewRLT(threshold uint) (*RLT, error) {
 	return this, nil
 }
 
+// Stateless: nothing to do
+func (this *RLT) reset(ctx *map[string]interface{}) bool {
+	return true
+}
+
 func (this *RLT) RunTheshold() uint {
 	return this.runThreshold
 }
@@ -58,11 +63,11 @@ func (this *RLT) Forward(src, dst []byte) (uint, uint, error) {
 	}
 
 	if &src[0] == &dst[0] {
-		return 0, 0, errors.New("Input and output buffers cannot be equal")
+		return 0, 0, kanzi.NewError("Input and output buffers cannot be equal", kanzi.ERR_INVALID_PARAM)
 	}
 
 	if n := this.MaxEncodedLen(len(src)); len(dst) < n {
-		return 0, 0, fmt.Errorf("Output buffer is too small - size: %d, required %d", len(dst), n)
+		return 0, 0, kanzi.NewError(fmt.Sprintf("Output buffer is too small - size: %d, required %d", len(dst), n), kanzi.ERR_BUFFER_TOO_SMALL)
 	}
 
 	counters := [256]int{}
@@ -184,9 +189,9 @@ func (this *RLT) Forward(src, dst []byte) (uint, uint, error) {
 
 		if dstIdx >= dstEnd4 {
 			if run >= RLT_RUN_LEN_ENCODE2 {
-				err = errors.New("Not enough space in destination buffer")
+				err = kanzi.NewError("Not enough space in destination buffer", kanzi.ERR_BUFFER_TOO_SMALL)
 			} else if run >= RLT_RUN_LEN_ENCODE1 && dstIdx > dstEnd4 {
-				err = errors.New("Not enough space in destination buffer")
+				err = kanzi.NewError("Not enough space in destination buffer", kanzi.ERR_BUFFER_TOO_SMALL)
 			}
 		} else {
 			dst[dstIdx] = prev
@@ -212,7 +217,7 @@ func (this *RLT) Forward(src, dst []byte) (uint, uint, error) {
 	}
 
 	if srcIdx != srcEnd {
-		err = errors.New("Not enough space in destination buffer")
+		err = kanzi.NewError("Not enough space in destination buffer", kanzi.ERR_BUFFER_TOO_SMALL)
 	}
 
 	return srcIdx, dstIdx, err
@@ -224,7 +229,7 @@ func (this *RLT) Inverse(src, dst []byte) (uint, uint, error) {
 	}
 
 	if &src[0] == &dst[0] {
-		return 0, 0, errors.New("Input and output buffers cannot be equal")
+		return 0, 0, kanzi.NewError("Input and output buffers cannot be equal", kanzi.ERR_INVALID_PARAM)
 	}
 
 	counters := [256]int{}
@@ -286,7 +291,7 @@ func (this *RLT) Inverse(src, dst []byte) (uint, uint, error) {
 				}
 
 				if dstIdx >= dstEnd+run || run > maxRun {
-					err = errors.New("Not enough space in destination buffer")
+					err = kanzi.NewError("Not enough space in destination buffer", kanzi.ERR_BUFFER_TOO_SMALL)
 					break
 				}
 
@@ -320,7 +325,7 @@ func (this *RLT) Inverse(src, dst []byte) (uint, uint, error) {
 	}
 
 	if srcIdx != srcEnd {
-		err = errors.New("Not enough space in destination buffer")
+		err = kanzi.NewError("Not enough space in destination buffer", kanzi.ERR_BUFFER_TOO_SMALL)
 	}
 
 	return srcIdx, dstIdx, err
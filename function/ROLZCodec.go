@@ -17,7 +17,6 @@ package function
 
 import (
 	"encoding/binary"
-	"errors"
 	"fmt"
 	kanzi "github.com/flanglet/kanzi-go"
 	"github.com/flanglet/kanzi-go/bitstream"
@@ -46,6 +45,7 @@ const (
 	MASK_0_56           = uint64(0x00FFFFFFFFFFFFFF)
 	MASK_0_32           = uint64(0x00000000FFFFFFFF)
 	MAX_BLOCK_SIZE      = 1 << 27 // 128 MB
+	ROLZ_DICT_SIZE      = 1 << 16 // max size of the dictionary prefix
 )
 
 func getKey(p []byte) uint32 {
@@ -83,6 +83,29 @@ func emitCopy(buf []byte, dstIdx, ref, matchLen int) int {
 	return dstIdx
 }
 
+// Return a buffer with the dictionary followed by size bytes. The buffer
+// is reused if large enough.
+func getROLZWindow(window *[]byte, dict []byte, size int) []byte {
+	n := len(dict) + size
+
+	if cap(*window) < n {
+		*window = make([]byte, n)
+	}
+
+	copy(*window, dict)
+	return (*window)[0:n]
+}
+
+// The dictionary primes the first chunk if both fit in a chunk (positions
+// are limited to ROLZ_CHUNK_SIZE)
+func getROLZDictionary(dict []byte, size int) []byte {
+	if len(dict)+size > ROLZ_CHUNK_SIZE {
+		return nil
+	}
+
+	return dict
+}
+
 type ROLZCodec struct {
 	delegate kanzi.ByteFunction
 }
@@ -129,23 +152,59 @@ func NewROLZCodecWithCtx(ctx *map[string]interface{}) (*ROLZCodec, error) {
 		this.delegate = d
 	}
 
+	if err == nil {
+		this.setDictionary(getDictionary(ctx))
+	}
+
 	return this, err
 }
 
+// The pre-shared dictionary (if any) is a prefix of the first chunk: the
+// matches can refer to it.
+func (this *ROLZCodec) setDictionary(dict []byte) {
+	dict = dictionaryTail(dict, ROLZ_DICT_SIZE)
+
+	switch d := this.delegate.(type) {
+	case *rolzCodec1:
+		d.dictionary = dict
+	case *rolzCodec2:
+		d.dictionary = dict
+	}
+}
+
+// The tables are cleared by each call to Forward/Inverse. Return false if
+// the context requires the other codec (ROLZ vs ROLZX).
+func (this *ROLZCodec) reset(ctx *map[string]interface{}) bool {
+	extra := false
+
+	if val, containsKey := (*ctx)["transform"]; containsKey {
+		extra = strings.Contains(val.(string), "ROLZX")
+	}
+
+	_, isExtra := this.delegate.(*rolzCodec2)
+
+	if extra != isExtra {
+		return false
+	}
+
+	this.setDictionary(getDictionary(ctx))
+	return true
+}
+
 func (this *ROLZCodec) Forward(src, dst []byte) (uint, uint, error) {
 	if len(src) == 0 {
 		return 0, 0, nil
 	}
 
 	if &src[0] == &dst[0] {
-		return 0, 0, errors.New("Input and output buffers cannot be equal")
+		return 0, 0, kanzi.NewError("Input and output buffers cannot be equal", kanzi.ERR_INVALID_PARAM)
 	}
 
 	if len(src) > TC_MAX_BLOCK_SIZE {
 		// Not a recoverable error: instead of silently fail the transform,
 		// issue a fatal error.
 		errMsg := fmt.Sprintf("The max NewROLZCodec block size is %v, got %v", TC_MAX_BLOCK_SIZE, len(src))
-		panic(errors.New(errMsg))
+		panic(kanzi.NewError(errMsg, kanzi.ERR_BLOCK_SIZE))
 	}
 
 	return this.delegate.Forward(src, dst)
@@ -157,14 +216,14 @@ func (this *ROLZCodec) Inverse(src, dst []byte) (uint, uint, error) {
 	}
 
 	if &src[0] == &dst[0] {
-		return 0, 0, errors.New("Input and output buffers cannot be equal")
+		return 0, 0, kanzi.NewError("Input and output buffers cannot be equal", kanzi.ERR_INVALID_PARAM)
 	}
 
 	if len(src) > TC_MAX_BLOCK_SIZE {
 		// Not a recoverable error: instead of silently fail the transform,
 		// issue a fatal error.
 		errMsg := fmt.Sprintf("The max NewROLZCodec block size is %v, got %v", TC_MAX_BLOCK_SIZE, len(src))
-		panic(errors.New(errMsg))
+		panic(kanzi.NewError(errMsg, kanzi.ERR_BLOCK_SIZE))
 	}
 
 	return this.delegate.Inverse(src, dst)
@@ -181,6 +240,8 @@ type rolzCodec1 struct {
 	logPosChecks uint
 	maskChecks   int32
 	posChecks    int32
+	dictionary   []byte // tail of the pre-shared dictionary (if any)
+	window       []byte // dictionary + first chunk
 }
 
 // Use ANS to encode/decode literals and matches
@@ -188,7 +249,7 @@ func newROLZCodec1(logPosChecks uint) (*rolzCodec1, error) {
 	this := new(rolzCodec1)
 
 	if (logPosChecks < 2) || (logPosChecks > 8) {
-		return nil, fmt.Errorf("Invalid logPosChecks parameter: %v (must be in [2..8])", logPosChecks)
+		return nil, kanzi.NewError(fmt.Sprintf("Invalid logPosChecks parameter: %v (must be in [2..8])", logPosChecks), kanzi.ERR_INVALID_PARAM)
 	}
 
 	this.logPosChecks = logPosChecks
@@ -261,9 +322,25 @@ func (this *rolzCodec1) findMatch(buf []byte, pos int) (int, int) {
 	return bestIdx, bestLen - ROLZ_MIN_MATCH
 }
 
+// Register the positions of the dictionary (prefix of buf)
+func (this *rolzCodec1) addDictionary(buf []byte, dictSize int, decoding bool) {
+	for pos := 2; pos < dictSize; pos++ {
+		key := getKey(buf[pos-2:])
+		this.counters[key]++
+		val := int32(pos)
+
+		// The encoder also records the hash (see findMatch)
+		if decoding == false {
+			val |= hash(buf[pos : pos+4])
+		}
+
+		this.matches[(key<<this.logPosChecks)+uint32(this.counters[key]&this.maskChecks)] = val
+	}
+}
+
 func (this *rolzCodec1) Forward(src, dst []byte) (uint, uint, error) {
 	if n := this.MaxEncodedLen(len(src)); len(dst) < n {
-		return 0, 0, fmt.Errorf("Output buffer is too small - size: %d, required %d", len(dst), n)
+		return 0, 0, kanzi.NewError(fmt.Sprintf("Output buffer is too small - size: %d, required %d", len(dst), n), kanzi.ERR_BUFFER_TOO_SMALL)
 	}
 
 	if len(src) <= 16 {
@@ -286,6 +363,7 @@ func (this *rolzCodec1) Forward(src, dst []byte) (uint, uint, error) {
 	litBuf := make([]byte, this.MaxEncodedLen(sizeChunk))
 	mLenBuf := make([]byte, sizeChunk/2)
 	mIdxBuf := make([]byte, sizeChunk/2)
+	dict := getROLZDictionary(this.dictionary, len(src))
 	var err error
 
 	for i := range this.counters {
@@ -309,7 +387,20 @@ func (this *rolzCodec1) Forward(src, dst []byte) (uint, uint, error) {
 
 		sizeChunk = endChunk - startChunk
 		buf := src[startChunk:endChunk]
-		srcIdx = 0
+		base := 0
+
+		// Prepend the dictionary (if any) to the first chunk
+		if startChunk == 0 && len(dict) > 0 {
+			base = len(dict)
+			// Copy the whole block: the hash of the last positions reads
+			// the last bytes (as with src)
+			buf = getROLZWindow(&this.window, dict, len(src))
+			copy(buf[base:], src)
+			buf = buf[0 : base+sizeChunk]
+			this.addDictionary(buf, base, false)
+		}
+
+		srcIdx = base
 		litBuf[litIdx] = buf[srcIdx]
 		litIdx++
 		srcIdx++
@@ -323,7 +414,7 @@ func (this *rolzCodec1) Forward(src, dst []byte) (uint, uint, error) {
 		firstLitIdx := srcIdx
 
 		// Next chunk
-		for srcIdx < sizeChunk {
+		for srcIdx < base+sizeChunk {
 			matchIdx, matchLen := this.findMatch(buf, srcIdx)
 
 			if matchIdx == -1 {
@@ -335,7 +426,7 @@ func (this *rolzCodec1) Forward(src, dst []byte) (uint, uint, error) {
 			litIdx += emitLiteralLength(litBuf[litIdx:], length)
 
 			// Emit literals
-			copy(litBuf[litIdx:], src[firstLitIdx:firstLitIdx+length])
+			copy(litBuf[litIdx:], buf[firstLitIdx:firstLitIdx+length])
 			litIdx += length
 
 			// Emit match
@@ -355,6 +446,7 @@ func (this *rolzCodec1) Forward(src, dst []byte) (uint, uint, error) {
 		}
 
 		litIdx += length
+		srcIdx -= base
 		var os util.BufferStream
 
 		// Scope to deallocate resources early
@@ -405,7 +497,7 @@ func (this *rolzCodec1) Forward(src, dst []byte) (uint, uint, error) {
 		bufSize := os.Len()
 
 		if dstIdx+bufSize > len(dst) {
-			err = errors.New("Destination buffer too small")
+			err = kanzi.NewError("Destination buffer too small", kanzi.ERR_BUFFER_TOO_SMALL)
 			break
 		}
 
@@ -425,7 +517,7 @@ End:
 		dstIdx += 4
 
 		if srcIdx != len(src) {
-			err = errors.New("Destination buffer too small")
+			err = kanzi.NewError("Destination buffer too small", kanzi.ERR_BUFFER_TOO_SMALL)
 		}
 	}
 
@@ -458,6 +550,7 @@ func (this *rolzCodec1) Inverse(src, dst []byte) (uint, uint, error) {
 	litBuf := make([]byte, this.MaxEncodedLen(sizeChunk))
 	mLenBuf := make([]byte, sizeChunk/2)
 	mIdxBuf := make([]byte, sizeChunk/2)
+	dict := getROLZDictionary(this.dictionary, dstEnd+4)
 	var err error
 
 	for i := range this.counters {
@@ -481,7 +574,16 @@ func (this *rolzCodec1) Inverse(src, dst []byte) (uint, uint, error) {
 
 		sizeChunk = endChunk - startChunk
 		buf := dst[startChunk:endChunk]
-		dstIdx = 0
+		base := 0
+
+		// Decode the first chunk after the dictionary (if any)
+		if startChunk == 0 && len(dict) > 0 {
+			base = len(dict)
+			buf = getROLZWindow(&this.window, dict, sizeChunk)
+			this.addDictionary(buf, base, true)
+		}
+
+		dstIdx = base
 
 		// Scope to deallocate resources early
 		{
@@ -532,7 +634,7 @@ func (this *rolzCodec1) Inverse(src, dst []byte) (uint, uint, error) {
 			ibs.Close()
 
 			if length > sizeChunk {
-				err = fmt.Errorf("Invalid length: got %v, must be less than or equal to %v", length, sizeChunk)
+				err = kanzi.NewError(fmt.Sprintf("Invalid length: got %v, must be less than or equal to %v", length, sizeChunk), kanzi.ERR_INVALID_FILE)
 				goto End
 			}
 		}
@@ -548,19 +650,19 @@ func (this *rolzCodec1) Inverse(src, dst []byte) (uint, uint, error) {
 		}
 
 		// Next chunk
-		for dstIdx < endChunk {
+		for dstIdx < base+endChunk {
 			length, litDelta := this.emitLiterals(litBuf[litIdx:], buf, dstIdx)
 
 			litIdx += (length + litDelta)
 			dstIdx += length
 
-			if dstIdx >= endChunk {
+			if dstIdx >= base+endChunk {
 				// Last chunk literals not followed by match
-				if dstIdx == endChunk {
+				if dstIdx == base+endChunk {
 					break
 				}
 
-				err = errors.New("Invalid input data")
+				err = kanzi.NewError("Invalid input data", kanzi.ERR_INVALID_FILE)
 				goto End
 			}
 
@@ -570,8 +672,8 @@ func (this *rolzCodec1) Inverse(src, dst []byte) (uint, uint, error) {
 			matchLen := int(mLenBuf[mIdx] & 0xFF)
 
 			// Sanity check
-			if dstIdx+matchLen+3 > dstEnd {
-				err = errors.New("Invalid input data")
+			if dstIdx+matchLen+3 > base+dstEnd {
+				err = kanzi.NewError("Invalid input data", kanzi.ERR_INVALID_FILE)
 				goto End
 			}
 
@@ -583,6 +685,11 @@ func (this *rolzCodec1) Inverse(src, dst []byte) (uint, uint, error) {
 			m[this.counters[key]&this.maskChecks] = int32(savedIdx)
 		}
 
+		if base > 0 {
+			copy(dst, buf[base:dstIdx])
+			dstIdx -= base
+		}
+
 		startChunk = endChunk
 	}
 
@@ -599,7 +706,7 @@ End:
 		dstIdx += 4
 
 		if srcIdx != len(src) {
-			err = errors.New("Invalid input data")
+			err = kanzi.NewError("Invalid input data", kanzi.ERR_INVALID_FILE)
 		}
 	}
 
@@ -685,13 +792,15 @@ type rolzCodec2 struct {
 	posChecks      int32
 	litPredictor   *rolzPredictor
 	matchPredictor *rolzPredictor
+	dictionary     []byte // tail of the pre-shared dictionary (if any)
+	window         []byte // dictionary + first chunk
 }
 
 func newROLZCodec2(logPosChecks uint) (*rolzCodec2, error) {
 	this := new(rolzCodec2)
 
 	if (logPosChecks < 2) || (logPosChecks > 8) {
-		return nil, fmt.Errorf("Invalid logPosChecks parameter: %v (must be in [2..8])", logPosChecks)
+		return nil, kanzi.NewError(fmt.Sprintf("Invalid logPosChecks parameter: %v (must be in [2..8])", logPosChecks), kanzi.ERR_INVALID_PARAM)
 	}
 
 	this.logPosChecks = logPosChecks
@@ -766,9 +875,25 @@ func (this *rolzCodec2) findMatch(buf []byte, pos int) (int, int) {
 	return bestIdx, bestLen - ROLZ_MIN_MATCH
 }
 
+// Register the positions of the dictionary (prefix of buf)
+func (this *rolzCodec2) addDictionary(buf []byte, dictSize int, decoding bool) {
+	for pos := 2; pos < dictSize; pos++ {
+		key := getKey(buf[pos-2:])
+		this.counters[key]++
+		val := int32(pos)
+
+		// The encoder also records the hash (see findMatch)
+		if decoding == false {
+			val |= hash(buf[pos : pos+4])
+		}
+
+		this.matches[(key<<this.logPosChecks)+uint32(this.counters[key]&this.maskChecks)] = val
+	}
+}
+
 func (this *rolzCodec2) Forward(src, dst []byte) (uint, uint, error) {
 	if n := this.MaxEncodedLen(len(src)); len(dst) < n {
-		return 0, 0, fmt.Errorf("Output buffer is too small - size: %d, required %d", len(dst), n)
+		return 0, 0, kanzi.NewError(fmt.Sprintf("Output buffer is too small - size: %d, required %d", len(dst), n), kanzi.ERR_BUFFER_TOO_SMALL)
 	}
 
 	if len(src) <= 16 {
@@ -792,6 +917,7 @@ func (this *rolzCodec2) Forward(src, dst []byte) (uint, uint, error) {
 	this.matchPredictor.reset()
 	predictors := [2]kanzi.Predictor{this.litPredictor, this.matchPredictor}
 	re, _ := newRolzEncoder(predictors[:], dst, &dstIdx)
+	dict := getROLZDictionary(this.dictionary, len(src))
 
 	for i := range this.counters {
 		this.counters[i] = 0
@@ -811,7 +937,20 @@ func (this *rolzCodec2) Forward(src, dst []byte) (uint, uint, error) {
 
 		sizeChunk = endChunk - startChunk
 		buf := src[startChunk:endChunk]
-		srcIdx = 0
+		base := 0
+
+		// Prepend the dictionary (if any) to the first chunk
+		if startChunk == 0 && len(dict) > 0 {
+			base = len(dict)
+			// Copy the whole block: the hash of the last positions reads
+			// the last bytes (as with src)
+			buf = getROLZWindow(&this.window, dict, len(src))
+			copy(buf[base:], src)
+			buf = buf[0 : base+sizeChunk]
+			this.addDictionary(buf, base, false)
+		}
+
+		srcIdx = base
 		this.litPredictor.setContext(0)
 		re.setContext(ROLZ_LITERAL_FLAG)
 		re.encodeBit(ROLZ_LITERAL_FLAG)
@@ -825,7 +964,7 @@ func (this *rolzCodec2) Forward(src, dst []byte) (uint, uint, error) {
 		}
 
 		// Next chunk
-		for srcIdx < sizeChunk {
+		for srcIdx < base+sizeChunk {
 			this.litPredictor.setContext(buf[srcIdx-1])
 			re.setContext(ROLZ_LITERAL_FLAG)
 			matchIdx, matchLen := this.findMatch(buf, srcIdx)
@@ -848,6 +987,7 @@ func (this *rolzCodec2) Forward(src, dst []byte) (uint, uint, error) {
 			}
 		}
 
+		srcIdx -= base
 		startChunk = endChunk
 	}
 
@@ -866,7 +1006,7 @@ func (this *rolzCodec2) Forward(src, dst []byte) (uint, uint, error) {
 	var err error
 
 	if srcIdx != len(src) {
-		err = errors.New("Destination buffer too small")
+		err = kanzi.NewError("Destination buffer too small", kanzi.ERR_BUFFER_TOO_SMALL)
 	}
 
 	return uint(srcIdx), uint(dstIdx), err
@@ -894,6 +1034,7 @@ func (this *rolzCodec2) Inverse(src, dst []byte) (uint, uint, error) {
 	this.matchPredictor.reset()
 	predictors := [2]kanzi.Predictor{this.litPredictor, this.matchPredictor}
 	rd, _ := newRolzDecoder(predictors[:], src, &srcIdx)
+	dict := getROLZDictionary(this.dictionary, dstEnd)
 
 	for i := range this.counters {
 		this.counters[i] = 0
@@ -913,7 +1054,16 @@ func (this *rolzCodec2) Inverse(src, dst []byte) (uint, uint, error) {
 
 		sizeChunk = endChunk - startChunk
 		buf := dst[startChunk:endChunk]
-		dstIdx = 0
+		base := 0
+
+		// Decode the first chunk after the dictionary (if any)
+		if startChunk == 0 && len(dict) > 0 {
+			base = len(dict)
+			buf = getROLZWindow(&this.window, dict, sizeChunk)
+			this.addDictionary(buf, base, true)
+		}
+
+		dstIdx = base
 		this.litPredictor.setContext(0)
 		rd.setContext(ROLZ_LITERAL_FLAG)
 		bit := rd.decodeBit()
@@ -934,12 +1084,12 @@ func (this *rolzCodec2) Inverse(src, dst []byte) (uint, uint, error) {
 
 		// Sanity check
 		if bit == ROLZ_MATCH_FLAG {
-			dstIdx += startChunk
+			dstIdx += startChunk - base
 			break
 		}
 
 		// Next chunk
-		for dstIdx < sizeChunk {
+		for dstIdx < base+sizeChunk {
 			savedIdx := dstIdx
 			key := getKey(buf[dstIdx-2:])
 			m := this.matches[key<<this.logPosChecks : (key+1)<<this.logPosChecks]
@@ -965,7 +1115,7 @@ func (this *rolzCodec2) Inverse(src, dst []byte) (uint, uint, error) {
 				}
 
 				ref := m[(this.counters[key]-matchIdx)&this.maskChecks]
-				dstIdx = emitCopy(dst, dstIdx, int(ref), matchLen)
+				dstIdx = emitCopy(buf, dstIdx, int(ref), matchLen)
 			} else {
 				// Literal flag
 				buf[dstIdx] = rd.decodeByte()
@@ -977,6 +1127,11 @@ func (this *rolzCodec2) Inverse(src, dst []byte) (uint, uint, error) {
 			m[this.counters[key]&this.maskChecks] = int32(savedIdx)
 		}
 
+		if base > 0 {
+			copy(dst, buf[base:dstIdx])
+			dstIdx -= base
+		}
+
 		startChunk = endChunk
 	}
 
@@ -985,7 +1140,7 @@ func (this *rolzCodec2) Inverse(src, dst []byte) (uint, uint, error) {
 	dstIdx += (startChunk - sizeChunk)
 
 	if srcIdx != len(src) {
-		err = errors.New("Invalid input data")
+		err = kanzi.NewError("Invalid input data", kanzi.ERR_INVALID_FILE)
 	}
 
 	return uint(srcIdx), uint(dstIdx), err
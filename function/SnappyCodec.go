@@ -19,7 +19,6 @@ package function
 // reasonable compression ratios.
 import (
 	"encoding/binary"
-	"errors"
 	"fmt"
 	kanzi "github.com/flanglet/kanzi-go"
 )
@@ -52,6 +51,15 @@ func NewSnappyCodec() (*SnappyCodec, error) {
 	return this, nil
 }
 
+// Clear the hash table (the positions of a previous block are not valid)
+func (this *SnappyCodec) reset(ctx *map[string]interface{}) bool {
+	for i := range this.buffer {
+		this.buffer[i] = 0
+	}
+
+	return true
+}
+
 // snappyEmitLiteral writes a literal chunk and returns the number of bytes written.
 func snappyEmitLiteral(src, dst []byte) int {
 	dstIdx := 0
@@ -146,13 +154,13 @@ func (this *SnappyCodec) Forward(src, dst []byte) (uint, uint, error) {
 	}
 
 	if &src[0] == &dst[0] {
-		return 0, 0, errors.New("Input and output buffers cannot be equal")
+		return 0, 0, kanzi.NewError("Input and output buffers cannot be equal", kanzi.ERR_INVALID_PARAM)
 	}
 
 	count := len(src)
 
 	if n := this.MaxEncodedLen(count); len(dst) < n {
-		return 0, 0, fmt.Errorf("Output buffer is too small - size: %d, required %d", len(dst), n)
+		return 0, 0, kanzi.NewError(fmt.Sprintf("Output buffer is too small - size: %d, required %d", len(dst), n), kanzi.ERR_BUFFER_TOO_SMALL)
 	}
 
 	// The block starts with the varint-encoded length of the decompressed bytes.
@@ -235,7 +243,7 @@ func (this *SnappyCodec) Inverse(src, dst []byte) (uint, uint, error) {
 	}
 
 	if &src[0] == &dst[0] {
-		return 0, 0, errors.New("Input and output buffers cannot be equal")
+		return 0, 0, kanzi.NewError("Input and output buffers cannot be equal", kanzi.ERR_INVALID_PARAM)
 	}
 
 	// Get decoded length
@@ -243,11 +251,11 @@ func (this *SnappyCodec) Inverse(src, dst []byte) (uint, uint, error) {
 	src = src[idx:]
 
 	if err != nil {
-		return 0, 0, fmt.Errorf("Decoding error: %v", err)
+		return 0, 0, kanzi.WrapError(err, fmt.Sprintf("Decoding error: %v", err), kanzi.ERR_INVALID_FILE)
 	}
 
 	if len(dst) < int(dLen) {
-		return 0, 0, errors.New("Decoding error: output buffer too small")
+		return 0, 0, kanzi.NewError("Decoding error: output buffer too small", kanzi.ERR_BUFFER_TOO_SMALL)
 	}
 
 	ends := uint(len(src))
@@ -333,7 +341,7 @@ func (this *SnappyCodec) Inverse(src, dst []byte) (uint, uint, error) {
 	}
 
 	if d != dLen {
-		err = fmt.Errorf("Decoding error: decoded %v byte(s), expected %v", d, dLen)
+		err = kanzi.NewError(fmt.Sprintf("Decoding error: decoded %v byte(s), expected %v", d, dLen), kanzi.ERR_INVALID_FILE)
 		return s, d, err
 	}
 
@@ -363,7 +371,7 @@ func (this SnappyCodec) getUvarint(buf []byte) (uint64, int, error) {
 
 		if s >= 63 {
 			if ((s == 63) && (b > 1)) || (s > 63) {
-				return 0, 0, errors.New("Overflow: value is larger than 64 bits")
+				return 0, 0, kanzi.NewError("Overflow: value is larger than 64 bits", kanzi.ERR_INVALID_FILE)
 			}
 		}
 
@@ -375,7 +383,7 @@ func (this SnappyCodec) getUvarint(buf []byte) (uint64, int, error) {
 		s += 7
 	}
 
-	return 0, 0, errors.New("Input buffer too small")
+	return 0, 0, kanzi.NewError("Input buffer too small", kanzi.ERR_INVALID_FILE)
 }
 
 // getDecodedLength returns the length of the decoded block
@@ -387,7 +395,7 @@ func (this SnappyCodec) getDecodedLength(buf []byte) (uint, int, error) {
 	}
 
 	if v > 0x7FFFFFFF {
-		return 0, idx, errors.New("Overflow: invalid length")
+		return 0, idx, kanzi.NewError("Overflow: invalid length", kanzi.ERR_INVALID_FILE)
 	}
 
 	return uint(v), idx, nil
@@ -16,7 +16,6 @@ limitations under the License.
 package function
 
 import (
-	"errors"
 	"fmt"
 	kanzi "github.com/flanglet/kanzi-go"
 )
@@ -39,6 +38,7 @@ const (
 	CR                 = byte(0x0D)
 	TC_HASH1           = int32(2146121005)  // 0x7FEB352D
 	TC_HASH2           = int32(-2073254261) // 0x846CA68B
+	TC_MAX_DICT_WORDS  = 1024               // max words from a pre-shared dictionary
 )
 
 type dictEntry struct {
@@ -48,7 +48,8 @@ type dictEntry struct {
 }
 
 type TextCodec struct {
-	delegate kanzi.ByteFunction
+	delegate   kanzi.ByteFunction
+	dictionary []byte // pre-shared dictionary (if any)
 }
 
 type textCodec1 struct {
@@ -746,6 +747,50 @@ func createDictionary(words []byte, dict []dictEntry, maxWords, startWord int) i
 	return nbWords
 }
 
+// Append the distinct words of a pre-shared dictionary to the static
+// dictionary (at most TC_MAX_DICT_WORDS words). Only the words that the
+// codec would add to the dynamic dictionary are kept (letters followed by
+// a delimiter, see Forward). Return the new number of words.
+func addDictionaryWords(words []byte, dict []dictEntry, nbWords int) int {
+	if len(words) == 0 {
+		return nbWords
+	}
+
+	known := make(map[string]bool, nbWords+TC_MAX_DICT_WORDS)
+
+	for i := range dict[0:nbWords] {
+		known[string(dict[i].ptr)] = true
+	}
+
+	maxWords := nbWords + TC_MAX_DICT_WORDS
+	anchor := 0
+	h := TC_HASH1
+
+	for i := 0; (i < len(words)) && (nbWords < maxWords); i++ {
+		cur := words[i]
+
+		if isText(cur) {
+			h = h*TC_HASH1 ^ int32(cur)*TC_HASH2
+			continue
+		}
+
+		length := i - anchor
+
+		if isDelimiter(cur) && length > 2 && length < TC_MAX_WORD_LENGTH {
+			if w := string(words[anchor:i]); known[w] == false {
+				known[w] = true
+				dict[nbWords] = dictEntry{ptr: words[anchor:i], hash: h, data: int32((length << 24) | nbWords)}
+				nbWords++
+			}
+		}
+
+		anchor = i + 1
+		h = TC_HASH1
+	}
+
+	return nbWords
+}
+
 func isText(val byte) bool {
 	return isLowerCase(val) || isUpperCase(val)
 }
@@ -825,23 +870,87 @@ func NewTextCodecWithCtx(ctx *map[string]interface{}) (*TextCodec, error) {
 		this.delegate = d
 	}
 
+	this.dictionary = getDictionary(ctx)
 	return this, err
 }
 
+// Return the log of the hash map size and the dictionary size for the
+// provided context
+func getTextCodecParams(ctx *map[string]interface{}) (uint, int) {
+	log := uint32(8)
+	blockSize := uint(0)
+
+	if val, containsKey := (*ctx)["size"]; containsKey {
+		// Actual block size
+		blockSize = val.(uint)
+
+		if blockSize >= 1<<28 {
+			log = 26
+		} else if blockSize >= 1<<10 {
+			log, _ = kanzi.Log2(uint32(blockSize / 4))
+		}
+	}
+
+	// Select an appropriate initial dictionary size
+	dSize := 1 << 12
+
+	for i := uint(14); i <= 24; i += 2 {
+		if blockSize >= 1<<i {
+			dSize <<= 1
+		}
+	}
+
+	extraMem := uint(0)
+
+	if val, containsKey := (*ctx)["extra"]; containsKey {
+		if val.(bool) == true {
+			extraMem = 1
+		}
+	}
+
+	return uint(log) + extraMem, dSize
+}
+
+// Return true if the codec can be reused for a block with the provided
+// context (same encoding and same dictionary sizes)
+func (this *TextCodec) reset(ctx *map[string]interface{}) bool {
+	encodingType := 1
+
+	if val, containsKey := (*ctx)["textcodec"]; containsKey {
+		encodingType = val.(int)
+	}
+
+	logHashSize, dictSize := getTextCodecParams(ctx)
+
+	// The pre-shared dictionary is part of the static dictionary
+	if sameDictionary(this.dictionary, getDictionary(ctx)) == false {
+		return false
+	}
+
+	switch d := this.delegate.(type) {
+	case *textCodec1:
+		return encodingType != 2 && d.logHashSize == logHashSize && d.dictSize == dictSize
+	case *textCodec2:
+		return encodingType == 2 && d.logHashSize == logHashSize && d.dictSize == dictSize
+	default:
+		return false
+	}
+}
+
 func (this *TextCodec) Forward(src, dst []byte) (uint, uint, error) {
 	if len(src) == 0 {
 		return 0, 0, nil
 	}
 
 	if &src[0] == &dst[0] {
-		return 0, 0, errors.New("Input and output buffers cannot be equal")
+		return 0, 0, kanzi.NewError("Input and output buffers cannot be equal", kanzi.ERR_INVALID_PARAM)
 	}
 
 	if len(src) > TC_MAX_BLOCK_SIZE {
 		// Not a recoverable error: instead of silently fail the transform,
 		// issue a fatal error.
 		errMsg := fmt.Sprintf("The max TextCodec block size is %v, got %v", TC_MAX_BLOCK_SIZE, len(src))
-		panic(errors.New(errMsg))
+		panic(kanzi.NewError(errMsg, kanzi.ERR_BLOCK_SIZE))
 	}
 
 	return this.delegate.Forward(src, dst)
@@ -853,14 +962,14 @@ func (this *TextCodec) Inverse(src, dst []byte) (uint, uint, error) {
 	}
 
 	if &src[0] == &dst[0] {
-		return 0, 0, errors.New("Input and output buffers cannot be equal")
+		return 0, 0, kanzi.NewError("Input and output buffers cannot be equal", kanzi.ERR_INVALID_PARAM)
 	}
 
 	if len(src) > TC_MAX_BLOCK_SIZE {
 		// Not a recoverable error: instead of silently fail the transform,
 		// issue a fatal error.
 		errMsg := fmt.Sprintf("The max TextCodec block size is %v, got %v", TC_MAX_BLOCK_SIZE, len(src))
-		panic(errors.New(errMsg))
+		panic(kanzi.NewError(errMsg, kanzi.ERR_BLOCK_SIZE))
 	}
 
 	return this.delegate.Inverse(src, dst)
@@ -896,39 +1005,7 @@ func newTextCodec1() (*textCodec1, error) {
 
 func newTextCodec1WithCtx(ctx *map[string]interface{}) (*textCodec1, error) {
 	this := new(textCodec1)
-	log := uint32(8)
-	blockSize := uint(0)
-
-	if val, containsKey := (*ctx)["size"]; containsKey {
-		// Actual block size
-		blockSize = val.(uint)
-
-		if blockSize >= 1<<28 {
-			log = 26
-		} else if blockSize >= 1<<10 {
-			log, _ = kanzi.Log2(uint32(blockSize / 4))
-		}
-	}
-
-	// Select an appropriate initial dictionary size
-	dSize := 1 << 12
-
-	for i := uint(14); i <= 24; i += 2 {
-		if blockSize >= 1<<i {
-			dSize <<= 1
-		}
-	}
-
-	extraMem := uint(0)
-
-	if val, containsKey := (*ctx)["extra"]; containsKey {
-		if val.(bool) == true {
-			extraMem = 1
-		}
-	}
-
-	this.logHashSize = uint(log) + extraMem
-	this.dictSize = dSize
+	this.logHashSize, this.dictSize = getTextCodecParams(ctx)
 	this.dictMap = make([]*dictEntry, 1<<this.logHashSize)
 	this.dictList = make([]dictEntry, this.dictSize)
 	this.hashMask = int32(1<<this.logHashSize) - 1
@@ -939,7 +1016,7 @@ func newTextCodec1WithCtx(ctx *map[string]interface{}) (*textCodec1, error) {
 	}
 
 	copy(this.dictList, TC_STATIC_DICTIONARY[0:size])
-	nbWords := TC_STATIC_DICT_WORDS
+	nbWords := addDictionaryWords(getDictionary(ctx), this.dictList, TC_STATIC_DICT_WORDS)
 
 	// Add special entries at end of static dictionary
 	this.dictList[nbWords] = dictEntry{ptr: []byte{TC_ESCAPE_TOKEN2}, hash: 0, data: int32((1 << 24) | (nbWords))}
@@ -969,7 +1046,7 @@ func (this *textCodec1) Forward(src, dst []byte) (uint, uint, error) {
 	count := len(src)
 
 	if n := this.MaxEncodedLen(count); len(dst) < n {
-		return 0, 0, fmt.Errorf("Output buffer is too small - size: %d, required %d", len(dst), n)
+		return 0, 0, kanzi.NewError(fmt.Sprintf("Output buffer is too small - size: %d, required %d", len(dst), n), kanzi.ERR_BUFFER_TOO_SMALL)
 	}
 
 	srcIdx := 0
@@ -978,7 +1055,7 @@ func (this *textCodec1) Forward(src, dst []byte) (uint, uint, error) {
 
 	// Not text ?
 	if mode&0x80 != 0 {
-		return uint(srcIdx), uint(dstIdx), errors.New("Input is not text, skipping")
+		return uint(srcIdx), uint(dstIdx), kanzi.NewError("Input is not text, skipping", kanzi.ERR_PROCESS_BLOCK)
 	}
 
 	if count <= 64 {
@@ -1128,7 +1205,7 @@ func (this *textCodec1) Forward(src, dst []byte) (uint, uint, error) {
 	var err error
 
 	if srcIdx != srcEnd {
-		err = fmt.Errorf("Forward transform failed. Source index: %v, expected: %v", srcIdx, srcEnd)
+		err = kanzi.NewError(fmt.Sprintf("Forward transform failed. Source index: %v, expected: %v", srcIdx, srcEnd), kanzi.ERR_PROCESS_BLOCK)
 	}
 
 	return uint(srcIdx), uint(dstIdx), err
@@ -1337,7 +1414,7 @@ func (this *textCodec1) Inverse(src, dst []byte) (uint, uint, error) {
 
 			// Sanity check
 			if buf == nil || dstIdx+length >= dstEnd {
-				err = fmt.Errorf("Invalid input data")
+				err = kanzi.NewError("Invalid input data", kanzi.ERR_INVALID_FILE)
 				break
 			}
 
@@ -1387,7 +1464,7 @@ func (this *textCodec1) Inverse(src, dst []byte) (uint, uint, error) {
 	}
 
 	if (err == nil) && (srcIdx != srcEnd) {
-		err = fmt.Errorf("Forward transform failed. Source index: %v, expected: %v", srcIdx, srcEnd)
+		err = kanzi.NewError(fmt.Sprintf("Inverse transform failed. Source index: %v, expected: %v", srcIdx, srcEnd), kanzi.ERR_PROCESS_BLOCK)
 	}
 
 	return uint(srcIdx), uint(dstIdx), err
@@ -1419,39 +1496,7 @@ func newTextCodec2() (*textCodec2, error) {
 
 func newTextCodec2WithCtx(ctx *map[string]interface{}) (*textCodec2, error) {
 	this := new(textCodec2)
-	log := uint32(8)
-	blockSize := uint(0)
-
-	if val, containsKey := (*ctx)["size"]; containsKey {
-		// Actual block size
-		blockSize = val.(uint)
-
-		if blockSize >= 1<<28 {
-			log = 26
-		} else if blockSize >= 1<<10 {
-			log, _ = kanzi.Log2(uint32(blockSize / 4))
-		}
-	}
-
-	// Select an appropriate initial dictionary size
-	dSize := 1 << 12
-
-	for i := uint(14); i <= 24; i += 2 {
-		if blockSize >= 1<<i {
-			dSize <<= 1
-		}
-	}
-
-	extraMem := uint(0)
-
-	if val, containsKey := (*ctx)["extra"]; containsKey {
-		if val.(bool) == true {
-			extraMem = 1
-		}
-	}
-
-	this.logHashSize = uint(log) + extraMem
-	this.dictSize = dSize
+	this.logHashSize, this.dictSize = getTextCodecParams(ctx)
 	this.dictMap = make([]*dictEntry, 1<<this.logHashSize)
 	this.dictList = make([]dictEntry, this.dictSize)
 	this.hashMask = int32(1<<this.logHashSize) - 1
@@ -1462,7 +1507,7 @@ func newTextCodec2WithCtx(ctx *map[string]interface{}) (*textCodec2, error) {
 	}
 
 	copy(this.dictList, TC_STATIC_DICTIONARY[0:size])
-	this.staticDictSize = TC_STATIC_DICT_WORDS
+	this.staticDictSize = addDictionaryWords(getDictionary(ctx), this.dictList, TC_STATIC_DICT_WORDS)
 	return this, nil
 }
 
@@ -1487,7 +1532,7 @@ func (this *textCodec2) Forward(src, dst []byte) (uint, uint, error) {
 	count := len(src)
 
 	if n := this.MaxEncodedLen(count); len(dst) < n {
-		return 0, 0, fmt.Errorf("Output buffer is too small - size: %d, required %d", len(dst), n)
+		return 0, 0, kanzi.NewError(fmt.Sprintf("Output buffer is too small - size: %d, required %d", len(dst), n), kanzi.ERR_BUFFER_TOO_SMALL)
 	}
 
 	srcIdx := 0
@@ -1496,7 +1541,7 @@ func (this *textCodec2) Forward(src, dst []byte) (uint, uint, error) {
 
 	// Not text ?
 	if mode&0x80 != 0 {
-		return uint(srcIdx), uint(dstIdx), errors.New("Input is not text, skipping")
+		return uint(srcIdx), uint(dstIdx), kanzi.NewError("Input is not text, skipping", kanzi.ERR_PROCESS_BLOCK)
 	}
 
 	if count <= 64 {
@@ -1645,7 +1690,7 @@ func (this *textCodec2) Forward(src, dst []byte) (uint, uint, error) {
 	var err error
 
 	if srcIdx != srcEnd {
-		err = fmt.Errorf("Forward transform failed. Source index: %v, expected: %v", srcIdx, srcEnd)
+		err = kanzi.NewError(fmt.Sprintf("Forward transform failed. Source index: %v, expected: %v", srcIdx, srcEnd), kanzi.ERR_PROCESS_BLOCK)
 	}
 
 	return uint(srcIdx), uint(dstIdx), err
@@ -1842,7 +1887,7 @@ func (this *textCodec2) Inverse(src, dst []byte) (uint, uint, error) {
 
 			// Sanity check
 			if buf == nil || dstIdx+length >= dstEnd {
-				err = fmt.Errorf("Invalid input data")
+				err = kanzi.NewError("Invalid input data", kanzi.ERR_INVALID_FILE)
 				break
 			}
 
@@ -1899,7 +1944,7 @@ func (this *textCodec2) Inverse(src, dst []byte) (uint, uint, error) {
 	}
 
 	if (err == nil) && (srcIdx != srcEnd) {
-		err = fmt.Errorf("Forward transform failed. Source index: %v, expected: %v", srcIdx, srcEnd)
+		err = kanzi.NewError(fmt.Sprintf("Inverse transform failed. Source index: %v, expected: %v", srcIdx, srcEnd), kanzi.ERR_PROCESS_BLOCK)
 	}
 
 	return uint(srcIdx), uint(dstIdx), err
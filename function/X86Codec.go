@@ -16,8 +16,8 @@ limitations under the License.
 package function
 
 import (
-	"errors"
 	"fmt"
+	kanzi "github.com/flanglet/kanzi-go"
 )
 
 const (
@@ -36,15 +36,20 @@ func NewX86Codec() (*X86Codec, error) {
 	return this, nil
 }
 
+// Stateless: nothing to do
+func (this *X86Codec) reset(ctx *map[string]interface{}) bool {
+	return true
+}
+
 func (this *X86Codec) Forward(src, dst []byte) (uint, uint, error) {
 	if &src[0] == &dst[0] {
-		return 0, 0, errors.New("Input and output buffers cannot be equal")
+		return 0, 0, kanzi.NewError("Input and output buffers cannot be equal", kanzi.ERR_INVALID_PARAM)
 	}
 
 	count := len(src)
 
 	if n := this.MaxEncodedLen(count); len(dst) < n {
-		return 0, 0, fmt.Errorf("Output buffer is too small - size: %d, required %d", len(dst), n)
+		return 0, 0, kanzi.NewError(fmt.Sprintf("Output buffer is too small - size: %d, required %d", len(dst), n), kanzi.ERR_BUFFER_TOO_SMALL)
 	}
 
 	jumps := 0
@@ -66,7 +71,7 @@ func (this *X86Codec) Forward(src, dst []byte) (uint, uint, error) {
 		// Number of jump instructions too small => either not a binary
 		// or not worth the change => skip. Very crude filter obviously.
 		// Also, binaries usually have a lot of 0x88..0x8C (MOV) instructions.
-		return 0, 0, errors.New("Not a binary or not enough jumps")
+		return 0, 0, kanzi.NewError("Not a binary or not enough jumps", kanzi.ERR_PROCESS_BLOCK)
 	}
 
 	srcIdx := 0
@@ -123,7 +128,7 @@ func (this *X86Codec) Forward(src, dst []byte) (uint, uint, error) {
 
 func (this *X86Codec) Inverse(src, dst []byte) (uint, uint, error) {
 	if &src[0] == &dst[0] {
-		return 0, 0, errors.New("Input and output buffers cannot be equal")
+		return 0, 0, kanzi.NewError("Input and output buffers cannot be equal", kanzi.ERR_INVALID_PARAM)
 	}
 
 	count := len(src)
@@ -16,7 +16,6 @@ limitations under the License.
 package function
 
 import (
-	"errors"
 	"fmt"
 	kanzi "github.com/flanglet/kanzi-go"
 )
@@ -35,17 +34,22 @@ func NewZRLT() (*ZRLT, error) {
 	return this, nil
 }
 
+// Stateless: nothing to do
+func (this *ZRLT) reset(ctx *map[string]interface{}) bool {
+	return true
+}
+
 func (this *ZRLT) Forward(src, dst []byte) (uint, uint, error) {
 	if len(src) == 0 {
 		return 0, 0, nil
 	}
 
 	if &src[0] == &dst[0] {
-		return 0, 0, errors.New("Input and output buffers cannot be equal")
+		return 0, 0, kanzi.NewError("Input and output buffers cannot be equal", kanzi.ERR_INVALID_PARAM)
 	}
 
 	if n := this.MaxEncodedLen(len(src)); len(dst) < n {
-		return 0, 0, fmt.Errorf("Output buffer is too small - size: %d, required %d", len(dst), n)
+		return 0, 0, kanzi.NewError(fmt.Sprintf("Output buffer is too small - size: %d, required %d", len(dst), n), kanzi.ERR_BUFFER_TOO_SMALL)
 	}
 
 	srcEnd, dstEnd := uint(len(src)), uint(len(dst))
@@ -105,7 +109,7 @@ func (this *ZRLT) Forward(src, dst []byte) (uint, uint, error) {
 	}
 
 	if srcIdx != srcEnd || runLength != 0 {
-		err = errors.New("Output buffer is too small")
+		err = kanzi.NewError("Output buffer is too small", kanzi.ERR_BUFFER_TOO_SMALL)
 	}
 
 	return srcIdx, dstIdx, err
@@ -117,7 +121,7 @@ func (this *ZRLT) Inverse(src, dst []byte) (uint, uint, error) {
 	}
 
 	if &src[0] == &dst[0] {
-		return 0, 0, errors.New("Input and output buffers cannot be equal")
+		return 0, 0, kanzi.NewError("Input and output buffers cannot be equal", kanzi.ERR_INVALID_PARAM)
 	}
 
 	srcEnd, dstEnd := len(src), len(dst)
@@ -177,7 +181,7 @@ End:
 	end := dstIdx + runLength - 1
 
 	if end > dstEnd {
-		err = errors.New("Output buffer is too small")
+		err = kanzi.NewError("Output buffer is too small", kanzi.ERR_BUFFER_TOO_SMALL)
 	} else {
 		for dstIdx < end {
 			dst[dstIdx] = 0
@@ -185,7 +189,7 @@ End:
 		}
 
 		if srcIdx < srcEnd {
-			err = errors.New("Output buffer is too small")
+			err = kanzi.NewError("Output buffer is too small", kanzi.ERR_BUFFER_TOO_SMALL)
 		}
 	}
 
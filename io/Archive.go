@@ -0,0 +1,678 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	kanzi "github.com/flanglet/kanzi-go"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// An archive stores many files in a single compressed stream. The stream
+// has a block index (random access to the entries) and the metadata key
+// METADATA_KEY_ARCHIVE (version of the archive format). Each entry starts
+// in a new block so that it can be extracted without decoding the others.
+// In solid mode (see ArchiveWriter.SetSolid), the entries are written back
+// to back: the blocks are full and the contexts of the transforms and
+// entropy codecs are shared by similar small files (better ratio), but the
+// extraction of an entry decodes the blocks shared with its neighbours.
+//
+// Decompressed content (big endian):
+//   data of the entries, back to back
+//   central directory:
+//     32 bits ARCHIVE_TYPE
+//     32 bits number of entries
+//     for each entry:
+//       16 bits length of the name, name (relative path, '/' separators)
+//       32 bits mode (os.FileMode)
+//       64 bits modification time in ns since epoch
+//       64 bits size
+//       64 bits position of the data in the decompressed content
+//       32 bits CRC32 (Castagnoli) of the data
+//     32 bits CRC32 of the central directory
+//   trailer:
+//     64 bits position of the central directory
+//     32 bits ARCHIVE_TYPE
+
+const (
+	ARCHIVE_TYPE          = 0x4B415243 // "KARC"
+	ARCHIVE_VERSION       = 1
+	ARCHIVE_TRAILER_SIZE  = 12
+	MAX_ARCHIVE_ENTRIES   = 1 << 24
+	MAX_ARCHIVE_NAME_SIZE = 0xFFFF
+	METADATA_KEY_ARCHIVE  = "archive" // version of the archive format (int)
+)
+
+var crc32Table = crc32.MakeTable(crc32.Castagnoli)
+
+// Description of a file (or a directory) stored in an archive
+type ArchiveEntry struct {
+	Name     string // relative path with '/' separators
+	Mode     os.FileMode
+	ModTime  time.Time
+	Size     int64  // set by the writer
+	Checksum uint32 // CRC32 (Castagnoli) of the data, set by the writer
+	position int64  // position of the data in the decompressed content
+}
+
+// Check that the name of an entry is a relative path inside the archive
+// (EG. no "../" component) and return it cleaned
+func checkArchiveName(name string) (string, error) {
+	clean := path.Clean(strings.Replace(name, "\\", "/", -1))
+
+	if len(name) == 0 || len(clean) > MAX_ARCHIVE_NAME_SIZE || clean == "." || clean == ".." ||
+		strings.HasPrefix(clean, "/") || strings.HasPrefix(clean, "../") ||
+		(len(clean) > 1 && clean[1] == ':') {
+		errMsg := fmt.Sprintf("Invalid archive entry name: '%s'", name)
+		return "", NewIOError(errMsg, kanzi.ERR_INVALID_PARAM)
+	}
+
+	return clean, nil
+}
+
+// Write files to an archive. Not thread safe.
+type ArchiveWriter struct {
+	cos      *CompressedOutputStream
+	entries  []ArchiveEntry
+	names    map[string]bool
+	position int64
+	buffer   []byte
+	closed   bool
+	solid    bool
+}
+
+// Create an archive writer. The context keys are the keys of a compressed
+// output stream (the block index is always enabled).
+func NewArchiveWriter(os io.WriteCloser, ctx map[string]interface{}) (*ArchiveWriter, error) {
+	cos, err := NewCompressedOutputStream(os, archiveContext(ctx))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newArchiveWriter(cos)
+}
+
+// Create an archive writer that stops encoding when goCtx is done (see
+// NewCompressedOutputStreamWithContext)
+func NewArchiveWriterWithContext(goCtx context.Context, os io.WriteCloser, ctx map[string]interface{}) (*ArchiveWriter, error) {
+	cos, err := NewCompressedOutputStreamWithContext(goCtx, os, archiveContext(ctx))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newArchiveWriter(cos)
+}
+
+func NewArchiveWriterWithOptions(os io.WriteCloser, opts Options) (*ArchiveWriter, error) {
+	opts.BlockIndex = true
+	cos, err := NewCompressedOutputStreamWithOptions(os, opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newArchiveWriter(cos)
+}
+
+// Copy the context and enable the block index
+func archiveContext(ctx map[string]interface{}) map[string]interface{} {
+	res := make(map[string]interface{})
+
+	for k, v := range ctx {
+		res[k] = v
+	}
+
+	res["blockIndex"] = true
+	return res
+}
+
+func newArchiveWriter(cos *CompressedOutputStream) (*ArchiveWriter, error) {
+	if err := cos.SetMetadataInt(METADATA_KEY_ARCHIVE, ARCHIVE_VERSION); err != nil {
+		return nil, err
+	}
+
+	this := new(ArchiveWriter)
+	this.cos = cos
+	this.entries = make([]ArchiveEntry, 0)
+	this.names = make(map[string]bool)
+	this.buffer = make([]byte, 64*1024)
+	return this, nil
+}
+
+// Write the next entries back to back (solid) or each entry in new blocks
+// (default)
+func (this *ArchiveWriter) SetSolid(solid bool) {
+	this.solid = solid
+}
+
+// Return true if the entries are written back to back
+func (this *ArchiveWriter) Solid() bool {
+	return this.solid
+}
+
+// Return the underlying compressed stream (EG. to add listeners)
+func (this *ArchiveWriter) Stream() *CompressedOutputStream {
+	return this.cos
+}
+
+// Add an entry with the data read from r (until EOF). The size and checksum
+// of the entry are computed. r is ignored for directories (can be nil).
+func (this *ArchiveWriter) Add(entry ArchiveEntry, r io.Reader) error {
+	if this.closed == true {
+		return NewIOError("Archive closed", kanzi.ERR_WRITE_FILE)
+	}
+
+	name, err := checkArchiveName(entry.Name)
+
+	if err != nil {
+		return err
+	}
+
+	if this.names[name] == true {
+		errMsg := fmt.Sprintf("Duplicate archive entry name: '%s'", name)
+		return NewIOError(errMsg, kanzi.ERR_INVALID_PARAM)
+	}
+
+	if len(this.entries) >= MAX_ARCHIVE_ENTRIES {
+		errMsg := fmt.Sprintf("Too many archive entries (max %d)", MAX_ARCHIVE_ENTRIES)
+		return NewIOError(errMsg, kanzi.ERR_INVALID_PARAM)
+	}
+
+	// Start the entry in a new block
+	if this.solid == false {
+		if err := this.cos.endBlock(); err != nil {
+			return err
+		}
+	}
+
+	entry.Name = name
+	entry.Size = 0
+	entry.Checksum = 0
+	entry.position = this.position
+
+	if entry.Mode.IsDir() == false && r != nil {
+		for {
+			n, err := r.Read(this.buffer)
+
+			if n > 0 {
+				if _, err := this.cos.Write(this.buffer[0:n]); err != nil {
+					return err
+				}
+
+				entry.Checksum = crc32.Update(entry.Checksum, crc32Table, this.buffer[0:n])
+				entry.Size += int64(n)
+			}
+
+			if err == io.EOF {
+				break
+			}
+
+			if err != nil {
+				errMsg := fmt.Sprintf("Cannot read data of archive entry '%s'", name)
+				return WrapIOError(err, errMsg, kanzi.ERR_READ_FILE)
+			}
+		}
+	}
+
+	this.position += entry.Size
+	this.names[name] = true
+	this.entries = append(this.entries, entry)
+	return nil
+}
+
+// Return the entries added so far
+func (this *ArchiveWriter) Entries() []ArchiveEntry {
+	return this.entries
+}
+
+// Return the number of bytes written to the underlying writer so far
+func (this *ArchiveWriter) GetWritten() uint64 {
+	return this.cos.GetWritten()
+}
+
+// Write the central directory and close the compressed stream
+func (this *ArchiveWriter) Close() error {
+	if this.closed == true {
+		return nil
+	}
+
+	this.closed = true
+
+	if err := this.cos.endBlock(); err != nil {
+		return err
+	}
+
+	dir := encodeArchiveDirectory(this.entries)
+	trailer := make([]byte, ARCHIVE_TRAILER_SIZE)
+	binary.BigEndian.PutUint64(trailer[0:], uint64(this.position))
+	binary.BigEndian.PutUint32(trailer[8:], ARCHIVE_TYPE)
+
+	if _, err := this.cos.Write(dir); err != nil {
+		return err
+	}
+
+	if _, err := this.cos.Write(trailer); err != nil {
+		return err
+	}
+
+	return this.cos.Close()
+}
+
+func encodeArchiveDirectory(entries []ArchiveEntry) []byte {
+	size := 12
+
+	for i := range entries {
+		size += 2 + len(entries[i].Name) + 32
+	}
+
+	buf := make([]byte, size)
+	binary.BigEndian.PutUint32(buf[0:], ARCHIVE_TYPE)
+	binary.BigEndian.PutUint32(buf[4:], uint32(len(entries)))
+	idx := 8
+
+	for i := range entries {
+		e := &entries[i]
+		binary.BigEndian.PutUint16(buf[idx:], uint16(len(e.Name)))
+		idx += 2
+		idx += copy(buf[idx:], e.Name)
+		binary.BigEndian.PutUint32(buf[idx:], uint32(e.Mode))
+		binary.BigEndian.PutUint64(buf[idx+4:], uint64(e.ModTime.UnixNano()))
+		binary.BigEndian.PutUint64(buf[idx+12:], uint64(e.Size))
+		binary.BigEndian.PutUint64(buf[idx+20:], uint64(e.position))
+		binary.BigEndian.PutUint32(buf[idx+28:], e.Checksum)
+		idx += 32
+	}
+
+	binary.BigEndian.PutUint32(buf[idx:], crc32.Checksum(buf[0:idx], crc32Table))
+	return buf
+}
+
+func decodeArchiveDirectory(buf []byte, dirPosition int64) ([]ArchiveEntry, error) {
+	invalid := func(msg string) error {
+		return NewIOError("Invalid archive directory: "+msg, kanzi.ERR_INVALID_FILE)
+	}
+
+	if len(buf) < 12 || binary.BigEndian.Uint32(buf[0:]) != ARCHIVE_TYPE {
+		return nil, invalid("incorrect type")
+	}
+
+	if crc32.Checksum(buf[0:len(buf)-4], crc32Table) != binary.BigEndian.Uint32(buf[len(buf)-4:]) {
+		return nil, invalid("checksum mismatch")
+	}
+
+	count := int(binary.BigEndian.Uint32(buf[4:]))
+
+	if count > MAX_ARCHIVE_ENTRIES || count > (len(buf)-12)/34 {
+		return nil, invalid(fmt.Sprintf("incorrect number of entries: %d", count))
+	}
+
+	entries := make([]ArchiveEntry, count)
+	idx := 8
+	end := len(buf) - 4
+
+	for i := range entries {
+		if idx+2 > end {
+			return nil, invalid("truncated entry")
+		}
+
+		nameLength := int(binary.BigEndian.Uint16(buf[idx:]))
+		idx += 2
+
+		if idx+nameLength+32 > end {
+			return nil, invalid("truncated entry")
+		}
+
+		e := &entries[i]
+		var err error
+
+		if e.Name, err = checkArchiveName(string(buf[idx : idx+nameLength])); err != nil {
+			return nil, WrapIOError(err, "Invalid archive directory", kanzi.ERR_INVALID_FILE)
+		}
+
+		idx += nameLength
+		e.Mode = os.FileMode(binary.BigEndian.Uint32(buf[idx:]))
+		e.ModTime = time.Unix(0, int64(binary.BigEndian.Uint64(buf[idx+4:])))
+		e.Size = int64(binary.BigEndian.Uint64(buf[idx+12:]))
+		e.position = int64(binary.BigEndian.Uint64(buf[idx+20:]))
+		e.Checksum = binary.BigEndian.Uint32(buf[idx+28:])
+		idx += 32
+
+		if e.Size < 0 || e.position < 0 || e.position > dirPosition-e.Size {
+			return nil, invalid(fmt.Sprintf("incorrect position of entry '%s'", e.Name))
+		}
+	}
+
+	if idx != end {
+		return nil, invalid("incorrect size")
+	}
+
+	return entries, nil
+}
+
+// Read the entries of an archive. The underlying reader must implement
+// io.Seeker. Not thread safe.
+type ArchiveReader struct {
+	cis      *CompressedInputStream
+	entries  []ArchiveEntry
+	names    map[string]int
+	position int64 // position in the decompressed content
+	buffer   []byte
+}
+
+// Create an archive reader and load the central directory. The context keys
+// are the keys of a compressed input stream.
+func NewArchiveReader(is io.ReadCloser, ctx map[string]interface{}) (*ArchiveReader, error) {
+	cis, err := NewCompressedInputStream(is, ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newArchiveReader(cis)
+}
+
+func NewArchiveReaderWithOptions(is io.ReadCloser, opts Options) (*ArchiveReader, error) {
+	cis, err := NewCompressedInputStreamWithOptions(is, opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newArchiveReader(cis)
+}
+
+// Create an archive reader that stops decoding when goCtx is done (see
+// NewCompressedInputStreamWithContext)
+func NewArchiveReaderWithContext(goCtx context.Context, is io.ReadCloser, ctx map[string]interface{}) (*ArchiveReader, error) {
+	cis, err := NewCompressedInputStreamWithContext(goCtx, is, ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newArchiveReader(cis)
+}
+
+func newArchiveReader(cis *CompressedInputStream) (*ArchiveReader, error) {
+	md, err := cis.Metadata()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if version, prst := md.GetInt(METADATA_KEY_ARCHIVE); prst == false {
+		return nil, NewIOError("The input is not an archive", kanzi.ERR_INVALID_FILE)
+	} else if version != ARCHIVE_VERSION {
+		errMsg := fmt.Sprintf("Unsupported archive version: %d", version)
+		return nil, NewIOError(errMsg, kanzi.ERR_STREAM_VERSION)
+	}
+
+	this := new(ArchiveReader)
+	this.cis = cis
+	this.buffer = make([]byte, 64*1024)
+
+	// Locate the central directory with the trailer
+	total, err := cis.Seek(0, io.SeekEnd)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if total < ARCHIVE_TRAILER_SIZE+12 {
+		return nil, NewIOError("Invalid archive: missing central directory", kanzi.ERR_INVALID_FILE)
+	}
+
+	this.position = total
+
+	trailer := make([]byte, ARCHIVE_TRAILER_SIZE)
+
+	if err = this.readAt(trailer, total-ARCHIVE_TRAILER_SIZE); err != nil {
+		return nil, err
+	}
+
+	dirPosition := int64(binary.BigEndian.Uint64(trailer[0:]))
+
+	if binary.BigEndian.Uint32(trailer[8:]) != ARCHIVE_TYPE || dirPosition < 0 ||
+		dirPosition > total-ARCHIVE_TRAILER_SIZE-12 {
+		return nil, NewIOError("Invalid archive: incorrect trailer", kanzi.ERR_INVALID_FILE)
+	}
+
+	dir := make([]byte, total-ARCHIVE_TRAILER_SIZE-dirPosition)
+
+	if err = this.readAt(dir, dirPosition); err != nil {
+		return nil, err
+	}
+
+	if this.entries, err = decodeArchiveDirectory(dir, dirPosition); err != nil {
+		return nil, err
+	}
+
+	this.names = make(map[string]int, len(this.entries))
+
+	for i := range this.entries {
+		this.names[this.entries[i].Name] = i
+	}
+
+	return this, nil
+}
+
+// Read len(buf) bytes of decompressed content at position
+func (this *ArchiveReader) readAt(buf []byte, position int64) error {
+	if this.position != position {
+		if _, err := this.cis.Seek(position, io.SeekStart); err != nil {
+			return err
+		}
+
+		this.position = position
+	}
+
+	for n := 0; n < len(buf); {
+		// The stream returns 0 bytes at the end of the data
+		read, err := this.cis.Read(buf[n:])
+		this.position += int64(read)
+
+		if err != nil {
+			return err
+		}
+
+		if read == 0 {
+			return WrapIOError(io.ErrUnexpectedEOF, "Invalid archive: truncated content", kanzi.ERR_INVALID_FILE)
+		}
+
+		n += read
+	}
+
+	return nil
+}
+
+// Return the underlying compressed stream (EG. to add listeners)
+func (this *ArchiveReader) Stream() *CompressedInputStream {
+	return this.cis
+}
+
+// Return the entries in the order of the archive
+func (this *ArchiveReader) Entries() []ArchiveEntry {
+	return this.entries
+}
+
+// Return the entry with the given name
+func (this *ArchiveReader) Lookup(name string) (ArchiveEntry, bool) {
+	if clean, err := checkArchiveName(name); err == nil {
+		if i, prst := this.names[clean]; prst == true {
+			return this.entries[i], true
+		}
+	}
+
+	return ArchiveEntry{}, false
+}
+
+// Write the data of an entry to w and verify its checksum. The entries are
+// decoded sequentially when extracted in the order of the archive.
+func (this *ArchiveReader) Extract(entry ArchiveEntry, w io.Writer) error {
+	i, prst := this.names[entry.Name]
+
+	if prst == false || this.entries[i].position != entry.position {
+		errMsg := fmt.Sprintf("Unknown archive entry: '%s'", entry.Name)
+		return NewIOError(errMsg, kanzi.ERR_INVALID_PARAM)
+	}
+
+	checksum := uint32(0)
+
+	for remaining := entry.Size; remaining > 0; {
+		buf := this.buffer
+
+		if remaining < int64(len(buf)) {
+			buf = buf[0:remaining]
+		}
+
+		if err := this.readAt(buf, entry.position+entry.Size-remaining); err != nil {
+			return err
+		}
+
+		checksum = crc32.Update(checksum, crc32Table, buf)
+
+		if _, err := w.Write(buf); err != nil {
+			errMsg := fmt.Sprintf("Cannot write data of archive entry '%s'", entry.Name)
+			return WrapIOError(err, errMsg, kanzi.ERR_WRITE_FILE)
+		}
+
+		remaining -= int64(len(buf))
+	}
+
+	if checksum != entry.Checksum {
+		errMsg := fmt.Sprintf("Corrupted data: expected checksum %08x, found %08x", entry.Checksum, checksum)
+		return NewIOError(errMsg, kanzi.ERR_CRC_CHECK)
+	}
+
+	return nil
+}
+
+func (this *ArchiveReader) Close() error {
+	return this.cis.Close()
+}
+
+// Verify the checksums of the entries of an archive while the decompressed
+// content is decoded sequentially (EG. read from the start of the stream by
+// a compressed input stream). The content is written to the verifier, then
+// Close reports the entries not found in the content.
+type ArchiveVerifier struct {
+	entries  []ArchiveEntry // sorted by position
+	next     int            // index of the next entry to verify
+	position int64          // position in the decompressed content
+	checksum uint32         // checksum of the next entry so far
+	err      error
+}
+
+// Create a verifier of the entries (see ArchiveReader.Entries)
+func NewArchiveVerifier(entries []ArchiveEntry) *ArchiveVerifier {
+	this := new(ArchiveVerifier)
+	this.entries = make([]ArchiveEntry, len(entries))
+	copy(this.entries, entries)
+
+	sort.SliceStable(this.entries, func(i, j int) bool {
+		return this.entries[i].position < this.entries[j].position
+	})
+
+	return this
+}
+
+// Implement io.Writer interface. Return an error when the checksum of an
+// entry does not match.
+func (this *ArchiveVerifier) Write(buf []byte) (int, error) {
+	if this.err != nil {
+		return 0, this.err
+	}
+
+	n := len(buf)
+
+	for {
+		// Check the entries ending at the current position
+		for this.next < len(this.entries) {
+			e := &this.entries[this.next]
+
+			if e.position+e.Size > this.position {
+				break
+			}
+
+			if this.checksum != e.Checksum {
+				errMsg := fmt.Sprintf("Corrupted archive entry '%s': expected checksum %08x, found %08x",
+					e.Name, e.Checksum, this.checksum)
+				this.err = NewIOError(errMsg, kanzi.ERR_CRC_CHECK)
+				return n - len(buf), this.err
+			}
+
+			this.checksum = 0
+			this.next++
+		}
+
+		if len(buf) == 0 || this.next == len(this.entries) {
+			break
+		}
+
+		e := &this.entries[this.next]
+		var length int64
+
+		if this.position < e.position {
+			// Skip the data preceding the entry
+			length = e.position - this.position
+		} else {
+			length = e.position + e.Size - this.position
+		}
+
+		if length > int64(len(buf)) {
+			length = int64(len(buf))
+		}
+
+		if this.position >= e.position {
+			this.checksum = crc32.Update(this.checksum, crc32Table, buf[0:length])
+		}
+
+		buf = buf[length:]
+		this.position += length
+	}
+
+	this.position += int64(len(buf))
+	return n, nil
+}
+
+// Return the number of entries verified so far
+func (this *ArchiveVerifier) Verified() int {
+	return this.next
+}
+
+// Check that all the entries have been verified
+func (this *ArchiveVerifier) Close() error {
+	if this.err != nil {
+		return this.err
+	}
+
+	if this.next < len(this.entries) {
+		errMsg := fmt.Sprintf("Invalid archive: truncated content (entry '%s')", this.entries[this.next].Name)
+		return NewIOError(errMsg, kanzi.ERR_INVALID_FILE)
+	}
+
+	return nil
+}
@@ -0,0 +1,345 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	kanzi "github.com/flanglet/kanzi-go"
+)
+
+type testFile struct {
+	name string
+	mode os.FileMode
+	data []byte
+}
+
+func testFiles() []testFile {
+	return []testFile{
+		{"docs", os.ModeDir | 0755, nil},
+		{"docs/readme.txt", 0644, testData(3000, 161)},
+		{"src/main.go", 0644, testData(70000, 162)},
+		{"src/empty.go", 0600, []byte{}},
+		{"./bin/tool", 0755, testData(150000, 163)},
+		{"src/util/helper.go", 0644, testData(40000, 164)},
+	}
+}
+
+// Write the files to an archive
+func writeArchive(t *testing.T, files []testFile, opts Options, solid bool) []byte {
+	t.Helper()
+	out := &testBuffer{}
+	aw, err := NewArchiveWriterWithOptions(out, opts)
+
+	if err != nil {
+		t.Fatalf("Cannot create archive: %v", err)
+	}
+
+	aw.SetSolid(solid)
+	modTime := time.Date(2020, 5, 17, 10, 30, 0, 123456789, time.UTC)
+
+	for i, f := range files {
+		entry := ArchiveEntry{Name: f.name, Mode: f.mode, ModTime: modTime.Add(time.Duration(i) * time.Hour)}
+
+		if err = aw.Add(entry, bytes.NewReader(f.data)); err != nil {
+			t.Fatalf("Cannot add entry '%s': %v", f.name, err)
+		}
+	}
+
+	if err = aw.Close(); err != nil {
+		t.Fatalf("Cannot close archive: %v", err)
+	}
+
+	return out.Bytes()
+}
+
+// Extract the entries in random order and check the data and metadata
+func checkArchive(t *testing.T, archive []byte, files []testFile) {
+	t.Helper()
+	ar, err := NewArchiveReaderWithOptions(newTestReader(archive), Options{Jobs: 2})
+
+	if err != nil {
+		t.Fatalf("Cannot open archive: %v", err)
+	}
+
+	if len(ar.Entries()) != len(files) {
+		t.Fatalf("Expected %d entries, got %d", len(files), len(ar.Entries()))
+	}
+
+	for _, i := range rand.New(rand.NewSource(165)).Perm(len(files)) {
+		f := files[i]
+		entry, found := ar.Lookup(f.name)
+
+		if found == false {
+			t.Fatalf("Entry '%s' not found", f.name)
+		}
+
+		if entry.Mode != f.mode || entry.Size != int64(len(f.data)) ||
+			entry.ModTime.Equal(time.Date(2020, 5, 17, 10+i, 30, 0, 123456789, time.UTC)) == false {
+			t.Fatalf("Entry '%s': invalid metadata %+v", f.name, entry)
+		}
+
+		var buf bytes.Buffer
+
+		if err = ar.Extract(entry, &buf); err != nil || bytes.Equal(buf.Bytes(), f.data) == false {
+			t.Fatalf("Entry '%s': extraction failed: %v", f.name, err)
+		}
+	}
+
+	ar.Close()
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	files := testFiles()
+	archive := writeArchive(t, files, Options{Level: 6, BlockSize: 64 * 1024, Checksum: true}, false)
+	checkArchive(t, archive, files)
+
+	// The names are cleaned
+	ar, _ := NewArchiveReaderWithOptions(newTestReader(archive), Options{})
+
+	if entry, found := ar.Lookup("bin/tool"); found == false || entry.Name != "bin/tool" {
+		t.Fatalf("Entry 'bin/tool' not found")
+	}
+
+	if _, found := ar.Lookup("bin/../bin/tool"); found == false {
+		t.Fatalf("Entry 'bin/../bin/tool' not found")
+	}
+
+	// Each entry starts a new block
+	index, err := readBlockIndex(newTestReader(archive), 0, BITSTREAM_FORMAT_VERSION, nil)
+
+	if err != nil {
+		t.Fatalf("Cannot read block index: %v", err)
+	}
+
+	starts := make(map[int64]bool)
+	position := int64(0)
+
+	for _, e := range index {
+		starts[position] = true
+		position += int64(e.size)
+	}
+
+	for _, entry := range ar.Entries() {
+		if entry.Size > 0 && starts[entry.position] == false {
+			t.Fatalf("Entry '%s' does not start a block", entry.Name)
+		}
+	}
+
+	ar.Close()
+
+	// A regular stream is not an archive
+	if _, err = NewArchiveReaderWithOptions(newTestReader(compressTest(t, files[1].data, Options{})), Options{}); kanzi.ErrorCode(err) != kanzi.ERR_INVALID_FILE {
+		t.Fatalf("Regular stream: expected ERR_INVALID_FILE, got %v", err)
+	}
+}
+
+func TestSolidArchive(t *testing.T) {
+	// Many small similar files
+	files := make([]testFile, 40)
+
+	for i := range files {
+		files[i] = testFile{fmt.Sprintf("src/file%02d.txt", i), 0644, testData(2000+50*i, 167+int64(i%4))}
+	}
+
+	opts := Options{Level: 6, BlockSize: 64 * 1024, Checksum: true}
+	solid := writeArchive(t, files, opts, true)
+	checkArchive(t, solid, files)
+	archive := writeArchive(t, files, opts, false)
+
+	// The entries share the blocks and the contexts
+	solidIndex, err := readBlockIndex(newTestReader(solid), 0, BITSTREAM_FORMAT_VERSION, nil)
+
+	if err != nil {
+		t.Fatalf("Cannot read block index: %v", err)
+	}
+
+	index, err := readBlockIndex(newTestReader(archive), 0, BITSTREAM_FORMAT_VERSION, nil)
+
+	if err != nil {
+		t.Fatalf("Cannot read block index: %v", err)
+	}
+
+	if len(solidIndex) >= len(files) || len(index) < len(files) {
+		t.Fatalf("Unexpected number of blocks: %d (solid), %d", len(solidIndex), len(index))
+	}
+
+	if len(solid) >= len(archive) {
+		t.Fatalf("No gain with a solid archive: %d bytes, %d bytes otherwise", len(solid), len(archive))
+	}
+
+	// The entries are back to back
+	ar, err := NewArchiveReaderWithOptions(newTestReader(solid), Options{})
+
+	if err != nil {
+		t.Fatalf("Cannot open archive: %v", err)
+	}
+
+	position := int64(0)
+
+	for _, entry := range ar.Entries() {
+		if entry.position != position {
+			t.Fatalf("Entry '%s': expected position %d, got %d", entry.Name, position, entry.position)
+		}
+
+		position += entry.Size
+	}
+
+	ar.Close()
+}
+
+func TestArchiveErrors(t *testing.T) {
+	aw, err := NewArchiveWriterWithOptions(&testBuffer{}, Options{Level: 1})
+
+	if err != nil {
+		t.Fatalf("Cannot create archive: %v", err)
+	}
+
+	if err = aw.Add(ArchiveEntry{Name: "a/b"}, bytes.NewReader([]byte("abc"))); err != nil {
+		t.Fatalf("Cannot add entry: %v", err)
+	}
+
+	for _, name := range []string{"", ".", "..", "../a", "a/../../b", "/etc/passwd", "C:/x", "a\\..\\..\\b", "./a/b"} {
+		if err = aw.Add(ArchiveEntry{Name: name}, nil); kanzi.ErrorCode(err) != kanzi.ERR_INVALID_PARAM {
+			t.Fatalf("Name '%s': expected ERR_INVALID_PARAM, got %v", name, err)
+		}
+	}
+
+	// Error of the reader of the data
+	if err = aw.Add(ArchiveEntry{Name: "c"}, &failingReader{}); kanzi.ErrorCode(err) != kanzi.ERR_READ_FILE {
+		t.Fatalf("Failing reader: expected ERR_READ_FILE, got %v", err)
+	}
+
+	aw.Close()
+
+	if err = aw.Add(ArchiveEntry{Name: "d"}, nil); kanzi.ErrorCode(err) != kanzi.ERR_WRITE_FILE {
+		t.Fatalf("Closed archive: expected ERR_WRITE_FILE, got %v", err)
+	}
+
+	// Corrupted central directory (the stream has no checksum)
+	files := testFiles()
+	archive := writeArchive(t, files, Options{Level: 0}, false)
+	content, err := decompressTest(archive, Options{})
+
+	if err != nil {
+		t.Fatalf("Cannot decompress archive: %v", err)
+	}
+
+	pos := bytes.LastIndex(archive, []byte("src/util/helper.go"))
+
+	if pos < 0 {
+		t.Fatalf("Central directory not found")
+	}
+
+	archive[pos] ^= 1
+
+	if _, err = NewArchiveReaderWithOptions(newTestReader(archive), Options{}); kanzi.ErrorCode(err) != kanzi.ERR_INVALID_FILE {
+		t.Fatalf("Corrupted directory: expected ERR_INVALID_FILE, got %v", err)
+	}
+
+	// Corrupted entry data
+	archive[pos] ^= 1
+	pos = bytes.Index(archive, files[2].data[1000:1100])
+	archive[pos] ^= 1
+	ar, err := NewArchiveReaderWithOptions(newTestReader(archive), Options{})
+
+	if err != nil {
+		t.Fatalf("Cannot open archive: %v", err)
+	}
+
+	entry, _ := ar.Lookup(files[2].name)
+
+	if err = ar.Extract(entry, &bytes.Buffer{}); kanzi.ErrorCode(err) != kanzi.ERR_CRC_CHECK {
+		t.Fatalf("Corrupted entry: expected ERR_CRC_CHECK, got %v", err)
+	}
+
+	if err = ar.Extract(ArchiveEntry{Name: "missing"}, &bytes.Buffer{}); kanzi.ErrorCode(err) != kanzi.ERR_INVALID_PARAM {
+		t.Fatalf("Unknown entry: expected ERR_INVALID_PARAM, got %v", err)
+	}
+
+	// Truncated content (the trailer is missing)
+	if _, err = NewArchiveReaderWithOptions(newTestReader(compressTest(t, content[0:len(content)-5],
+		Options{Level: 0})), Options{}); err == nil {
+		t.Fatalf("No error for an archive without trailer")
+	}
+}
+
+func TestArchiveVerifier(t *testing.T) {
+	files := testFiles()
+	archive := writeArchive(t, files, Options{Level: 1}, false)
+	content, err := decompressTest(archive, Options{})
+
+	if err != nil {
+		t.Fatalf("Cannot decompress archive: %v", err)
+	}
+
+	ar, err := NewArchiveReaderWithOptions(newTestReader(archive), Options{})
+
+	if err != nil {
+		t.Fatalf("Cannot open archive: %v", err)
+	}
+
+	entries := ar.Entries()
+	ar.Close()
+
+	// Write the content in chunks of random sizes
+	verify := func(content []byte) (*ArchiveVerifier, error) {
+		v := NewArchiveVerifier(entries)
+		rnd := rand.New(rand.NewSource(166))
+
+		for n := 0; n < len(content); {
+			end := n + 1 + rnd.Intn(20000)
+
+			if end > len(content) {
+				end = len(content)
+			}
+
+			if _, err := v.Write(content[n:end]); err != nil {
+				return v, err
+			}
+
+			n = end
+		}
+
+		return v, v.Close()
+	}
+
+	if v, err := verify(content); err != nil || v.Verified() != len(entries) {
+		t.Fatalf("Verification failed: %v", err)
+	}
+
+	corrupted := append([]byte(nil), content...)
+	corrupted[entries[4].position+100] ^= 1
+
+	if v, err := verify(corrupted); kanzi.ErrorCode(err) != kanzi.ERR_CRC_CHECK || v.Verified() != 4 {
+		t.Fatalf("Corrupted entry: expected ERR_CRC_CHECK, got %v", err)
+	}
+
+	if _, err := verify(content[0 : entries[5].position+10]); kanzi.ErrorCode(err) != kanzi.ERR_INVALID_FILE {
+		t.Fatalf("Truncated content: expected ERR_INVALID_FILE, got %v", err)
+	}
+}
+
+type failingReader struct{}
+
+func (this *failingReader) Read(buf []byte) (int, error) {
+	return 0, fmt.Errorf("read failure")
+}
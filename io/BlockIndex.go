@@ -0,0 +1,263 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"encoding/binary"
+	"fmt"
+	kanzi "github.com/flanglet/kanzi-go"
+	"io"
+)
+
+// The block index is an optional trailer written after the end block of the
+// stream. It lists the position of each block in the bitstream, the size of
+// the block before compression and the size of the encoded block, allowing
+// random access to the data.
+// Layout (byte aligned, big endian):
+// - 32 bits: INDEX_TYPE
+// - 32 bits: number of entries
+// - for each block: 64 bits offset of block header in bits, 32 bits block size,
+//   32 bits size of the encoded block in bytes, rounded up (encrypted and followed by a 128 bits tag if the stream is encrypted)
+// - 64 bits: offset of the index in bytes (relative to the start of the stream)
+// - 32 bits: INDEX_TYPE
+// Before version 9, the entries do not have the size of the encoded block and
+// are not encrypted.
+
+const (
+	INDEX_TYPE        = 0x4B494458 // "KIDX"
+	INDEX_ENTRY_SIZE  = 16
+	INDEX_ENTRY_SIZE8 = 12 // before version 9
+	INDEX_FOOTER_SIZE = 12
+	MAX_INDEX_ENTRIES = 1 << 28
+)
+
+type blockIndexEntry struct {
+	offset   uint64 // position of the block header in the bitstream (in bits)
+	size     uint32 // size of the block before compression
+	length   uint32 // size of the encoded block in bytes (rounded up), 0 before version 9
+	position int64  // position of the block in the decompressed data (decoder only)
+}
+
+// Write the block index (the entries are encrypted if cipher is not nil)
+func writeBlockIndex(obs kanzi.OutputBitStream, entries []blockIndexEntry, cipher *blockCipher) *IOError {
+	// Align to next byte
+	if r := uint(obs.Written() & 7); r != 0 {
+		obs.WriteBits(0, 8-r)
+	}
+
+	start := obs.Written() >> 3
+
+	if obs.WriteBits(INDEX_TYPE, 32) != 32 {
+		return NewIOError("Cannot write block index type", kanzi.ERR_WRITE_FILE)
+	}
+
+	if obs.WriteBits(uint64(len(entries)), 32) != 32 {
+		return NewIOError("Cannot write number of entries in block index", kanzi.ERR_WRITE_FILE)
+	}
+
+	data := make([]byte, len(entries)*INDEX_ENTRY_SIZE)
+
+	for i, e := range entries {
+		binary.BigEndian.PutUint64(data[i*INDEX_ENTRY_SIZE:], e.offset)
+		binary.BigEndian.PutUint32(data[i*INDEX_ENTRY_SIZE+8:], e.size)
+		binary.BigEndian.PutUint32(data[i*INDEX_ENTRY_SIZE+12:], e.length)
+	}
+
+	if cipher != nil {
+		data = cipher.sealTrailer(nonceIndex, uint64(len(entries)), data)
+	}
+
+	if obs.WriteArray(data, uint(8*len(data))) != uint(8*len(data)) {
+		return NewIOError("Cannot write block index entries", kanzi.ERR_WRITE_FILE)
+	}
+
+	if obs.WriteBits(start, 64) != 64 {
+		return NewIOError("Cannot write block index offset", kanzi.ERR_WRITE_FILE)
+	}
+
+	if obs.WriteBits(INDEX_TYPE, 32) != 32 {
+		return NewIOError("Cannot write block index type", kanzi.ERR_WRITE_FILE)
+	}
+
+	return nil
+}
+
+// Return the size of the entries of the block index of a stream version
+func indexEntrySize(version uint) int {
+	if version < 9 {
+		return INDEX_ENTRY_SIZE8
+	}
+
+	return INDEX_ENTRY_SIZE
+}
+
+// Skip the block index following the end block (used to reach the next
+// stream in a concatenation of streams). Panics on read errors.
+func skipBlockIndex(ibs kanzi.InputBitStream, version uint, encrypted bool) {
+	// Align to next byte
+	if r := uint(ibs.Read() & 7); r != 0 {
+		ibs.ReadBits(8 - r)
+	}
+
+	if ibs.ReadBits(32) != INDEX_TYPE {
+		panic(NewIOError("Invalid block index type", kanzi.ERR_INVALID_FILE))
+	}
+
+	count := ibs.ReadBits(32)
+
+	if count > MAX_INDEX_ENTRIES {
+		errMsg := fmt.Sprintf("Invalid number of entries in block index: %d", count)
+		panic(NewIOError(errMsg, kanzi.ERR_INVALID_FILE))
+	}
+
+	size := count * uint64(indexEntrySize(version))
+
+	if encrypted == true {
+		size += ENCRYPTION_TAG_SIZE
+	}
+
+	for ; size >= 8; size -= 8 {
+		ibs.ReadBits(64)
+	}
+
+	if size > 0 {
+		ibs.ReadBits(uint(8 * size))
+	}
+
+	ibs.ReadBits(64)
+
+	if ibs.ReadBits(32) != INDEX_TYPE {
+		panic(NewIOError("Invalid block index type", kanzi.ERR_INVALID_FILE))
+	}
+}
+
+// Locate the block index at the end of the stream of the provided version
+// and load it (the entries are decrypted if cipher is not nil). Return no
+// entries and no error if the reader does not end with a block index or if
+// the index belongs to another stream (concatenated after this one).
+// The origin is the position of the start of the stream in the reader.
+func readBlockIndex(rs io.ReadSeeker, origin int64, version uint, cipher *blockCipher) ([]blockIndexEntry, error) {
+	buf := make([]byte, 8)
+
+	end, err := rs.Seek(-INDEX_FOOTER_SIZE, io.SeekEnd)
+
+	if err != nil {
+		return nil, WrapIOError(err, "Cannot locate block index", kanzi.ERR_READ_FILE)
+	}
+
+	footer := make([]byte, INDEX_FOOTER_SIZE)
+
+	if _, err := io.ReadFull(rs, footer); err != nil {
+		return nil, WrapIOError(err, "Cannot read block index", kanzi.ERR_READ_FILE)
+	}
+
+	if binary.BigEndian.Uint32(footer[8:12]) != INDEX_TYPE {
+		return nil, nil
+	}
+
+	start := int64(binary.BigEndian.Uint64(footer[0:8]))
+
+	if start < 0 || origin+start >= end {
+		return nil, NewIOError("Invalid block index offset", kanzi.ERR_INVALID_FILE)
+	}
+
+	if _, err := rs.Seek(origin+start, io.SeekStart); err != nil {
+		return nil, WrapIOError(err, "Cannot locate block index", kanzi.ERR_READ_FILE)
+	}
+
+	if _, err := io.ReadFull(rs, buf); err != nil {
+		return nil, WrapIOError(err, "Cannot read block index", kanzi.ERR_READ_FILE)
+	}
+
+	// The offset of the index of another stream is relative to the start
+	// of that stream
+	if binary.BigEndian.Uint32(buf[0:4]) != INDEX_TYPE {
+		return nil, nil
+	}
+
+	count := int(binary.BigEndian.Uint32(buf[4:8]))
+
+	if count > MAX_INDEX_ENTRIES {
+		errMsg := fmt.Sprintf("Invalid number of entries in block index: %d", count)
+		return nil, NewIOError(errMsg, kanzi.ERR_INVALID_FILE)
+	}
+
+	// The index at the end of the reader must belong to this stream (and not
+	// to another stream concatenated after this one). Checked before reading
+	// the entries since the count is not trusted.
+	entrySize := indexEntrySize(version)
+	size := count * entrySize
+
+	if cipher != nil {
+		size += ENCRYPTION_TAG_SIZE
+	}
+
+	if origin+start+8+int64(size) != end {
+		return nil, nil
+	}
+
+	data := make([]byte, size)
+
+	if _, err := io.ReadFull(rs, data); err != nil {
+		return nil, WrapIOError(err, "Cannot read block index", kanzi.ERR_READ_FILE)
+	}
+
+	if cipher != nil {
+		if data, err = cipher.openTrailer(nonceIndex, uint64(count), data); err != nil {
+			return nil, err
+		}
+	}
+
+	entries := make([]blockIndexEntry, count)
+	position := int64(0)
+
+	for i := range entries {
+		entry := data[i*entrySize:]
+		entries[i].offset = binary.BigEndian.Uint64(entry[0:])
+		entries[i].size = binary.BigEndian.Uint32(entry[8:])
+
+		if entrySize == INDEX_ENTRY_SIZE {
+			entries[i].length = binary.BigEndian.Uint32(entry[12:])
+		}
+
+		entries[i].position = position
+		position += int64(entries[i].size)
+
+		if entries[i].offset>>3 >= uint64(start) {
+			return nil, NewIOError("Invalid block offset in block index", kanzi.ERR_INVALID_FILE)
+		}
+	}
+
+	return entries, nil
+}
+
+// Return the index of the block containing the provided position in the
+// decompressed data (or len(entries) if the position is past the end)
+func findIndexEntry(entries []blockIndexEntry, position int64) int {
+	lo, hi := 0, len(entries)
+
+	for lo < hi {
+		mid := (lo + hi) >> 1
+
+		if entries[mid].position+int64(entries[mid].size) <= position {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+
+	return lo
+}
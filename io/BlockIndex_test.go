@@ -0,0 +1,221 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+
+	kanzi "github.com/flanglet/kanzi-go"
+)
+
+func TestBlockIndexSeek(t *testing.T) {
+	data := testData(300000, 2)
+
+	for _, jobs := range []uint{1, 4} {
+		opts := Options{Transform: "LZ4", Codec: "HUFFMAN", BlockSize: 16 * 1024, Jobs: jobs, BlockIndex: true}
+		compressed := compressTest(t, data, opts)
+		cis, err := NewCompressedInputStreamWithOptions(newTestReader(compressed), Options{Jobs: jobs})
+
+		if err != nil {
+			t.Fatalf("Cannot create compressed stream: %v", err)
+		}
+
+		if total, err := cis.Seek(0, io.SeekEnd); err != nil || total != int64(len(data)) {
+			t.Fatalf("Seek to end: got %d (%v), expected %d", total, err, len(data))
+		}
+
+		rnd := rand.New(rand.NewSource(3))
+		buf := make([]byte, 5000)
+
+		for i := 0; i < 50; i++ {
+			pos := rnd.Intn(len(data) - len(buf))
+
+			if _, err := cis.Seek(int64(pos), io.SeekStart); err != nil {
+				t.Fatalf("Seek to %d: %v", pos, err)
+			}
+
+			if _, err := io.ReadFull(cis, buf); err != nil {
+				t.Fatalf("Read at %d: %v", pos, err)
+			}
+
+			if bytes.Equal(buf, data[pos:pos+len(buf)]) == false {
+				t.Fatalf("Read at %d: the data differs from the input", pos)
+			}
+
+			pos = rnd.Intn(len(data) - len(buf))
+
+			if n, err := cis.ReadAt(buf, int64(pos)); err != nil || n != len(buf) {
+				t.Fatalf("ReadAt %d: %d bytes (%v)", pos, n, err)
+			}
+
+			if bytes.Equal(buf, data[pos:pos+len(buf)]) == false {
+				t.Fatalf("ReadAt %d: the data differs from the input", pos)
+			}
+		}
+
+		cis.Close()
+
+		// Sequential decoding skips the index
+		if output, err := decompressTest(compressed, Options{Jobs: jobs}); err != nil || bytes.Equal(output, data) == false {
+			t.Fatalf("Sequential decoding failed: %v", err)
+		}
+	}
+}
+
+// Seekable input that does not implement io.ReaderAt
+type seekReader struct {
+	r *bytes.Reader
+}
+
+func (this *seekReader) Read(buf []byte) (int, error) {
+	return this.r.Read(buf)
+}
+
+func (this *seekReader) Seek(offset int64, whence int) (int64, error) {
+	return this.r.Seek(offset, whence)
+}
+
+func (this *seekReader) Close() error {
+	return nil
+}
+
+func TestBlockIndexConcurrentReadAt(t *testing.T) {
+	data := testData(300000, 6)
+	compressed := compressTest(t, data, Options{Level: 2, BlockSize: 16 * 1024, BlockIndex: true})
+	inputs := []io.ReadCloser{newTestReader(compressed), &seekReader{r: bytes.NewReader(compressed)}}
+
+	for _, input := range inputs {
+		cis, err := NewCompressedInputStreamWithOptions(input, Options{})
+
+		if err != nil {
+			t.Fatalf("Cannot create compressed stream: %v", err)
+		}
+
+		errs := make(chan error, 8)
+
+		for i := 0; i < cap(errs); i++ {
+			go func(seed int64) {
+				rnd := rand.New(rand.NewSource(seed))
+				buf := make([]byte, 3000)
+
+				for j := 0; j < 20; j++ {
+					pos := rnd.Intn(len(data) - len(buf))
+
+					if n, err := cis.ReadAt(buf, int64(pos)); err != nil || n != len(buf) {
+						errs <- fmt.Errorf("ReadAt %d: %d bytes (%v)", pos, n, err)
+						return
+					}
+
+					if bytes.Equal(buf, data[pos:pos+len(buf)]) == false {
+						errs <- fmt.Errorf("ReadAt %d: the data differs from the input", pos)
+						return
+					}
+				}
+
+				errs <- nil
+			}(int64(i))
+		}
+
+		for i := 0; i < cap(errs); i++ {
+			if err := <-errs; err != nil {
+				t.Fatalf("%T: %v", input, err)
+			}
+		}
+
+		// The position used by Read is not modified
+		output := make([]byte, len(data))
+
+		if _, err := io.ReadFull(cis, output); err != nil || bytes.Equal(output, data) == false {
+			t.Fatalf("%T: Read after ReadAt failed: %v", input, err)
+		}
+
+		cis.Close()
+	}
+}
+
+func TestBlockIndexMissing(t *testing.T) {
+	data := testData(50000, 4)
+	plain := compressTest(t, data, Options{Level: 1})
+	indexed := compressTest(t, data, Options{Level: 1, BlockSize: 16 * 1024, BlockIndex: true})
+
+	// The index at the end of the concatenated streams is not the index of
+	// the first stream (or there is none)
+	inputs := map[string][]byte{
+		"no index":             plain,
+		"index of next stream": append(append([]byte(nil), indexed...), indexed...),
+		"no index in last":     append(append([]byte(nil), indexed...), plain...),
+	}
+
+	for name, input := range inputs {
+		cis, _ := NewCompressedInputStreamWithOptions(newTestReader(input), Options{})
+
+		if _, err := cis.Seek(100, io.SeekStart); kanzi.ErrorCode(err) != kanzi.ERR_INVALID_PARAM {
+			t.Fatalf("Seek with %s: expected ERR_INVALID_PARAM, got %v", name, err)
+		}
+	}
+}
+
+func TestBlockIndexCorrupted(t *testing.T) {
+	data := testData(100000, 5)
+	compressed := compressTest(t, data, Options{Level: 1, BlockSize: 16 * 1024, BlockIndex: true})
+	footer := len(compressed) - INDEX_FOOTER_SIZE
+	start := int(binary.BigEndian.Uint64(compressed[footer:]))
+
+	corrupt := func(update func(buf []byte)) error {
+		buf := append([]byte(nil), compressed...)
+		update(buf)
+		cis, err := NewCompressedInputStreamWithOptions(newTestReader(buf), Options{})
+
+		if err != nil {
+			return err
+		}
+
+		_, err = cis.Seek(1000, io.SeekStart)
+		return err
+	}
+
+	// Huge number of entries: rejected before allocating the entries
+	err := corrupt(func(buf []byte) { binary.BigEndian.PutUint32(buf[start+4:], MAX_INDEX_ENTRIES+1) })
+
+	if kanzi.ErrorCode(err) != kanzi.ERR_INVALID_FILE {
+		t.Fatalf("Invalid number of entries: expected ERR_INVALID_FILE, got %v", err)
+	}
+
+	// Offset of the index past the end of the stream
+	err = corrupt(func(buf []byte) { binary.BigEndian.PutUint64(buf[footer:], uint64(len(buf))) })
+
+	if kanzi.ErrorCode(err) != kanzi.ERR_INVALID_FILE {
+		t.Fatalf("Invalid index offset: expected ERR_INVALID_FILE, got %v", err)
+	}
+
+	err = corrupt(func(buf []byte) { binary.BigEndian.PutUint64(buf[footer:], 1<<63) })
+
+	if kanzi.ErrorCode(err) != kanzi.ERR_INVALID_FILE {
+		t.Fatalf("Negative index offset: expected ERR_INVALID_FILE, got %v", err)
+	}
+
+	// Offset of a block past the index
+	err = corrupt(func(buf []byte) { binary.BigEndian.PutUint64(buf[start+8:], uint64(start)<<3) })
+
+	if kanzi.ErrorCode(err) != kanzi.ERR_INVALID_FILE {
+		t.Fatalf("Invalid block offset: expected ERR_INVALID_FILE, got %v", err)
+	}
+}
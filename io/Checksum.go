@@ -0,0 +1,138 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"fmt"
+	kanzi "github.com/flanglet/kanzi-go"
+	"github.com/flanglet/kanzi-go/util/hash"
+	"strings"
+)
+
+// Block checksum algorithms (3 bits in the bitstream header)
+const (
+	CHECKSUM_XXHASH32 = uint(0) // default, 32 bits
+	CHECKSUM_XXHASH64 = uint(1) // 64 bits
+	CHECKSUM_MURMUR3  = uint(2) // 32 bits
+	CHECKSUM_SIPHASH  = uint(3) // keyed MAC, 64 bits
+	SIPHASH_KEY_SIZE  = 16
+)
+
+// Compute the checksum of a block. Shared by concurrent tasks.
+type blockChecksum struct {
+	checksumType uint
+	xxh32        *hash.XXHash32
+	xxh64        *hash.XXHash64
+	murmur3      *hash.MurMurHash3
+	key          []byte
+}
+
+func newBlockChecksum(checksumType uint, key []byte) (*blockChecksum, error) {
+	this := &blockChecksum{checksumType: checksumType}
+	var err error
+
+	switch checksumType {
+	case CHECKSUM_XXHASH32:
+		this.xxh32, err = hash.NewXXHash32(BITSTREAM_TYPE)
+
+	case CHECKSUM_XXHASH64:
+		this.xxh64, err = hash.NewXXHash64(BITSTREAM_TYPE)
+
+	case CHECKSUM_MURMUR3:
+		this.murmur3, err = hash.NewMurMurHash3(BITSTREAM_TYPE)
+
+	case CHECKSUM_SIPHASH:
+		if len(key) != SIPHASH_KEY_SIZE {
+			errMsg := fmt.Sprintf("The SipHash checksum requires a key of %d bytes", SIPHASH_KEY_SIZE)
+			return nil, NewIOError(errMsg, kanzi.ERR_MISSING_PARAM)
+		}
+
+		this.key = make([]byte, SIPHASH_KEY_SIZE)
+		copy(this.key, key)
+
+	default:
+		errMsg := fmt.Sprintf("Unsupported checksum type: %d", checksumType)
+		return nil, NewIOError(errMsg, kanzi.ERR_INVALID_PARAM)
+	}
+
+	return this, err
+}
+
+func (this *blockChecksum) Hash(data []byte) uint64 {
+	switch this.checksumType {
+	case CHECKSUM_XXHASH64:
+		return this.xxh64.Hash(data)
+
+	case CHECKSUM_MURMUR3:
+		return uint64(this.murmur3.Hash(data))
+
+	case CHECKSUM_SIPHASH:
+		// The SipHash state is modified by Hash(): use a new instance
+		h, _ := hash.NewSipHashFromBuf(this.key)
+		return h.Hash(data)
+
+	default:
+		return uint64(this.xxh32.Hash(data))
+	}
+}
+
+// Return the number of bits of the checksum in the bitstream
+func (this *blockChecksum) Size() uint {
+	if this.checksumType == CHECKSUM_XXHASH64 || this.checksumType == CHECKSUM_SIPHASH {
+		return 64
+	}
+
+	return 32
+}
+
+func GetChecksumType(name string) (uint, error) {
+	switch strings.ToUpper(name) {
+	case "XXH32", "XXHASH32":
+		return CHECKSUM_XXHASH32, nil
+
+	case "XXH64", "XXHASH64":
+		return CHECKSUM_XXHASH64, nil
+
+	case "MURMUR3", "MURMURHASH3":
+		return CHECKSUM_MURMUR3, nil
+
+	case "SIPHASH", "SIPHASH24":
+		return CHECKSUM_SIPHASH, nil
+
+	default:
+		errMsg := fmt.Sprintf("Unsupported checksum type: '%s'", name)
+		return 0, NewIOError(errMsg, kanzi.ERR_INVALID_PARAM)
+	}
+}
+
+func GetChecksumName(checksumType uint) string {
+	switch checksumType {
+	case CHECKSUM_XXHASH32:
+		return "XXH32"
+
+	case CHECKSUM_XXHASH64:
+		return "XXH64"
+
+	case CHECKSUM_MURMUR3:
+		return "MURMUR3"
+
+	case CHECKSUM_SIPHASH:
+		return "SIPHASH"
+
+	default:
+		return "UNKNOWN"
+	}
+}
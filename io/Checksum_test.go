@@ -0,0 +1,92 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"bytes"
+	"testing"
+
+	kanzi "github.com/flanglet/kanzi-go"
+)
+
+func TestContentChecksum(t *testing.T) {
+	data := testData(100000, 6)
+	compressed := compressTest(t, data, Options{Level: 1, BlockSize: 16 * 1024, Checksum: true})
+
+	if output, err := decompressTest(compressed, Options{}); err != nil || bytes.Equal(output, data) == false {
+		t.Fatalf("Round trip failed: %v", err)
+	}
+
+	// The trailer ends with the content size (64 bits) and the number of
+	// blocks (32 bits), followed by the padding of the last byte
+	for _, pos := range []int{len(compressed) - 6, len(compressed) - 10} {
+		buf := append([]byte(nil), compressed...)
+		buf[pos] ^= 0x01
+
+		if _, err := decompressTest(buf, Options{}); kanzi.ErrorCode(err) != kanzi.ERR_CRC_CHECK {
+			t.Fatalf("Corrupted trailer at %d: expected ERR_CRC_CHECK, got %v", pos, err)
+		}
+	}
+}
+
+func TestChecksumTypes(t *testing.T) {
+	data := testData(100000, 7)
+	key := []byte("0123456789ABCDEF")
+
+	for _, name := range []string{"XXH32", "XXH64", "MURMUR3", "SIPHASH"} {
+		for _, jobs := range []uint{1, 4} {
+			opts := Options{Level: 4, BlockSize: 16 * 1024, Jobs: jobs, Checksum: true, ChecksumType: name}
+			decOpts := Options{Jobs: jobs}
+
+			if name == "SIPHASH" {
+				opts.ChecksumKey = key
+				decOpts.ChecksumKey = key
+			}
+
+			compressed := compressTest(t, data, opts)
+
+			if output, err := decompressTest(compressed, decOpts); err != nil || bytes.Equal(output, data) == false {
+				t.Fatalf("%s, %d job(s): round trip failed: %v", name, jobs, err)
+			}
+
+			// Corrupt a block: the checksum (or the decoder) must detect it
+			buf := append([]byte(nil), compressed...)
+			buf[len(buf)/2] ^= 0x10
+
+			if _, err := decompressTest(buf, decOpts); err == nil {
+				t.Fatalf("%s, %d job(s): corrupted block not detected", name, jobs)
+			}
+		}
+	}
+}
+
+func TestChecksumKey(t *testing.T) {
+	data := testData(50000, 8)
+	opts := Options{Level: 1, Checksum: true, ChecksumType: "SIPHASH", ChecksumKey: []byte("0123456789ABCDEF")}
+	compressed := compressTest(t, data, opts)
+
+	if _, err := decompressTest(compressed, Options{ChecksumKey: []byte("FEDCBA9876543210")}); kanzi.ErrorCode(err) != kanzi.ERR_CRC_CHECK {
+		t.Fatalf("Wrong key: expected ERR_CRC_CHECK, got %v", err)
+	}
+
+	if _, err := decompressTest(compressed, Options{}); err == nil {
+		t.Fatalf("Missing key: error expected")
+	}
+
+	if _, err := NewCompressedOutputStreamWithOptions(&testBuffer{}, Options{Checksum: true, ChecksumType: "CRC"}); kanzi.ErrorCode(err) != kanzi.ERR_INVALID_PARAM {
+		t.Fatalf("Unknown checksum type: expected ERR_INVALID_PARAM, got %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"fmt"
+	kanzi "github.com/flanglet/kanzi-go"
+)
+
+// Content-defined chunking (see Options.ChunkSize): the encoder cuts the
+// blocks where a rolling hash of the data matches a mask instead of every
+// blockSize bytes. An insertion or a deletion only changes the blocks around
+// the modification, the following blocks are identical (EG. block level
+// deduplication of several versions of a file).
+//
+// The rolling hash is a Gear hash (see FastCDC): the hash is shifted by one
+// bit and a random value of the next byte is added, so it only depends on
+// the last 64 bytes. The hash is reset at the start of each block and the
+// first MinChunkSize bytes are skipped. Normalized chunking is used to get
+// block sizes closer to the average: the mask has one more bit before the
+// average size and one less bit after.
+//
+// The bitstream is not modified: the length of each block is already
+// recorded in the block header and the decoder handles blocks of any size
+// up to the block size of the stream.
+
+const (
+	MIN_CHUNK_SIZE     = 256 // smallest average chunk size
+	DEFAULT_CHUNK_SIZE = 64 * 1024
+)
+
+// Random values of the bytes, generated with a fixed seed. The values must
+// not change: the block boundaries (hence the deduplication across streams)
+// depend on them.
+var gearTable [256]uint64
+
+func init() {
+	// SplitMix64
+	seed := uint64(0x4B414E5A49434443) // "KANZICDC"
+
+	for i := range gearTable {
+		seed += 0x9E3779B97F4A7C15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+		z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+		gearTable[i] = z ^ (z >> 31)
+	}
+}
+
+type chunker struct {
+	minSize int
+	avgSize int
+	maxSize int
+	maskS   uint64 // before the average size (harder to match)
+	maskL   uint64 // after the average size (easier to match)
+}
+
+// Sizes validated by the options (see Options.Validate)
+func newChunker(minSize, avgSize, maxSize uint) (*chunker, error) {
+	if avgSize < MIN_CHUNK_SIZE || avgSize&(avgSize-1) != 0 {
+		errMsg := fmt.Sprintf("Invalid average chunk size: %d (must be a power of 2, at least %d)", avgSize, MIN_CHUNK_SIZE)
+		return nil, NewIOError(errMsg, kanzi.ERR_INVALID_PARAM)
+	}
+
+	if minSize >= avgSize || maxSize <= avgSize {
+		errMsg := fmt.Sprintf("Invalid chunk sizes: %d, %d, %d (must be min < avg < max)", minSize, avgSize, maxSize)
+		return nil, NewIOError(errMsg, kanzi.ERR_INVALID_PARAM)
+	}
+
+	this := &chunker{minSize: int(minSize), avgSize: int(avgSize), maxSize: int(maxSize)}
+	bits := uint(0)
+
+	for (1 << bits) < avgSize {
+		bits++
+	}
+
+	// Use the high bits of the hash (the low bits only depend on the last
+	// bytes)
+	this.maskS = ((uint64(1) << (bits + 1)) - 1) << (63 - bits)
+	this.maskL = ((uint64(1) << (bits - 1)) - 1) << (65 - bits)
+	return this, nil
+}
+
+// Return the length of the first block of buf. Return 0 if no boundary is
+// found in buf and more data may follow (final is false).
+func (this *chunker) next(buf []byte, final bool) int {
+	n := len(buf)
+
+	if n >= this.maxSize {
+		n = this.maxSize
+	} else if final == false && n <= this.minSize {
+		return 0
+	}
+
+	if n <= this.minSize {
+		return n
+	}
+
+	normal := this.avgSize
+
+	if normal > n {
+		normal = n
+	}
+
+	h := uint64(0)
+	i := this.minSize
+
+	for ; i < normal; i++ {
+		h = (h << 1) + gearTable[buf[i]]
+
+		if h&this.maskS == 0 {
+			return i + 1
+		}
+	}
+
+	for ; i < n; i++ {
+		h = (h << 1) + gearTable[buf[i]]
+
+		if h&this.maskL == 0 {
+			return i + 1
+		}
+	}
+
+	if n == this.maxSize || final == true {
+		return n
+	}
+
+	return 0
+}
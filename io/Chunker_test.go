@@ -0,0 +1,157 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Return the sizes of the blocks cut by the chunker
+func chunkSizes(c *chunker, data []byte) []uint32 {
+	var res []uint32
+
+	for len(data) > 0 {
+		n := c.next(data, true)
+		res = append(res, uint32(n))
+		data = data[n:]
+	}
+
+	return res
+}
+
+// Return the sizes of the blocks of a stream (from the block index)
+func streamBlockSizes(t *testing.T, stream []byte) []uint32 {
+	t.Helper()
+	index, err := readBlockIndex(newTestReader(stream), 0, BITSTREAM_FORMAT_VERSION, nil)
+
+	if err != nil {
+		t.Fatalf("Cannot read block index: %v", err)
+	}
+
+	res := make([]uint32, len(index))
+
+	for i := range index {
+		res[i] = index[i].size
+	}
+
+	return res
+}
+
+func TestChunker(t *testing.T) {
+	c, err := newChunker(2048, 8192, 32768)
+
+	if err != nil {
+		t.Fatalf("Cannot create chunker: %v", err)
+	}
+
+	data := testData(500000, 151)
+	sizes := chunkSizes(c, data)
+
+	for i, sz := range sizes {
+		if sz > 32768 || (sz <= 2048 && i != len(sizes)-1) {
+			t.Fatalf("Block %d: invalid size %d", i, sz)
+		}
+	}
+
+	if avg := len(data) / len(sizes); avg < 4096 || avg > 16384 {
+		t.Fatalf("Average size too far from 8192: %d", avg)
+	}
+
+	// No boundary before the max size unless the data is final
+	if n := c.next(data[0:2048], false); n != 0 {
+		t.Fatalf("Expected no boundary in a small buffer, got %d", n)
+	}
+
+	if n := c.next(data[0:2048], true); n != 2048 {
+		t.Fatalf("Expected the whole final buffer, got %d", n)
+	}
+
+	// An insertion only changes the blocks around it
+	modified := append(append(append([]byte(nil), data[0:1000]...), "inserted"...), data[1000:]...)
+	sizes2 := chunkSizes(c, modified)
+
+	if bytes.Equal(u32Bytes(sizes[len(sizes)-40:]), u32Bytes(sizes2[len(sizes2)-40:])) == false {
+		t.Fatalf("The blocks after the insertion differ")
+	}
+
+	for _, sizes := range [][]uint{{256, 1000, 4096}, {2048, 1024, 4096}, {256, 1024, 1024}} {
+		if _, err = newChunker(sizes[0], sizes[1], sizes[2]); err == nil {
+			t.Fatalf("No error for invalid sizes: %v", sizes)
+		}
+	}
+}
+
+func u32Bytes(vals []uint32) []byte {
+	res := make([]byte, 0, 4*len(vals))
+
+	for _, v := range vals {
+		res = append(res, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+
+	return res
+}
+
+func TestChunkedStream(t *testing.T) {
+	data := testData(600000, 152)
+	c, _ := newChunker(2048, 8192, 32768)
+	expected := chunkSizes(c, data)
+
+	// The block boundaries do not depend on the number of jobs, on the size
+	// of the writes or on the size of the buffer (block size)
+	for _, jobs := range []uint{1, 3, 8} {
+		for _, blockSize := range []uint{32 * 1024, 64 * 1024} {
+			opts := Options{Transform: "ROLZ", Codec: "ANS0", BlockSize: blockSize, Jobs: jobs, ChunkSize: 8192,
+				MinChunkSize: 2048, MaxChunkSize: 32768, BlockIndex: true, Checksum: true}
+			stream := compressTest(t, data, opts)
+
+			if sizes := streamBlockSizes(t, stream); bytes.Equal(u32Bytes(sizes), u32Bytes(expected)) == false {
+				t.Fatalf("%d job(s), block size %d: unexpected block boundaries (%d blocks, expected %d)",
+					jobs, blockSize, len(sizes), len(expected))
+			}
+
+			if output, err := decompressTest(stream, Options{Jobs: jobs}); err != nil || bytes.Equal(output, data) == false {
+				t.Fatalf("%d job(s), block size %d: round trip failed: %v", jobs, blockSize, err)
+			}
+		}
+	}
+
+	// Flush cuts a block at the current position
+	out := &testBuffer{}
+	opts := Options{Transform: "ROLZ", Codec: "ANS0", Jobs: 4, ChunkSize: 8192, BlockIndex: true}
+	cos, _ := NewCompressedOutputStreamWithOptions(out, opts)
+	cos.Write(data[0:100000])
+	cos.Flush()
+	cos.Write(data[100000:])
+
+	if err := cos.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+
+	if output, err := decompressTest(out.Bytes(), Options{}); err != nil || bytes.Equal(output, data) == false {
+		t.Fatalf("Round trip with flush failed: %v", err)
+	}
+
+	total := uint32(0)
+
+	for _, sz := range streamBlockSizes(t, out.Bytes()) {
+		if total += sz; total == 100000 {
+			return
+		}
+	}
+
+	t.Fatalf("No block boundary at the flush position")
+}
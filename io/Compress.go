@@ -0,0 +1,441 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"fmt"
+	kanzi "github.com/flanglet/kanzi-go"
+	"github.com/flanglet/kanzi-go/bitstream"
+	"github.com/flanglet/kanzi-go/entropy"
+	"github.com/flanglet/kanzi-go/function"
+	"github.com/flanglet/kanzi-go/util"
+)
+
+// One-shot API: compress and decompress byte slices without the cost of a
+// stream. No go routine is started unless Jobs is greater than 1.
+//
+// By default, the output of Compress is a regular stream (readable by
+// CompressedInputStream). In headerless mode (see Options), the output is
+// the size of the input (varint) followed by a single block and the
+// decoder must be given the same options as the encoder. The effective block
+// size (see prepareOneShot) is derived from the size of the input by both.
+
+// Size of the bitstream buffers for small inputs
+const ONE_SHOT_MIN_BUFFER_SIZE = 1024
+
+// Return the max size of the output of Compress for an input of srcLen bytes
+func CompressBound(srcLen int, opts Options) (int, error) {
+	if srcLen < 0 {
+		return 0, NewIOError("Invalid negative input size", kanzi.ERR_INVALID_PARAM)
+	}
+
+	prepareOneShot(&opts, srcLen)
+
+	if err := opts.Validate(); err != nil {
+		return 0, err
+	}
+
+	ctx := make(map[string]interface{})
+	opts.updateContext(ctx, false)
+	ctx["size"] = opts.BlockSize
+	t, err := function.NewByteFunction(&ctx, function.GetType(opts.Transform))
+
+	if err != nil {
+		return 0, WrapIOError(err, "", kanzi.ERR_CREATE_CODEC)
+	}
+
+	entropyType := entropy.GetType(opts.Codec)
+
+	// Block header: mode, skip flags, length (4 bytes max) and checksum
+	blockBound := func(length int) int {
+		res := entropy.MaxEncodedLen(entropyType, t.MaxEncodedLen(length))
+		return res + 14
+	}
+
+	if opts.Headerless == true {
+		// Size (varint) and block
+		return 5 + blockBound(srcLen), nil
+	}
+
+	// Header, end block, content hash and block index trailers
+	res := 64
+	blockSize := int(opts.BlockSize)
+	nbBlocks := 0
+	maxBound := 0
+
+	// With content-defined chunking, the blocks have at least MinChunkSize
+	// bytes (except the last one) and at most MaxChunkSize bytes. Assume the
+	// smallest blocks (most block overhead).
+	if opts.ChunkSize > 0 {
+		blockSize = int(opts.MinChunkSize)
+	}
+
+	for n := srcLen; n > 0; n -= blockSize {
+		length := blockSize
+
+		if length > n {
+			length = n
+		}
+
+		// Size of independent block and block index entry
+		bound := blockBound(length) + 4 + INDEX_ENTRY_SIZE
+
+		if opts.encrypted() == true {
+			bound += ENCRYPTION_TAG_SIZE
+		}
+
+		if bound > maxBound {
+			maxBound = bound
+		}
+
+		res += bound
+		nbBlocks++
+	}
+
+	if opts.ChunkSize > 0 && srcLen > blockSize {
+		// Largest block (size of the parity blocks)
+		length := int(opts.MaxChunkSize)
+
+		if length > srcLen {
+			length = srcLen
+		}
+
+		maxBound = blockBound(length) + 4 + INDEX_ENTRY_SIZE
+
+		if opts.encrypted() == true {
+			maxBound += ENCRYPTION_TAG_SIZE
+		}
+	}
+
+	if opts.ParityBlocks > 0 {
+		// Header (2 copies) and parity blocks of each group
+		groupSize := int(opts.ParityGroupSize)
+		nbGroups := (nbBlocks + groupSize - 1) / groupSize
+		nbParity := int(opts.ParityBlocks)
+		res += nbGroups * (2*parityHeaderSize(groupSize, nbParity) + nbParity*maxBound)
+	}
+
+	if opts.encrypted() == true {
+		// Encryption header and tag of the end block
+		res += 5 + ENCRYPTION_SALT_SIZE + 2*ENCRYPTION_TAG_SIZE
+	}
+
+	return res, nil
+}
+
+// Compress src to dst and return the number of bytes written to dst. Fail
+// with ERR_BUFFER_TOO_SMALL if dst is too small (see CompressBound).
+func Compress(dst, src []byte, opts Options) (int, error) {
+	prepareOneShot(&opts, len(src))
+
+	if err := opts.Validate(); err != nil {
+		return 0, err
+	}
+
+	if opts.FileSize == 0 {
+		opts.FileSize = int64(len(src))
+	}
+
+	ctx := make(map[string]interface{})
+	opts.updateContext(ctx, false)
+	sw := &sliceWriter{buf: dst}
+	var err error
+
+	if opts.Headerless == true {
+		err = compressBlock(sw, src, &opts, ctx)
+	} else {
+		err = compressStream(sw, src, &opts, ctx)
+	}
+
+	if sw.overflow == true {
+		errMsg := fmt.Sprintf("Output buffer too small (%d bytes)", len(dst))
+		return 0, NewIOError(errMsg, kanzi.ERR_BUFFER_TOO_SMALL)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return sw.written, nil
+}
+
+// Decompress src to dst and return the number of bytes written to dst. Fail
+// with ERR_BUFFER_TOO_SMALL if dst is too small.
+func Decompress(dst, src []byte, opts Options) (int, error) {
+	if opts.Headerless == true {
+		// The options are validated once the size of the data is known
+		return decompressBlock(dst, src, opts)
+	}
+
+	if err := opts.Validate(); err != nil {
+		return 0, err
+	}
+
+	ctx := make(map[string]interface{})
+	opts.updateContext(ctx, true)
+	cis, err := newCompressedInputStream(util.NewBufferStream(src), &opts, ctx, oneShotBufferSize(len(src)))
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer cis.Close()
+	read := 0
+
+	for read < len(dst) {
+		n, err := cis.Read(dst[read:])
+		read += n
+
+		if err != nil {
+			return read, err
+		}
+
+		if n == 0 {
+			return read, nil
+		}
+	}
+
+	// Check that the end of the stream has been reached
+	var probe [1]byte
+
+	if n, err := cis.Read(probe[:]); n > 0 {
+		errMsg := fmt.Sprintf("Output buffer too small (%d bytes)", len(dst))
+		return read, NewIOError(errMsg, kanzi.ERR_BUFFER_TOO_SMALL)
+	} else if err != nil {
+		return read, err
+	}
+
+	return read, nil
+}
+
+// Use one block (if possible) for small inputs to save memory
+func prepareOneShot(opts *Options, srcLen int) {
+	// The chunk sizes are validated against the block size
+	if opts.BlockSize != 0 || opts.ChunkSize != 0 || srcLen >= DEFAULT_BLOCK_SIZE {
+		return
+	}
+
+	opts.BlockSize = uint(srcLen+15) & ^uint(15)
+
+	if opts.BlockSize < MIN_BITSTREAM_BLOCK_SIZE {
+		opts.BlockSize = MIN_BITSTREAM_BLOCK_SIZE
+	}
+}
+
+func oneShotBufferSize(size int) uint {
+	if size >= STREAM_DEFAULT_BUFFER_SIZE {
+		return STREAM_DEFAULT_BUFFER_SIZE
+	}
+
+	if size < ONE_SHOT_MIN_BUFFER_SIZE {
+		return ONE_SHOT_MIN_BUFFER_SIZE
+	}
+
+	return uint(size+7) & ^uint(7)
+}
+
+// Write src as a regular stream
+func compressStream(sw *sliceWriter, src []byte, opts *Options, ctx map[string]interface{}) (err error) {
+	defer func() {
+		// The stream panics on write errors after the last block (trailers)
+		if r := recover(); r != nil {
+			err = toIOError(r, kanzi.ERR_WRITE_FILE)
+		}
+	}()
+
+	cos, err := newCompressedOutputStream(sw, opts, ctx, oneShotBufferSize(len(sw.buf)))
+
+	if err != nil {
+		return err
+	}
+
+	if _, err = cos.Write(src); err != nil {
+		return err
+	}
+
+	return cos.Close()
+}
+
+// Write the size of src followed by src encoded as one block
+func compressBlock(sw *sliceWriter, src []byte, opts *Options, ctx map[string]interface{}) (err error) {
+	if len(src) > MAX_BITSTREAM_BLOCK_SIZE {
+		errMsg := fmt.Sprintf("The input is too big for headerless mode (max %d bytes)", MAX_BITSTREAM_BLOCK_SIZE)
+		return NewIOError(errMsg, kanzi.ERR_BLOCK_SIZE)
+	}
+
+	obs, err := bitstream.NewDefaultOutputBitStream(sw, oneShotBufferSize(len(sw.buf)))
+
+	if err != nil {
+		return WrapIOError(err, "", kanzi.ERR_CREATE_BITSTREAM)
+	}
+
+	hasher, err := newOneShotChecksum(opts)
+
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		// The bitstream panics on write errors
+		if r := recover(); r != nil {
+			err = toIOError(r, kanzi.ERR_WRITE_FILE)
+		}
+	}()
+
+	entropy.WriteVarInt(obs, len(src))
+
+	if len(src) > 0 {
+		// The transforms may modify the input buffer
+		data := make([]byte, len(src))
+		copy(data, src)
+
+		task := EncodingTask{
+			iBuffer:            &blockBuffer{Buf: data},
+			oBuffer:            &blockBuffer{Buf: EMPTY_BYTE_SLICE},
+			hasher:             hasher,
+			blockLength:        uint(len(src)),
+			blockTransformType: function.GetType(opts.Transform),
+			blockEntropyType:   entropy.GetType(opts.Codec),
+			currentBlockId:     1,
+			input:              make(chan error, 1),
+			output:             make(chan error, 1),
+			obs:                obs,
+			ctx:                ctx}
+
+		task.input <- error(nil)
+		task.encode()
+
+		if err = <-task.output; err != nil {
+			return err
+		}
+	}
+
+	if _, err = obs.Close(); err != nil {
+		return WrapIOError(err, "", kanzi.ERR_WRITE_FILE)
+	}
+
+	return nil
+}
+
+// Read the size of the data and decode the block written by compressBlock
+func decompressBlock(dst, src []byte, opts Options) (read int, err error) {
+	ibs, err := bitstream.NewDefaultInputBitStream(util.NewBufferStream(src), oneShotBufferSize(len(src)))
+
+	if err != nil {
+		return 0, WrapIOError(err, "", kanzi.ERR_CREATE_BITSTREAM)
+	}
+
+	defer func() {
+		// Truncated input
+		if r := recover(); r != nil {
+			read, err = 0, toIOError(r, kanzi.ERR_READ_FILE)
+		}
+	}()
+
+	size := entropy.ReadVarInt(ibs)
+
+	// Same block size as the encoder (some codecs depend on it, EG. TPAQ)
+	prepareOneShot(&opts, size)
+
+	if err = opts.Validate(); err != nil {
+		return 0, err
+	}
+
+	if size == 0 {
+		return 0, nil
+	}
+
+	hasher, err := newOneShotChecksum(&opts)
+
+	if err != nil {
+		return 0, err
+	}
+
+	ctx := make(map[string]interface{})
+	opts.updateContext(ctx, false)
+	opts.updateContext(ctx, true)
+
+	if size > len(dst) {
+		errMsg := fmt.Sprintf("Output buffer too small (%d bytes, %d required)", len(dst), size)
+		return 0, NewIOError(errMsg, kanzi.ERR_BUFFER_TOO_SMALL)
+	}
+
+	blkSize := size + EXTRA_BUFFER_SIZE
+	result := make(chan Message, 1)
+
+	task := DecodingTask{
+		iBuffer:            &blockBuffer{Buf: make([]byte, blkSize)},
+		oBuffer:            &blockBuffer{Buf: EMPTY_BYTE_SLICE},
+		hasher:             hasher,
+		blockLength:        uint(blkSize),
+		blockTransformType: function.GetType(opts.Transform),
+		blockEntropyType:   entropy.GetType(opts.Codec),
+		currentBlockId:     1,
+		result:             result,
+		ibs:                ibs,
+		ctx:                ctx}
+
+	task.decode()
+	res := <-result
+
+	if res.err != nil {
+		return 0, res.err
+	}
+
+	if res.decoded != size {
+		errMsg := fmt.Sprintf("Invalid block size: expected %d, got %d", size, res.decoded)
+		return 0, NewIOError(errMsg, kanzi.ERR_PROCESS_BLOCK)
+	}
+
+	return copy(dst, res.data[0:size]), nil
+}
+
+func newOneShotChecksum(opts *Options) (*blockChecksum, error) {
+	if opts.Checksum == false {
+		return nil, nil
+	}
+
+	checksumType, err := GetChecksumType(opts.ChecksumType)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newBlockChecksum(checksumType, opts.ChecksumKey)
+}
+
+// Writer to a fixed size byte slice
+type sliceWriter struct {
+	buf      []byte
+	written  int
+	overflow bool
+}
+
+func (this *sliceWriter) Write(b []byte) (int, error) {
+	n := copy(this.buf[this.written:], b)
+	this.written += n
+
+	if n < len(b) {
+		this.overflow = true
+		return n, NewIOError("Output buffer too small", kanzi.ERR_BUFFER_TOO_SMALL)
+	}
+
+	return n, nil
+}
+
+func (this *sliceWriter) Close() error {
+	return nil
+}
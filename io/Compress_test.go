@@ -0,0 +1,121 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"bytes"
+	"testing"
+
+	kanzi "github.com/flanglet/kanzi-go"
+)
+
+// Compress and decompress with the one-shot API
+func oneShotTest(t *testing.T, data []byte, opts Options) {
+	t.Helper()
+	bound, err := CompressBound(len(data), opts)
+
+	if err != nil {
+		t.Fatalf("Level %d, %d bytes: CompressBound error: %v", opts.Level, len(data), err)
+	}
+
+	compressed := make([]byte, bound)
+	n, err := Compress(compressed, data, opts)
+
+	if err != nil {
+		t.Fatalf("Level %d, %d bytes, headerless=%v: compression error: %v", opts.Level, len(data), opts.Headerless, err)
+	}
+
+	output := make([]byte, len(data))
+	m, err := Decompress(output, compressed[0:n], opts)
+
+	if err != nil || m != len(data) || bytes.Equal(output, data) == false {
+		t.Fatalf("Level %d, %d bytes, headerless=%v: round trip failed: %v", opts.Level, len(data), opts.Headerless, err)
+	}
+}
+
+func TestOneShotLevels(t *testing.T) {
+	small := testData(20000, 81)
+	big := testData(900*1024, 82)
+
+	for level := 0; level <= MAX_LEVEL; level++ {
+		for _, data := range [][]byte{nil, small, big} {
+			oneShotTest(t, data, Options{Level: level, Checksum: true})
+
+			// The decoder derives the block size from the size of the data
+			oneShotTest(t, data, Options{Level: level, Headerless: true})
+		}
+	}
+
+	// A block size requested by the user must be provided to the decoder
+	oneShotTest(t, big, Options{Level: 7, BlockSize: 2 * 1024 * 1024, Headerless: true})
+	oneShotTest(t, big, Options{Level: 3, BlockSize: 64 * 1024, Jobs: 4, BlockIndex: true})
+}
+
+func TestOneShotStream(t *testing.T) {
+	// The output of Compress is a regular stream
+	data := testData(150000, 83)
+	compressed := make([]byte, 200000)
+	n, err := Compress(compressed, data, Options{Level: 2, BlockSize: 32 * 1024})
+
+	if err != nil {
+		t.Fatalf("Compression error: %v", err)
+	}
+
+	if output, err := decompressTest(compressed[0:n], Options{}); err != nil || bytes.Equal(output, data) == false {
+		t.Fatalf("Stream decompression failed: %v", err)
+	}
+
+	stream := compressTest(t, data, Options{Level: 5})
+	output := make([]byte, len(data))
+
+	if m, err := Decompress(output, stream, Options{}); err != nil || bytes.Equal(output[0:m], data) == false {
+		t.Fatalf("One-shot decompression of a stream failed: %v", err)
+	}
+}
+
+func TestOneShotErrors(t *testing.T) {
+	data := testData(50000, 84)
+
+	for _, headerless := range []bool{false, true} {
+		opts := Options{Level: 2, Headerless: headerless}
+		compressed := make([]byte, 100000)
+		n, err := Compress(compressed, data, opts)
+
+		if err != nil {
+			t.Fatalf("Headerless=%v: compression error: %v", headerless, err)
+		}
+
+		if _, err = Compress(make([]byte, n/2), data, opts); kanzi.ErrorCode(err) != kanzi.ERR_BUFFER_TOO_SMALL {
+			t.Fatalf("Headerless=%v: expected ERR_BUFFER_TOO_SMALL, got %v", headerless, err)
+		}
+
+		if _, err = Decompress(make([]byte, len(data)-1), compressed[0:n], opts); kanzi.ErrorCode(err) != kanzi.ERR_BUFFER_TOO_SMALL {
+			t.Fatalf("Headerless=%v: expected ERR_BUFFER_TOO_SMALL, got %v", headerless, err)
+		}
+
+		if _, err = Decompress(make([]byte, len(data)), compressed[0:n/2], opts); err == nil {
+			t.Fatalf("Headerless=%v: no error for a truncated input", headerless)
+		}
+
+		if _, err = Decompress(make([]byte, len(data)), compressed[0:n], Options{Level: 100, Headerless: headerless}); kanzi.ErrorCode(err) != kanzi.ERR_INVALID_PARAM {
+			t.Fatalf("Headerless=%v: expected ERR_INVALID_PARAM, got %v", headerless, err)
+		}
+	}
+
+	if _, err := CompressBound(-1, Options{}); kanzi.ErrorCode(err) != kanzi.ERR_INVALID_PARAM {
+		t.Fatalf("Negative size: expected ERR_INVALID_PARAM, got %v", err)
+	}
+}
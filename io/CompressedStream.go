@@ -16,13 +16,19 @@ limitations under the License.
 package io
 
 import (
+	"context"
+	"encoding/binary"
 	"fmt"
 	kanzi "github.com/flanglet/kanzi-go"
 	"github.com/flanglet/kanzi-go/bitstream"
 	"github.com/flanglet/kanzi-go/entropy"
 	"github.com/flanglet/kanzi-go/function"
+	"github.com/flanglet/kanzi-go/util"
 	"github.com/flanglet/kanzi-go/util/hash"
 	"io"
+	"math"
+	"sort"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -35,7 +41,7 @@ import (
 
 const (
 	BITSTREAM_TYPE             = 0x4B414E5A // "KANZ"
-	BITSTREAM_FORMAT_VERSION   = 7
+	BITSTREAM_FORMAT_VERSION   = 9
 	STREAM_DEFAULT_BUFFER_SIZE = 256 * 1024
 	EXTRA_BUFFER_SIZE          = 256
 	COPY_BLOCK_MASK            = 0x80
@@ -44,19 +50,50 @@ const (
 	MAX_BITSTREAM_BLOCK_SIZE   = 1024 * 1024 * 1024
 	SMALL_BLOCK_SIZE           = 15
 	MAX_CONCURRENCY            = 64
+	HEADER_FLAG_BLOCK_INDEX    = 0x0001
+	HEADER_FLAG_CONTENT_HASH   = 0x0002
+	HEADER_FLAG_METADATA       = 0x0004
+	HEADER_FLAG_INDEPENDENT    = 0x0008
+	HEADER_FLAG_DICTIONARY     = 0x0010
+	HEADER_FLAG_ENCRYPTED      = 0x0020
+	HEADER_FLAG_PARITY         = 0x0040
+	FLUSH_BLOCK_MODE           = COPY_BLOCK_MASK | 0x20 // empty block with a 2 byte length
+	FLUSH_BLOCK_SIZE           = 0xFFFFFFFF             // independent blocks
+	CONTENT_TRAILER_SIZE       = 20                     // content hash, content size and number of blocks
 )
 
 var (
 	EMPTY_BYTE_SLICE = make([]byte, 0)
 )
 
+// Error returned by the streams and the one-shot API. It matches the sentinel
+// error of its code (EG. errors.Is(err, kanzi.ErrCRCCheck)) and keeps the
+// underlying error (if any). When known, the ID of the block and the offset
+// in the compressed stream (in bytes) where the error occurred are provided.
 type IOError struct {
-	msg  string
-	code int
+	msg     string
+	code    int
+	cause   error
+	blockId int   // 0 if unknown
+	offset  int64 // -1 if unknown
 }
 
 func NewIOError(msg string, code int) *IOError {
-	return &IOError{msg: msg, code: code}
+	return &IOError{msg: msg, code: code, offset: -1}
+}
+
+// Create an error caused by err. The message is the message of err prefixed
+// with prefix (if not empty).
+func WrapIOError(err error, prefix string, code int) *IOError {
+	msg := err.Error()
+
+	if len(prefix) > 0 {
+		msg = prefix + ": " + msg
+	}
+
+	res := NewIOError(msg, code)
+	res.cause = err
+	return res
 }
 
 // Implement error interface
@@ -72,6 +109,44 @@ func (this IOError) ErrorCode() int {
 	return this.code
 }
 
+// Return the ID of the block (the first block is 1) or 0 if unknown
+func (this IOError) BlockId() int {
+	return this.blockId
+}
+
+// Return the offset in the compressed stream (in bytes) or -1 if unknown
+func (this IOError) Offset() int64 {
+	return this.offset
+}
+
+// Return the underlying error (if any)
+func (this IOError) Unwrap() error {
+	return this.cause
+}
+
+// Match the sentinel error of the code (see errors.Is)
+func (this IOError) Is(target error) bool {
+	return target == kanzi.CodeError(this.code)
+}
+
+// Set the position of the error unless already known (the position of the
+// innermost failure is kept)
+func (this *IOError) setPosition(blockId int, offset int64) *IOError {
+	if this.blockId == 0 {
+		this.blockId = blockId
+	}
+
+	if this.offset < 0 {
+		this.offset = offset
+	}
+
+	return this
+}
+
+type flusher interface {
+	Flush() error
+}
+
 type blockBuffer struct {
 	// Enclose a buffer in a struct to share it between stream and tasks
 	// and reduce memory allocation.
@@ -82,87 +157,179 @@ type blockBuffer struct {
 type CompressedOutputStream struct {
 	blockSize     uint
 	nbInputBlocks uint8
-	hasher        *hash.XXHash32
+	hasher        *blockChecksum
+	blockHasher   *hash.XXHash64
+	contentHasher *hash.XXHash64
+	contentHash   uint64
+	contentSize   uint64
+	nbBlocks      uint32
 	data          []byte
 	buffers       []blockBuffer
 	entropyType   uint32
 	transformType uint64
+	headerFlags   uint
+	dictionaryID  uint32
+	cipher        *blockCipher
+	blockCount    uint64 // number of blocks of the frame (nonce of encrypted blocks)
+	parity        *parityWriter
+	chunker       *chunker // content-defined chunking (nil for fixed size blocks)
+	metadata      *Metadata
+	consumed      uint64    // number of input bytes encoded (progress events)
+	startTime     time.Time // start of the encoding (progress events)
+	os            io.WriteCloser
 	obs           kanzi.OutputBitStream
 	initialized   int32
 	closed        int32
 	blockId       int
+	startIdx      int // start of the data not encoded yet (content-defined chunking)
 	curIdx        int
 	jobs          int
+	index         []blockIndexEntry
 	channels      []chan error
 	listeners     []kanzi.Listener
+	goCtx         context.Context
 	ctx           map[string]interface{}
+	opts          Options // kept for Reset
+	initCtx       map[string]interface{}
+	bufferSize    uint
+	transforms    []*function.ByteFunctionPool // one pool per job
+	predictors    []*entropy.PredictorPool
 }
 
 type EncodingTask struct {
 	iBuffer            *blockBuffer
 	oBuffer            *blockBuffer
-	hasher             *hash.XXHash32
+	hasher             *blockChecksum
+	blockHasher        *hash.XXHash64
+	blockHash          *uint64
 	blockLength        uint
 	blockTransformType uint64
 	blockEntropyType   uint32
 	currentBlockId     int
+	independent        bool
+	cipher             *blockCipher
+	blockNumber        uint64 // position of the block in the frame
+	parity             *parityWriter
+	indexEntry         *blockIndexEntry
 	input              chan error
 	output             chan error
 	listeners          []kanzi.Listener
 	obs                kanzi.OutputBitStream
+	transforms         *function.ByteFunctionPool
+	predictors         *entropy.PredictorPool
+	goCtx              context.Context
 	ctx                map[string]interface{}
 }
 
+// Create a compressed output stream from a context map. The map is validated
+// (see Options) and shared with the transforms and entropy codecs.
 func NewCompressedOutputStream(os io.WriteCloser, ctx map[string]interface{}) (*CompressedOutputStream, error) {
-	if os == nil {
-		return nil, NewIOError("Invalid null writer parameter", kanzi.ERR_CREATE_STREAM)
-	}
-
 	if ctx == nil {
 		return nil, NewIOError("Invalid null context parameter", kanzi.ERR_CREATE_STREAM)
 	}
 
-	entropyCodec := ctx["codec"].(string)
-	transform := ctx["transform"].(string)
-	tasks := ctx["jobs"].(uint)
+	opts, err := newOptionsFromContext(ctx, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	opts.updateContext(ctx, false)
+	return newCompressedOutputStream(os, opts, ctx, STREAM_DEFAULT_BUFFER_SIZE)
+}
+
+func NewCompressedOutputStreamWithOptions(os io.WriteCloser, opts Options) (*CompressedOutputStream, error) {
+	if opts.Headerless == true {
+		return nil, invalidOption("Headerless", "not supported by streams")
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	ctx := make(map[string]interface{})
+	opts.updateContext(ctx, false)
+	return newCompressedOutputStream(os, &opts, ctx, STREAM_DEFAULT_BUFFER_SIZE)
+}
 
-	if tasks == 0 || tasks > MAX_CONCURRENCY {
-		errMsg := fmt.Sprintf("The number of jobs must be in [1..%v]", MAX_CONCURRENCY)
-		return nil, NewIOError(errMsg, kanzi.ERR_CREATE_STREAM)
+func newCompressedOutputStream(os io.WriteCloser, opts *Options, ctx map[string]interface{}, bufferSize uint) (*CompressedOutputStream, error) {
+	this := new(CompressedOutputStream)
+	this.listeners = make([]kanzi.Listener, 0)
+
+	if err := this.init(os, opts, ctx, bufferSize); err != nil {
+		return nil, err
 	}
 
-	bSize := ctx["blockSize"].(uint)
+	return this, nil
+}
 
-	if bSize > MAX_BITSTREAM_BLOCK_SIZE {
-		errMsg := fmt.Sprintf("The block size must be at most %d MB", MAX_BITSTREAM_BLOCK_SIZE>>20)
-		return nil, NewIOError(errMsg, kanzi.ERR_CREATE_STREAM)
+// Discard the state of the stream (the pending data is not written) and
+// make it write a new stream to os with the same options. The buffers,
+// transforms and entropy predictors are reused. The listeners and the
+// cancellation context are kept.
+func (this *CompressedOutputStream) Reset(os io.WriteCloser) error {
+	prev := *this
+	*this = CompressedOutputStream{
+		data:       prev.data,
+		buffers:    prev.buffers,
+		transforms: prev.transforms,
+		predictors: prev.predictors,
+		listeners:  prev.listeners,
+		goCtx:      prev.goCtx}
+
+	ctx := make(map[string]interface{})
+
+	for k, v := range prev.initCtx {
+		ctx[k] = v
+	}
+
+	if err := this.init(os, &prev.opts, ctx, prev.bufferSize); err != nil {
+		// Unusable until the next successful reset
+		this.closed = 1
+		return err
 	}
 
-	if bSize < MIN_BITSTREAM_BLOCK_SIZE {
-		errMsg := fmt.Sprintf("The block size must be at least %d", MIN_BITSTREAM_BLOCK_SIZE)
-		return nil, NewIOError(errMsg, kanzi.ERR_CREATE_STREAM)
+	return nil
+}
+
+// Initialize the state of the stream. The buffers and pools already
+// allocated (see Reset) are reused if they fit.
+func (this *CompressedOutputStream) init(os io.WriteCloser, opts *Options, ctx map[string]interface{}, bufferSize uint) error {
+	// Keep the options to allow a reset after a failure
+	this.opts = *opts
+	this.bufferSize = bufferSize
+	this.initCtx = make(map[string]interface{})
+
+	for k, v := range ctx {
+		this.initCtx[k] = v
 	}
 
-	if int(bSize)&-16 != int(bSize) {
-		return nil, NewIOError("The block size must be a multiple of 16", kanzi.ERR_CREATE_STREAM)
+	if os == nil {
+		return NewIOError("Invalid null writer parameter", kanzi.ERR_CREATE_STREAM)
 	}
 
+	tasks := opts.Jobs
+	bSize := opts.BlockSize
+
 	if uint64(bSize)*uint64(tasks) >= uint64(1<<31) {
 		tasks = (1 << 31) / bSize
 	}
 
-	this := new(CompressedOutputStream)
 	var err error
 
-	if this.obs, err = bitstream.NewDefaultOutputBitStream(os, STREAM_DEFAULT_BUFFER_SIZE); err != nil {
-		return nil, err
+	if this.obs, err = bitstream.NewDefaultOutputBitStream(os, bufferSize); err != nil {
+		return err
 	}
 
-	// Check entropy type validity (panic on error)
-	this.entropyType = entropy.GetType(entropyCodec)
-
-	// Check transform type validity (panic on error)
-	this.transformType = function.GetType(transform)
+	this.os = os
+	this.entropyType = entropy.GetType(opts.Codec)
+	this.transformType = function.GetType(opts.Transform)
+	this.consumed = 0
+	this.startTime = time.Time{}
 	nbBlocks := uint8(0)
 
 	this.blockSize = bSize
@@ -172,8 +339,14 @@ func NewCompressedOutputStream(os io.WriteCloser, ctx map[string]interface{}) (*
 	// This value is written to the bitstream header to let the decoder make
 	// better decisions about memory usage and job allocation in concurrent
 	// decompression scenario.
-	if fileSize, ok := ctx["fileSize"].(int64); ok {
-		nbBlocks = uint8((uint(fileSize) + (bSize - 1)) / bSize)
+	if opts.FileSize > 0 {
+		avgSize := bSize
+
+		if opts.ChunkSize > 0 {
+			avgSize = opts.ChunkSize
+		}
+
+		nbBlocks = uint8((uint(opts.FileSize) + (avgSize - 1)) / avgSize)
 	}
 
 	if nbBlocks > 63 {
@@ -182,34 +355,123 @@ func NewCompressedOutputStream(os io.WriteCloser, ctx map[string]interface{}) (*
 		this.nbInputBlocks = nbBlocks
 	}
 
-	checksum := ctx["checksum"].(bool)
-
-	if checksum == true {
-		this.hasher, err = hash.NewXXHash32(BITSTREAM_TYPE)
+	if opts.Checksum == true {
+		checksumType, err := GetChecksumType(opts.ChecksumType)
 
 		if err != nil {
-			return nil, err
+			return err
+		}
+
+		if this.hasher, err = newBlockChecksum(checksumType, opts.ChecksumKey); err != nil {
+			return err
+		}
+
+		// Also protect the whole content against dropped or reordered blocks
+		if this.blockHasher, err = hash.NewXXHash64(BITSTREAM_TYPE); err != nil {
+			return err
+		}
+
+		if this.contentHasher, err = hash.NewXXHash64(BITSTREAM_TYPE); err != nil {
+			return err
+		}
+
+		this.headerFlags |= HEADER_FLAG_CONTENT_HASH
+	}
+
+	if opts.BlockIndex == true {
+		this.headerFlags |= HEADER_FLAG_BLOCK_INDEX
+		this.index = make([]blockIndexEntry, 0)
+	}
+
+	// Independent blocks are entropy coded in parallel, each one in its own
+	// buffer. They are written to the stream with a size prefix.
+	if opts.IndependentBlocks == true {
+		this.headerFlags |= HEADER_FLAG_INDEPENDENT
+	}
+
+	// The ID of the dictionary lets the decoder check its own dictionary
+	if len(opts.Dictionary) > 0 {
+		this.headerFlags |= HEADER_FLAG_DICTIONARY
+		this.dictionaryID = DictionaryID(opts.Dictionary)
+	}
+
+	// Encrypted blocks use the independent block layout: each block is
+	// encrypted once entropy coded in its own buffer.
+	this.cipher = nil
+	this.blockCount = 0
+
+	if opts.encrypted() == true {
+		if this.cipher, err = newEncryptionCipher(opts); err != nil {
+			return err
+		}
+
+		this.headerFlags |= HEADER_FLAG_ENCRYPTED | HEADER_FLAG_INDEPENDENT
+	}
+
+	// Parity blocks are computed from the encoded blocks (independent block
+	// layout), see Parity.go
+	this.parity = nil
+
+	if opts.ParityBlocks > 0 {
+		if this.parity, err = newParityWriter(int(opts.ParityGroupSize), int(opts.ParityBlocks)); err != nil {
+			return err
+		}
+
+		this.headerFlags |= HEADER_FLAG_PARITY | HEADER_FLAG_INDEPENDENT
+	}
+
+	// The block boundaries follow the content, see Chunker.go
+	this.chunker = nil
+
+	if opts.ChunkSize > 0 {
+		if this.chunker, err = newChunker(opts.MinChunkSize, opts.ChunkSize, opts.MaxChunkSize); err != nil {
+			return err
 		}
 	}
 
 	this.jobs = int(tasks)
-	this.data = make([]byte, int(this.blockSize)) // initially 1 blockSize
-	this.buffers = make([]blockBuffer, 2*this.jobs)
 
-	for i := range this.buffers {
-		this.buffers[i] = blockBuffer{Buf: EMPTY_BYTE_SLICE}
+	if len(this.data) < int(this.blockSize) {
+		this.data = make([]byte, int(this.blockSize)) // initially 1 blockSize
+	}
+
+	if len(this.buffers) != 2*this.jobs {
+		this.buffers = make([]blockBuffer, 2*this.jobs)
+
+		for i := range this.buffers {
+			this.buffers[i] = blockBuffer{Buf: EMPTY_BYTE_SLICE}
+		}
+	}
+
+	if len(this.transforms) != this.jobs {
+		this.transforms, this.predictors = newTaskPools(this.jobs)
 	}
 
 	this.blockId = 0
 	this.channels = make([]chan error, this.jobs+1)
 
+	// Buffered to let a single task run in the calling go routine
 	for i := range this.channels {
-		this.channels[i] = make(chan error)
+		this.channels[i] = make(chan error, 1)
 	}
 
-	this.listeners = make([]kanzi.Listener, 0)
 	this.ctx = ctx
-	return this, nil
+	return nil
+}
+
+// Create one pool of transforms and one pool of predictors per job. A pool
+// is used by one task at a time: the tasks of a batch are complete before
+// the next batch starts.
+func newTaskPools(jobs int) ([]*function.ByteFunctionPool, []*entropy.PredictorPool) {
+	transforms := make([]*function.ByteFunctionPool, jobs)
+	predictors := make([]*entropy.PredictorPool, jobs)
+
+	for i := 0; i < jobs; i++ {
+		transforms[i] = function.NewByteFunctionPool()
+		predictors[i] = entropy.NewPredictorPool()
+	}
+
+	return transforms, predictors
 }
 
 func (this *CompressedOutputStream) AddListener(bl kanzi.Listener) bool {
@@ -238,9 +500,11 @@ func (this *CompressedOutputStream) RemoveListener(bl kanzi.Listener) bool {
 
 func (this *CompressedOutputStream) writeHeader() *IOError {
 	cksum := 0
+	checksumType := uint(0)
 
 	if this.hasher != nil {
 		cksum = 1
+		checksumType = this.hasher.checksumType
 	}
 
 	if this.obs.WriteBits(BITSTREAM_TYPE, 32) != 32 {
@@ -271,18 +535,140 @@ func (this *CompressedOutputStream) writeHeader() *IOError {
 		return NewIOError("Cannot write number of blocks to header", kanzi.ERR_WRITE_FILE)
 	}
 
-	if this.obs.WriteBits(0, 3) != 3 {
-		return NewIOError("Cannot write reserved bits to header", kanzi.ERR_WRITE_FILE)
+	if this.obs.WriteBits(uint64(checksumType), 3) != 3 {
+		return NewIOError("Cannot write checksum type to header", kanzi.ERR_WRITE_FILE)
+	}
+
+	if this.metadata != nil && this.metadata.Len() > 0 {
+		this.headerFlags |= HEADER_FLAG_METADATA
+	}
+
+	if this.obs.WriteBits(uint64(this.headerFlags), 16) != 16 {
+		return NewIOError("Cannot write flags to header", kanzi.ERR_WRITE_FILE)
+	}
+
+	if this.headerFlags&HEADER_FLAG_DICTIONARY != 0 {
+		if this.obs.WriteBits(uint64(this.dictionaryID), 32) != 32 {
+			return NewIOError("Cannot write dictionary ID to header", kanzi.ERR_WRITE_FILE)
+		}
+	}
+
+	if this.headerFlags&HEADER_FLAG_ENCRYPTED != 0 {
+		if err := this.cipher.writeHeader(this.obs); err != nil {
+			return err
+		}
+	}
+
+	if this.headerFlags&HEADER_FLAG_PARITY != 0 {
+		if this.obs.WriteBits(uint64(this.parity.groupSize), 8) != 8 {
+			return NewIOError("Cannot write parity group size to header", kanzi.ERR_WRITE_FILE)
+		}
+
+		if this.obs.WriteBits(uint64(this.parity.nbParity), 8) != 8 {
+			return NewIOError("Cannot write number of parity blocks to header", kanzi.ERR_WRITE_FILE)
+		}
+	}
+
+	if this.headerFlags&HEADER_FLAG_ENCRYPTED != 0 {
+		// The metadata is encrypted and authenticated with the header fields
+		var metadata []byte
+
+		if this.headerFlags&HEADER_FLAG_METADATA != 0 {
+			var err error
+
+			if metadata, err = marshalMetadata(this.metadata); err != nil {
+				return WrapIOError(err, "", kanzi.ERR_WRITE_FILE)
+			}
+
+			if this.obs.WriteBits(uint64(len(metadata)), 32) != 32 {
+				return NewIOError("Cannot write metadata size to header", kanzi.ERR_WRITE_FILE)
+			}
+		}
+
+		hdr := &frameHeader{
+			version:       BITSTREAM_FORMAT_VERSION,
+			checksum:      cksum == 1,
+			checksumType:  checksumType,
+			entropyType:   this.entropyType,
+			transformType: this.transformType,
+			blockSize:     this.blockSize,
+			nbInputBlocks: this.nbInputBlocks,
+			flags:         this.headerFlags,
+			dictionaryID:  this.dictionaryID,
+		}
+
+		if this.parity != nil {
+			hdr.parityGroup = this.parity.groupSize
+			hdr.parityBlocks = this.parity.nbParity
+		}
+
+		return this.cipher.writeHeaderTag(this.obs, hdr.encryptionAAD(), metadata)
+	}
+
+	if this.headerFlags&HEADER_FLAG_METADATA != 0 {
+		if err := writeMetadata(this.obs, this.metadata); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+// Return the metadata to write to the header, failing if the header has
+// already been written
+func (this *CompressedOutputStream) writableMetadata() (*Metadata, error) {
+	if atomic.LoadInt32(&this.initialized) == 1 {
+		return nil, NewIOError("Cannot set metadata after the header has been written", kanzi.ERR_WRITE_FILE)
+	}
+
+	if this.metadata == nil {
+		this.metadata = NewMetadata()
+	}
+
+	return this.metadata, nil
+}
+
+// Add a key/value pair to the metadata section of the header.
+// Must be called before the first block is written.
+func (this *CompressedOutputStream) SetMetadataBytes(key string, value []byte) error {
+	md, err := this.writableMetadata()
+
+	if err != nil {
+		return err
+	}
+
+	return md.SetBytes(key, value)
+}
+
+func (this *CompressedOutputStream) SetMetadataString(key string, value string) error {
+	md, err := this.writableMetadata()
+
+	if err != nil {
+		return err
+	}
+
+	return md.SetString(key, value)
+}
+
+func (this *CompressedOutputStream) SetMetadataInt(key string, value int64) error {
+	md, err := this.writableMetadata()
+
+	if err != nil {
+		return err
+	}
+
+	return md.SetInt(key, value)
+}
+
 func (this *CompressedOutputStream) Write(block []byte) (int, error) {
 	if atomic.LoadInt32(&this.closed) == 1 {
 		return 0, NewIOError("Stream closed", kanzi.ERR_WRITE_FILE)
 	}
 
+	if err := checkContext(this.goCtx); err != nil {
+		return 0, err
+	}
+
 	startChunk := 0
 	remaining := len(block)
 
@@ -317,22 +703,149 @@ func (this *CompressedOutputStream) Write(block []byte) (int, error) {
 	return len(block) - remaining, nil
 }
 
+// Write the pending data as a block followed by a flush block and flush the
+// bitstream, so that a reader can decode all the data written so far without
+// waiting for more input. The flush block pads the bitstream to a byte boundary.
+func (this *CompressedOutputStream) Flush() error {
+	if atomic.LoadInt32(&this.closed) == 1 {
+		return NewIOError("Stream closed", kanzi.ERR_WRITE_FILE)
+	}
+
+	if err := checkContext(this.goCtx); err != nil {
+		return err
+	}
+
+	// Several batches may be required with content-defined chunking
+	for this.curIdx > 0 {
+		if err := this.processBlock(true); err != nil {
+			return err
+		}
+	}
+
+	if atomic.SwapInt32(&this.initialized, 1) == 0 {
+		if err := this.writeHeader(); err != nil {
+			return err
+		}
+	}
+
+	if this.headerFlags&HEADER_FLAG_INDEPENDENT != 0 {
+		// Complete the current parity group
+		if this.parity != nil {
+			if err := this.parity.flush(this.obs); err != nil {
+				return err
+			}
+		}
+
+		this.obs.WriteBits(FLUSH_BLOCK_SIZE, 32)
+	} else {
+		this.obs.WriteBits(FLUSH_BLOCK_MODE, 8)
+		this.obs.WriteBits(0, 16)
+	}
+
+	// Align to next byte
+	if r := uint(this.obs.Written() & 7); r != 0 {
+		this.obs.WriteBits(0, 8-r)
+	}
+
+	if f, isFlusher := this.obs.(flusher); isFlusher == true {
+		if err := f.Flush(); err != nil {
+			return WrapIOError(err, "", kanzi.ERR_WRITE_FILE)
+		}
+	}
+
+	// Flush the underlying writer if possible (EG. bufio.Writer)
+	if f, isFlusher := this.os.(flusher); isFlusher == true {
+		if err := f.Flush(); err != nil {
+			return WrapIOError(err, "", kanzi.ERR_WRITE_FILE)
+		}
+	}
+
+	return nil
+}
+
+// Encode the pending data so that the next byte written starts a new block
+// (see ArchiveWriter)
+func (this *CompressedOutputStream) endBlock() error {
+	if atomic.LoadInt32(&this.closed) == 1 {
+		return NewIOError("Stream closed", kanzi.ERR_WRITE_FILE)
+	}
+
+	for this.curIdx > 0 {
+		if err := this.processBlock(true); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (this *CompressedOutputStream) Close() error {
 	if atomic.SwapInt32(&this.closed, 1) == 1 {
 		return nil
 	}
 
-	if this.curIdx > 0 {
+	// Cancelled: the stream is incomplete, do not write the end of stream
+	if err := checkContext(this.goCtx); err != nil {
+		return err
+	}
+
+	// Several batches may be required with content-defined chunking
+	for this.curIdx > 0 {
 		if err := this.processBlock(true); err != nil {
 			return err
 		}
+	}
 
-		this.curIdx = 0
+	// Empty stream: the header has not been written yet
+	if atomic.SwapInt32(&this.initialized, 1) == 0 {
+		if err := this.writeHeader(); err != nil {
+			return err
+		}
 	}
 
 	// Write end block of size 0
-	this.obs.WriteBits(COPY_BLOCK_MASK, 8)
-	this.obs.WriteBits(0, 8)
+	if this.headerFlags&HEADER_FLAG_INDEPENDENT != 0 {
+		if this.parity != nil {
+			if err := this.parity.flush(this.obs); err != nil {
+				return err
+			}
+		}
+
+		this.obs.WriteBits(0, 32)
+
+		if this.cipher != nil {
+			this.obs.WriteArray(this.cipher.endTag(this.blockCount), 8*ENCRYPTION_TAG_SIZE)
+		}
+	} else {
+		this.obs.WriteBits(COPY_BLOCK_MASK, 8)
+		this.obs.WriteBits(0, 8)
+	}
+
+	if this.headerFlags&HEADER_FLAG_CONTENT_HASH != 0 {
+		// Write content hash, content size and number of blocks
+		if this.cipher != nil {
+			trailer := make([]byte, CONTENT_TRAILER_SIZE)
+			binary.BigEndian.PutUint64(trailer[0:], this.contentHash)
+			binary.BigEndian.PutUint64(trailer[8:], this.contentSize)
+			binary.BigEndian.PutUint32(trailer[16:], this.nbBlocks)
+			trailer = this.cipher.sealTrailer(nonceTrailer, 0, trailer)
+			this.obs.WriteArray(trailer, uint(8*len(trailer)))
+		} else {
+			this.obs.WriteBits(this.contentHash, 64)
+			this.obs.WriteBits(this.contentSize, 64)
+			this.obs.WriteBits(uint64(this.nbBlocks), 32)
+		}
+	}
+
+	if this.headerFlags&HEADER_FLAG_BLOCK_INDEX != 0 {
+		if this.parity != nil {
+			this.parity.updateIndex(this.index)
+		}
+
+		if err := writeBlockIndex(this.obs, this.index, this.cipher); err != nil {
+			return err
+		}
+	}
 
 	if _, err := this.obs.Close(); err != nil {
 		return err
@@ -357,6 +870,10 @@ func (this *CompressedOutputStream) processBlock(force bool) error {
 		return nil
 	}
 
+	if err := checkContext(this.goCtx); err != nil {
+		return err
+	}
+
 	if !force && len(this.data) < int(this.blockSize)*this.jobs {
 		// Grow byte array until max allowed
 		buf := make([]byte, len(this.data)+int(this.blockSize))
@@ -371,26 +888,51 @@ func (this *CompressedOutputStream) processBlock(force bool) error {
 		}
 	}
 
-	offset := uint(0)
+	if this.startTime.IsZero() {
+		this.startTime = time.Now()
+	}
+
+	offset := uint(this.startIdx)
+	compact := false
 
 	// Protect against future concurrent modification of the list of block listeners
 	listeners := make([]kanzi.Listener, len(this.listeners))
 	copy(listeners, this.listeners)
 	nbJobs := 0
+	var entries []blockIndexEntry
+	var blockHashes []uint64
+
+	if this.index != nil {
+		entries = make([]blockIndexEntry, this.jobs)
+	}
+
+	if this.contentHasher != nil {
+		blockHashes = make([]uint64, this.jobs)
+	}
+
+	// Allow start of entropy coding for first block
+	this.channels[0] <- error(nil)
 
 	// Invoke as many go routines as required
 	for jobId := 0; jobId < this.jobs; jobId++ {
-		if this.curIdx == 0 {
+		if offset == uint(this.curIdx) {
 			break
 		}
 
-		nbJobs = jobId + 1
-		sz := uint(this.curIdx)
+		sz := uint(this.curIdx) - offset
 
-		if sz >= this.blockSize {
+		if this.chunker != nil {
+			// No boundary found: keep the data for the next batch
+			if sz = uint(this.chunker.next(this.data[offset:offset+sz], force)); sz == 0 {
+				compact = true
+				break
+			}
+		} else if sz >= this.blockSize {
 			sz = this.blockSize
 		}
 
+		nbJobs = jobId + 1
+
 		if len(this.buffers[2*jobId].Buf) < int(sz) {
 			this.buffers[2*jobId].Buf = make([]byte, sz)
 		}
@@ -410,28 +952,76 @@ func (this *CompressedOutputStream) processBlock(force bool) error {
 			blockTransformType: this.transformType,
 			blockEntropyType:   this.entropyType,
 			currentBlockId:     this.blockId + jobId + 1,
+			independent:        this.headerFlags&HEADER_FLAG_INDEPENDENT != 0,
+			cipher:             this.cipher,
+			blockNumber:        this.blockCount + uint64(jobId) + 1,
+			parity:             this.parity,
 			input:              this.channels[jobId],
 			output:             this.channels[jobId+1],
-			obs:                this.obs,
+			obs:                newContextOutputBitStream(this.goCtx, this.obs),
 			listeners:          listeners,
+			transforms:         this.transforms[jobId],
+			predictors:         this.predictors[jobId],
+			goCtx:              this.goCtx,
 			ctx:                copyCtx}
 
-		// Invoke the tasks concurrently
-		// Tasks are chained through channels. Upon completion of transform
-		// (concurrently) the tasks wait for a signal to start entropy encoding
-		go task.encode()
+		if entries != nil {
+			task.indexEntry = &entries[jobId]
+		}
+
+		if blockHashes != nil {
+			task.blockHasher = this.blockHasher
+			task.blockHash = &blockHashes[jobId]
+			this.contentSize += uint64(sz)
+			this.nbBlocks++
+		}
+
+		if this.jobs == 1 {
+			// No concurrency: avoid the cost of a go routine
+			task.encode()
+		} else {
+			// Invoke the tasks concurrently
+			// Tasks are chained through channels. Upon completion of transform
+			// (concurrently) the tasks wait for a signal to start entropy encoding
+			go task.encode()
+		}
 
 		offset += sz
-		this.curIdx -= int(sz)
 	}
 
-	// Allow start of entropy coding for first block
-	this.channels[0] <- error(nil)
+	this.consumed += uint64(offset) - uint64(this.startIdx)
+	this.startIdx = int(offset)
+
+	// The data left by the chunker (if any) is encoded by the next batches
+	// from the read cursor. It is moved to the start of the buffer only once
+	// no boundary is found in it: the rest of the buffer has been consumed.
+	if this.startIdx == this.curIdx {
+		this.startIdx = 0
+		this.curIdx = 0
+	} else if compact == true {
+		this.curIdx = copy(this.data, this.data[this.startIdx:this.curIdx])
+		this.startIdx = 0
+	}
 
 	// Wait for completion of last task
 	err := <-this.channels[nbJobs]
+	this.blockCount += uint64(nbJobs)
 
-	this.blockId += this.jobs
+	if err == nil && entries != nil {
+		this.index = append(this.index, entries[0:nbJobs]...)
+	}
+
+	// Chain the block hashes in block order
+	for i := 0; i < nbJobs && blockHashes != nil; i++ {
+		this.contentHash = chainContentHash(this.contentHasher, this.contentHash, blockHashes[i])
+	}
+
+	if err == nil && len(listeners) > 0 {
+		notifyProgress(listeners, this.blockId+nbJobs, this.consumed, this.GetWritten(),
+			this.opts.FileSize, this.startTime)
+	}
+
+	this.blockId += nbJobs
 	return err
 }
 
@@ -449,18 +1039,35 @@ func (this *CompressedOutputStream) GetWritten() uint64 {
 //  case more than 4 transforms
 //      | 0b00000000
 //      then 0byyyyyyyy => transform sequence skip flags (1 means skip)
+// Return err with the ID of the block. The offset in the compressed stream
+// is only known once the task owns the bitstream (input received).
+func (this *EncodingTask) blockError(err *IOError, inputReceived bool) error {
+	offset := int64(-1)
+
+	if inputReceived == true {
+		offset = int64(this.obs.Written() >> 3)
+	}
+
+	return err.setPosition(this.currentBlockId, offset)
+}
+
 func (this *EncodingTask) encode() {
 	data := this.iBuffer.Buf
 	buffer := this.oBuffer.Buf
 	mode := byte(0)
 	var postTransformLength uint
-	checksum := uint32(0)
+	checksum := uint64(0)
 
 	// Compute block checksum
 	if this.hasher != nil {
 		checksum = this.hasher.Hash(data[0:this.blockLength])
 	}
 
+	// Compute block hash used for the content checksum
+	if this.blockHasher != nil {
+		*this.blockHash = this.blockHasher.Hash(data[0:this.blockLength])
+	}
+
 	if len(this.listeners) > 0 {
 		// Notify before transform
 		evt := kanzi.NewEvent(kanzi.EVT_BEFORE_TRANSFORM, this.currentBlockId,
@@ -476,10 +1083,16 @@ func (this *EncodingTask) encode() {
 				<-this.input
 			}
 
-			this.output <- NewIOError(r.(error).Error(), kanzi.ERR_PROCESS_BLOCK)
+			this.output <- this.blockError(toIOError(r, kanzi.ERR_PROCESS_BLOCK), inputReceived)
 		}
 	}()
 
+	if err := checkContext(this.goCtx); err != nil {
+		<-this.input
+		this.output <- this.blockError(err, false)
+		return
+	}
+
 	if this.blockLength <= SMALL_BLOCK_SIZE {
 		if this.blockLength == 0 {
 			this.blockTransformType = function.NONE_TYPE
@@ -504,11 +1117,11 @@ func (this *EncodingTask) encode() {
 	}
 
 	this.ctx["size"] = this.blockLength
-	t, err := function.NewByteFunction(&this.ctx, this.blockTransformType)
+	t, err := this.transforms.NewByteFunction(&this.ctx, this.blockTransformType)
 
 	if err != nil {
 		<-this.input
-		this.output <- NewIOError(err.Error(), kanzi.ERR_CREATE_CODEC)
+		this.output <- this.blockError(WrapIOError(err, "", kanzi.ERR_CREATE_CODEC), inputReceived)
 		return
 	}
 
@@ -530,7 +1143,7 @@ func (this *EncodingTask) encode() {
 
 	if dataSize > 3 {
 		<-this.input
-		this.output <- NewIOError("Invalid block data length", kanzi.ERR_WRITE_FILE)
+		this.output <- this.blockError(NewIOError("Invalid block data length", kanzi.ERR_WRITE_FILE), inputReceived)
 		return
 	}
 
@@ -545,34 +1158,51 @@ func (this *EncodingTask) encode() {
 		notifyListeners(this.listeners, evt)
 	}
 
-	// Wait for the concurrent task processing the previous block to complete
-	// entropy encoding. Entropy encoding must happen sequentially (and
-	// in the correct block order) in the bitstream.
-	err2 := <-this.input
-	inputReceived = true
+	obs := this.obs
+	var bs *util.BufferStream
 
-	if err2 != nil {
-		this.output <- err2
-		return
+	if this.independent == true {
+		// Encode the block in its own bitstream, concurrently with the
+		// other tasks
+		bs = util.NewBufferStream(nil)
+
+		if obs, err = bitstream.NewDefaultOutputBitStream(bs, STREAM_DEFAULT_BUFFER_SIZE); err != nil {
+			<-this.input
+			this.output <- this.blockError(WrapIOError(err, "", kanzi.ERR_CREATE_BITSTREAM), inputReceived)
+			return
+		}
+
+		obs = newContextOutputBitStream(this.goCtx, obs)
+	} else {
+		// Wait for the concurrent task processing the previous block to complete
+		// entropy encoding. Entropy encoding must happen sequentially (and
+		// in the correct block order) in the bitstream.
+		err2 := <-this.input
+		inputReceived = true
+
+		if err2 != nil {
+			this.output <- err2
+			return
+		}
 	}
 
 	// Write block 'header' (mode + compressed length)
-	written := this.obs.Written()
+	written := obs.Written()
 
 	if ((mode & COPY_BLOCK_MASK) != 0) || (t.NbFunctions() <= 4) {
 		mode |= byte(t.SkipFlags() >> 4)
-		this.obs.WriteBits(uint64(mode), 8)
+		obs.WriteBits(uint64(mode), 8)
 	} else {
 		mode |= TRANSFORMS_MASK
-		this.obs.WriteBits(uint64(mode), 8)
-		this.obs.WriteBits(uint64(t.SkipFlags()), 8)
+		obs.WriteBits(uint64(mode), 8)
+		obs.WriteBits(uint64(t.SkipFlags()), 8)
 	}
 
-	this.obs.WriteBits(uint64(postTransformLength), 8*dataSize)
+	obs.WriteBits(uint64(postTransformLength), 8*dataSize)
 
 	// Write checksum
 	if this.hasher != nil {
-		this.obs.WriteBits(uint64(checksum), 32)
+		obs.WriteBits(checksum, this.hasher.Size())
 	}
 
 	if len(this.listeners) > 0 {
@@ -584,10 +1214,14 @@ func (this *EncodingTask) encode() {
 
 	// Each block is encoded separately
 	// Rebuild the entropy encoder to reset block statistics
-	ee, err := entropy.NewEntropyEncoder(this.obs, this.ctx, this.blockEntropyType)
+	ee, err := this.predictors.NewEntropyEncoder(obs, this.ctx, this.blockEntropyType)
 
 	if err != nil {
-		this.output <- NewIOError(err.Error(), kanzi.ERR_CREATE_CODEC)
+		if inputReceived == false {
+			<-this.input
+		}
+
+		this.output <- this.blockError(WrapIOError(err, "", kanzi.ERR_CREATE_CODEC), inputReceived)
 		return
 	}
 
@@ -595,17 +1229,66 @@ func (this *EncodingTask) encode() {
 	_, err = ee.Encode(buffer[0:postTransformLength])
 
 	if err != nil {
-		this.output <- NewIOError(err.Error(), kanzi.ERR_PROCESS_BLOCK)
+		if inputReceived == false {
+			<-this.input
+		}
+
+		this.output <- this.blockError(WrapIOError(err, "", kanzi.ERR_PROCESS_BLOCK), inputReceived)
 		return
 	}
 
 	// Dispose before displaying statistics. Dispose may write to the bitstream
 	ee.Dispose()
+	encoded := int64(obs.Written()-written) / 8
+	length := uint32((obs.Written() - written + 7) >> 3)
+
+	if this.independent == true {
+		obs.Close()
+		block := bs.Bytes()
+
+		// Encrypt once entropy coded (before waiting for the previous block)
+		if this.cipher != nil {
+			block = this.cipher.seal(nil, block, this.blockNumber)
+		}
+
+		encoded = int64(len(block))
+		length = uint32(encoded)
+
+		// Wait for the concurrent task processing the previous block to
+		// complete. Only the copy of the encoded block is sequential.
+		err2 := <-this.input
+		inputReceived = true
+
+		if err2 != nil {
+			this.output <- err2
+			return
+		}
+
+		// Write the size of the encoded block followed by the block
+		written = this.obs.Written()
+
+		if this.parity != nil {
+			// Written with its group (the index is updated at the end)
+			if err := this.parity.add(this.obs, block); err != nil {
+				this.output <- this.blockError(err, true)
+				return
+			}
+		} else {
+			this.obs.WriteBits(uint64(encoded), 32)
+			this.obs.WriteArray(block, uint(8*encoded))
+		}
+	}
+
+	if this.indexEntry != nil {
+		this.indexEntry.offset = written
+		this.indexEntry.size = uint32(this.blockLength)
+		this.indexEntry.length = length
+	}
 
 	if len(this.listeners) > 0 {
 		// Notify after entropy
 		evt := kanzi.NewEvent(kanzi.EVT_AFTER_ENTROPY, this.currentBlockId,
-			int64(this.obs.Written()-written)/8, checksum, this.hasher != nil, time.Now())
+			encoded, checksum, this.hasher != nil, time.Now())
 		notifyListeners(this.listeners, evt)
 	}
 
@@ -613,6 +1296,23 @@ func (this *EncodingTask) encode() {
 	this.output <- error(nil)
 }
 
+// Chain the hash of a block to the hash of the previous blocks. The result
+// depends on the content and the order of all the blocks.
+func chainContentHash(hasher *hash.XXHash64, contentHash, blockHash uint64) uint64 {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], blockHash)
+	hasher.SetSeed(contentHash)
+	return hasher.Hash(buf[:])
+}
+
+// Notify the listeners of the number of bytes processed so far (input and
+// output) and of the size of the input (0 if unknown)
+func notifyProgress(listeners []kanzi.Listener, id int, input, output uint64, total int64, start time.Time) {
+	now := time.Now()
+	evt := kanzi.NewProgressEvent(id, int64(input), int64(output), total, now.Sub(start), now)
+	notifyListeners(listeners, evt)
+}
+
 func notifyListeners(listeners []kanzi.Listener, evt *kanzi.Event) {
 	defer func() {
 		//lint:ignore SA9003 ignore panics in listeners
@@ -631,7 +1331,14 @@ type Message struct {
 	data           []byte
 	decoded        int
 	blockId        int
-	checksum       uint32
+	checksum       uint64
+	blockHash      uint64
+	bitOffset      uint64 // position of the block header in the bitstream
+	endOffset      uint64 // position of the end of the block in the bitstream (once released)
+	groupIdx       int    // position of the block in its parity group (if any)
+	flushed        bool   // flush block: return the data decoded so far
+	damaged        bool   // recovery mode: block decoded with errors
+	resync         bool   // recovery mode: block could not be entropy decoded
 	completionTime time.Time
 }
 
@@ -640,81 +1347,237 @@ type semaphore chan bool
 type CompressedInputStream struct {
 	blockSize     uint
 	nbInputBlocks uint8
-	hasher        *hash.XXHash32
+	hasher        *blockChecksum
+	blockHasher   *hash.XXHash64
+	contentHasher *hash.XXHash64
+	contentHash   uint64
+	contentSize   uint64
+	nbBlocks      uint32
 	data          []byte
 	buffers       []blockBuffer
 	entropyType   uint32
 	transformType uint64
+	headerFlags   uint
+	dictionaryID  uint32
+	version       uint // version of the current frame
+	cipher        *blockCipher
+	blockCount    uint64 // number of blocks read in the frame (nonce of encrypted blocks)
+	parity        *parityReader
+	metadata      *Metadata
+	is            io.ReadCloser
+	origin        int64
 	ibs           kanzi.InputBitStream
 	initialized   int32
 	closed        int32
 	blockId       int
 	maxIdx        int
 	curIdx        int
+	skipIdx       int
+	position      int64
 	jobs          int
+	index         []blockIndexEntry
+	indexLock     sync.Mutex // serializes the loading of the index (ReadAt)
+	readAtLock    sync.Mutex // serializes ReadAt if the reader does not implement io.ReaderAt
 	resChan       chan Message
 	listeners     []kanzi.Listener
 	readLastBlock bool
+	flushed       bool
+	singleFrame   bool
+	recover       bool
+	err           error // recovery mode: error returned once the blocks decoded before it have been read
+	frames        int
+	ibsOffset     uint64 // position of the start of ibs in the bitstream (in bits)
+	maxBlockSize  uint   // resource limits (0 means no limit)
+	maxMemory     uint64
+	maxOutputSize uint64
+	outputSize    uint64    // size of the decompressed data so far
+	inputSize     int64     // size of the input if known (progress events)
+	startTime     time.Time // start of the decoding (progress events)
+	goCtx         context.Context
 	ctx           map[string]interface{}
+	opts          Options // kept for Reset
+	initCtx       map[string]interface{}
+	bufferSize    uint
+	transforms    []*function.ByteFunctionPool // one pool per job
+	predictors    []*entropy.PredictorPool
 }
 
 type DecodingTask struct {
 	iBuffer            *blockBuffer
 	oBuffer            *blockBuffer
-	hasher             *hash.XXHash32
+	hasher             *blockChecksum
+	blockHasher        *hash.XXHash64
 	blockLength        uint
 	blockTransformType uint64
 	blockEntropyType   uint32
 	currentBlockId     int
+	independent        bool
+	cipher             *blockCipher
+	blockCount         *uint64 // updated while reading the block (sequential)
+	parity             *parityReader
+	recover            bool
 	input              chan bool
 	output             chan bool
 	result             chan Message
 	listeners          []kanzi.Listener
 	ibs                kanzi.InputBitStream
+	transforms         *function.ByteFunctionPool
+	predictors         *entropy.PredictorPool
+	goCtx              context.Context
 	ctx                map[string]interface{}
 }
 
+// Create a compressed input stream from a context map. The map is validated
+// (see Options) and shared with the transforms and entropy codecs.
 func NewCompressedInputStream(is io.ReadCloser, ctx map[string]interface{}) (*CompressedInputStream, error) {
-	if is == nil {
-		return nil, NewIOError("Invalid null reader parameter", kanzi.ERR_CREATE_STREAM)
-	}
-
 	if ctx == nil {
 		return nil, NewIOError("Invalid null context parameter", kanzi.ERR_CREATE_STREAM)
 	}
 
-	tasks := ctx["jobs"].(uint)
+	opts, err := newOptionsFromContext(ctx, true)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	opts.updateContext(ctx, true)
+	return newCompressedInputStream(is, opts, ctx, STREAM_DEFAULT_BUFFER_SIZE)
+}
+
+// Create a compressed input stream. Only the decoding options (jobs, checksum
+// key, single frame, recovery mode and resource limits) are used, the others
+// are read from the stream header.
+func NewCompressedInputStreamWithOptions(is io.ReadCloser, opts Options) (*CompressedInputStream, error) {
+	if opts.Headerless == true {
+		return nil, invalidOption("Headerless", "not supported by streams")
+	}
 
-	if tasks == 0 || tasks > MAX_CONCURRENCY {
-		errMsg := fmt.Sprintf("The number of jobs must be in [1..%v]", MAX_CONCURRENCY)
-		return nil, NewIOError(errMsg, kanzi.ERR_CREATE_STREAM)
+	if err := opts.Validate(); err != nil {
+		return nil, err
 	}
 
+	ctx := make(map[string]interface{})
+	opts.updateContext(ctx, true)
+	return newCompressedInputStream(is, &opts, ctx, STREAM_DEFAULT_BUFFER_SIZE)
+}
+
+func newCompressedInputStream(is io.ReadCloser, opts *Options, ctx map[string]interface{}, bufferSize uint) (*CompressedInputStream, error) {
 	this := new(CompressedInputStream)
+	this.data = EMPTY_BYTE_SLICE
+	this.listeners = make([]kanzi.Listener, 0)
 
-	this.jobs = int(tasks)
+	if err := this.init(is, opts, ctx, bufferSize); err != nil {
+		return nil, err
+	}
+
+	return this, nil
+}
+
+// Discard the state of the stream and make it read a new stream from is
+// with the same options. The buffers, transforms and entropy predictors
+// are reused. The listeners and the cancellation context are kept.
+func (this *CompressedInputStream) Reset(is io.ReadCloser) error {
+	opts, initCtx, bufferSize := this.opts, this.initCtx, this.bufferSize
+	*this = CompressedInputStream{
+		data:       this.data,
+		buffers:    this.buffers,
+		transforms: this.transforms,
+		predictors: this.predictors,
+		listeners:  this.listeners,
+		goCtx:      this.goCtx}
+
+	ctx := make(map[string]interface{})
+
+	for k, v := range initCtx {
+		ctx[k] = v
+	}
+
+	if err := this.init(is, &opts, ctx, bufferSize); err != nil {
+		// Unusable until the next successful reset
+		this.closed = 1
+		return err
+	}
+
+	return nil
+}
+
+// Initialize the state of the stream. The buffers and pools already
+// allocated (see Reset) are reused if they fit.
+func (this *CompressedInputStream) init(is io.ReadCloser, opts *Options, ctx map[string]interface{}, bufferSize uint) error {
+	// Keep the options to allow a reset after a failure
+	this.opts = *opts
+	this.bufferSize = bufferSize
+	this.initCtx = make(map[string]interface{})
+
+	for k, v := range ctx {
+		this.initCtx[k] = v
+	}
+
+	if is == nil {
+		return NewIOError("Invalid null reader parameter", kanzi.ERR_CREATE_STREAM)
+	}
+
+	this.jobs = int(opts.Jobs)
 	this.blockId = 0
-	this.data = EMPTY_BYTE_SLICE
-	this.buffers = make([]blockBuffer, 2*this.jobs)
 
-	for i := range this.buffers {
-		this.buffers[i] = blockBuffer{Buf: EMPTY_BYTE_SLICE}
+	if len(this.buffers) != 2*this.jobs {
+		this.buffers = make([]blockBuffer, 2*this.jobs)
+
+		for i := range this.buffers {
+			this.buffers[i] = blockBuffer{Buf: EMPTY_BYTE_SLICE}
+		}
+	}
+
+	if len(this.transforms) != this.jobs {
+		this.transforms, this.predictors = newTaskPools(this.jobs)
 	}
 
-	this.resChan = make(chan Message)
+	// Buffered to let a single task run in the calling go routine
+	this.resChan = make(chan Message, 1)
+	this.is = is
 	var err error
 
-	if this.ibs, err = bitstream.NewDefaultInputBitStream(is, STREAM_DEFAULT_BUFFER_SIZE); err != nil {
+	this.inputSize = 0
+	this.startTime = time.Time{}
+
+	if rs, isSeeker := is.(io.Seeker); isSeeker == true {
+		// Remember where the stream starts to locate blocks from the index
+		if this.origin, err = rs.Seek(0, io.SeekCurrent); err != nil {
+			this.origin = 0
+		} else if end, err2 := rs.Seek(0, io.SeekEnd); err2 == nil {
+			// Size of the input for the progress events
+			this.inputSize = end - this.origin
+			rs.Seek(this.origin, io.SeekStart)
+		}
+	}
+
+	if this.ibs, err = bitstream.NewDefaultInputBitStream(is, bufferSize); err != nil {
 		errMsg := fmt.Sprintf("Cannot create input bit stream: %v", err)
-		return nil, NewIOError(errMsg, kanzi.ERR_CREATE_BITSTREAM)
+		return NewIOError(errMsg, kanzi.ERR_CREATE_BITSTREAM)
 	}
 
-	this.listeners = make([]kanzi.Listener, 0)
+	// Concatenated streams are decoded unless single frame mode is requested
+	this.singleFrame = opts.SingleFrame
+
+	// In recovery mode, damaged blocks are zero filled (or skipped) instead
+	// of interrupting decoding
+	this.recover = opts.Recover
+	this.err = nil
+
+	// Resource limits (see Limits.go)
+	this.maxBlockSize = opts.MaxBlockSize
+	this.maxMemory = opts.MaxMemory
+	this.maxOutputSize = opts.MaxOutputSize
+
 	this.ctx = ctx
 	this.blockSize = 0
 	this.entropyType = entropy.NONE_TYPE
 	this.transformType = function.NONE_TYPE
-	return this, nil
+	return nil
 }
 
 func (this *CompressedInputStream) AddListener(bl kanzi.Listener) bool {
@@ -741,10 +1604,133 @@ func (this *CompressedInputStream) RemoveListener(bl kanzi.Listener) bool {
 	return false
 }
 
-func (this *CompressedInputStream) readHeader() error {
+// Fields of the header of a frame, after the stream type. Shared by the
+// decoder and ReadStreamInfo.
+type frameHeader struct {
+	version       uint
+	checksum      bool
+	checksumType  uint
+	entropyType   uint32
+	transformType uint64
+	blockSize     uint
+	nbInputBlocks uint8 // 0 means 'unknown' and 63 means 63 or more
+	flags         uint  // HEADER_FLAG_*
+	dictionaryID  uint32
+	cipher        *blockCipher // key derivation parameters and salt, no key
+	parityGroup   int          // number of data blocks per parity group (0 if no parity)
+	parityBlocks  int
+	metadata      *Metadata // nil if no metadata (or encrypted since version 9)
+	headerTag     []byte    // encrypted metadata section (since version 9) and tag authenticating the header
+}
+
+// Read the header of a frame, after the stream type. The fields are checked
+// but the header is not authenticated (encrypted stream) and the dictionary
+// is not checked. On error, the fields read so far are returned with the
+// error. Panics on read errors.
+func parseFrameHeader(ibs kanzi.InputBitStream) (*frameHeader, error) {
+	hdr := &frameHeader{}
+	hdr.version = uint(ibs.ReadBits(5))
+
+	// Sanity check
+	if hdr.version < 7 || hdr.version > BITSTREAM_FORMAT_VERSION {
+		errMsg := fmt.Sprintf("Invalid bitstream, cannot read this version of the stream: %d", hdr.version)
+		return hdr, NewIOError(errMsg, kanzi.ERR_STREAM_VERSION)
+	}
+
+	// Read block checksum
+	hdr.checksum = ibs.ReadBit() == 1
+
+	// Read entropy codec
+	hdr.entropyType = uint32(ibs.ReadBits(5))
+
+	// Read transforms: 8*6 bits
+	hdr.transformType = ibs.ReadBits(48)
+
+	// Read block size
+	hdr.blockSize = uint(ibs.ReadBits(28)) << 4
+
+	if hdr.blockSize < MIN_BITSTREAM_BLOCK_SIZE || hdr.blockSize > MAX_BITSTREAM_BLOCK_SIZE {
+		errMsg := fmt.Sprintf("Invalid bitstream, incorrect block size: %d", hdr.blockSize)
+		return hdr, NewIOError(errMsg, kanzi.ERR_BLOCK_SIZE)
+	}
+
+	// Read number of blocks in input
+	hdr.nbInputBlocks = uint8(ibs.ReadBits(6))
+
+	// Read block checksum type (always XXHash32 before version 8)
+	hdr.checksumType = uint(ibs.ReadBits(3))
+
+	// Read flags (no flags before version 8)
+	if hdr.version >= 8 {
+		hdr.flags = uint(ibs.ReadBits(16))
+	}
+
+	if hdr.flags&HEADER_FLAG_DICTIONARY != 0 {
+		hdr.dictionaryID = uint32(ibs.ReadBits(32))
+	}
+
+	if hdr.flags&HEADER_FLAG_ENCRYPTED != 0 {
+		if hdr.flags&HEADER_FLAG_INDEPENDENT == 0 {
+			return hdr, NewIOError("Invalid bitstream, encrypted blocks must be independent", kanzi.ERR_INVALID_FILE)
+		}
+
+		var err error
+
+		if hdr.cipher, err = readEncryptionHeader(ibs); err != nil {
+			return hdr, err
+		}
+
+		// Tag of the header fields before version 9 (metadata not encrypted)
+		if hdr.version < 9 {
+			hdr.headerTag = readHeaderTag(ibs, 0)
+		}
+	}
+
+	if hdr.flags&HEADER_FLAG_PARITY != 0 {
+		if hdr.flags&HEADER_FLAG_INDEPENDENT == 0 {
+			return hdr, NewIOError("Invalid bitstream, parity blocks require independent blocks", kanzi.ERR_INVALID_FILE)
+		}
+
+		groupSize := int(ibs.ReadBits(8))
+		nbParity := int(ibs.ReadBits(8))
+
+		if groupSize == 0 || groupSize > MAX_PARITY_GROUP_SIZE || nbParity == 0 || nbParity > MAX_PARITY_BLOCKS {
+			errMsg := fmt.Sprintf("Invalid bitstream, incorrect parity parameters: %d data blocks, %d parity blocks", groupSize, nbParity)
+			return hdr, NewIOError(errMsg, kanzi.ERR_INVALID_FILE)
+		}
+
+		hdr.parityGroup = groupSize
+		hdr.parityBlocks = nbParity
+	}
+
+	if hdr.flags&HEADER_FLAG_ENCRYPTED != 0 && hdr.version >= 9 {
+		// Encrypted metadata section (if any) and tag of the header
+		size := uint64(0)
+
+		if hdr.flags&HEADER_FLAG_METADATA != 0 {
+			size = ibs.ReadBits(32)
+		}
+
+		hdr.headerTag = readHeaderTag(ibs, size)
+	} else if hdr.flags&HEADER_FLAG_METADATA != 0 {
+		var err error
+
+		if hdr.metadata, err = readMetadata(ibs); err != nil {
+			return hdr, err
+		}
+	}
+
+	return hdr, nil
+}
+
+func (this *CompressedInputStream) readHeader() (err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			panic(NewIOError("Cannot read bitstream header: "+r.(error).Error(), kanzi.ERR_READ_FILE))
+			err = toIOError(r, kanzi.ERR_READ_FILE)
+		}
+
+		if ioerr, isIOErr := err.(*IOError); isIOErr == true {
+			ioerr.setPosition(0, int64(this.GetRead()))
 		}
 	}()
 
@@ -756,54 +1742,139 @@ func (this *CompressedInputStream) readHeader() error {
 		return NewIOError("Invalid stream type", kanzi.ERR_INVALID_FILE)
 	}
 
-	version := this.ibs.ReadBits(5)
+	return this.readFrameHeader()
+}
+
+// Read the header of a frame, after the stream type. Each frame (stream)
+// in a concatenation of streams has its own header.
+func (this *CompressedInputStream) readFrameHeader() error {
+	// Reset the state of the previous frame (if any)
+	this.hasher = nil
+	this.blockHasher = nil
+	this.contentHasher = nil
+	this.contentHash = 0
+	this.contentSize = 0
+	this.nbBlocks = 0
+	this.headerFlags = 0
+	this.metadata = nil
+	this.version = 0
+	this.cipher = nil
+	this.blockCount = 0
+	this.parity = nil
+	this.dictionaryID = 0
+	delete(this.ctx, "dictionary")
+	hdr, err := parseFrameHeader(this.ibs)
 
-	// Sanity check
-	if version != BITSTREAM_FORMAT_VERSION {
-		errMsg := fmt.Sprintf("Invalid bitstream, cannot read this version of the stream: %d", version)
-		return NewIOError(errMsg, kanzi.ERR_STREAM_VERSION)
+	if err != nil {
+		return err
 	}
 
-	// Read block checksum
-	if this.ibs.ReadBit() == 1 {
-		var err error
-		this.hasher, err = hash.NewXXHash32(BITSTREAM_TYPE)
+	this.entropyType = hdr.entropyType
+	this.ctx["codec"] = entropy.GetName(this.entropyType)
+	this.transformType = hdr.transformType
+	this.ctx["transform"] = function.GetName(this.transformType)
+	this.blockSize = hdr.blockSize
+	this.ctx["blockSize"] = this.blockSize
 
-		if err != nil {
+	if uint64(this.blockSize)*uint64(this.jobs) >= uint64(1<<31) {
+		this.jobs = int(uint(1<<31) / this.blockSize)
+	}
+
+	this.nbInputBlocks = hdr.nbInputBlocks
+	this.headerFlags = hdr.flags
+	this.dictionaryID = hdr.dictionaryID
+	this.version = hdr.version
+
+	// Fail before allocating anything if the stream exceeds the limits
+	if err := this.checkHeaderLimits(); err != nil {
+		return err
+	}
+
+	if hdr.checksum == true {
+		var key []byte
+
+		if k, prst := this.ctx["checksumKey"].([]byte); prst == true {
+			key = k
+		}
+
+		if this.hasher, err = newBlockChecksum(hdr.checksumType, key); err != nil {
 			return err
 		}
 	}
 
-	// Read entropy codec
-	this.entropyType = uint32(this.ibs.ReadBits(5))
-	this.ctx["codec"] = entropy.GetName(this.entropyType)
+	if err := this.checkDictionary(); err != nil {
+		return err
+	}
 
-	// Read transforms: 8*6 bits
-	this.transformType = this.ibs.ReadBits(48)
-	this.ctx["transform"] = function.GetName(this.transformType)
+	if hdr.cipher != nil {
+		metadata, err := hdr.cipher.authenticate(&this.opts, hdr.encryptionAAD(), hdr.headerTag)
 
-	// Read block size
-	this.blockSize = uint(this.ibs.ReadBits(28)) << 4
-	this.ctx["blockSize"] = this.blockSize
+		if err != nil {
+			return err
+		}
+
+		if this.headerFlags&HEADER_FLAG_METADATA != 0 && hdr.version >= 9 {
+			if hdr.metadata, err = unmarshalMetadata(metadata); err != nil {
+				return err
+			}
+		}
 
-	if this.blockSize < MIN_BITSTREAM_BLOCK_SIZE || this.blockSize > MAX_BITSTREAM_BLOCK_SIZE {
-		errMsg := fmt.Sprintf("Invalid bitstream, incorrect block size: %d", this.blockSize)
-		return NewIOError(errMsg, kanzi.ERR_BLOCK_SIZE)
+		this.cipher = hdr.cipher
 	}
 
-	if uint64(this.blockSize)*uint64(this.jobs) >= uint64(1<<31) {
-		this.jobs = int(uint(1<<31) / this.blockSize)
+	if hdr.parityGroup > 0 {
+		if err := this.checkParityLimits(hdr.parityGroup, hdr.parityBlocks); err != nil {
+			return err
+		}
+
+		if this.parity, err = newParityReader(hdr.parityGroup, hdr.parityBlocks); err != nil {
+			return err
+		}
 	}
 
-	// Read number of blocks in input. 0 means 'unknown' and 63 means 63 or more.
-	this.nbInputBlocks = uint8(this.ibs.ReadBits(6))
+	this.metadata = hdr.metadata
 
-	// Read reserved bits
-	this.ibs.ReadBits(3)
+	if this.headerFlags&HEADER_FLAG_CONTENT_HASH != 0 {
+		if this.blockHasher, err = hash.NewXXHash64(BITSTREAM_TYPE); err != nil {
+			return err
+		}
+
+		if this.contentHasher, err = hash.NewXXHash64(BITSTREAM_TYPE); err != nil {
+			return err
+		}
+	}
 
 	if len(this.listeners) > 0 {
 		msg := ""
 		msg += fmt.Sprintf("Checksum set to %v\n", this.hasher != nil)
+
+		if this.hasher != nil {
+			msg += fmt.Sprintf("Checksum type set to %v\n", GetChecksumName(this.hasher.checksumType))
+		}
+
+		msg += fmt.Sprintf("Content checksum set to %v\n", this.contentHasher != nil)
+		msg += fmt.Sprintf("Independent blocks set to %v\n", this.headerFlags&HEADER_FLAG_INDEPENDENT != 0)
+
+		if this.headerFlags&HEADER_FLAG_DICTIONARY != 0 {
+			msg += fmt.Sprintf("Dictionary ID: %08X\n", this.dictionaryID)
+		}
+
+		if this.cipher != nil {
+			if this.cipher.kdf == ENCRYPTION_KDF_PBKDF2 {
+				msg += "Encryption: AES-256-GCM (passphrase)\n"
+			} else {
+				msg += "Encryption: AES-256-GCM (key)\n"
+			}
+		}
+
+		if this.parity != nil {
+			msg += fmt.Sprintf("Parity: %d parity blocks every %d blocks\n", this.parity.nbParity, this.parity.groupSize)
+		}
+
+		if this.metadata != nil {
+			msg += fmt.Sprintf("Metadata entries: %d\n", this.metadata.Len())
+		}
+
 		msg += fmt.Sprintf("Block size set to %d bytes\n", this.blockSize)
 		w1 := entropy.GetName(this.entropyType)
 
@@ -826,33 +1897,75 @@ func (this *CompressedInputStream) readHeader() error {
 	return nil
 }
 
-// Implement kanzi.InputStream interface
-func (this *CompressedInputStream) Close() error {
-	if atomic.SwapInt32(&this.closed, 1) == 1 {
+// Check the dictionary of the decoder against the ID read from the header.
+// The transforms get the dictionary only if the frame requires it.
+func (this *CompressedInputStream) checkDictionary() error {
+	if this.headerFlags&HEADER_FLAG_DICTIONARY == 0 {
 		return nil
 	}
 
-	if _, err := this.ibs.Close(); err != nil {
-		return err
+	if len(this.opts.Dictionary) == 0 {
+		errMsg := fmt.Sprintf("The stream requires a dictionary (ID %08X)", this.dictionaryID)
+		return NewIOError(errMsg, kanzi.ERR_DICTIONARY)
 	}
 
-	// Release resources
-	this.maxIdx = 0
-	this.data = EMPTY_BYTE_SLICE
-
-	for i := range this.buffers {
-		this.buffers[i] = blockBuffer{Buf: EMPTY_BYTE_SLICE}
+	if id := DictionaryID(this.opts.Dictionary); id != this.dictionaryID {
+		errMsg := fmt.Sprintf("Invalid dictionary: the stream requires ID %08X, got %08X", this.dictionaryID, id)
+		return NewIOError(errMsg, kanzi.ERR_DICTIONARY)
 	}
 
-	close(this.resChan)
+	this.ctx["dictionary"] = this.opts.Dictionary
 	return nil
 }
 
-// Implement kanzi.InputStream interface
-func (this *CompressedInputStream) Read(array []byte) (int, error) {
-	if atomic.LoadInt32(&this.closed) == 1 {
-		return 0, NewIOError("Stream closed", kanzi.ERR_READ_FILE)
-	}
+// Return the metadata section of the header (empty if the stream has no
+// metadata). The header is read if necessary. With concatenated streams,
+// the metadata of the current stream is returned.
+func (this *CompressedInputStream) Metadata() (*Metadata, error) {
+	if atomic.SwapInt32(&this.initialized, 1) == 0 {
+		if err := this.readHeader(); err != nil {
+			return nil, err
+		}
+	}
+
+	if this.metadata == nil {
+		return NewMetadata(), nil
+	}
+
+	return this.metadata, nil
+}
+
+// Implement kanzi.InputStream interface
+func (this *CompressedInputStream) Close() error {
+	if atomic.SwapInt32(&this.closed, 1) == 1 {
+		return nil
+	}
+
+	if _, err := this.ibs.Close(); err != nil {
+		return err
+	}
+
+	// Release resources
+	this.maxIdx = 0
+	this.data = EMPTY_BYTE_SLICE
+
+	for i := range this.buffers {
+		this.buffers[i] = blockBuffer{Buf: EMPTY_BYTE_SLICE}
+	}
+
+	close(this.resChan)
+	return nil
+}
+
+// Implement kanzi.InputStream interface
+func (this *CompressedInputStream) Read(array []byte) (int, error) {
+	if atomic.LoadInt32(&this.closed) == 1 {
+		return 0, NewIOError("Stream closed", kanzi.ERR_READ_FILE)
+	}
+
+	if err := checkContext(this.goCtx); err != nil {
+		return 0, err
+	}
 
 	startChunk := 0
 	remaining := len(array)
@@ -869,6 +1982,7 @@ func (this *CompressedInputStream) Read(array []byte) (int, error) {
 			// Process a chunk of in-buffer data. No access to bitstream required
 			copy(array[startChunk:], this.data[this.curIdx:this.curIdx+lenChunk])
 			this.curIdx += lenChunk
+			this.position += int64(lenChunk)
 			startChunk += lenChunk
 			remaining -= lenChunk
 
@@ -879,6 +1993,11 @@ func (this *CompressedInputStream) Read(array []byte) (int, error) {
 
 		// Buffer empty, time to decode
 		if this.curIdx >= this.maxIdx {
+			if this.flushed == true && remaining < len(array) {
+				// Return the data preceding a flush block without blocking
+				break
+			}
+
 			var err error
 
 			if this.maxIdx, err = this.processBlock(); err != nil {
@@ -886,6 +2005,11 @@ func (this *CompressedInputStream) Read(array []byte) (int, error) {
 			}
 
 			if this.maxIdx == 0 {
+				if this.readLastBlock == false {
+					// Empty frame or end of frame, continue with the next frame
+					continue
+				}
+
 				// Reached end of stream
 				if len(array) == remaining {
 					// EOF and we did not read any bytes in this call
@@ -907,12 +2031,25 @@ func (this *CompressedInputStream) processBlock() (int, error) {
 		}
 	}
 
+	if this.err != nil {
+		return 0, this.err
+	}
+
 	if this.readLastBlock == true {
 		return 0, nil
 	}
 
+	if err := checkContext(this.goCtx); err != nil {
+		return 0, err
+	}
+
+	this.flushed = false
 	blkSize := int(this.blockSize)
 
+	if this.startTime.IsZero() {
+		this.startTime = time.Now()
+	}
+
 	// Add a padding area to manage any block with header (of size <= EXTRA_BUFFER_SIZE)
 	blkSize += EXTRA_BUFFER_SIZE
 
@@ -935,6 +2072,8 @@ func (this *CompressedInputStream) processBlock() (int, error) {
 			}
 		}
 
+		// Limit the number of concurrent tasks to fit the memory limit
+		nbJobs = this.maxTasks(nbJobs)
 		jobsPerTask = kanzi.ComputeJobsPerTask(make([]uint, nbJobs), uint(this.jobs), nbJobs)
 	} else {
 		jobsPerTask = make([]uint, nbJobs)
@@ -980,25 +2119,39 @@ func (this *CompressedInputStream) processBlock() (int, error) {
 			iBuffer:            &this.buffers[2*jobId],
 			oBuffer:            &this.buffers[2*jobId+1],
 			hasher:             this.hasher,
+			blockHasher:        this.blockHasher,
 			blockLength:        uint(blkSize),
 			blockTransformType: this.transformType,
 			blockEntropyType:   this.entropyType,
 			currentBlockId:     this.blockId + jobId + 1,
+			independent:        this.headerFlags&HEADER_FLAG_INDEPENDENT != 0,
+			cipher:             this.cipher,
+			blockCount:         &this.blockCount,
+			parity:             this.parity,
+			recover:            this.recover,
 			input:              syncChan[jobId],
 			output:             syncChan[(jobId+1)%int(nbJobs)],
 			result:             this.resChan,
 			listeners:          listeners,
-			ibs:                this.ibs,
+			ibs:                newContextInputBitStream(this.goCtx, this.ibs),
+			transforms:         this.transforms[jobId],
+			predictors:         this.predictors[jobId],
+			goCtx:              this.goCtx,
 			ctx:                copyCtx}
 
-		// Invoke the tasks concurrently
-		// Tasks are daisy chained through channels. All tasks wait for a signal
-		// on the input channel to start entropy decoding and then issue a message
-		// to the next task on the output channel upon entropy decoding completion.
-		// The transform step runs concurrently. The result is returned on the shared
-		// channel. The output channel is nil for the last task and the input channel
-		// is nil for the first task.
-		go task.decode()
+		if nbJobs == 1 {
+			// No concurrency: avoid the cost of a go routine
+			task.decode()
+		} else {
+			// Invoke the tasks concurrently
+			// Tasks are daisy chained through channels. All tasks wait for a signal
+			// on the input channel to start entropy decoding and then issue a message
+			// to the next task on the output channel upon entropy decoding completion.
+			// The transform step runs concurrently. The result is returned on the shared
+			// channel. The output channel is nil for the last task and the input channel
+			// is nil for the first task.
+			go task.decode()
+		}
 	}
 
 	var err error
@@ -1012,24 +2165,84 @@ func (this *CompressedInputStream) processBlock() (int, error) {
 		res := <-this.resChan
 
 		// Order the results based on block ID
+		if res.err != nil {
+			res.err.setPosition(res.blockId, int64((this.ibsOffset+res.bitOffset)>>3))
+		}
+
 		results[res.blockId-this.blockId-1] = res
 		decoded += res.decoded
 
-		if res.err != nil {
-			return decoded, res.err
+		// Keep the first error but collect all the results to let the
+		// tasks terminate
+		if res.err != nil && res.damaged == false && res.resync == false && err == nil {
+			err = res.err
 		}
 	}
 
+	if err != nil {
+		return decoded, err
+	}
+
 	if decoded > int(nbJobs)*int(this.blockSize) {
 		return decoded, NewIOError("Invalid data", kanzi.ERR_PROCESS_BLOCK)
 	}
 
+	// Position of the first block of the batch in the decompressed data
+	start := this.position - int64(this.skipIdx)
+
+	if this.recover == true {
+		decoded = this.sizeDamagedBlocks(results, start)
+	}
+
+	if this.maxOutputSize > 0 && this.outputSize+uint64(decoded) > this.maxOutputSize {
+		errMsg := fmt.Sprintf("The decompressed size exceeds the limit (%d)", this.maxOutputSize)
+		return 0, NewIOError(errMsg, kanzi.ERR_RESOURCE_LIMIT)
+	}
+
+	this.outputSize += uint64(decoded)
+
 	if len(this.data) < decoded {
 		this.data = make([]byte, decoded)
 	}
 
 	// Process results
 	for _, res := range results {
+		if res.damaged == true || res.resync == true {
+			// Zero fill the damaged block (if the size is known)
+			for i := offset; i < offset+res.decoded; i++ {
+				this.data[i] = 0
+			}
+
+			if len(listeners) > 0 {
+				inOffset, inSize := this.skippedRange(&res)
+				evt := kanzi.NewDamageEvent(res.blockId, start+int64(offset), int64(res.decoded),
+					inOffset, inSize, res.completionTime)
+				notifyListeners(listeners, evt)
+			}
+
+			offset += res.decoded
+
+			// The content checksum cannot be verified anymore
+			this.blockHasher = nil
+
+			if res.resync == true {
+				// Continue with the next block after the damaged one
+				if err = this.resync(res); err != nil {
+					// Return the blocks decoded before the damaged one first
+					decoded = offset
+
+					if offset > 0 {
+						this.err = err
+						err = nil
+					}
+				}
+
+				break
+			}
+
+			continue
+		}
+
 		copy(this.data[offset:], res.data[0:res.decoded])
 		offset += res.decoded
 
@@ -1040,20 +2253,636 @@ func (this *CompressedInputStream) processBlock() (int, error) {
 			notifyListeners(listeners, evt)
 		}
 
+		if res.flushed == true {
+			// Return the blocks preceding the flush block without waiting
+			this.flushed = true
+			break
+		}
+
 		if res.decoded == 0 {
 			this.readLastBlock = true
+
+			if this.contentHasher != nil {
+				err = this.verifyContentHash()
+			}
+
+			if err == nil {
+				// Keep decoding if another stream follows
+				var found bool
+
+				if found, err = this.nextFrame(); found == true {
+					this.readLastBlock = false
+				} else if err != nil && offset > 0 {
+					// Return the blocks decoded before the end of the frame first
+					this.err = err
+					err = nil
+				}
+			}
+
 			break
 		}
+
+		if this.contentHasher != nil {
+			this.contentHash = chainContentHash(this.contentHasher, this.contentHash, res.blockHash)
+			this.contentSize += uint64(res.decoded)
+			this.nbBlocks++
+		}
+	}
+
+	if err == nil && len(listeners) > 0 {
+		notifyProgress(listeners, this.blockId+int(nbJobs), this.GetRead(), this.outputSize,
+			this.inputSize, this.startTime)
 	}
 
 	this.blockId += this.jobs
 	this.curIdx = 0
+
+	// Skip the beginning of the first block after a call to Seek()
+	if this.skipIdx > 0 {
+		if this.skipIdx < decoded {
+			this.curIdx = this.skipIdx
+		} else {
+			this.curIdx = decoded
+		}
+
+		this.skipIdx = 0
+	}
+
 	return decoded, err
 }
 
+// Skip the trailers of the current frame and look for the header of another
+// frame (concatenated streams). Return true if a new frame header was read.
+// Trailing data that is not a stream is ignored, but any data following the
+// frame is an error in single frame mode.
+func (this *CompressedInputStream) nextFrame() (found bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			found = false
+			err = toIOError(r, kanzi.ERR_READ_FILE)
+		}
+	}()
+
+	if this.headerFlags&HEADER_FLAG_BLOCK_INDEX != 0 {
+		skipBlockIndex(this.ibs, this.version, this.trailerCipher() != nil)
+	}
+
+	// Frames are byte aligned
+	if r := uint(this.ibs.Read() & 7); r != 0 {
+		this.ibs.ReadBits(8 - r)
+	}
+
+	if more, _ := this.ibs.HasMoreToRead(); more == false {
+		return false, nil
+	}
+
+	if this.singleFrame == true {
+		return false, NewIOError("Unexpected data after the end of the stream (single frame mode)", kanzi.ERR_INVALID_FILE)
+	}
+
+	if this.ibs.ReadBits(32) != BITSTREAM_TYPE {
+		return false, nil
+	}
+
+	if err = this.readFrameHeader(); err != nil {
+		return false, err
+	}
+
+	this.frames++
+	return true, nil
+}
+
+// Recovery mode: compute the number of bytes to zero fill for each damaged
+// block of the batch and return the total number of decoded bytes.
+// The size of a damaged block comes from the block index if available or
+// from the decoded block (checksum failure). A block that could not be entropy
+// decoded is zero filled up to the position of the block where decoding
+// resumes (see resync). Otherwise the block is skipped.
+func (this *CompressedInputStream) sizeDamagedBlocks(results []Message, start int64) int {
+	decoded := 0
+
+	for i := range results {
+		res := &results[i]
+
+		if res.damaged == true && this.headerFlags&HEADER_FLAG_INDEPENDENT == 0 && this.resyncEntry(res) >= 0 {
+			// The damaged block may have consumed the wrong number of bits:
+			// decode the next blocks again from the block index
+			res.damaged = false
+			res.resync = true
+		}
+
+		if res.resync == true {
+			res.decoded = 0
+
+			if n := this.resyncEntry(res); n >= 0 {
+				pos := start + int64(decoded)
+				end := this.index[len(this.index)-1].position + int64(this.index[len(this.index)-1].size)
+
+				if n < len(this.index) {
+					end = this.index[n].position
+				}
+
+				// The skipped blocks cannot be bigger than the block size
+				blocks := n - findIndexEntry(this.index, pos)
+
+				if end > pos && end-pos <= int64(blocks)*int64(this.blockSize) {
+					res.decoded = int(end - pos)
+				}
+			}
+		} else if res.damaged == true {
+			if n := this.lookupIndexEntry(res.bitOffset, res.groupIdx); n >= 0 {
+				res.decoded = int(this.index[n].size)
+			}
+
+			if res.decoded > int(this.blockSize) {
+				res.decoded = 0
+			}
+		}
+
+		decoded += res.decoded
+
+		if res.resync == true || (res.decoded == 0 && res.damaged == false) {
+			// Following blocks have been cancelled or end/flush block
+			break
+		}
+	}
+
+	return decoded
+}
+
+// Return the index of the block index entry of the block starting at the
+// provided position in the current bitstream (or -1 if not available).
+// With parity blocks, the position is the position of the group and groupIdx
+// the position of the block in the group.
+func (this *CompressedInputStream) lookupIndexEntry(bitOffset uint64, groupIdx int) int {
+	// The block index only applies to the first stream
+	if this.frames > 0 || this.headerFlags&HEADER_FLAG_BLOCK_INDEX == 0 {
+		return -1
+	}
+
+	if _, err := this.loadIndex(); err != nil {
+		return -1
+	}
+
+	offset := this.ibsOffset + bitOffset
+	n := sort.Search(len(this.index), func(i int) bool { return this.index[i].offset >= offset })
+	n += groupIdx
+
+	if n >= len(this.index) || this.index[n].offset != offset {
+		return -1
+	}
+
+	return n
+}
+
+// Recovery mode: return the index of the block index entry where decoding
+// resumes after a block that could not be entropy decoded (len(this.index)
+// if the damaged block is the last one or -1 if there is no block index).
+// If the header of the block was not read at a block boundary (a previous
+// damaged block consumed the wrong number of bits), decoding resumes with
+// the block following the one containing the position of the header.
+func (this *CompressedInputStream) resyncEntry(res *Message) int {
+	if n := this.lookupIndexEntry(res.bitOffset, res.groupIdx); n >= 0 {
+		return n + 1
+	}
+
+	// lookupIndexEntry loads the block index if available
+	if this.frames > 0 || len(this.index) == 0 {
+		return -1
+	}
+
+	offset := this.ibsOffset + res.bitOffset
+	n := sort.Search(len(this.index), func(i int) bool { return this.index[i].offset > offset })
+
+	if n == 0 {
+		return -1
+	}
+
+	return n
+}
+
+// Recovery mode: return the position and size of the range of compressed
+// bytes skipped because of a damaged block (the size is 0 if unknown)
+func (this *CompressedInputStream) skippedRange(res *Message) (int64, int64) {
+	start := (this.ibsOffset + res.bitOffset) >> 3
+	end := start
+
+	if res.damaged == true {
+		end = (this.ibsOffset + res.endOffset) >> 3
+	} else if n := this.resyncEntry(res); n >= 0 && n < len(this.index) {
+		end = this.index[n].offset >> 3
+	}
+
+	if end < start {
+		end = start
+	}
+
+	return int64(start), int64(end - start)
+}
+
+// Recovery mode: position the bitstream at the block following a block that
+// could not be entropy decoded. Requires a block index.
+func (this *CompressedInputStream) resync(res Message) error {
+	n := this.resyncEntry(&res)
+
+	if n < 0 {
+		errMsg := fmt.Sprintf("%v (cannot resynchronize on the next block without block index)", res.err.Message())
+		err := NewIOError(errMsg, res.err.ErrorCode())
+		err.cause = res.err.cause
+		return err.setPosition(res.err.blockId, res.err.offset)
+	}
+
+	if n == len(this.index) {
+		// The last block is damaged: the position of the end block is unknown
+		this.readLastBlock = true
+		return nil
+	}
+
+	rs, err := this.loadIndex()
+
+	if err != nil {
+		return err
+	}
+
+	if this.ibs, err = this.newBlockBitStream(rs, nil, &this.index[n]); err != nil {
+		return err
+	}
+
+	this.ibsOffset = (this.index[n].offset >> 3) << 3
+	this.blockCount = uint64(n)
+
+	// The group is read again (the header may be damaged), from the next block
+	if this.parity != nil {
+		this.parity.reset(n - parityGroupStart(this.index, n))
+	}
+
+	return nil
+}
+
+// Return the cipher of the content checksum trailer and of the block index
+// entries, nil if they are not encrypted (before version 9)
+func (this *CompressedInputStream) trailerCipher() *blockCipher {
+	if this.version < 9 {
+		return nil
+	}
+
+	return this.cipher
+}
+
+// Read the content hash, size and number of blocks following the end block
+// and compare them to the values computed during decoding
+func (this *CompressedInputStream) verifyContentHash() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = toIOError(r, kanzi.ERR_READ_FILE)
+		}
+	}()
+
+	var contentHash, contentSize uint64
+	var nbBlocks uint32
+
+	if cipher := this.trailerCipher(); cipher != nil {
+		trailer := make([]byte, CONTENT_TRAILER_SIZE+ENCRYPTION_TAG_SIZE)
+		this.ibs.ReadArray(trailer, uint(8*len(trailer)))
+
+		if trailer, err = cipher.openTrailer(nonceTrailer, 0, trailer); err != nil {
+			return err
+		}
+
+		contentHash = binary.BigEndian.Uint64(trailer[0:])
+		contentSize = binary.BigEndian.Uint64(trailer[8:])
+		nbBlocks = binary.BigEndian.Uint32(trailer[16:])
+	} else {
+		contentHash = this.ibs.ReadBits(64)
+		contentSize = this.ibs.ReadBits(64)
+		nbBlocks = uint32(this.ibs.ReadBits(32))
+	}
+
+	// No verification possible if some blocks have been skipped (Seek)
+	if this.blockHasher == nil {
+		return nil
+	}
+
+	if nbBlocks != this.nbBlocks || contentSize != this.contentSize {
+		errMsg := fmt.Sprintf("Corrupted bitstream: expected %d bytes in %d blocks, found %d bytes in %d blocks",
+			contentSize, nbBlocks, this.contentSize, this.nbBlocks)
+		return NewIOError(errMsg, kanzi.ERR_CRC_CHECK)
+	}
+
+	if contentHash != this.contentHash {
+		errMsg := fmt.Sprintf("Corrupted bitstream: expected content checksum %x, found %x", contentHash, this.contentHash)
+		return NewIOError(errMsg, kanzi.ERR_CRC_CHECK)
+	}
+
+	return nil
+}
+
 // Return the number of bytes read so far
 func (this *CompressedInputStream) GetRead() uint64 {
-	return (this.ibs.Read() + 7) >> 3
+	return (this.ibsOffset + this.ibs.Read() + 7) >> 3
+}
+
+// Load the block index from the end of the stream (once).
+// The underlying reader must implement io.Seeker.
+func (this *CompressedInputStream) loadIndex() (io.ReadSeeker, error) {
+	rs, isSeeker := this.is.(io.ReadSeeker)
+
+	if isSeeker == false {
+		return nil, NewIOError("The underlying reader does not support seeking", kanzi.ERR_READ_FILE)
+	}
+
+	this.indexLock.Lock()
+	defer this.indexLock.Unlock()
+
+	if atomic.SwapInt32(&this.initialized, 1) == 0 {
+		if err := this.readHeader(); err != nil {
+			return nil, err
+		}
+	}
+
+	if this.index != nil {
+		return rs, nil
+	}
+
+	if this.headerFlags&HEADER_FLAG_BLOCK_INDEX == 0 {
+		return nil, NewIOError("The stream has no block index", kanzi.ERR_INVALID_PARAM)
+	}
+
+	// Restore the current position after loading the index
+	current, err := rs.Seek(0, io.SeekCurrent)
+
+	if err != nil {
+		return nil, WrapIOError(err, "", kanzi.ERR_READ_FILE)
+	}
+
+	index, err := readBlockIndex(rs, this.origin, this.version, this.trailerCipher())
+
+	if _, err2 := rs.Seek(current, io.SeekStart); err == nil && err2 != nil {
+		err = WrapIOError(err2, "", kanzi.ERR_READ_FILE)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// No index at the end of the reader or index of another stream
+	// (concatenated streams)
+	if index == nil {
+		return nil, NewIOError("The stream has no block index", kanzi.ERR_INVALID_PARAM)
+	}
+
+	this.index = index
+	return rs, nil
+}
+
+// Section of the input read by ReadAt. The bitstream fails if a read returns
+// data along with io.EOF (as io.SectionReader does at the end of the input),
+// so the end of the input is reported by the next read instead.
+type sectionReader struct {
+	*io.SectionReader
+}
+
+func (this *sectionReader) Read(buf []byte) (int, error) {
+	n, err := this.SectionReader.Read(buf)
+
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+
+	return n, err
+}
+
+func (this *sectionReader) Close() error {
+	return nil
+}
+
+// Create a bitstream positioned at the header of the provided block. If ra is
+// not nil, the block is read through a section reader and the position of the
+// underlying reader is not modified. Otherwise, the underlying reader is moved
+// to the block.
+func (this *CompressedInputStream) newBlockBitStream(rs io.ReadSeeker, ra io.ReaderAt, entry *blockIndexEntry) (ibs kanzi.InputBitStream, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = toIOError(r, kanzi.ERR_READ_FILE)
+		}
+	}()
+
+	start := this.origin + int64(entry.offset>>3)
+	src := this.is
+
+	if ra != nil {
+		src = &sectionReader{SectionReader: io.NewSectionReader(ra, start, math.MaxInt64-start)}
+	} else if _, err = rs.Seek(start, io.SeekStart); err != nil {
+		return nil, WrapIOError(err, "", kanzi.ERR_READ_FILE)
+	}
+
+	if ibs, err = bitstream.NewDefaultInputBitStream(src, STREAM_DEFAULT_BUFFER_SIZE); err != nil {
+		return nil, WrapIOError(err, "", kanzi.ERR_CREATE_BITSTREAM)
+	}
+
+	// Skip bits before the block header
+	if entry.offset&7 != 0 {
+		ibs.ReadBits(uint(entry.offset & 7))
+	}
+
+	return ibs, nil
+}
+
+// Implement io.Seeker interface. The stream must have been written with a block
+// index and the underlying reader must implement io.Seeker.
+// The offset is a position in the decompressed data.
+func (this *CompressedInputStream) Seek(offset int64, whence int) (int64, error) {
+	if atomic.LoadInt32(&this.closed) == 1 {
+		return 0, NewIOError("Stream closed", kanzi.ERR_READ_FILE)
+	}
+
+	rs, err := this.loadIndex()
+
+	if err != nil {
+		return 0, err
+	}
+
+	total := int64(0)
+
+	if len(this.index) > 0 {
+		last := this.index[len(this.index)-1]
+		total = last.position + int64(last.size)
+	}
+
+	switch whence {
+	case io.SeekStart:
+		// Nothing to do
+
+	case io.SeekCurrent:
+		offset += this.position
+
+	case io.SeekEnd:
+		offset += total
+
+	default:
+		return 0, NewIOError(fmt.Sprintf("Invalid whence parameter: %d", whence), kanzi.ERR_INVALID_PARAM)
+	}
+
+	if offset < 0 {
+		return 0, NewIOError("Invalid negative position", kanzi.ERR_INVALID_PARAM)
+	}
+
+	this.curIdx = 0
+	this.maxIdx = 0
+	this.skipIdx = 0
+	this.err = nil
+	this.position = offset
+
+	// The content checksum cannot be verified once blocks have been skipped
+	this.blockHasher = nil
+
+	if offset >= total {
+		// Past the last block: subsequent reads return no data
+		this.readLastBlock = true
+		return offset, nil
+	}
+
+	n := findIndexEntry(this.index, offset)
+
+	if this.ibs, err = this.newBlockBitStream(rs, nil, &this.index[n]); err != nil {
+		return 0, err
+	}
+
+	this.ibsOffset = (this.index[n].offset >> 3) << 3
+
+	this.blockId = n
+	this.blockCount = uint64(n)
+	this.skipIdx = int(offset - this.index[n].position)
+
+	if this.parity != nil {
+		this.parity.reset(n - parityGroupStart(this.index, n))
+	}
+
+	this.readLastBlock = false
+	return offset, nil
+}
+
+// Implement io.ReaderAt interface. The stream must have been written with a block
+// index and the underlying reader must implement io.Seeker. Only the blocks that
+// overlap the requested range are decoded. The position of the stream used by
+// Read() is not modified. Concurrent calls decode their blocks in parallel if
+// the underlying reader implements io.ReaderAt, otherwise they are serialized.
+func (this *CompressedInputStream) ReadAt(array []byte, offset int64) (int, error) {
+	if atomic.LoadInt32(&this.closed) == 1 {
+		return 0, NewIOError("Stream closed", kanzi.ERR_READ_FILE)
+	}
+
+	if offset < 0 {
+		return 0, NewIOError("Invalid negative position", kanzi.ERR_INVALID_PARAM)
+	}
+
+	rs, err := this.loadIndex()
+
+	if err != nil {
+		return 0, err
+	}
+
+	ra, isReaderAt := this.is.(io.ReaderAt)
+
+	if isReaderAt == false {
+		this.readAtLock.Lock()
+		defer this.readAtLock.Unlock()
+
+		// Restore the position of the underlying reader upon exit since the
+		// current bitstream may be reading from it
+		current, err := rs.Seek(0, io.SeekCurrent)
+
+		if err != nil {
+			return 0, WrapIOError(err, "", kanzi.ERR_READ_FILE)
+		}
+
+		defer rs.Seek(current, io.SeekStart)
+	}
+
+	blkSize := int(this.blockSize) + EXTRA_BUFFER_SIZE
+	buffers := []blockBuffer{{Buf: make([]byte, blkSize)}, {Buf: EMPTY_BYTE_SLICE}}
+	result := make(chan Message, 1)
+	read := 0
+	var parity *parityReader
+	var ibs kanzi.InputBitStream
+	var ibsOffset uint64
+
+	if this.parity != nil {
+		if parity, err = newParityReader(this.parity.groupSize, this.parity.nbParity); err != nil {
+			return 0, err
+		}
+	}
+
+	for n := findIndexEntry(this.index, offset); n < len(this.index) && read < len(array); n++ {
+		entry := &this.index[n]
+
+		// The blocks of a parity group are read at once with the first one
+		if ibs == nil || parity == nil || this.index[n-1].offset != entry.offset {
+			if ibs, err = this.newBlockBitStream(rs, ra, entry); err != nil {
+				return read, err
+			}
+
+			ibsOffset = (entry.offset >> 3) << 3
+
+			if parity != nil {
+				parity.reset(n - parityGroupStart(this.index, n))
+			}
+		}
+
+		copyCtx := make(map[string]interface{})
+
+		for k, v := range this.ctx {
+			copyCtx[k] = v
+		}
+
+		copyCtx["jobs"] = uint(this.jobs)
+		blockCount := uint64(n)
+
+		task := DecodingTask{
+			iBuffer:            &buffers[0],
+			oBuffer:            &buffers[1],
+			hasher:             this.hasher,
+			blockLength:        uint(blkSize),
+			blockTransformType: this.transformType,
+			blockEntropyType:   this.entropyType,
+			currentBlockId:     n + 1,
+			independent:        this.headerFlags&HEADER_FLAG_INDEPENDENT != 0,
+			cipher:             this.cipher,
+			blockCount:         &blockCount,
+			parity:             parity,
+			result:             result,
+			listeners:          this.listeners,
+			ibs:                ibs,
+			ctx:                copyCtx}
+
+		task.decode()
+		res := <-result
+
+		if res.err != nil {
+			return read, res.err.setPosition(res.blockId, int64((ibsOffset+res.bitOffset)>>3))
+		}
+
+		if res.decoded != int(entry.size) {
+			errMsg := fmt.Sprintf("Invalid block size: expected %d, got %d", entry.size, res.decoded)
+			return read, NewIOError(errMsg, kanzi.ERR_PROCESS_BLOCK)
+		}
+
+		start := int(offset + int64(read) - entry.position)
+		read += copy(array[read:], res.data[start:res.decoded])
+	}
+
+	if read < len(array) {
+		return read, io.EOF
+	}
+
+	return read, nil
+}
+
+// Skip the bits up to the next byte boundary (after a flush block)
+func skipPadding(ibs kanzi.InputBitStream) {
+	if r := uint(ibs.Read() & 7); r != 0 {
+		ibs.ReadBits(8 - r)
+	}
 }
 
 // Used by block decoding tasks to synchronize and return result
@@ -1093,17 +2922,144 @@ func (this *DecodingTask) decode() {
 		}
 	}
 
+	// Set once the task processing the next block has been released
+	released := false
+
+	// Report an error and cancel the pending tasks (if not released yet)
+	fail := func(err *IOError) {
+		res.err = err
+
+		// A cancellation is not a damaged block
+		rec := this.recover && err.code != kanzi.ERR_CANCELLED
+
+		if released == true {
+			res.damaged = rec
+			notify(nil, this.result, false, res)
+		} else {
+			res.resync = rec
+			notify(this.output, this.result, false, res)
+		}
+	}
+
 	defer func() {
 		if r := recover(); r != nil {
-			// Error => cancel concurrent decoding tasks
-			res.err = NewIOError(r.(error).Error(), kanzi.ERR_READ_FILE)
-			notify(this.output, this.result, false, res)
+			fail(toIOError(r, kanzi.ERR_READ_FILE))
 		}
 	}()
 
+	ibs := this.ibs
+	read := ibs.Read()
+	res.bitOffset = read
+
+	if this.independent == true {
+		var size uint
+		var pb *parityBlock
+
+		if this.parity != nil {
+			// Read the next block of the parity group (the whole group is
+			// read and repaired with the first block)
+			res.groupIdx = this.parity.skip
+			var err *IOError
+
+			if size, pb, err = this.parity.next(ibs, 2*this.blockLength); err != nil {
+				fail(err)
+				return
+			}
+
+			if pb != nil {
+				res.bitOffset = pb.groupOffset
+				res.groupIdx = pb.groupIdx
+			}
+		} else {
+			// Read the size of the encoded block
+			size = uint(ibs.ReadBits(32))
+		}
+
+		if size == 0 || size == FLUSH_BLOCK_SIZE {
+			// Last block is empty or flush block, return success and cancel
+			// pending tasks
+			if size == FLUSH_BLOCK_SIZE {
+				skipPadding(ibs)
+				res.flushed = true
+			} else if this.cipher != nil {
+				// Check the number of blocks of the frame (truncated stream)
+				tag := make([]byte, ENCRYPTION_TAG_SIZE)
+				ibs.ReadArray(tag, 8*ENCRYPTION_TAG_SIZE)
+
+				if this.cipher.checkEndTag(tag, *this.blockCount) == false {
+					fail(NewIOError("Authentication failed: truncated or corrupted stream", kanzi.ERR_AUTHENTICATION))
+					return
+				}
+			}
+
+			res.decoded = 0
+			notify(this.output, this.result, false, res)
+			return
+		}
+
+		// An encoded block cannot be much bigger than the block size
+		if size > 2*this.blockLength {
+			errMsg := fmt.Sprintf("Invalid encoded block size: %d", size)
+			fail(NewIOError(errMsg, kanzi.ERR_BLOCK_SIZE))
+			return
+		}
+
+		var block []byte
+
+		if pb != nil {
+			block = pb.data
+		} else {
+			block = make([]byte, size)
+			ibs.ReadArray(block, 8*size)
+		}
+
+		blockNumber := uint64(0)
+
+		if this.cipher != nil {
+			*this.blockCount++
+			blockNumber = *this.blockCount
+		}
+
+		// The rest of the block is decoded from its own bitstream, concurrently
+		// with the other tasks
+		res.endOffset = ibs.Read()
+		notify(this.output, nil, true, res)
+		released = true
+		var err error
+
+		if pb != nil {
+			if pb.err != nil {
+				fail(pb.err)
+				return
+			}
+
+			if pb.repaired == true && len(this.listeners) > 0 {
+				evt := kanzi.NewEvent(kanzi.EVT_BLOCK_REPAIRED, this.currentBlockId,
+					int64(len(block)), 0, false, time.Now())
+				notifyListeners(this.listeners, evt)
+			}
+		}
+
+		// Decrypt (and authenticate) before entropy decoding
+		if this.cipher != nil {
+			if block, err = this.cipher.open(block, blockNumber); err != nil {
+				fail(toIOError(err, kanzi.ERR_AUTHENTICATION))
+				return
+			}
+		}
+
+		if ibs, err = bitstream.NewDefaultInputBitStream(util.NewBufferStream(block), STREAM_DEFAULT_BUFFER_SIZE); err != nil {
+			fail(WrapIOError(err, "", kanzi.ERR_CREATE_BITSTREAM))
+			return
+		}
+
+		ibs = newContextInputBitStream(this.goCtx, ibs)
+
+		read = 0
+	}
+
 	// Extract block header directly from bitstream
-	read := this.ibs.Read()
-	mode := byte(this.ibs.ReadBits(8))
+	mode := byte(ibs.ReadBits(8))
 	skipFlags := byte(0)
 
 	if mode&COPY_BLOCK_MASK != 0 {
@@ -1111,7 +3067,7 @@ func (this *DecodingTask) decode() {
 		this.blockEntropyType = entropy.NONE_TYPE
 	} else {
 		if mode&TRANSFORMS_MASK != 0 {
-			skipFlags = byte(this.ibs.ReadBits(8))
+			skipFlags = byte(ibs.ReadBits(8))
 		} else {
 			skipFlags = (mode << 4) | 0x0F
 		}
@@ -1120,28 +3076,34 @@ func (this *DecodingTask) decode() {
 	dataSize := 1 + uint((mode>>5)&0x03)
 	length := dataSize << 3
 	mask := uint64(1<<length) - 1
-	preTransformLength := uint(this.ibs.ReadBits(length) & mask)
+	preTransformLength := uint(ibs.ReadBits(length) & mask)
+
+	if preTransformLength == 0 && this.independent == false {
+		// Last block is empty or flush block (length written on more than
+		// one byte), return success and cancel pending tasks
+		if dataSize > 1 {
+			skipPadding(ibs)
+			res.flushed = true
+		}
 
-	if preTransformLength == 0 {
-		// Last block is empty, return success and cancel pending tasks
 		res.decoded = 0
 		notify(this.output, this.result, false, res)
 		return
 	}
 
-	if preTransformLength > MAX_BITSTREAM_BLOCK_SIZE {
+	// The transforms cannot expand a block much (see MaxEncodedLen())
+	if preTransformLength == 0 || preTransformLength > 2*this.blockLength {
 		// Error => cancel concurrent decoding tasks
 		errMsg := fmt.Sprintf("Invalid compressed block length: %d", preTransformLength)
-		res.err = NewIOError(errMsg, kanzi.ERR_BLOCK_SIZE)
-		notify(this.output, this.result, false, res)
+		fail(NewIOError(errMsg, kanzi.ERR_BLOCK_SIZE))
 		return
 	}
 
-	checksum1 := uint32(0)
+	checksum1 := uint64(0)
 
 	// Extract checksum from bit stream (if any)
 	if this.hasher != nil {
-		checksum1 = uint32(this.ibs.ReadBits(32))
+		checksum1 = ibs.ReadBits(this.hasher.Size())
 	}
 
 	if len(this.listeners) > 0 {
@@ -1167,12 +3129,11 @@ func (this *DecodingTask) decode() {
 
 	// Each block is decoded separately
 	// Rebuild the entropy decoder to reset block statistics
-	ed, err := entropy.NewEntropyDecoder(this.ibs, this.ctx, this.blockEntropyType)
+	ed, err := this.predictors.NewEntropyDecoder(ibs, this.ctx, this.blockEntropyType)
 
 	if err != nil {
 		// Error => cancel concurrent decoding tasks
-		res.err = NewIOError(err.Error(), kanzi.ERR_INVALID_CODEC)
-		notify(this.output, this.result, false, res)
+		fail(WrapIOError(err, "", kanzi.ERR_INVALID_CODEC))
 		return
 	}
 
@@ -1181,21 +3142,24 @@ func (this *DecodingTask) decode() {
 	// Block entropy decode
 	if _, err = ed.Decode(buffer[0:preTransformLength]); err != nil {
 		// Error => cancel concurrent decoding tasks
-		res.err = NewIOError(err.Error(), kanzi.ERR_PROCESS_BLOCK)
-		notify(this.output, this.result, false, res)
+		fail(WrapIOError(err, "", kanzi.ERR_PROCESS_BLOCK))
 		return
 	}
 
 	if len(this.listeners) > 0 {
 		// Notify after entropy
 		evt := kanzi.NewEvent(kanzi.EVT_AFTER_ENTROPY, this.currentBlockId,
-			int64(this.ibs.Read()-read)/8, checksum1, this.hasher != nil, time.Now())
+			int64(ibs.Read()-read)/8, checksum1, this.hasher != nil, time.Now())
 		notifyListeners(this.listeners, evt)
 	}
 
-	// After completion of the entropy decoding, unfreeze the task processing
-	// the next block (if any)
-	notify(this.output, nil, true, res)
+	if released == false {
+		// After completion of the entropy decoding, unfreeze the task processing
+		// the next block (if any)
+		res.endOffset = ibs.Read()
+		notify(this.output, nil, true, res)
+		released = true
+	}
 
 	if len(this.listeners) > 0 {
 		// Notify before transform
@@ -1204,13 +3168,17 @@ func (this *DecodingTask) decode() {
 		notifyListeners(this.listeners, evt)
 	}
 
+	if ioerr := checkContext(this.goCtx); ioerr != nil {
+		fail(ioerr)
+		return
+	}
+
 	this.ctx["size"] = preTransformLength
-	transform, err := function.NewByteFunction(&this.ctx, this.blockTransformType)
+	transform, err := this.transforms.NewByteFunction(&this.ctx, this.blockTransformType)
 
 	if err != nil {
 		// Error => return
-		res.err = NewIOError(err.Error(), kanzi.ERR_INVALID_CODEC)
-		notify(nil, this.result, false, res)
+		fail(WrapIOError(err, "", kanzi.ERR_INVALID_CODEC))
 		return
 	}
 
@@ -1220,21 +3188,24 @@ func (this *DecodingTask) decode() {
 	// Inverse transform
 	if _, oIdx, err = transform.Inverse(buffer[0:preTransformLength], data); err != nil {
 		// Error => return
-		res.err = NewIOError(err.Error(), kanzi.ERR_PROCESS_BLOCK)
-		notify(nil, this.result, false, res)
+		fail(WrapIOError(err, "", kanzi.ERR_PROCESS_BLOCK))
 		return
 	}
 
 	res.decoded = int(oIdx)
 
+	// Compute block hash used for the content checksum
+	if this.blockHasher != nil {
+		res.blockHash = this.blockHasher.Hash(data[0:res.decoded])
+	}
+
 	// Verify checksum
 	if this.hasher != nil {
 		checksum2 := this.hasher.Hash(data[0:res.decoded])
 
 		if checksum2 != checksum1 {
 			errMsg := fmt.Sprintf("Corrupted bitstream: expected checksum %x, found %x", checksum1, checksum2)
-			res.err = NewIOError(errMsg, kanzi.ERR_CRC_CHECK)
-			notify(nil, this.result, false, res)
+			fail(NewIOError(errMsg, kanzi.ERR_CRC_CHECK))
 			return
 		}
 	}
@@ -0,0 +1,205 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// In memory output of the tests
+type testBuffer struct {
+	bytes.Buffer
+}
+
+func (this *testBuffer) Close() error {
+	return nil
+}
+
+// Seekable input of the tests
+type testReader struct {
+	*bytes.Reader
+}
+
+func newTestReader(buf []byte) *testReader {
+	return &testReader{Reader: bytes.NewReader(buf)}
+}
+
+func (this *testReader) Close() error {
+	return nil
+}
+
+// Return compressible data: runs and repeated words drawn from a small
+// vocabulary
+func testData(size int, seed int64) []byte {
+	words := []string{"kanzi", "block", "stream", "entropy", "transform", "index ", "\n", "  ", "0123"}
+	rnd := rand.New(rand.NewSource(seed))
+	buf := make([]byte, 0, size+16)
+
+	for len(buf) < size {
+		if rnd.Intn(8) == 0 {
+			val := byte(rnd.Intn(256))
+
+			for run := rnd.Intn(32); run >= 0; run-- {
+				buf = append(buf, val)
+			}
+		} else {
+			buf = append(buf, words[rnd.Intn(len(words))]...)
+		}
+	}
+
+	return buf[0:size]
+}
+
+// Compress data in chunks of random sizes
+func compressTest(t *testing.T, data []byte, opts Options) []byte {
+	t.Helper()
+	out := &testBuffer{}
+	cos, err := NewCompressedOutputStreamWithOptions(out, opts)
+
+	if err != nil {
+		t.Fatalf("Cannot create compressed stream: %v", err)
+	}
+
+	rnd := rand.New(rand.NewSource(int64(len(data))))
+
+	for n := 0; n < len(data); {
+		end := n + 1 + rnd.Intn(100000)
+
+		if end > len(data) {
+			end = len(data)
+		}
+
+		if _, err = cos.Write(data[n:end]); err != nil {
+			t.Fatalf("Compression error: %v", err)
+		}
+
+		n = end
+	}
+
+	if err = cos.Close(); err != nil {
+		t.Fatalf("Compression error: %v", err)
+	}
+
+	return out.Bytes()
+}
+
+// Decompress the whole stream (the first error is returned with the data
+// decoded so far)
+func decompressTest(data []byte, opts Options) ([]byte, error) {
+	cis, err := NewCompressedInputStreamWithOptions(newTestReader(data), opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var res []byte
+	buf := make([]byte, 32768)
+
+	for {
+		n, err := cis.Read(buf)
+		res = append(res, buf[0:n]...)
+
+		if err != nil {
+			return res, err
+		}
+
+		if n == 0 {
+			break
+		}
+	}
+
+	return res, cis.Close()
+}
+
+func TestRoundTripLevels(t *testing.T) {
+	data := testData(200000, 1)
+
+	for level := 0; level <= MAX_LEVEL; level++ {
+		for _, jobs := range []uint{1, 4} {
+			opts := Options{Level: level, BlockSize: 64 * 1024, Jobs: jobs, Checksum: true}
+			output, err := decompressTest(compressTest(t, data, opts), Options{Jobs: jobs})
+
+			if err != nil {
+				t.Fatalf("Level %d, %d job(s): decompression error: %v", level, jobs, err)
+			}
+
+			if bytes.Equal(output, data) == false {
+				t.Fatalf("Level %d, %d job(s): the decompressed data differs from the input", level, jobs)
+			}
+		}
+	}
+}
+
+// Streams written with version 8 of the format: level 1, blocks of 1024
+// bytes, checksum, block index and metadata (name "v8.txt"), the second one
+// encrypted with a key of 0x38 bytes
+const (
+	STREAM_V8           = "4b414e5a44250600000000000000800000070001046e616d6501000676382e747874032730a5e8a492a4000e1a353d3903330026000299246c9bb7aa2a57a2cd8b26adcf746033a9ed2e20860452a53fd33a9ed00ca3ee848c664a900038e4d4dcd68ccc0098000a6452326eca9aa75e8b362b8ca2b6fdd0619d535cb88206229529fe033aa0194d7c9952ac981801246eca5caa18c60030200005481e5c73277654ff5a2a7e79571214b6d2fac8308bbb44b8c40c33a05fecc184503287eb805ee9303000148dd44dcaa18c60600080001520a1464f76f54eb4aa7f0243be662c68b8de8502f245d670a067c4bd50cf988000dc3eaff340dfd29e0000000000000e10000000044b49445800000004000000000000011000000400000000000000029e00000400000000000000042d0000040000000000000005dc0000021000000000000001074b494458"
+	STREAM_V8_ENCRYPTED = "4b414e5a442506000000000000008000002f0048a2ae57d9cdbca97a193130eed041dae1639dd6a1069cb96e5176589ef2bee10001046e616d6501000676382e7478740000004220ed4db6ada63c2be529e23d1f9bfe2b4b050f03c4ad9215db69b414b68bc41818c64db702a0ae0d2024a7cfa6d26cb25c7a74abb9d02b20b7b44cf4ce51ba0e4fcd000000421ad258f78af2760c8d1e73c3ac33b9dbb2fa5bc46c2d3a7d98ced2c4dbe21489564bcfba77d1c2631bf3757a22bfb6772abacf4e73e000918a5e4c28578f4c89461b000000467365a6a6e4c6f1363b25e9f17ce85e06f4e4cc6cc014cf36ae2c4a250a560f211660bdc15e484e65596d2d6b01522286df1dfb7945f4d0a9b1925403b3cb9b91fb6beeaed0b1000000460772966be0064fa7088ff30c61ec70d6ab022ccfae4d18de0e2a05b5cb5d31732ce9f49fbfbac6a696ec02c2978d6b7e44709559747e32a0d3f278b3668b838f81d02e278c88000000006948f49b347c7ffaf10227bd112cf5b4dc3eaff340dfd29e0000000000000e10000000044b4944580000000400000000000002180000040000000000000004480000040000000000000006780000040000000000000008c800000210000000000000018b4b494458"
+)
+
+func TestReadVersion8(t *testing.T) {
+	data := []byte(strings.Repeat("Version 8 stream. ", 200))
+	key := bytes.Repeat([]byte{0x38}, ENCRYPTION_KEY_SIZE)
+
+	for _, stream := range []string{STREAM_V8, STREAM_V8_ENCRYPTED} {
+		compressed, _ := hex.DecodeString(stream)
+		opts := Options{}
+
+		if stream == STREAM_V8_ENCRYPTED {
+			opts.EncryptionKey = key
+		}
+
+		if output, err := decompressTest(compressed, opts); err != nil || bytes.Equal(output, data) == false {
+			t.Fatalf("Cannot decode a version 8 stream: %v", err)
+		}
+
+		// Trailers of the first frame skipped
+		concat := append(append([]byte(nil), compressed...), compressed...)
+
+		if output, err := decompressTest(concat, opts); err != nil || bytes.Equal(output, append(data, data...)) == false {
+			t.Fatalf("Cannot decode concatenated version 8 streams: %v", err)
+		}
+
+		// Block index without size of the encoded blocks
+		cis, _ := NewCompressedInputStreamWithOptions(newTestReader(compressed), opts)
+		buf := make([]byte, 1000)
+
+		if _, err := cis.ReadAt(buf, 1500); err != nil || bytes.Equal(buf, data[1500:2500]) == false {
+			t.Fatalf("ReadAt in a version 8 stream failed: %v", err)
+		}
+
+		if md, err := cis.Metadata(); err != nil || md.Len() != 1 {
+			t.Fatalf("Cannot read the metadata of a version 8 stream: %v", err)
+		}
+
+		// Metadata, content checksum and block index not encrypted
+		info, err := ReadStreamInfo(newTestReader(compressed))
+
+		if err != nil || info.Version != 8 || info.Metadata.Len() != 1 || len(info.Blocks) != 4 || info.ContentSize != int64(len(data)) {
+			t.Fatalf("Invalid description of a version 8 stream: %+v (%v)", info, err)
+		}
+
+		// The size of the last block is unknown without independent blocks
+		if info.Blocks[0].Size == 0 || (info.Encryption == "" && info.Blocks[3].Size != 0) {
+			t.Fatalf("Invalid size of the blocks of a version 8 stream: %+v", info.Blocks)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"context"
+	kanzi "github.com/flanglet/kanzi-go"
+	"io"
+)
+
+// Number of bitstream calls between two checks of the cancellation context
+const CONTEXT_CHECK_MASK = 0x3FF
+
+// Create a compressed output stream that stops encoding when goCtx is done.
+// Pending and in-flight encoding tasks return an IOError with the code
+// ERR_CANCELLED wrapping goCtx.Err().
+func NewCompressedOutputStreamWithContext(goCtx context.Context, os io.WriteCloser, ctx map[string]interface{}) (*CompressedOutputStream, error) {
+	if goCtx == nil {
+		return nil, NewIOError("Invalid null cancellation context parameter", kanzi.ERR_CREATE_STREAM)
+	}
+
+	this, err := NewCompressedOutputStream(os, ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	this.goCtx = goCtx
+	return this, nil
+}
+
+// Create a compressed input stream that stops decoding when goCtx is done.
+// Pending and in-flight decoding tasks return an IOError with the code
+// ERR_CANCELLED wrapping goCtx.Err().
+func NewCompressedInputStreamWithContext(goCtx context.Context, is io.ReadCloser, ctx map[string]interface{}) (*CompressedInputStream, error) {
+	if goCtx == nil {
+		return nil, NewIOError("Invalid null cancellation context parameter", kanzi.ERR_CREATE_STREAM)
+	}
+
+	this, err := NewCompressedInputStream(is, ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	this.goCtx = goCtx
+	return this, nil
+}
+
+// Return an error if goCtx is done, nil otherwise (or if goCtx is nil)
+func checkContext(goCtx context.Context) *IOError {
+	if goCtx == nil {
+		return nil
+	}
+
+	if err := goCtx.Err(); err != nil {
+		return WrapIOError(err, "Operation cancelled", kanzi.ERR_CANCELLED)
+	}
+
+	return nil
+}
+
+// Convert the value returned by recover() in a task to an IOError. Keep
+// the original error if it is already an IOError (EG. cancellation).
+// Otherwise, the error is kept as the cause: a bitstream reaching the end
+// of its input in the middle of a block is reported as io.ErrUnexpectedEOF.
+func toIOError(r interface{}, code int) *IOError {
+	if ioerr, isIOErr := r.(*IOError); isIOErr == true {
+		return ioerr
+	}
+
+	if err, isErr := r.(error); isErr == true {
+		res := NewIOError(err.Error(), code)
+		res.cause = err
+
+		if err == io.EOF {
+			res.cause = io.ErrUnexpectedEOF
+		}
+
+		return res
+	}
+
+	return NewIOError("Unknown error", code)
+}
+
+// Output bitstream that panics with a cancellation error once the context
+// is done. The entropy coders write to the bitstream often, so it is used
+// to interrupt the tasks in the middle of a block. Only used by the tasks
+// (which recover from the panic).
+type contextOutputBitStream struct {
+	kanzi.OutputBitStream
+	goCtx context.Context
+	calls uint
+}
+
+// Return obs unchanged if goCtx is nil or can never be cancelled
+func newContextOutputBitStream(goCtx context.Context, obs kanzi.OutputBitStream) kanzi.OutputBitStream {
+	if goCtx == nil || goCtx.Done() == nil {
+		return obs
+	}
+
+	return &contextOutputBitStream{OutputBitStream: obs, goCtx: goCtx}
+}
+
+func (this *contextOutputBitStream) check() {
+	this.calls++
+
+	if this.calls&CONTEXT_CHECK_MASK == 0 {
+		this.CheckCancelled()
+	}
+}
+
+// Implement kanzi.Cancellable interface
+func (this *contextOutputBitStream) CheckCancelled() {
+	if err := checkContext(this.goCtx); err != nil {
+		panic(err)
+	}
+}
+
+func (this *contextOutputBitStream) WriteBit(bit int) {
+	this.check()
+	this.OutputBitStream.WriteBit(bit)
+}
+
+func (this *contextOutputBitStream) WriteBits(bits uint64, length uint) uint {
+	this.check()
+	return this.OutputBitStream.WriteBits(bits, length)
+}
+
+func (this *contextOutputBitStream) WriteArray(bits []byte, length uint) uint {
+	// Arrays can be large: always check
+	this.CheckCancelled()
+
+	return this.OutputBitStream.WriteArray(bits, length)
+}
+
+// Input bitstream that panics with a cancellation error once the context
+// is done.
+type contextInputBitStream struct {
+	kanzi.InputBitStream
+	goCtx context.Context
+	calls uint
+}
+
+// Return ibs unchanged if goCtx is nil or can never be cancelled
+func newContextInputBitStream(goCtx context.Context, ibs kanzi.InputBitStream) kanzi.InputBitStream {
+	if goCtx == nil || goCtx.Done() == nil {
+		return ibs
+	}
+
+	return &contextInputBitStream{InputBitStream: ibs, goCtx: goCtx}
+}
+
+func (this *contextInputBitStream) check() {
+	this.calls++
+
+	if this.calls&CONTEXT_CHECK_MASK == 0 {
+		this.CheckCancelled()
+	}
+}
+
+// Implement kanzi.Cancellable interface
+func (this *contextInputBitStream) CheckCancelled() {
+	if err := checkContext(this.goCtx); err != nil {
+		panic(err)
+	}
+}
+
+func (this *contextInputBitStream) ReadBit() int {
+	this.check()
+	return this.InputBitStream.ReadBit()
+}
+
+func (this *contextInputBitStream) ReadBits(length uint) uint64 {
+	this.check()
+	return this.InputBitStream.ReadBits(length)
+}
+
+func (this *contextInputBitStream) ReadArray(bits []byte, length uint) uint {
+	// Arrays can be large: always check
+	this.CheckCancelled()
+
+	return this.InputBitStream.ReadArray(bits, length)
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	kanzi "github.com/flanglet/kanzi-go"
+)
+
+func TestCancelledCompression(t *testing.T) {
+	data := testData(300000, 51)
+
+	for _, jobs := range []uint{1, 4} {
+		goCtx, cancel := context.WithCancel(context.Background())
+		ctx := map[string]interface{}{"transform": "BWT", "codec": "ANS0", "blockSize": uint(32 * 1024), "jobs": jobs}
+		cos, err := NewCompressedOutputStreamWithContext(goCtx, &testBuffer{}, ctx)
+
+		if err != nil {
+			t.Fatalf("Cannot create compressed stream: %v", err)
+		}
+
+		if _, err = cos.Write(data[0:100000]); err != nil {
+			t.Fatalf("%d job(s): Write error before cancellation: %v", jobs, err)
+		}
+
+		cancel()
+
+		if _, err = cos.Write(data[100000:]); err == nil {
+			err = cos.Close()
+		}
+
+		if kanzi.ErrorCode(err) != kanzi.ERR_CANCELLED || errors.Is(err, context.Canceled) == false {
+			t.Fatalf("%d job(s): expected ERR_CANCELLED, got %v", jobs, err)
+		}
+	}
+}
+
+func TestCancelledDecompression(t *testing.T) {
+	data := testData(400000, 52)
+	compressed := compressTest(t, data, Options{Level: 4, BlockSize: 32 * 1024, Checksum: true})
+
+	for _, jobs := range []uint{1, 4} {
+		goCtx, cancel := context.WithCancel(context.Background())
+		cis, err := NewCompressedInputStreamWithContext(goCtx, newTestReader(compressed),
+			map[string]interface{}{"jobs": jobs})
+
+		if err != nil {
+			t.Fatalf("Cannot create compressed stream: %v", err)
+		}
+
+		buf := make([]byte, 50000)
+
+		if n, err := cis.Read(buf); err != nil || bytes.Equal(buf[0:n], data[0:n]) == false {
+			t.Fatalf("%d job(s): Read error before cancellation: %v", jobs, err)
+		}
+
+		cancel()
+		_, err = cis.Read(buf)
+
+		if kanzi.ErrorCode(err) != kanzi.ERR_CANCELLED || errors.Is(err, context.Canceled) == false {
+			t.Fatalf("%d job(s): expected ERR_CANCELLED, got %v", jobs, err)
+		}
+
+		// A cancellation is not a damaged block in recovery mode
+		goCtx, cancel = context.WithCancel(context.Background())
+		cancel()
+		ctx := map[string]interface{}{"jobs": jobs, "recover": true}
+
+		if cis, err = NewCompressedInputStreamWithContext(goCtx, newTestReader(compressed), ctx); err != nil {
+			t.Fatalf("Cannot create compressed stream: %v", err)
+		}
+
+		if _, err = cis.Read(buf); kanzi.ErrorCode(err) != kanzi.ERR_CANCELLED {
+			t.Fatalf("%d job(s), recovery mode: expected ERR_CANCELLED, got %v", jobs, err)
+		}
+	}
+}
+
+func TestContextDeadline(t *testing.T) {
+	goCtx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	<-goCtx.Done()
+
+	cis, err := NewCompressedInputStreamWithContext(goCtx, newTestReader(compressTest(t, testData(1000, 53), Options{})),
+		map[string]interface{}{"jobs": uint(1)})
+
+	if err != nil {
+		t.Fatalf("Cannot create compressed stream: %v", err)
+	}
+
+	if _, err = cis.Read(make([]byte, 100)); errors.Is(err, context.DeadlineExceeded) == false {
+		t.Fatalf("Expected a deadline error, got %v", err)
+	}
+
+	if _, err = NewCompressedInputStreamWithContext(nil, newTestReader(nil), map[string]interface{}{}); kanzi.ErrorCode(err) != kanzi.ERR_CREATE_STREAM {
+		t.Fatalf("Nil context: expected ERR_CREATE_STREAM, got %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"encoding/binary"
+	"fmt"
+	kanzi "github.com/flanglet/kanzi-go"
+	"github.com/flanglet/kanzi-go/util/hash"
+	"sort"
+)
+
+// A pre-shared dictionary is a sample of the data to compress, provided to
+// both the encoder and the decoder (see Options). It primes the word
+// dictionary of the text codec and the history window of the LZ codecs,
+// which improves the compression of small blocks. The content is free:
+// TrainDictionary builds a dictionary from sample files.
+//
+// The stream header records the ID of the dictionary (HEADER_FLAG_DICTIONARY)
+// and the decoder rejects a missing or different dictionary with
+// ERR_DICTIONARY.
+
+const (
+	MIN_DICTIONARY_SIZE     = 256
+	MAX_DICTIONARY_SIZE     = 1 << 20
+	DEFAULT_DICTIONARY_SIZE = 64 * 1024 // size of the LZ history window
+	DICT_SEGMENT_SIZE       = 256       // size of the segments selected by the training
+	DICT_DMER_SIZE          = 8         // size of the substrings scored by the training
+)
+
+// Return the ID of a dictionary (written to the stream header)
+func DictionaryID(dict []byte) uint32 {
+	h, _ := hash.NewXXHash32(BITSTREAM_TYPE)
+	return h.Hash(dict)
+}
+
+type dictSegment struct {
+	pos   int
+	score int
+}
+
+// Build a dictionary of at most size bytes from samples of the data to
+// compress (EG. one sample per file). The samples are split into epochs
+// and the segment of each epoch with the most substrings shared by other
+// samples is selected. The best segments are placed at the end of the
+// dictionary (closest to the data, see the LZ codecs).
+func TrainDictionary(samples [][]byte, size int) ([]byte, error) {
+	if size < MIN_DICTIONARY_SIZE || size > MAX_DICTIONARY_SIZE {
+		errMsg := fmt.Sprintf("Invalid dictionary size: %d (must be in [%d..%d])", size, MIN_DICTIONARY_SIZE, MAX_DICTIONARY_SIZE)
+		return nil, NewIOError(errMsg, kanzi.ERR_INVALID_PARAM)
+	}
+
+	total := 0
+
+	for _, s := range samples {
+		total += len(s)
+	}
+
+	if total == 0 {
+		return nil, NewIOError("No training data", kanzi.ERR_INVALID_PARAM)
+	}
+
+	data := make([]byte, 0, total)
+
+	for _, s := range samples {
+		data = append(data, s...)
+	}
+
+	// Small training set: keep everything
+	if total <= size {
+		return data, nil
+	}
+
+	// Count the samples containing each substring (dmer)
+	freqs := make(map[uint64]int32)
+	last := make(map[uint64]int32)
+
+	for i, s := range samples {
+		for j := 0; j+DICT_DMER_SIZE <= len(s); j++ {
+			key := binary.LittleEndian.Uint64(s[j:])
+
+			if n, prst := last[key]; prst == false || n != int32(i+1) {
+				last[key] = int32(i + 1)
+				freqs[key]++
+			}
+		}
+	}
+
+	last = nil
+	nbSegments := size / DICT_SEGMENT_SIZE
+	epochSize := total / nbSegments
+
+	if epochSize < DICT_SEGMENT_SIZE {
+		epochSize = DICT_SEGMENT_SIZE
+		nbSegments = total / DICT_SEGMENT_SIZE
+	}
+
+	segments := make([]dictSegment, 0, nbSegments)
+
+	for e := 0; e < nbSegments; e++ {
+		start := e * epochSize
+		end := start + epochSize
+
+		if end > total {
+			end = total
+		}
+
+		if seg := bestSegment(data[start:end], freqs); seg.score > 0 {
+			seg.pos += start
+			segments = append(segments, seg)
+
+			// Do not score the selected substrings again
+			for i := seg.pos; i+DICT_DMER_SIZE <= seg.pos+DICT_SEGMENT_SIZE; i++ {
+				freqs[binary.LittleEndian.Uint64(data[i:])] = 1
+			}
+		}
+	}
+
+	sort.SliceStable(segments, func(i, j int) bool {
+		return segments[i].score < segments[j].score
+	})
+
+	res := make([]byte, 0, len(segments)*DICT_SEGMENT_SIZE)
+
+	for _, seg := range segments {
+		res = append(res, data[seg.pos:seg.pos+DICT_SEGMENT_SIZE]...)
+	}
+
+	return res, nil
+}
+
+// Return the segment of buf with the highest score. The score of a segment
+// is the sum of the frequencies (number of other samples) of its distinct
+// dmers.
+func bestSegment(buf []byte, freqs map[uint64]int32) dictSegment {
+	res := dictSegment{pos: 0, score: 0}
+
+	if len(buf) < DICT_SEGMENT_SIZE {
+		return res
+	}
+
+	// Only the dmers shared by several samples matter
+	weight := func(key uint64) int {
+		if f := freqs[key]; f > 1 {
+			return int(f) - 1
+		}
+
+		return 0
+	}
+
+	nbDmers := DICT_SEGMENT_SIZE - DICT_DMER_SIZE + 1
+	active := make(map[uint64]int32)
+	score := 0
+
+	for i := 0; i+DICT_DMER_SIZE <= len(buf); i++ {
+		key := binary.LittleEndian.Uint64(buf[i:])
+
+		if active[key] == 0 {
+			score += weight(key)
+		}
+
+		active[key]++
+
+		// Slide the window: remove the first dmer
+		if i >= nbDmers {
+			key0 := binary.LittleEndian.Uint64(buf[i-nbDmers:])
+			active[key0]--
+
+			if active[key0] == 0 {
+				score -= weight(key0)
+				delete(active, key0)
+			}
+		}
+
+		if i >= nbDmers-1 && score > res.score {
+			res.score = score
+			res.pos = i - nbDmers + 1
+		}
+	}
+
+	return res
+}
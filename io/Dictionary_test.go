@@ -0,0 +1,126 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	kanzi "github.com/flanglet/kanzi-go"
+)
+
+// Small JSON records sharing most of their content
+func testRecords(n int, seed int64) [][]byte {
+	rnd := rand.New(rand.NewSource(seed))
+	cities := []string{"Paris", "London", "Tokyo", "Lima", "Oslo", "Cairo"}
+	res := make([][]byte, n)
+
+	for i := range res {
+		res[i] = []byte(fmt.Sprintf(`{"id":%d,"name":"user%d","email":"user%d@example.com",`+
+			`"city":"%s","active":%v,"roles":["reader","writer"],"score":%d}`,
+			rnd.Intn(100000), rnd.Intn(1000), rnd.Intn(1000), cities[rnd.Intn(len(cities))],
+			rnd.Intn(2) == 0, rnd.Intn(100)))
+	}
+
+	return res
+}
+
+func TestTrainDictionary(t *testing.T) {
+	samples := testRecords(2000, 101)
+	dict, err := TrainDictionary(samples, 4096)
+
+	if err != nil {
+		t.Fatalf("Training error: %v", err)
+	}
+
+	if len(dict) == 0 || len(dict) > 4096 {
+		t.Fatalf("Invalid dictionary size: %d", len(dict))
+	}
+
+	// The content of a small training set is kept
+	if small, err := TrainDictionary(samples[0:2], 4096); err != nil ||
+		bytes.Equal(small, append(append([]byte(nil), samples[0]...), samples[1]...)) == false {
+		t.Fatalf("Invalid dictionary for a small training set: %v", err)
+	}
+
+	if _, err = TrainDictionary(samples, MIN_DICTIONARY_SIZE-1); kanzi.ErrorCode(err) != kanzi.ERR_INVALID_PARAM {
+		t.Fatalf("Invalid size: expected ERR_INVALID_PARAM, got %v", err)
+	}
+
+	if _, err = TrainDictionary([][]byte{nil}, 4096); kanzi.ErrorCode(err) != kanzi.ERR_INVALID_PARAM {
+		t.Fatalf("No data: expected ERR_INVALID_PARAM, got %v", err)
+	}
+
+	if DictionaryID(dict) == DictionaryID(dict[1:]) {
+		t.Fatalf("Same ID for different dictionaries")
+	}
+}
+
+func TestDictionaryRoundTrip(t *testing.T) {
+	dict, err := TrainDictionary(testRecords(2000, 102), 8192)
+
+	if err != nil {
+		t.Fatalf("Training error: %v", err)
+	}
+
+	record := bytes.Join(testRecords(3, 103), []byte("\n"))
+
+	for _, level := range []int{1, 2, 3, 4} {
+		for _, jobs := range []uint{1, 4} {
+			opts := Options{Level: level, Jobs: jobs, Checksum: true, Dictionary: dict}
+			compressed := compressTest(t, record, opts)
+			output, err := decompressTest(compressed, Options{Jobs: jobs, Dictionary: dict})
+
+			if err != nil || bytes.Equal(output, record) == false {
+				t.Fatalf("Level %d, %d job(s): round trip failed: %v", level, jobs, err)
+			}
+
+			// The decoder must be given the same dictionary
+			if _, err = decompressTest(compressed, Options{Jobs: jobs}); kanzi.ErrorCode(err) != kanzi.ERR_DICTIONARY {
+				t.Fatalf("Level %d: missing dictionary: expected ERR_DICTIONARY, got %v", level, err)
+			}
+
+			if _, err = decompressTest(compressed, Options{Jobs: jobs, Dictionary: dict[1:]}); kanzi.ErrorCode(err) != kanzi.ERR_DICTIONARY {
+				t.Fatalf("Level %d: wrong dictionary: expected ERR_DICTIONARY, got %v", level, err)
+			}
+		}
+
+		// The dictionary improves the compression of small inputs
+		with := compressTest(t, record, Options{Level: level, Dictionary: dict})
+		without := compressTest(t, record, Options{Level: level})
+
+		if len(with) >= len(without) {
+			t.Fatalf("Level %d: no gain with the dictionary (%d >= %d bytes)", level, len(with), len(without))
+		}
+	}
+
+	// One-shot API
+	dst := make([]byte, 4096)
+	n, err := Compress(dst, record, Options{Level: 2, Dictionary: dict, Headerless: true})
+
+	if err != nil {
+		t.Fatalf("One-shot compression error: %v", err)
+	}
+
+	output := make([]byte, len(record))
+
+	if _, err = Decompress(output, dst[0:n], Options{Level: 2, Dictionary: dict, Headerless: true}); err != nil ||
+		bytes.Equal(output, record) == false {
+		t.Fatalf("One-shot round trip failed: %v", err)
+	}
+}
@@ -0,0 +1,349 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	kanzi "github.com/flanglet/kanzi-go"
+)
+
+// Encrypted streams (HEADER_FLAG_ENCRYPTED) use AES-256-GCM. Each block is
+// entropy coded in its own buffer (independent block layout) and the buffer
+// is encrypted and authenticated before being written with its size.
+//
+// The key of a frame is derived from a random salt written to the header:
+// with PBKDF2-HMAC-SHA256 from a passphrase or with HMAC-SHA256 from a key
+// (EG. a key file). Since each frame has its own key, the nonce of a block
+// is simply its position in the frame (the first block is 1).
+//
+// Header (after the dictionary ID):
+//   8 bits   key derivation (ENCRYPTION_KDF_*)
+//   32 bits  number of PBKDF2 iterations (passphrase only)
+//   128 bits salt
+//   parity parameters (if HEADER_FLAG_PARITY, see Parity.go)
+//   32 bits  size of the metadata section (if HEADER_FLAG_METADATA)
+//   encrypted metadata section followed by a 128 bits tag authenticating
+//   the metadata and all the header fields (detects a wrong key)
+// The end block of the frame is followed by a tag authenticating the number
+// of blocks (detects a truncated stream). The content checksum trailer and
+// the entries of the block index are encrypted (each one followed by a 128
+// bits tag): they would reveal the size and a hash of the content.
+//
+// Before version 9, the tag of the header follows the salt and only
+// authenticates the entropy codec, transforms, block size, flags and
+// dictionary ID. The metadata section, the content checksum trailer and the
+// block index are not encrypted.
+
+const (
+	ENCRYPTION_KEY_SIZE       = 32 // AES-256
+	ENCRYPTION_SALT_SIZE      = 16
+	ENCRYPTION_TAG_SIZE       = 16
+	ENCRYPTION_KDF_KEY        = 0 // key provided by the application
+	ENCRYPTION_KDF_PBKDF2     = 1 // key derived from a passphrase
+	PBKDF2_DEFAULT_ITERATIONS = 200000
+	PBKDF2_MIN_ITERATIONS     = 1000
+	PBKDF2_MAX_ITERATIONS     = 1 << 24
+)
+
+// Nonce domains (first 4 bytes of the nonce)
+const (
+	nonceHeader  = uint32(0)
+	nonceBlock   = uint32(1)
+	nonceEnd     = uint32(2)
+	nonceTrailer = uint32(3)
+	nonceIndex   = uint32(4)
+)
+
+// Encrypt and decrypt the blocks of a frame. Shared by concurrent tasks.
+type blockCipher struct {
+	kdf        uint
+	iterations uint32
+	salt       [ENCRYPTION_SALT_SIZE]byte
+	aead       cipher.AEAD
+}
+
+// Return true if the options enable encryption
+func (this *Options) encrypted() bool {
+	return len(this.Passphrase) > 0 || len(this.EncryptionKey) > 0
+}
+
+// Create the cipher of a new frame (random salt)
+func newEncryptionCipher(opts *Options) (*blockCipher, error) {
+	this := &blockCipher{kdf: ENCRYPTION_KDF_KEY}
+
+	if len(opts.Passphrase) > 0 {
+		this.kdf = ENCRYPTION_KDF_PBKDF2
+		this.iterations = PBKDF2_DEFAULT_ITERATIONS
+	}
+
+	if _, err := rand.Read(this.salt[:]); err != nil {
+		return nil, WrapIOError(err, "Cannot generate encryption salt", kanzi.ERR_CREATE_STREAM)
+	}
+
+	if err := this.init(opts); err != nil {
+		return nil, err
+	}
+
+	return this, nil
+}
+
+// Derive the key of the frame and create the AEAD
+func (this *blockCipher) init(opts *Options) error {
+	var key []byte
+
+	if this.kdf == ENCRYPTION_KDF_PBKDF2 {
+		if len(opts.Passphrase) == 0 {
+			return NewIOError("The stream is encrypted with a passphrase: missing passphrase", kanzi.ERR_MISSING_PARAM)
+		}
+
+		key = pbkdf2SHA256([]byte(opts.Passphrase), this.salt[:], int(this.iterations), ENCRYPTION_KEY_SIZE)
+	} else {
+		if len(opts.EncryptionKey) == 0 {
+			return NewIOError("The stream is encrypted with a key: missing encryption key", kanzi.ERR_MISSING_PARAM)
+		}
+
+		mac := hmac.New(sha256.New, opts.EncryptionKey)
+		mac.Write(this.salt[:])
+		key = mac.Sum(nil)
+	}
+
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		return WrapIOError(err, "", kanzi.ERR_CREATE_STREAM)
+	}
+
+	if this.aead, err = cipher.NewGCM(block); err != nil {
+		return WrapIOError(err, "", kanzi.ERR_CREATE_STREAM)
+	}
+
+	return nil
+}
+
+func (this *blockCipher) nonce(domain uint32, blockId uint64) []byte {
+	var nonce [12]byte
+	binary.BigEndian.PutUint32(nonce[0:], domain)
+	binary.BigEndian.PutUint64(nonce[4:], blockId)
+	return nonce[:]
+}
+
+// Encrypt a block and append the result (with the tag) to dst
+func (this *blockCipher) seal(dst, block []byte, blockId uint64) []byte {
+	return this.aead.Seal(dst, this.nonce(nonceBlock, blockId), block, nil)
+}
+
+// Decrypt a block in place, fail if the block has been modified (or the key
+// is wrong)
+func (this *blockCipher) open(block []byte, blockId uint64) ([]byte, error) {
+	res, err := this.aead.Open(block[:0], this.nonce(nonceBlock, blockId), block, nil)
+
+	if err != nil {
+		errMsg := fmt.Sprintf("Authentication failed for block %d", blockId)
+		return nil, NewIOError(errMsg, kanzi.ERR_AUTHENTICATION)
+	}
+
+	return res, nil
+}
+
+// Return the tag of the end of the frame (number of blocks)
+func (this *blockCipher) endTag(nbBlocks uint64) []byte {
+	return this.aead.Seal(nil, this.nonce(nonceEnd, nbBlocks), nil, nil)
+}
+
+// Return true if the tag matches the number of blocks of the frame
+func (this *blockCipher) checkEndTag(tag []byte, nbBlocks uint64) bool {
+	_, err := this.aead.Open(nil, this.nonce(nonceEnd, nbBlocks), tag, nil)
+	return err == nil
+}
+
+// Encrypt a trailer of the frame (content checksum or block index entries)
+// and append the tag
+func (this *blockCipher) sealTrailer(domain uint32, id uint64, trailer []byte) []byte {
+	return this.aead.Seal(nil, this.nonce(domain, id), trailer, nil)
+}
+
+// Decrypt a trailer of the frame in place, fail if it has been modified
+func (this *blockCipher) openTrailer(domain uint32, id uint64, trailer []byte) ([]byte, error) {
+	res, err := this.aead.Open(trailer[:0], this.nonce(domain, id), trailer, nil)
+
+	if err != nil {
+		return nil, NewIOError("Authentication failed: corrupted trailer", kanzi.ERR_AUTHENTICATION)
+	}
+
+	return res, nil
+}
+
+// Write the encryption parameters
+func (this *blockCipher) writeHeader(obs kanzi.OutputBitStream) *IOError {
+	if obs.WriteBits(uint64(this.kdf), 8) != 8 {
+		return NewIOError("Cannot write key derivation to header", kanzi.ERR_WRITE_FILE)
+	}
+
+	if this.kdf == ENCRYPTION_KDF_PBKDF2 {
+		if obs.WriteBits(uint64(this.iterations), 32) != 32 {
+			return NewIOError("Cannot write key derivation to header", kanzi.ERR_WRITE_FILE)
+		}
+	}
+
+	if obs.WriteArray(this.salt[:], 8*ENCRYPTION_SALT_SIZE) != 8*ENCRYPTION_SALT_SIZE {
+		return NewIOError("Cannot write encryption salt to header", kanzi.ERR_WRITE_FILE)
+	}
+
+	return nil
+}
+
+// Write the serialized metadata section (nil if none) encrypted, followed by
+// the tag authenticating the metadata and the header fields (aad)
+func (this *blockCipher) writeHeaderTag(obs kanzi.OutputBitStream, aad []byte, metadata []byte) *IOError {
+	sealed := this.aead.Seal(nil, this.nonce(nonceHeader, 0), metadata, aad)
+
+	if obs.WriteArray(sealed, uint(8*len(sealed))) != uint(8*len(sealed)) {
+		return NewIOError("Cannot write encryption tag to header", kanzi.ERR_WRITE_FILE)
+	}
+
+	return nil
+}
+
+// Read the encryption parameters of a frame (the key is not derived, see
+// authenticate)
+func readEncryptionHeader(ibs kanzi.InputBitStream) (*blockCipher, error) {
+	this := &blockCipher{kdf: uint(ibs.ReadBits(8))}
+
+	if this.kdf == ENCRYPTION_KDF_PBKDF2 {
+		this.iterations = uint32(ibs.ReadBits(32))
+
+		if this.iterations < PBKDF2_MIN_ITERATIONS || this.iterations > PBKDF2_MAX_ITERATIONS {
+			errMsg := fmt.Sprintf("Invalid bitstream, incorrect number of key derivation iterations: %d", this.iterations)
+			return nil, NewIOError(errMsg, kanzi.ERR_INVALID_FILE)
+		}
+	} else if this.kdf != ENCRYPTION_KDF_KEY {
+		errMsg := fmt.Sprintf("Invalid bitstream, unknown key derivation: %d", this.kdf)
+		return nil, NewIOError(errMsg, kanzi.ERR_INVALID_FILE)
+	}
+
+	ibs.ReadArray(this.salt[:], 8*ENCRYPTION_SALT_SIZE)
+	return this, nil
+}
+
+// Read the encrypted metadata section of the provided size (0 if none)
+// followed by the tag of the header. The buffer grows with the data actually
+// read (the size is not trusted). Panics on read errors.
+func readHeaderTag(ibs kanzi.InputBitStream, size uint64) []byte {
+	size += ENCRYPTION_TAG_SIZE
+	res := make([]byte, 0, ENCRYPTION_TAG_SIZE)
+	var chunk [4096]byte
+
+	for uint64(len(res)) < size {
+		n := uint64(len(chunk))
+
+		if n > size-uint64(len(res)) {
+			n = size - uint64(len(res))
+		}
+
+		ibs.ReadArray(chunk[0:n], uint(8*n))
+		res = append(res, chunk[0:n]...)
+	}
+
+	return res
+}
+
+// Derive the key of the frame, check the tag of the header fields (aad) and
+// return the decrypted metadata section (empty if none)
+func (this *blockCipher) authenticate(opts *Options, aad []byte, sealed []byte) ([]byte, error) {
+	if err := this.init(opts); err != nil {
+		return nil, err
+	}
+
+	metadata, err := this.aead.Open(nil, this.nonce(nonceHeader, 0), sealed, aad)
+
+	if err != nil {
+		return nil, NewIOError("Authentication failed: invalid key or corrupted header", kanzi.ERR_AUTHENTICATION)
+	}
+
+	return metadata, nil
+}
+
+// Return the header fields authenticated by the tag of the header
+func (this *frameHeader) encryptionAAD() []byte {
+	if this.version < 9 {
+		aad := make([]byte, 28)
+		binary.BigEndian.PutUint32(aad[0:], BITSTREAM_TYPE)
+		binary.BigEndian.PutUint32(aad[4:], this.entropyType)
+		binary.BigEndian.PutUint64(aad[8:], this.transformType)
+		binary.BigEndian.PutUint32(aad[16:], uint32(this.blockSize))
+		binary.BigEndian.PutUint32(aad[20:], uint32(this.flags))
+		binary.BigEndian.PutUint32(aad[24:], this.dictionaryID)
+		return aad
+	}
+
+	aad := make([]byte, 33)
+	binary.BigEndian.PutUint32(aad[0:], BITSTREAM_TYPE)
+	aad[4] = byte(this.version)
+	aad[5] = byte(this.checksumType)
+
+	if this.checksum == true {
+		aad[5] |= 0x80
+	}
+
+	aad[6] = this.nbInputBlocks
+	binary.BigEndian.PutUint32(aad[7:], this.entropyType)
+	binary.BigEndian.PutUint64(aad[11:], this.transformType)
+	binary.BigEndian.PutUint32(aad[19:], uint32(this.blockSize))
+	binary.BigEndian.PutUint32(aad[23:], uint32(this.flags))
+	binary.BigEndian.PutUint32(aad[27:], this.dictionaryID)
+	aad[31] = byte(this.parityGroup)
+	aad[32] = byte(this.parityBlocks)
+	return aad
+}
+
+// PBKDF2 (RFC 8018) with HMAC-SHA256
+func pbkdf2SHA256(password, salt []byte, iterations, keyLen int) []byte {
+	mac := hmac.New(sha256.New, password)
+	size := mac.Size()
+	res := make([]byte, 0, ((keyLen+size-1)/size)*size)
+	var idx [4]byte
+	u := make([]byte, size)
+	t := make([]byte, size)
+
+	for block := uint32(1); len(res) < keyLen; block++ {
+		binary.BigEndian.PutUint32(idx[:], block)
+		mac.Reset()
+		mac.Write(salt)
+		mac.Write(idx[:])
+		u = mac.Sum(u[:0])
+		copy(t, u)
+
+		for i := 1; i < iterations; i++ {
+			mac.Reset()
+			mac.Write(u)
+			u = mac.Sum(u[:0])
+
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+
+		res = append(res, t...)
+	}
+
+	return res[0:keyLen]
+}
@@ -0,0 +1,235 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+
+	kanzi "github.com/flanglet/kanzi-go"
+)
+
+func TestPBKDF2(t *testing.T) {
+	// Test vectors for PBKDF2-HMAC-SHA256 (RFC 7914 section 11 and
+	// the SHA-256 variants of the RFC 6070 vectors)
+	tests := []struct {
+		password   string
+		salt       string
+		iterations int
+		expected   string
+	}{
+		{"password", "salt", 1, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{"password", "salt", 2, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+		{"password", "salt", 4096, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+		{"passwd", "salt", 1, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" +
+			"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+	}
+
+	for i, test := range tests {
+		expected, _ := hex.DecodeString(test.expected)
+		key := pbkdf2SHA256([]byte(test.password), []byte(test.salt), test.iterations, len(expected))
+
+		if bytes.Equal(key, expected) == false {
+			t.Fatalf("Test %d: expected %s, got %x", i, test.expected, key)
+		}
+
+		// A shorter key is a prefix of the longer key
+		if key = pbkdf2SHA256([]byte(test.password), []byte(test.salt), test.iterations, 20); bytes.Equal(key, expected[0:20]) == false {
+			t.Fatalf("Test %d: expected %x, got %x", i, expected[0:20], key)
+		}
+	}
+}
+
+func TestEncryptionRoundTrip(t *testing.T) {
+	data := testData(200000, 111)
+	key := bytes.Repeat([]byte{0x5A}, ENCRYPTION_KEY_SIZE)
+
+	for _, opts := range []Options{
+		{Level: 2, BlockSize: 64 * 1024, Passphrase: "secret", Checksum: true},
+		{Level: 6, BlockSize: 32 * 1024, EncryptionKey: key, BlockIndex: true},
+	} {
+		for _, jobs := range []uint{1, 4} {
+			opts.Jobs = jobs
+			compressed := compressTest(t, data, opts)
+
+			// Encrypted with a random salt
+			if bytes.Equal(compressed, compressTest(t, data, opts)) == true {
+				t.Fatalf("%d job(s): same output for two encryptions", jobs)
+			}
+
+			if bytes.Contains(compressed, data[1000:1100]) == true {
+				t.Fatalf("%d job(s): clear text found in the stream", jobs)
+			}
+
+			output, err := decompressTest(compressed, Options{Jobs: jobs, Passphrase: opts.Passphrase, EncryptionKey: opts.EncryptionKey})
+
+			if err != nil || bytes.Equal(output, data) == false {
+				t.Fatalf("%d job(s): round trip failed: %v", jobs, err)
+			}
+
+			// Missing or wrong secret
+			if _, err = decompressTest(compressed, Options{Jobs: jobs}); kanzi.ErrorCode(err) != kanzi.ERR_MISSING_PARAM {
+				t.Fatalf("%d job(s): missing secret: expected ERR_MISSING_PARAM, got %v", jobs, err)
+			}
+
+			wrong := Options{Jobs: jobs, Passphrase: "Secret"}
+
+			if opts.EncryptionKey != nil {
+				wrong = Options{Jobs: jobs, EncryptionKey: bytes.Repeat([]byte{0x5B}, ENCRYPTION_KEY_SIZE)}
+			}
+
+			if output, err = decompressTest(compressed, wrong); kanzi.ErrorCode(err) != kanzi.ERR_AUTHENTICATION || len(output) != 0 {
+				t.Fatalf("%d job(s): wrong secret: expected ERR_AUTHENTICATION, got %v", jobs, err)
+			}
+		}
+	}
+}
+
+func TestEncryptionTampering(t *testing.T) {
+	data := testData(200000, 112)
+	key := bytes.Repeat([]byte{0xA5}, ENCRYPTION_KEY_SIZE)
+	opts := Options{Level: 3, BlockSize: 32 * 1024, EncryptionKey: key}
+	compressed := compressTest(t, data, opts)
+
+	for _, jobs := range []uint{1, 4} {
+		decOpts := Options{Jobs: jobs, EncryptionKey: key}
+
+		// A modified block fails authentication
+		tampered := append([]byte(nil), compressed...)
+		tampered[len(tampered)/2] ^= 0x01
+
+		if _, err := decompressTest(tampered, decOpts); kanzi.ErrorCode(err) != kanzi.ERR_AUTHENTICATION {
+			t.Fatalf("%d job(s): modified block: expected ERR_AUTHENTICATION, got %v", jobs, err)
+		}
+
+		// A truncated stream is detected
+		for _, size := range []int{len(compressed) - 1, len(compressed) - ENCRYPTION_TAG_SIZE, len(compressed) / 3} {
+			if output, err := decompressTest(compressed[0:size], decOpts); err == nil {
+				t.Fatalf("%d job(s), %d bytes: no error for a truncated stream (%d bytes decoded)", jobs, size, len(output))
+			}
+		}
+	}
+}
+
+func TestEncryptionHeader(t *testing.T) {
+	data := testData(100000, 113)
+	key := bytes.Repeat([]byte{0x3C}, ENCRYPTION_KEY_SIZE)
+	out := &testBuffer{}
+	cos, err := NewCompressedOutputStreamWithOptions(out, Options{Level: 2, BlockSize: 32 * 1024, EncryptionKey: key,
+		ParityGroupSize: 4, ParityBlocks: 2})
+
+	if err != nil {
+		t.Fatalf("Cannot create compressed stream: %v", err)
+	}
+
+	cos.SetMetadataString(METADATA_KEY_NAME, "secret.txt")
+	cos.SetMetadataInt(METADATA_KEY_MODE, 0640)
+	cos.Write(data)
+
+	if err = cos.Close(); err != nil {
+		t.Fatalf("Compression error: %v", err)
+	}
+
+	compressed := out.Bytes()
+
+	// The metadata is encrypted
+	if bytes.Contains(compressed, []byte("secret.txt")) == true {
+		t.Fatalf("Clear text metadata found in the stream")
+	}
+
+	cis, _ := NewCompressedInputStreamWithOptions(newTestReader(compressed), Options{EncryptionKey: key})
+	md, err := cis.Metadata()
+
+	if err != nil {
+		t.Fatalf("Cannot read metadata: %v", err)
+	}
+
+	if name, _ := md.GetString(METADATA_KEY_NAME); name != "secret.txt" {
+		t.Fatalf("Invalid metadata: %v", md.Keys())
+	}
+
+	if info, err := ReadStreamInfo(newTestReader(compressed)); err != nil || info.Metadata.Len() != 0 {
+		t.Fatalf("Stream info of an encrypted stream: %v", err)
+	}
+
+	// Any modification of the header fails authentication. Layout: 18 bytes
+	// of fixed header, key derivation (1 byte), salt (16 bytes), parity
+	// parameters (2 bytes), metadata size (4 bytes), encrypted metadata
+	// and tag
+	for _, pos := range []int{35, 36, 41, 60, 80} {
+		tampered := append([]byte(nil), compressed...)
+		tampered[pos] ^= 0x01
+
+		if _, err := decompressTest(tampered, Options{EncryptionKey: key}); kanzi.ErrorCode(err) != kanzi.ERR_AUTHENTICATION {
+			t.Fatalf("Modified header byte %d: expected ERR_AUTHENTICATION, got %v", pos, err)
+		}
+	}
+}
+
+func TestEncryptionTrailers(t *testing.T) {
+	data := testData(150000, 114)
+	key := bytes.Repeat([]byte{0xC3}, ENCRYPTION_KEY_SIZE)
+	compressed := compressTest(t, data, Options{Level: 2, BlockSize: 32 * 1024, EncryptionKey: key, Checksum: true, BlockIndex: true})
+
+	// Neither the content size nor the block sizes appear in clear text
+	var size [8]byte
+	binary.BigEndian.PutUint64(size[:], uint64(len(data)))
+
+	if bytes.Contains(compressed, size[:]) == true {
+		t.Fatalf("Clear text content size found in the stream")
+	}
+
+	if info, err := ReadStreamInfo(newTestReader(compressed)); err != nil || info.ContentSize != -1 || info.Size != int64(len(compressed)) {
+		t.Fatalf("Stream info of an encrypted stream: %v", err)
+	}
+
+	// Random access with the encrypted block index
+	cis, _ := NewCompressedInputStreamWithOptions(newTestReader(compressed), Options{EncryptionKey: key})
+	buf := make([]byte, 1000)
+
+	if n, err := cis.ReadAt(buf, 100000); err != nil || n != len(buf) || bytes.Equal(buf, data[100000:101000]) == false {
+		t.Fatalf("Cannot read from the encrypted block index: %v", err)
+	}
+
+	footer := len(compressed) - INDEX_FOOTER_SIZE
+	start := int(binary.BigEndian.Uint64(compressed[footer:]))
+
+	// Modified block index entries
+	tampered := append([]byte(nil), compressed...)
+	tampered[start+8] ^= 0x01
+	cis, _ = NewCompressedInputStreamWithOptions(newTestReader(tampered), Options{EncryptionKey: key})
+
+	if _, err := cis.ReadAt(buf, 100000); kanzi.ErrorCode(err) != kanzi.ERR_AUTHENTICATION {
+		t.Fatalf("Modified block index: expected ERR_AUTHENTICATION, got %v", err)
+	}
+
+	// Modified content checksum (just before the block index)
+	tampered = append([]byte(nil), compressed...)
+	tampered[start-1] ^= 0x01
+
+	if _, err := decompressTest(tampered, Options{EncryptionKey: key}); kanzi.ErrorCode(err) != kanzi.ERR_AUTHENTICATION {
+		t.Fatalf("Modified content checksum: expected ERR_AUTHENTICATION, got %v", err)
+	}
+
+	// Empty stream
+	empty := compressTest(t, nil, Options{EncryptionKey: key, Checksum: true, BlockIndex: true})
+
+	if output, err := decompressTest(empty, Options{EncryptionKey: key}); err != nil || len(output) != 0 {
+		t.Fatalf("Empty encrypted stream: %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	kanzi "github.com/flanglet/kanzi-go"
+	"github.com/flanglet/kanzi-go/entropy"
+	"github.com/flanglet/kanzi-go/function"
+)
+
+func TestIOError(t *testing.T) {
+	cause := fmt.Errorf("disk failure")
+	err := error(WrapIOError(cause, "Cannot read block", kanzi.ERR_READ_FILE))
+
+	if errors.Is(err, kanzi.ErrReadFile) == false || errors.Is(err, kanzi.ErrWriteFile) == true {
+		t.Fatalf("The error does not match its sentinel error: %v", err)
+	}
+
+	if errors.Unwrap(err) != cause || errors.Is(err, cause) == false {
+		t.Fatalf("The cause is lost: %v", err)
+	}
+
+	var ioErr *IOError
+
+	if errors.As(fmt.Errorf("wrapped: %w", err), &ioErr) == false || ioErr.Message() != "Cannot read block: disk failure" ||
+		ioErr.BlockId() != 0 || ioErr.Offset() != -1 {
+		t.Fatalf("Invalid IOError: %+v", ioErr)
+	}
+
+	if kanzi.ErrorCode(fmt.Errorf("wrapped: %w", err)) != kanzi.ERR_READ_FILE {
+		t.Fatalf("Invalid error code: %d", kanzi.ErrorCode(err))
+	}
+}
+
+func TestStreamErrors(t *testing.T) {
+	data := testData(8*TEST_RECOVERY_BLOCK_SIZE, 141)
+	stream := compressTest(t, data, Options{Level: 2, BlockSize: TEST_RECOVERY_BLOCK_SIZE, Checksum: true, BlockIndex: true})
+	index, err := readBlockIndex(newTestReader(stream), 0, BITSTREAM_FORMAT_VERSION, nil)
+
+	if err != nil {
+		t.Fatalf("Cannot read block index: %v", err)
+	}
+
+	// The position of a damaged block is reported
+	damaged := damageBlock(t, stream, 5)
+
+	for _, jobs := range []uint{1, 4} {
+		_, err := decompressTest(damaged, Options{Jobs: jobs})
+		var ioErr *IOError
+
+		if errors.As(err, &ioErr) == false {
+			t.Fatalf("%d job(s): expected an IOError, got %v", jobs, err)
+		}
+
+		// Checksum mismatch or failure of the decoder
+		if errors.Is(err, kanzi.ErrCRCCheck) == false && errors.Is(err, kanzi.ErrReadFile) == false {
+			t.Fatalf("%d job(s): unexpected error: %v", jobs, err)
+		}
+
+		if ioErr.BlockId() != 6 || ioErr.Offset() < int64(index[5].offset>>3) || ioErr.Offset() >= int64(index[6].offset>>3) {
+			t.Fatalf("%d job(s): invalid position: block %d, offset %d (block at %d)", jobs, ioErr.BlockId(),
+				ioErr.Offset(), index[5].offset>>3)
+		}
+	}
+
+	// Errors of the other packages carry a code
+	ctx := map[string]interface{}{}
+
+	if _, err = function.NewByteFunction(&ctx, 63<<function.BFF_MAX_SHIFT); errors.Is(err, kanzi.ErrInvalidCodec) == false {
+		t.Fatalf("Unknown transform: expected ErrInvalidCodec, got %v", err)
+	}
+
+	if _, err = entropy.NewEntropyDecoder(nil, ctx, 99); errors.Is(err, kanzi.ErrInvalidCodec) == false {
+		t.Fatalf("Unknown codec: expected ErrInvalidCodec, got %v", err)
+	}
+
+	if _, err = decompressTest([]byte("KANZ"), Options{}); errors.Is(err, kanzi.ErrInvalidFile) == false &&
+		errors.Is(err, kanzi.ErrReadFile) == false {
+		t.Fatalf("Truncated header: unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	kanzi "github.com/flanglet/kanzi-go"
+)
+
+func TestFlushStreaming(t *testing.T) {
+	messages := [][]byte{testData(1000, 41), testData(70000, 42), testData(10, 43)}
+
+	for _, independent := range []bool{false, true} {
+		for _, jobs := range []uint{1, 4} {
+			pr, pw := io.Pipe()
+			next := make(chan bool)
+			errs := make(chan error, 1)
+
+			// The writer waits for the reader to decode each message
+			go func() {
+				cos, err := NewCompressedOutputStreamWithOptions(pw, Options{Level: 2, BlockSize: 64 * 1024,
+					Jobs: jobs, IndependentBlocks: independent, Checksum: true})
+
+				if err == nil {
+					for _, msg := range messages {
+						if _, err = cos.Write(msg); err == nil {
+							err = cos.Flush()
+						}
+
+						if err != nil || <-next == false {
+							break
+						}
+					}
+
+					if err == nil {
+						err = cos.Close()
+					}
+				}
+
+				pw.CloseWithError(err)
+				errs <- err
+			}()
+
+			cis, err := NewCompressedInputStreamWithOptions(pr, Options{Jobs: jobs})
+
+			if err != nil {
+				t.Fatalf("Cannot create compressed stream: %v", err)
+			}
+
+			for i, msg := range messages {
+				buf := make([]byte, len(msg))
+				done := make(chan error, 1)
+
+				go func() {
+					_, err := io.ReadFull(cis, buf)
+					done <- err
+				}()
+
+				select {
+				case err = <-done:
+				case <-time.After(10 * time.Second):
+					t.Fatalf("Independent=%v, %d job(s): message %d not available after Flush", independent, jobs, i)
+				}
+
+				if err != nil || bytes.Equal(buf, msg) == false {
+					t.Fatalf("Independent=%v, %d job(s): invalid message %d: %v", independent, jobs, i, err)
+				}
+
+				next <- true
+			}
+
+			if n, err := cis.Read(make([]byte, 16)); n != 0 || err != nil {
+				t.Fatalf("Independent=%v, %d job(s): expected end of stream, got %d bytes, %v", independent, jobs, n, err)
+			}
+
+			if err = <-errs; err != nil {
+				t.Fatalf("Independent=%v, %d job(s): compression error: %v", independent, jobs, err)
+			}
+
+			cis.Close()
+		}
+	}
+}
+
+func TestFlushRoundTrip(t *testing.T) {
+	data := testData(150000, 44)
+	out := &testBuffer{}
+	cos, err := NewCompressedOutputStreamWithOptions(out, Options{Level: 3, BlockSize: 32 * 1024,
+		BlockIndex: true, Checksum: true})
+
+	if err != nil {
+		t.Fatalf("Cannot create compressed stream: %v", err)
+	}
+
+	// Flush with pending data, several times in a row and with no data
+	pos := 0
+
+	for _, n := range []int{0, 2500, 2500, 0, 20000, 20000, 75000} {
+		if _, err = cos.Write(data[pos : pos+n]); err != nil {
+			t.Fatalf("Write error: %v", err)
+		}
+
+		pos += n
+
+		if err = cos.Flush(); err != nil {
+			t.Fatalf("Flush error: %v", err)
+		}
+	}
+
+	if _, err = cos.Write(data[pos:]); err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+
+	if err = cos.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+
+	if err = cos.Flush(); kanzi.ErrorCode(err) != kanzi.ERR_WRITE_FILE {
+		t.Fatalf("Flush after Close: expected ERR_WRITE_FILE, got %v", err)
+	}
+
+	for _, jobs := range []uint{1, 4} {
+		output, err := decompressTest(out.Bytes(), Options{Jobs: jobs})
+
+		if err != nil || bytes.Equal(output, data) == false {
+			t.Fatalf("%d job(s): round trip failed: %v", jobs, err)
+		}
+	}
+
+	// The block index skips the flush blocks
+	cis, err := NewCompressedInputStreamWithOptions(newTestReader(out.Bytes()), Options{})
+
+	if err != nil {
+		t.Fatalf("Cannot create compressed stream: %v", err)
+	}
+
+	buf := make([]byte, 1000)
+
+	if _, err = cis.ReadAt(buf, 60000); err != nil || bytes.Equal(buf, data[60000:61000]) == false {
+		t.Fatalf("ReadAt after flush blocks failed: %v", err)
+	}
+
+	cis.Close()
+}
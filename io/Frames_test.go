@@ -0,0 +1,64 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"bytes"
+	kanzi "github.com/flanglet/kanzi-go"
+	"testing"
+)
+
+func TestConcatenatedStreams(t *testing.T) {
+	data1 := testData(70000, 9)
+	data2 := testData(50000, 10)
+	stream1 := compressTest(t, data1, Options{Level: 1, BlockSize: 16 * 1024, BlockIndex: true, Checksum: true})
+	stream2 := compressTest(t, data2, Options{Level: 4, BlockSize: 32 * 1024})
+	empty := compressTest(t, nil, Options{Level: 1})
+	concat := append(append(append([]byte(nil), stream1...), empty...), stream2...)
+
+	for _, jobs := range []uint{1, 4} {
+		output, err := decompressTest(concat, Options{Jobs: jobs})
+
+		if err != nil || bytes.Equal(output, append(append([]byte(nil), data1...), data2...)) == false {
+			t.Fatalf("%d job(s): concatenated streams not decoded: %v", jobs, err)
+		}
+
+		output, err = decompressTest(stream1, Options{Jobs: jobs, SingleFrame: true})
+
+		if err != nil || bytes.Equal(output, data1) == false {
+			t.Fatalf("%d job(s): single frame not decoded: %v", jobs, err)
+		}
+
+		// Strict single frame read: the data following the first frame is
+		// an error, the first frame is returned before the error
+		output, err = decompressTest(concat, Options{Jobs: jobs, SingleFrame: true})
+
+		if kanzi.ErrorCode(err) != kanzi.ERR_INVALID_FILE || bytes.Equal(output, data1) == false {
+			t.Fatalf("%d job(s): single frame followed by data: expected ERR_INVALID_FILE, got %v", jobs, err)
+		}
+	}
+
+	// Trailing data that is not a stream is ignored (except in single frame mode)
+	garbage := append(append([]byte(nil), stream2...), []byte("not a stream")...)
+
+	if output, err := decompressTest(garbage, Options{}); err != nil || bytes.Equal(output, data2) == false {
+		t.Fatalf("Trailing data: %v", err)
+	}
+
+	if output, err := decompressTest(garbage, Options{SingleFrame: true}); kanzi.ErrorCode(err) != kanzi.ERR_INVALID_FILE || bytes.Equal(output, data2) == false {
+		t.Fatalf("Trailing data in single frame mode: expected ERR_INVALID_FILE, got %v", err)
+	}
+}
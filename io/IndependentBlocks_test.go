@@ -0,0 +1,96 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	kanzi "github.com/flanglet/kanzi-go"
+)
+
+func TestIndependentBlocks(t *testing.T) {
+	data := testData(200000, 1)
+
+	for level := 0; level <= MAX_LEVEL; level++ {
+		var compressed []byte
+
+		for _, jobs := range []uint{1, 4} {
+			opts := Options{Level: level, BlockSize: 32 * 1024, Jobs: jobs, IndependentBlocks: true, Checksum: true}
+			buf := compressTest(t, data, opts)
+
+			// The output does not depend on the number of jobs
+			if compressed == nil {
+				compressed = buf
+			} else if bytes.Equal(buf, compressed) == false {
+				t.Fatalf("Level %d: different outputs with 1 and %d jobs", level, jobs)
+			}
+
+			for _, decJobs := range []uint{1, 3} {
+				output, err := decompressTest(buf, Options{Jobs: decJobs})
+
+				if err != nil || bytes.Equal(output, data) == false {
+					t.Fatalf("Level %d, %d/%d job(s): round trip failed: %v", level, jobs, decJobs, err)
+				}
+			}
+		}
+	}
+}
+
+func TestIndependentBlocksCorrupted(t *testing.T) {
+	data := testData(200000, 32)
+	compressed := compressTest(t, data, Options{Level: 2, BlockSize: 32 * 1024, BlockIndex: true,
+		IndependentBlocks: true, Checksum: true})
+	index, err := readBlockIndex(newTestReader(compressed), 0, BITSTREAM_FORMAT_VERSION, nil)
+
+	if err != nil {
+		t.Fatalf("Cannot read block index: %v", err)
+	}
+
+	// Each block starts with the size of the encoded block (32 bits)
+	pos := int(index[2].offset >> 3)
+
+	if index[2].offset&7 != 0 {
+		t.Fatalf("Block 3 is not byte aligned")
+	}
+
+	buf := append([]byte(nil), compressed...)
+	binary.BigEndian.PutUint32(buf[pos:], 0xFFFFFFF0)
+
+	for _, jobs := range []uint{1, 4} {
+		if _, err := decompressTest(buf, Options{Jobs: jobs}); kanzi.ErrorCode(err) != kanzi.ERR_BLOCK_SIZE {
+			t.Fatalf("%d job(s): expected ERR_BLOCK_SIZE, got %v", jobs, err)
+		}
+	}
+
+	// A damaged payload is detected by the block checksum
+	buf = append([]byte(nil), compressed...)
+	buf[pos+200] ^= 0x10
+
+	for _, jobs := range []uint{1, 4} {
+		output, err := decompressTest(buf, Options{Jobs: jobs})
+
+		if err == nil {
+			t.Fatalf("%d job(s): no error for a damaged block", jobs)
+		}
+
+		// The blocks preceding the damaged block are returned
+		if len(output) > 2*32*1024 || bytes.Equal(output, data[0:len(output)]) == false {
+			t.Fatalf("%d job(s): invalid data before the damaged block", jobs)
+		}
+	}
+}
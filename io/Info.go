@@ -0,0 +1,346 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"encoding/binary"
+	"fmt"
+	kanzi "github.com/flanglet/kanzi-go"
+	"github.com/flanglet/kanzi-go/bitstream"
+	"github.com/flanglet/kanzi-go/entropy"
+	"github.com/flanglet/kanzi-go/function"
+	"io"
+	"io/ioutil"
+)
+
+// Description of a compressed stream read from the stream header and the
+// block headers only: no entropy decoding, no inverse transform, no key or
+// dictionary required (see ReadStreamInfo).
+type StreamInfo struct {
+	Version      int
+	Codec        string
+	Transform    string
+	BlockSize    uint
+	Checksum     string      // type of the block checksum or "NONE"
+	InputBlocks  int         // number of blocks announced by the encoder (0 if unknown, 63 means 63 or more)
+	Flags        uint        // HEADER_FLAG_*
+	DictionaryID uint32      // 0 if no dictionary
+	Encryption   string      // key derivation ("PASSPHRASE" or "KEY") or empty if not encrypted
+	ParityGroup  int         // number of data blocks per parity group (0 if no parity)
+	ParityBlocks int         // number of parity blocks per group
+	Metadata     *Metadata   // empty if the stream is encrypted (since version 9)
+	Blocks       []BlockInfo // nil if the blocks cannot be located
+	ContentSize  int64       // size of the decompressed data or -1 if unknown
+	Size         int64       // size of the stream in bytes or -1 if unknown
+}
+
+// Description of a block read from its header
+type BlockInfo struct {
+	Id           int
+	Offset       int64 // position in the stream in bytes (of the group with parity blocks)
+	Size         int64 // compressed size in bytes (rounded up for blocks that are not byte aligned), 0 if unknown
+	Mode         byte  // mode byte of the block header
+	SkipFlags    byte  // one bit per transform, 1 means skipped
+	Length       uint  // size of the block after entropy decoding
+	OriginalSize int64 // size of the block after decompression or -1 if unknown
+	Encrypted    bool  // the mode, skip flags and length are not available
+	Damaged      bool  // damaged block that cannot be repaired with the parity blocks
+	Repaired     bool  // damaged block repaired with the parity blocks
+}
+
+// Return true if the block is stored without transform and entropy coding
+func (this BlockInfo) IsCopy() bool {
+	return this.Mode&COPY_BLOCK_MASK != 0
+}
+
+// Read the description of the stream at the current position of the reader.
+//
+// The blocks are located by walking the stream when the blocks are
+// independent (the size of each block is provided). Otherwise, the size of a
+// block is only known after entropy decoding and the block index is used if
+// present (the reader must implement io.Seeker). Only the first stream of a
+// concatenation of streams is described. On error, the information read so
+// far is returned with the error.
+func ReadStreamInfo(is io.Reader) (info *StreamInfo, err error) {
+	rc, isCloser := is.(io.ReadCloser)
+
+	if isCloser == false {
+		rc = ioutil.NopCloser(is)
+	}
+
+	rs, isSeeker := is.(io.ReadSeeker)
+	origin := int64(0)
+
+	if isSeeker == true {
+		if origin, err = rs.Seek(0, io.SeekCurrent); err != nil {
+			return nil, WrapIOError(err, "", kanzi.ERR_READ_FILE)
+		}
+	}
+
+	ibs, err := bitstream.NewDefaultInputBitStream(rc, STREAM_DEFAULT_BUFFER_SIZE)
+
+	if err != nil {
+		return nil, WrapIOError(err, "", kanzi.ERR_CREATE_BITSTREAM)
+	}
+
+	info = &StreamInfo{Checksum: "NONE", ContentSize: -1, Size: -1}
+
+	defer func() {
+		if r := recover(); r != nil {
+			if r == io.EOF {
+				err = WrapIOError(io.ErrUnexpectedEOF, "Truncated stream", kanzi.ERR_READ_FILE)
+			} else {
+				err = toIOError(r, kanzi.ERR_READ_FILE)
+			}
+		}
+	}()
+
+	if err = readStreamInfoHeader(ibs, info); err != nil {
+		return info, err
+	}
+
+	if info.Flags&HEADER_FLAG_INDEPENDENT != 0 {
+		return info, walkBlocks(ibs, info)
+	}
+
+	if info.Flags&HEADER_FLAG_BLOCK_INDEX != 0 && isSeeker == true {
+		return info, readIndexedBlocks(rs, origin, info)
+	}
+
+	if isSeeker == true {
+		if end, err := rs.Seek(0, io.SeekEnd); err == nil {
+			info.Size = end - origin
+		}
+	}
+
+	return info, nil
+}
+
+// Read the stream header (see parseFrameHeader). The header of an encrypted
+// stream is not authenticated. Panics on read errors.
+func readStreamInfoHeader(ibs kanzi.InputBitStream, info *StreamInfo) error {
+	if ibs.ReadBits(32) != BITSTREAM_TYPE {
+		return NewIOError("Invalid stream type", kanzi.ERR_INVALID_FILE)
+	}
+
+	hdr, err := parseFrameHeader(ibs)
+	info.Version = int(hdr.version)
+
+	if hdr.blockSize > 0 {
+		info.Codec = entropy.GetName(hdr.entropyType)
+		info.Transform = function.GetName(hdr.transformType)
+		info.BlockSize = hdr.blockSize
+	}
+
+	info.InputBlocks = int(hdr.nbInputBlocks)
+	info.Flags = hdr.flags
+	info.DictionaryID = hdr.dictionaryID
+	info.ParityGroup = hdr.parityGroup
+	info.ParityBlocks = hdr.parityBlocks
+	info.Metadata = hdr.metadata
+
+	if hdr.checksum == true {
+		info.Checksum = GetChecksumName(hdr.checksumType)
+	}
+
+	if hdr.cipher != nil {
+		if hdr.cipher.kdf == ENCRYPTION_KDF_PBKDF2 {
+			info.Encryption = "PASSPHRASE"
+		} else {
+			info.Encryption = "KEY"
+		}
+	}
+
+	if info.Metadata == nil {
+		info.Metadata = NewMetadata()
+	}
+
+	return err
+}
+
+// Walk the independent blocks (size prefix) up to the end block and read the
+// trailers. Panics on read errors.
+func walkBlocks(ibs kanzi.InputBitStream, info *StreamInfo) error {
+	maxSize := 2 * (info.BlockSize + EXTRA_BUFFER_SIZE)
+	info.Blocks = make([]BlockInfo, 0)
+	var pr *parityReader
+
+	if info.ParityGroup > 0 {
+		var err error
+
+		if pr, err = newParityReader(info.ParityGroup, info.ParityBlocks); err != nil {
+			return err
+		}
+	}
+
+	for {
+		bi := BlockInfo{Id: len(info.Blocks) + 1, Offset: int64(ibs.Read() >> 3), OriginalSize: -1}
+		var size uint
+		var block []byte
+
+		if pr != nil {
+			var pb *parityBlock
+			var err *IOError
+
+			if size, pb, err = pr.next(ibs, maxSize); err != nil {
+				err.setPosition(bi.Id, bi.Offset)
+				return err
+			}
+
+			if pb != nil {
+				block = pb.data
+				bi.Offset = int64(pb.groupOffset >> 3)
+				bi.Damaged = pb.err != nil
+				bi.Repaired = pb.repaired
+			}
+		} else {
+			size = uint(ibs.ReadBits(32))
+		}
+
+		if block == nil && bi.Damaged == false {
+			if size == 0 {
+				break
+			}
+
+			if size == FLUSH_BLOCK_SIZE {
+				skipPadding(ibs)
+				continue
+			}
+
+			if size > maxSize {
+				errMsg := fmt.Sprintf("Invalid encoded block size: %d", size)
+				return NewIOError(errMsg, kanzi.ERR_BLOCK_SIZE).setPosition(bi.Id, bi.Offset)
+			}
+
+			block = make([]byte, size)
+			ibs.ReadArray(block, 8*size)
+		}
+
+		bi.Size = int64(len(block))
+
+		if info.Encryption != "" {
+			bi.Encrypted = true
+		} else if bi.Damaged == false {
+			parseBlockHeader(block, 0, &bi)
+		}
+
+		info.Blocks = append(info.Blocks, bi)
+	}
+
+	if info.Encryption != "" {
+		// Tag of the end of the frame
+		tag := make([]byte, ENCRYPTION_TAG_SIZE)
+		ibs.ReadArray(tag, 8*ENCRYPTION_TAG_SIZE)
+	}
+
+	// The trailers of encrypted streams are encrypted since version 9
+	sealed := info.Encryption != "" && info.Version >= 9
+
+	if info.Flags&HEADER_FLAG_CONTENT_HASH != 0 {
+		if sealed == true {
+			// Encrypted content size
+			trailer := make([]byte, CONTENT_TRAILER_SIZE+ENCRYPTION_TAG_SIZE)
+			ibs.ReadArray(trailer, uint(8*len(trailer)))
+		} else {
+			ibs.ReadBits(64)
+			info.ContentSize = int64(ibs.ReadBits(64))
+			ibs.ReadBits(32)
+		}
+	}
+
+	if info.Flags&HEADER_FLAG_BLOCK_INDEX != 0 {
+		skipBlockIndex(ibs, uint(info.Version), sealed)
+	}
+
+	info.Size = int64((ibs.Read() + 7) >> 3)
+	return nil
+}
+
+// Read the header of each block listed in the block index. The blocks are
+// not located if the index at the end of the reader belongs to another
+// stream (concatenated streams).
+func readIndexedBlocks(rs io.ReadSeeker, origin int64, info *StreamInfo) error {
+	index, err := readBlockIndex(rs, origin, uint(info.Version), nil)
+
+	if err != nil || index == nil {
+		return err
+	}
+
+	end, err := rs.Seek(0, io.SeekEnd)
+
+	if err != nil {
+		return WrapIOError(err, "", kanzi.ERR_READ_FILE)
+	}
+
+	info.Size = end - origin
+	info.Blocks = make([]BlockInfo, len(index))
+	info.ContentSize = 0
+	buf := make([]byte, 8)
+
+	for i, e := range index {
+		bi := &info.Blocks[i]
+		bi.Id = i + 1
+		bi.Offset = int64(e.offset >> 3)
+		bi.OriginalSize = int64(e.size)
+		bi.Size = int64(e.length)
+
+		// No size of the encoded blocks before version 9: deduced from the
+		// offset of the next block (unknown for the last block)
+		if info.Version < 9 && i+1 < len(index) {
+			bi.Size = int64((index[i+1].offset - e.offset + 7) >> 3)
+		}
+		info.ContentSize += int64(e.size)
+
+		if _, err := rs.Seek(origin+bi.Offset, io.SeekStart); err != nil {
+			return WrapIOError(err, "", kanzi.ERR_READ_FILE)
+		}
+
+		// The block header fits in 8 bytes (the last block may be shorter)
+		for j := range buf {
+			buf[j] = 0
+		}
+
+		if _, err := io.ReadFull(rs, buf); err != nil && err != io.ErrUnexpectedEOF {
+			return WrapIOError(err, "Cannot read block header", kanzi.ERR_READ_FILE).setPosition(bi.Id, bi.Offset)
+		}
+
+		parseBlockHeader(buf, uint(e.offset&7), bi)
+	}
+
+	return nil
+}
+
+// Read the mode, the skip flags and the length of a block header starting at
+// the provided bit of buf (see DecodingTask.decode())
+func parseBlockHeader(buf []byte, bitOffset uint, bi *BlockInfo) {
+	var window [8]byte
+	copy(window[:], buf)
+	bits := binary.BigEndian.Uint64(window[:]) << bitOffset
+	bi.Mode = byte(bits >> 56)
+	bits <<= 8
+
+	if bi.Mode&COPY_BLOCK_MASK == 0 {
+		if bi.Mode&TRANSFORMS_MASK != 0 {
+			bi.SkipFlags = byte(bits >> 56)
+			bits <<= 8
+		} else {
+			bi.SkipFlags = (bi.Mode << 4) | 0x0F
+		}
+	}
+
+	dataSize := 1 + uint((bi.Mode>>5)&0x03)
+	bi.Length = uint(bits >> (64 - 8*dataSize))
+}
@@ -0,0 +1,247 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	kanzi "github.com/flanglet/kanzi-go"
+)
+
+// Input that is not seekable
+type streamReader struct {
+	r io.Reader
+}
+
+func (this *streamReader) Read(buf []byte) (int, error) {
+	return this.r.Read(buf)
+}
+
+func TestStreamInfo(t *testing.T) {
+	data := testData(200000, 181)
+	dict, err := TrainDictionary(testRecords(500, 182), 4096)
+
+	if err != nil || len(dict) == 0 {
+		t.Fatalf("Cannot train dictionary: %v", err)
+	}
+
+	out := &testBuffer{}
+	opts := Options{Transform: "ROLZ", Codec: "ANS0", BlockSize: 32 * 1024, Checksum: true, ChecksumType: "XXH64",
+		IndependentBlocks: true, Dictionary: dict}
+	cos, err := NewCompressedOutputStreamWithOptions(out, opts)
+
+	if err != nil {
+		t.Fatalf("Cannot create compressed stream: %v", err)
+	}
+
+	cos.SetMetadataString(METADATA_KEY_NAME, "foo.txt")
+	cos.Write(data[0:100000])
+	cos.Flush()
+	cos.Write(data[100000:])
+
+	if err = cos.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+
+	stream := out.Bytes()
+
+	if output, err := decompressTest(stream, Options{Dictionary: dict}); err != nil || bytes.Equal(output, data) == false {
+		t.Fatalf("Round trip failed: %v", err)
+	}
+
+	// The dictionary is not required
+	info, err := ReadStreamInfo(newTestReader(stream))
+
+	if err != nil {
+		t.Fatalf("Cannot read stream info: %v", err)
+	}
+
+	if info.Version != BITSTREAM_FORMAT_VERSION || info.Codec != "ANS0" || info.Transform != "ROLZ" ||
+		info.BlockSize != 32*1024 || info.Checksum != "XXH64" || info.DictionaryID != DictionaryID(dict) ||
+		info.Flags&HEADER_FLAG_INDEPENDENT == 0 || info.Encryption != "" || info.ParityGroup != 0 {
+		t.Fatalf("Invalid stream info: %+v", info)
+	}
+
+	if name, _ := info.Metadata.GetString(METADATA_KEY_NAME); name != "foo.txt" {
+		t.Fatalf("Invalid metadata: '%s'", name)
+	}
+
+	if info.Size != int64(len(stream)) || info.ContentSize != int64(len(data)) {
+		t.Fatalf("Invalid sizes: stream %d, content %d", info.Size, info.ContentSize)
+	}
+
+	// 4 blocks before the flush, 4 after
+	if len(info.Blocks) != 8 {
+		t.Fatalf("Expected 8 blocks, got %d", len(info.Blocks))
+	}
+
+	for i, bi := range info.Blocks {
+		if bi.Id != i+1 || bi.Size == 0 || bi.Length == 0 || bi.Encrypted == true || bi.Damaged == true ||
+			(i > 0 && bi.Offset <= info.Blocks[i-1].Offset) {
+			t.Fatalf("Invalid block info: %+v", bi)
+		}
+	}
+
+	// Encrypted stream with parity blocks: the secret is not required
+	for _, opts := range []Options{{Level: 2, Passphrase: "secret"}, {Level: 2, EncryptionKey: make([]byte, ENCRYPTION_KEY_SIZE),
+		ParityGroupSize: 4, ParityBlocks: 2}} {
+		opts.BlockSize = 32 * 1024
+		stream := compressTest(t, data, opts)
+		info, err := ReadStreamInfo(newTestReader(stream))
+
+		if err != nil {
+			t.Fatalf("Cannot read stream info: %v", err)
+		}
+
+		if (opts.Passphrase != "" && info.Encryption != "PASSPHRASE") || (opts.Passphrase == "" && info.Encryption != "KEY") ||
+			info.ParityGroup != int(opts.ParityGroupSize) || info.ParityBlocks != int(opts.ParityBlocks) {
+			t.Fatalf("Invalid stream info: %+v", info)
+		}
+
+		if len(info.Blocks) != 7 || info.Blocks[0].Encrypted == false || info.Size != int64(len(stream)) {
+			t.Fatalf("Invalid blocks: %d blocks, stream of %d bytes", len(info.Blocks), info.Size)
+		}
+	}
+}
+
+func TestStreamInfoIndex(t *testing.T) {
+	data := testData(200000, 183)
+	stream := compressTest(t, data, Options{Level: 6, BlockSize: 64 * 1024, BlockIndex: true})
+	info, err := ReadStreamInfo(newTestReader(stream))
+
+	if err != nil {
+		t.Fatalf("Cannot read stream info: %v", err)
+	}
+
+	if len(info.Blocks) != 4 || info.ContentSize != int64(len(data)) || info.Size != int64(len(stream)) {
+		t.Fatalf("Invalid stream info: %d blocks, content %d, stream %d", len(info.Blocks), info.ContentSize, info.Size)
+	}
+
+	total := int64(0)
+
+	for _, bi := range info.Blocks {
+		total += bi.OriginalSize
+
+		if bi.Length == 0 || bi.Size == 0 {
+			t.Fatalf("Invalid block info: %+v", bi)
+		}
+	}
+
+	if total != int64(len(data)) {
+		t.Fatalf("Invalid size of the blocks: %d", total)
+	}
+
+	// The blocks cannot be located without the index
+	if info, err = ReadStreamInfo(&streamReader{bytes.NewReader(stream)}); err != nil || info.Blocks != nil ||
+		info.Size != -1 || info.BlockSize != 64*1024 {
+		t.Fatalf("Invalid stream info without seek: %+v, %v", info, err)
+	}
+
+	// Concatenated streams: the index at the end belongs to the last stream
+	concat := append(append([]byte(nil), stream...), stream...)
+
+	if info, err = ReadStreamInfo(newTestReader(concat)); err != nil || info.Blocks != nil || info.BlockSize != 64*1024 {
+		t.Fatalf("Invalid stream info of concatenated streams: %+v, %v", info, err)
+	}
+}
+
+// Collect the size of the encoded blocks
+type entropyListener struct {
+	sizes map[int]int64
+}
+
+func (this *entropyListener) ProcessEvent(evt *kanzi.Event) {
+	if evt.Type() == kanzi.EVT_AFTER_ENTROPY {
+		this.sizes[evt.Id()] = evt.Size()
+	}
+}
+
+func TestStreamInfoBlockSize(t *testing.T) {
+	// The size of a block followed by a flush block or by the end block and
+	// the trailers does not include them
+	data := testData(100000, 185)
+	out := &testBuffer{}
+	cos, err := NewCompressedOutputStreamWithOptions(out, Options{Level: 2, BlockSize: 32 * 1024, BlockIndex: true,
+		Checksum: true, Jobs: 1})
+
+	if err != nil {
+		t.Fatalf("Cannot create compressed stream: %v", err)
+	}
+
+	listener := &entropyListener{sizes: make(map[int]int64)}
+	cos.AddListener(listener)
+	cos.Write(data[0:50000])
+	cos.Flush()
+	cos.Write(data[50000:])
+
+	if err = cos.Close(); err != nil {
+		t.Fatalf("Compression error: %v", err)
+	}
+
+	info, err := ReadStreamInfo(newTestReader(out.Bytes()))
+
+	if err != nil || len(info.Blocks) != 4 || len(listener.sizes) != 4 {
+		t.Fatalf("Invalid stream info: %+v, %v", info, err)
+	}
+
+	for _, bi := range info.Blocks {
+		// The event reports the size in bytes rounded down
+		if encoded := listener.sizes[bi.Id]; bi.Size != encoded && bi.Size != encoded+1 {
+			t.Fatalf("Block %d: expected %d bytes, got %d", bi.Id, encoded, bi.Size)
+		}
+	}
+}
+
+func TestStreamInfoErrors(t *testing.T) {
+	stream := compressTest(t, testData(50000, 184), Options{Level: 1, BlockSize: 32 * 1024})
+
+	if _, err := ReadStreamInfo(newTestReader(stream[1:])); kanzi.ErrorCode(err) != kanzi.ERR_INVALID_FILE {
+		t.Fatalf("Invalid stream type: expected ERR_INVALID_FILE, got %v", err)
+	}
+
+	if _, err := ReadStreamInfo(newTestReader(stream[0:10])); kanzi.ErrorCode(err) != kanzi.ERR_READ_FILE {
+		t.Fatalf("Truncated header: expected ERR_READ_FILE, got %v", err)
+	}
+
+	// The decoder and ReadStreamInfo share the parser of the header
+	invalidVersion := append([]byte(nil), stream...)
+	invalidVersion[4] = (invalidVersion[4] & 0x07) | ((BITSTREAM_FORMAT_VERSION + 1) << 3)
+	encryptedFlag := append([]byte(nil), stream...)
+	encryptedFlag[17] |= HEADER_FLAG_ENCRYPTED
+	tests := []struct {
+		name    string
+		stream  []byte
+		code    int
+		version int
+	}{
+		{"Invalid version", invalidVersion, kanzi.ERR_STREAM_VERSION, BITSTREAM_FORMAT_VERSION + 1},
+		{"Encrypted blocks not independent", encryptedFlag, kanzi.ERR_INVALID_FILE, BITSTREAM_FORMAT_VERSION},
+	}
+
+	for _, test := range tests {
+		info, err := ReadStreamInfo(newTestReader(test.stream))
+
+		if kanzi.ErrorCode(err) != test.code || info == nil || info.Version != test.version {
+			t.Fatalf("%s: expected code %d, got %v", test.name, test.code, err)
+		}
+
+		if _, err = decompressTest(test.stream, Options{}); kanzi.ErrorCode(err) != test.code {
+			t.Fatalf("%s: decoder: expected code %d, got %v", test.name, test.code, err)
+		}
+	}
+}
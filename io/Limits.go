@@ -0,0 +1,110 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"fmt"
+	kanzi "github.com/flanglet/kanzi-go"
+	"github.com/flanglet/kanzi-go/entropy"
+	"github.com/flanglet/kanzi-go/function"
+)
+
+// The decoder resource limits protect against hostile streams (EG. a header
+// with a huge block size). They are set with the Options fields (or the
+// equivalent context keys):
+// - MaxBlockSize ("maxBlockSize", uint): max block size declared in the header
+// - MaxMemory ("maxMemory", uint64): max memory used by the decoding tasks.
+//   The number of concurrent tasks is reduced if needed.
+// - MaxOutputSize ("maxOutputSize", uint64): max size of the decompressed data
+// A value of 0 means no limit. Violations fail with ERR_RESOURCE_LIMIT.
+
+// Return an estimate of the memory (in bytes) used by a decoding task
+func EstimateDecodingTaskMemory(blockSize uint, entropyType uint32, transformType uint64) uint64 {
+	// Input and output buffers of the task plus the share of the stream buffer
+	res := 3 * uint64(blockSize+EXTRA_BUFFER_SIZE)
+	res += entropy.EstimateDecoderMemory(entropyType, blockSize)
+	res += function.EstimateInverseMemory(transformType, blockSize)
+	return res
+}
+
+// Check the limits against the values read from the frame header
+func (this *CompressedInputStream) checkHeaderLimits() error {
+	if this.maxBlockSize > 0 && this.blockSize > this.maxBlockSize {
+		errMsg := fmt.Sprintf("The block size (%d) exceeds the limit (%d)", this.blockSize, this.maxBlockSize)
+		return NewIOError(errMsg, kanzi.ERR_RESOURCE_LIMIT)
+	}
+
+	if this.maxMemory > 0 {
+		// At least one task is required
+		mem := EstimateDecodingTaskMemory(this.blockSize, this.entropyType, this.transformType)
+
+		if mem > this.maxMemory {
+			errMsg := fmt.Sprintf("Decoding requires about %d bytes of memory, exceeding the limit (%d)", mem, this.maxMemory)
+			return NewIOError(errMsg, kanzi.ERR_RESOURCE_LIMIT)
+		}
+	}
+
+	// The encoder writes the number of blocks rounded up (63 means 63 or more)
+	if this.maxOutputSize > 0 && this.nbInputBlocks > 1 && this.nbInputBlocks < 63 {
+		minSize := uint64(this.nbInputBlocks-1) * uint64(this.blockSize)
+
+		if this.outputSize+minSize > this.maxOutputSize {
+			errMsg := fmt.Sprintf("The decompressed size exceeds the limit (%d)", this.maxOutputSize)
+			return NewIOError(errMsg, kanzi.ERR_RESOURCE_LIMIT)
+		}
+	}
+
+	return nil
+}
+
+// Check the memory limit against the size of a parity group (read at once,
+// see Parity.go)
+func (this *CompressedInputStream) checkParityLimits(groupSize, nbParity int) error {
+	if this.maxMemory == 0 {
+		return nil
+	}
+
+	// An encoded block can be up to twice the block size
+	mem := EstimateDecodingTaskMemory(this.blockSize, this.entropyType, this.transformType)
+	mem += uint64(groupSize+nbParity) * 2 * uint64(this.blockSize)
+
+	if mem > this.maxMemory {
+		errMsg := fmt.Sprintf("Decoding requires about %d bytes of memory, exceeding the limit (%d)", mem, this.maxMemory)
+		return NewIOError(errMsg, kanzi.ERR_RESOURCE_LIMIT)
+	}
+
+	return nil
+}
+
+// Return the max number of concurrent decoding tasks allowed by the memory limit
+func (this *CompressedInputStream) maxTasks(nbTasks uint) uint {
+	if this.maxMemory == 0 {
+		return nbTasks
+	}
+
+	n := this.maxMemory / EstimateDecodingTaskMemory(this.blockSize, this.entropyType, this.transformType)
+
+	if n == 0 {
+		// Checked in checkHeaderLimits
+		n = 1
+	}
+
+	if uint64(nbTasks) > n {
+		return uint(n)
+	}
+
+	return nbTasks
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"bytes"
+	"testing"
+
+	kanzi "github.com/flanglet/kanzi-go"
+	"github.com/flanglet/kanzi-go/entropy"
+	"github.com/flanglet/kanzi-go/function"
+)
+
+func TestDecodingLimits(t *testing.T) {
+	data := testData(300000, 61)
+	blockSize := uint(64 * 1024)
+	compressed := compressTest(t, data, Options{Transform: "BWT", Codec: "ANS0", BlockSize: blockSize, Checksum: true})
+	transformType := function.GetType("BWT")
+	entropyType := entropy.GetType("ANS0")
+	taskMem := EstimateDecodingTaskMemory(blockSize, entropyType, transformType)
+
+	tests := []struct {
+		opts Options
+		fail bool
+	}{
+		{Options{MaxBlockSize: blockSize}, false},
+		{Options{MaxBlockSize: blockSize - 1}, true},
+		{Options{MaxMemory: taskMem - 1}, true},
+		{Options{MaxMemory: taskMem, Jobs: 4}, false},
+		{Options{MaxMemory: 2*taskMem + 1, Jobs: 4}, false},
+		{Options{MaxOutputSize: uint64(len(data))}, false},
+		{Options{MaxOutputSize: uint64(len(data)) - 1}, true},
+		{Options{MaxOutputSize: 100000, Jobs: 4}, true},
+	}
+
+	for i, test := range tests {
+		output, err := decompressTest(compressed, test.opts)
+
+		if test.fail == true {
+			if kanzi.ErrorCode(err) != kanzi.ERR_RESOURCE_LIMIT {
+				t.Fatalf("Test %d: expected ERR_RESOURCE_LIMIT, got %v", i, err)
+			}
+
+			if uint64(len(output)) > test.opts.MaxOutputSize && test.opts.MaxOutputSize > 0 {
+				t.Fatalf("Test %d: %d bytes decoded, exceeding the limit", i, len(output))
+			}
+		} else if err != nil || bytes.Equal(output, data) == false {
+			t.Fatalf("Test %d: round trip failed: %v", i, err)
+		}
+	}
+}
+
+func TestDecodingLimitsContext(t *testing.T) {
+	compressed := compressTest(t, testData(100000, 62), Options{Level: 2, BlockSize: 32 * 1024})
+	ctx := map[string]interface{}{"jobs": uint(1), "maxBlockSize": uint(16 * 1024)}
+	cis, err := NewCompressedInputStream(newTestReader(compressed), ctx)
+
+	if err != nil {
+		t.Fatalf("Cannot create compressed stream: %v", err)
+	}
+
+	if _, err = cis.Read(make([]byte, 1000)); kanzi.ErrorCode(err) != kanzi.ERR_RESOURCE_LIMIT {
+		t.Fatalf("Expected ERR_RESOURCE_LIMIT, got %v", err)
+	}
+
+	// Any integer type is accepted, other types and negative values are rejected
+	ctx["maxBlockSize"] = 16 * 1024
+
+	if cis, err = NewCompressedInputStream(newTestReader(compressed), ctx); err != nil {
+		t.Fatalf("Cannot create compressed stream: %v", err)
+	}
+
+	if _, err = cis.Read(make([]byte, 1000)); kanzi.ErrorCode(err) != kanzi.ERR_RESOURCE_LIMIT {
+		t.Fatalf("Expected ERR_RESOURCE_LIMIT, got %v", err)
+	}
+
+	for _, val := range []interface{}{"16K", -1} {
+		ctx["maxBlockSize"] = val
+
+		if _, err = NewCompressedInputStream(newTestReader(compressed), ctx); kanzi.ErrorCode(err) != kanzi.ERR_INVALID_PARAM {
+			t.Fatalf("Invalid value %v: expected ERR_INVALID_PARAM, got %v", val, err)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"encoding/binary"
+	"fmt"
+	kanzi "github.com/flanglet/kanzi-go"
+	"github.com/flanglet/kanzi-go/bitstream"
+	"github.com/flanglet/kanzi-go/util"
+)
+
+// The metadata section is an optional list of key/value pairs written after
+// the fixed bitstream header (when HEADER_FLAG_METADATA is set).
+// Layout (big endian, not byte aligned):
+// - 16 bits: number of entries
+// - for each entry: 8 bits key length, key, 8 bits value type,
+//   16 bits value length, value
+// The section of an encrypted stream is encrypted (see Encryption.go).
+
+const (
+	METADATA_TYPE_BYTES     = byte(0)
+	METADATA_TYPE_STRING    = byte(1)
+	METADATA_TYPE_INT       = byte(2) // 64 bits signed
+	MAX_METADATA_ENTRIES    = 0xFFFF
+	MAX_METADATA_KEY_SIZE   = 0xFF
+	MAX_METADATA_VALUE_SIZE = 0xFFFF
+
+	// Keys used by the block compressor to store file attributes
+	METADATA_KEY_NAME  = "name"  // file name (string)
+	METADATA_KEY_MODE  = "mode"  // permission bits (int)
+	METADATA_KEY_MTIME = "mtime" // modification time in ns since epoch (int)
+)
+
+type metadataEntry struct {
+	key   string
+	kind  byte
+	value []byte
+}
+
+// Metadata is a list of typed key/value pairs. The order of insertion
+// is preserved.
+type Metadata struct {
+	entries []metadataEntry
+}
+
+func NewMetadata() *Metadata {
+	this := new(Metadata)
+	this.entries = make([]metadataEntry, 0)
+	return this
+}
+
+func (this *Metadata) set(key string, kind byte, value []byte) error {
+	if len(key) == 0 || len(key) > MAX_METADATA_KEY_SIZE {
+		errMsg := fmt.Sprintf("Invalid metadata key '%s' (the size must be in [1..%d])", key, MAX_METADATA_KEY_SIZE)
+		return NewIOError(errMsg, kanzi.ERR_INVALID_PARAM)
+	}
+
+	if len(value) > MAX_METADATA_VALUE_SIZE {
+		errMsg := fmt.Sprintf("Invalid metadata value for key '%s' (the size must be at most %d)", key, MAX_METADATA_VALUE_SIZE)
+		return NewIOError(errMsg, kanzi.ERR_INVALID_PARAM)
+	}
+
+	buf := make([]byte, len(value))
+	copy(buf, value)
+
+	for i := range this.entries {
+		if this.entries[i].key == key {
+			this.entries[i].kind = kind
+			this.entries[i].value = buf
+			return nil
+		}
+	}
+
+	if len(this.entries) >= MAX_METADATA_ENTRIES {
+		return NewIOError("Too many metadata entries", kanzi.ERR_INVALID_PARAM)
+	}
+
+	this.entries = append(this.entries, metadataEntry{key: key, kind: kind, value: buf})
+	return nil
+}
+
+func (this *Metadata) get(key string, kind byte) ([]byte, bool) {
+	for i := range this.entries {
+		if this.entries[i].key == key && this.entries[i].kind == kind {
+			return this.entries[i].value, true
+		}
+	}
+
+	return nil, false
+}
+
+func (this *Metadata) SetBytes(key string, value []byte) error {
+	return this.set(key, METADATA_TYPE_BYTES, value)
+}
+
+func (this *Metadata) SetString(key string, value string) error {
+	return this.set(key, METADATA_TYPE_STRING, []byte(value))
+}
+
+func (this *Metadata) SetInt(key string, value int64) error {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(value))
+	return this.set(key, METADATA_TYPE_INT, buf[:])
+}
+
+// Return a copy of the value of the provided key if it exists and has
+// the bytes type
+func (this *Metadata) GetBytes(key string) ([]byte, bool) {
+	value, found := this.get(key, METADATA_TYPE_BYTES)
+
+	if found == false {
+		return nil, false
+	}
+
+	buf := make([]byte, len(value))
+	copy(buf, value)
+	return buf, true
+}
+
+func (this *Metadata) GetString(key string) (string, bool) {
+	value, found := this.get(key, METADATA_TYPE_STRING)
+	return string(value), found
+}
+
+func (this *Metadata) GetInt(key string) (int64, bool) {
+	value, found := this.get(key, METADATA_TYPE_INT)
+
+	if found == false || len(value) != 8 {
+		return 0, false
+	}
+
+	return int64(binary.BigEndian.Uint64(value)), true
+}
+
+func (this *Metadata) Remove(key string) bool {
+	for i := range this.entries {
+		if this.entries[i].key == key {
+			this.entries = append(this.entries[0:i], this.entries[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// Return the keys in order of insertion
+func (this *Metadata) Keys() []string {
+	keys := make([]string, len(this.entries))
+
+	for i := range this.entries {
+		keys[i] = this.entries[i].key
+	}
+
+	return keys
+}
+
+func (this *Metadata) Len() int {
+	return len(this.entries)
+}
+
+func writeMetadata(obs kanzi.OutputBitStream, md *Metadata) *IOError {
+	if obs.WriteBits(uint64(len(md.entries)), 16) != 16 {
+		return NewIOError("Cannot write number of metadata entries to header", kanzi.ERR_WRITE_FILE)
+	}
+
+	for _, e := range md.entries {
+		obs.WriteBits(uint64(len(e.key)), 8)
+		obs.WriteArray([]byte(e.key), uint(8*len(e.key)))
+		obs.WriteBits(uint64(e.kind), 8)
+
+		if obs.WriteBits(uint64(len(e.value)), 16) != 16 {
+			return NewIOError("Cannot write metadata to header", kanzi.ERR_WRITE_FILE)
+		}
+
+		if len(e.value) > 0 {
+			obs.WriteArray(e.value, uint(8*len(e.value)))
+		}
+	}
+
+	return nil
+}
+
+// Read the metadata section of the header. Panics on read errors.
+func readMetadata(ibs kanzi.InputBitStream) (*Metadata, error) {
+	md := NewMetadata()
+	count := int(ibs.ReadBits(16))
+
+	for i := 0; i < count; i++ {
+		keyLength := int(ibs.ReadBits(8))
+
+		if keyLength == 0 {
+			return nil, NewIOError("Invalid bitstream, empty metadata key", kanzi.ERR_INVALID_FILE)
+		}
+
+		key := make([]byte, keyLength)
+		ibs.ReadArray(key, uint(8*keyLength))
+		kind := byte(ibs.ReadBits(8))
+
+		if kind > METADATA_TYPE_INT {
+			errMsg := fmt.Sprintf("Invalid bitstream, unknown metadata type: %d", kind)
+			return nil, NewIOError(errMsg, kanzi.ERR_INVALID_FILE)
+		}
+
+		value := make([]byte, ibs.ReadBits(16))
+
+		if len(value) > 0 {
+			ibs.ReadArray(value, uint(8*len(value)))
+		}
+
+		md.entries = append(md.entries, metadataEntry{key: string(key), kind: kind, value: value})
+	}
+
+	return md, nil
+}
+
+// Return the serialized metadata section (see writeMetadata)
+func marshalMetadata(md *Metadata) ([]byte, error) {
+	bs := util.NewBufferStream(nil)
+	obs, err := bitstream.NewDefaultOutputBitStream(bs, 1024)
+
+	if err != nil {
+		return nil, WrapIOError(err, "", kanzi.ERR_CREATE_BITSTREAM)
+	}
+
+	if err := writeMetadata(obs, md); err != nil {
+		return nil, err
+	}
+
+	if _, err := obs.Close(); err != nil {
+		return nil, WrapIOError(err, "", kanzi.ERR_WRITE_FILE)
+	}
+
+	return bs.Bytes(), nil
+}
+
+// Parse a serialized metadata section (see readMetadata)
+func unmarshalMetadata(buf []byte) (md *Metadata, err error) {
+	ibs, err := bitstream.NewDefaultInputBitStream(util.NewBufferStream(buf), 1024)
+
+	if err != nil {
+		return nil, WrapIOError(err, "", kanzi.ERR_CREATE_BITSTREAM)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			md = nil
+			err = toIOError(r, kanzi.ERR_INVALID_FILE)
+		}
+	}()
+
+	if md, err = readMetadata(ibs); err != nil {
+		return nil, err
+	}
+
+	if ibs.Read() != uint64(8*len(buf)) {
+		return nil, NewIOError("Invalid bitstream, incorrect size of the metadata section", kanzi.ERR_INVALID_FILE)
+	}
+
+	return md, nil
+}
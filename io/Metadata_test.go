@@ -0,0 +1,107 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	kanzi "github.com/flanglet/kanzi-go"
+)
+
+func TestMetadataRoundTrip(t *testing.T) {
+	data := testData(40000, 11)
+	out := &testBuffer{}
+	cos, err := NewCompressedOutputStreamWithOptions(out, Options{Level: 2, BlockSize: 16 * 1024, Checksum: true})
+
+	if err != nil {
+		t.Fatalf("Cannot create compressed stream: %v", err)
+	}
+
+	cos.SetMetadataString(METADATA_KEY_NAME, "foo.txt")
+	cos.SetMetadataInt(METADATA_KEY_MTIME, -1234567890123)
+	cos.SetMetadataBytes("raw", []byte{0, 1, 2, 255})
+	cos.SetMetadataString("empty", "")
+	cos.Write(data)
+
+	if err = cos.SetMetadataInt("late", 1); kanzi.ErrorCode(err) != kanzi.ERR_WRITE_FILE {
+		t.Fatalf("Metadata after the header: expected ERR_WRITE_FILE, got %v", err)
+	}
+
+	if err = cos.Close(); err != nil {
+		t.Fatalf("Compression error: %v", err)
+	}
+
+	cis, _ := NewCompressedInputStreamWithOptions(newTestReader(out.Bytes()), Options{})
+	md, err := cis.Metadata()
+
+	if err != nil {
+		t.Fatalf("Cannot read metadata: %v", err)
+	}
+
+	if strings.Join(md.Keys(), ",") != "name,mtime,raw,empty" {
+		t.Fatalf("Invalid keys: %v", md.Keys())
+	}
+
+	if val, found := md.GetString(METADATA_KEY_NAME); found == false || val != "foo.txt" {
+		t.Fatalf("Invalid string value: '%v'", val)
+	}
+
+	if val, found := md.GetInt(METADATA_KEY_MTIME); found == false || val != -1234567890123 {
+		t.Fatalf("Invalid int value: %v", val)
+	}
+
+	if val, found := md.GetBytes("raw"); found == false || bytes.Equal(val, []byte{0, 1, 2, 255}) == false {
+		t.Fatalf("Invalid bytes value: %v", val)
+	}
+
+	if _, found := md.GetInt(METADATA_KEY_NAME); found == true {
+		t.Fatalf("A value of another type must not be found")
+	}
+
+	if output, err := decompressTest(out.Bytes(), Options{}); err != nil || bytes.Equal(output, data) == false {
+		t.Fatalf("Round trip failed: %v", err)
+	}
+}
+
+func TestMetadataLimits(t *testing.T) {
+	md := NewMetadata()
+
+	if err := md.SetString("", "value"); kanzi.ErrorCode(err) != kanzi.ERR_INVALID_PARAM {
+		t.Fatalf("Empty key: expected ERR_INVALID_PARAM, got %v", err)
+	}
+
+	if err := md.SetString(strings.Repeat("k", MAX_METADATA_KEY_SIZE+1), "value"); kanzi.ErrorCode(err) != kanzi.ERR_INVALID_PARAM {
+		t.Fatalf("Key too long: expected ERR_INVALID_PARAM, got %v", err)
+	}
+
+	if err := md.SetBytes("key", make([]byte, MAX_METADATA_VALUE_SIZE+1)); kanzi.ErrorCode(err) != kanzi.ERR_INVALID_PARAM {
+		t.Fatalf("Value too long: expected ERR_INVALID_PARAM, got %v", err)
+	}
+
+	md.SetInt("a", 1)
+	md.SetInt("b", 2)
+	md.SetString("a", "replaced")
+
+	if md.Len() != 2 || md.Remove("b") == false || md.Remove("b") == true {
+		t.Fatalf("Invalid entries: %v", md.Keys())
+	}
+
+	if val, _ := md.GetString("a"); val != "replaced" {
+		t.Fatalf("Invalid replaced value: '%v'", val)
+	}
+}
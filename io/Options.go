@@ -0,0 +1,595 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"fmt"
+	kanzi "github.com/flanglet/kanzi-go"
+	"github.com/flanglet/kanzi-go/entropy"
+	"github.com/flanglet/kanzi-go/function"
+	"strings"
+)
+
+const (
+	DEFAULT_BLOCK_SIZE = 1024 * 1024
+	MAX_LEVEL          = 8
+)
+
+// Options is a typed alternative to the context map of the compressed
+// streams. The zero value is valid: level 0 (no compression), blocks of
+// DEFAULT_BLOCK_SIZE and one job.
+type Options struct {
+	// Entropy codec (EG. "ANS0") and transform sequence (EG. "BWT+RANK+ZRLT").
+	// If both are empty, they are selected by Level. A missing one is "NONE".
+	Codec     string
+	Transform string
+
+	// Compression level in [0..MAX_LEVEL], used if Codec and Transform are empty
+	Level int
+
+	// Block size (multiple of 16), 0 means DEFAULT_BLOCK_SIZE
+	BlockSize uint
+
+	// Max number of concurrent tasks, 0 means 1
+	Jobs uint
+
+	// Block checksum. ChecksumType is XXH32 (default), XXH64, MURMUR3 or
+	// SIPHASH (which requires a ChecksumKey of SIPHASH_KEY_SIZE bytes).
+	Checksum     bool
+	ChecksumType string
+	ChecksumKey  []byte
+
+	// Copy the blocks with a high entropy instead of compressing them
+	SkipBlocks bool
+
+	// Size of the input if known (0 otherwise), used to optimize the decoder
+	FileSize int64
+
+	// Append a block index, encode blocks independently
+	BlockIndex        bool
+	IndependentBlocks bool
+
+	// Pre-shared dictionary (see Dictionary.go), at most MAX_DICTIONARY_SIZE
+	// bytes. The decoder must be given the same dictionary.
+	Dictionary []byte
+
+	// AES-256-GCM encryption of the blocks (see Encryption.go), with a key
+	// derived from a passphrase or from an EncryptionKey of
+	// ENCRYPTION_KEY_SIZE bytes (not both). The decoder must be given the
+	// same passphrase or key.
+	Passphrase    string
+	EncryptionKey []byte
+
+	// Reed-Solomon parity blocks (see Parity.go): ParityBlocks parity blocks
+	// are written after each group of ParityGroupSize data blocks (0 means
+	// DEFAULT_PARITY_GROUP_SIZE). No parity blocks if ParityBlocks is 0.
+	ParityGroupSize uint
+	ParityBlocks    uint
+
+	// Content-defined chunking (see Chunker.go): the blocks are cut where a
+	// rolling hash of the data matches, with an average size of ChunkSize
+	// (a power of 2). MinChunkSize defaults to ChunkSize/4 and MaxChunkSize
+	// to 4*ChunkSize (at most BlockSize). Fixed size blocks if ChunkSize is 0.
+	ChunkSize    uint
+	MinChunkSize uint
+	MaxChunkSize uint
+
+	// Decoding only: decode the first frame only, recovery mode and resource
+	// limits (0 means no limit, see Limits.go)
+	SingleFrame   bool
+	Recover       bool
+	MaxBlockSize  uint
+	MaxMemory     uint64
+	MaxOutputSize uint64
+
+	// One-shot API only (see Compress): compact format without stream header.
+	// The same Codec, Transform (or Level), checksum options and Dictionary
+	// must be provided to Decompress (the dictionary ID is not checked).
+	Headerless bool
+}
+
+// Return the transform and the entropy codec of a compression level
+func GetTransformAndCodec(level int) (string, string, error) {
+	switch level {
+	case 0:
+		return "NONE", "NONE", nil
+
+	case 1:
+		return "TEXT+LZ4", "HUFFMAN", nil
+
+	case 2:
+		return "TEXT+ROLZ", "NONE", nil
+
+	case 3:
+		return "TEXT+ROLZX", "NONE", nil
+
+	case 4:
+		return "TEXT+BWT+RANK+ZRLT", "ANS0", nil
+
+	case 5:
+		return "TEXT+BWT+RANK+ZRLT", "FPAQ", nil
+
+	case 6:
+		return "BWT", "CM", nil
+
+	case 7:
+		return "X86+RLT+TEXT", "TPAQ", nil
+
+	case 8:
+		return "X86+RLT+TEXT", "TPAQX", nil
+
+	default:
+		errMsg := fmt.Sprintf("Invalid compression level: %d (must be in [0..%d])", level, MAX_LEVEL)
+		return "", "", NewIOError(errMsg, kanzi.ERR_INVALID_PARAM)
+	}
+}
+
+func invalidOption(field string, format string, args ...interface{}) *IOError {
+	errMsg := fmt.Sprintf("Invalid option "+field+": "+format, args...)
+	return NewIOError(errMsg, kanzi.ERR_INVALID_PARAM)
+}
+
+// Check the options and replace default values (EG. 0 jobs) with actual
+// values. The codec and transform names are normalized (EG. "bwt+none"
+// becomes "BWT"). The error message names the invalid field.
+func (this *Options) Validate() error {
+	if this.Jobs == 0 {
+		this.Jobs = 1
+	}
+
+	if this.Jobs > MAX_CONCURRENCY {
+		return invalidOption("Jobs", "%d (must be in [1..%d])", this.Jobs, MAX_CONCURRENCY)
+	}
+
+	if this.ChecksumKey != nil && len(this.ChecksumKey) != SIPHASH_KEY_SIZE {
+		return invalidOption("ChecksumKey", "the key must have %d bytes (got %d)", SIPHASH_KEY_SIZE, len(this.ChecksumKey))
+	}
+
+	if len(this.Dictionary) > MAX_DICTIONARY_SIZE {
+		return invalidOption("Dictionary", "%d bytes (must be at most %d)", len(this.Dictionary), MAX_DICTIONARY_SIZE)
+	}
+
+	if len(this.Passphrase) > 0 && len(this.EncryptionKey) > 0 {
+		return invalidOption("EncryptionKey", "cannot be combined with Passphrase")
+	}
+
+	if this.EncryptionKey != nil && len(this.EncryptionKey) != ENCRYPTION_KEY_SIZE {
+		return invalidOption("EncryptionKey", "the key must have %d bytes (got %d)", ENCRYPTION_KEY_SIZE, len(this.EncryptionKey))
+	}
+
+	if this.Headerless == true && this.encrypted() == true {
+		return invalidOption("Headerless", "cannot be combined with encryption")
+	}
+
+	if this.ParityBlocks > 0 {
+		if this.ParityGroupSize == 0 {
+			this.ParityGroupSize = DEFAULT_PARITY_GROUP_SIZE
+		}
+
+		if this.ParityGroupSize > MAX_PARITY_GROUP_SIZE {
+			return invalidOption("ParityGroupSize", "%d (must be in [1..%d])", this.ParityGroupSize, MAX_PARITY_GROUP_SIZE)
+		}
+
+		if this.ParityBlocks > MAX_PARITY_BLOCKS {
+			return invalidOption("ParityBlocks", "%d (must be in [0..%d])", this.ParityBlocks, MAX_PARITY_BLOCKS)
+		}
+
+		if this.Headerless == true {
+			return invalidOption("Headerless", "cannot be combined with parity blocks")
+		}
+	}
+
+	if len(this.Codec) == 0 && len(this.Transform) == 0 {
+		var err error
+
+		if this.Transform, this.Codec, err = GetTransformAndCodec(this.Level); err != nil {
+			return invalidOption("Level", "%d (must be in [0..%d])", this.Level, MAX_LEVEL)
+		}
+	} else if this.Level != 0 {
+		// Accept the values of the level (options already validated)
+		transform, codec, err := GetTransformAndCodec(this.Level)
+
+		if err != nil || strings.EqualFold(transform, this.Transform) == false ||
+			strings.EqualFold(codec, this.Codec) == false {
+			return invalidOption("Level", "cannot be combined with Codec or Transform")
+		}
+	}
+
+	if len(this.Codec) == 0 {
+		this.Codec = "NONE"
+	}
+
+	if len(this.Transform) == 0 {
+		this.Transform = "NONE"
+	}
+
+	// The factories panic on unknown names (the error is kept as the cause)
+	if err := checkName(func() { this.Codec = entropy.GetName(entropy.GetType(this.Codec)) }); err != nil {
+		res := invalidOption("Codec", "%v", err)
+		res.cause = err
+		return res
+	}
+
+	if err := checkName(func() { this.Transform = function.GetName(function.GetType(this.Transform)) }); err != nil {
+		res := invalidOption("Transform", "%v", err)
+		res.cause = err
+		return res
+	}
+
+	if this.BlockSize == 0 {
+		this.BlockSize = DEFAULT_BLOCK_SIZE
+	}
+
+	if this.BlockSize < MIN_BITSTREAM_BLOCK_SIZE || this.BlockSize > MAX_BITSTREAM_BLOCK_SIZE {
+		return invalidOption("BlockSize", "%d (must be in [%d..%d])", this.BlockSize, MIN_BITSTREAM_BLOCK_SIZE, MAX_BITSTREAM_BLOCK_SIZE)
+	}
+
+	if this.BlockSize&15 != 0 {
+		return invalidOption("BlockSize", "%d (must be a multiple of 16)", this.BlockSize)
+	}
+
+	if this.ChunkSize > 0 {
+		if this.ChunkSize < MIN_CHUNK_SIZE || this.ChunkSize&(this.ChunkSize-1) != 0 {
+			return invalidOption("ChunkSize", "%d (must be a power of 2, at least %d)", this.ChunkSize, MIN_CHUNK_SIZE)
+		}
+
+		if this.MinChunkSize == 0 {
+			this.MinChunkSize = this.ChunkSize / 4
+		}
+
+		if this.MaxChunkSize == 0 {
+			this.MaxChunkSize = 4 * this.ChunkSize
+
+			if this.MaxChunkSize > this.BlockSize {
+				this.MaxChunkSize = this.BlockSize
+			}
+		}
+
+		if this.MinChunkSize >= this.ChunkSize {
+			return invalidOption("MinChunkSize", "%d (must be less than ChunkSize)", this.MinChunkSize)
+		}
+
+		if this.MaxChunkSize <= this.ChunkSize || this.MaxChunkSize > this.BlockSize {
+			return invalidOption("MaxChunkSize", "%d (must be greater than ChunkSize and at most BlockSize)", this.MaxChunkSize)
+		}
+
+		if this.Headerless == true {
+			return invalidOption("Headerless", "cannot be combined with content-defined chunking")
+		}
+	}
+
+	if this.FileSize < 0 {
+		return invalidOption("FileSize", "%d (must be positive)", this.FileSize)
+	}
+
+	if this.Checksum == true {
+		if len(this.ChecksumType) == 0 {
+			this.ChecksumType = GetChecksumName(CHECKSUM_XXHASH32)
+		}
+
+		checksumType, err := GetChecksumType(this.ChecksumType)
+
+		if err != nil {
+			return invalidOption("ChecksumType", "'%s'", this.ChecksumType)
+		}
+
+		if checksumType == CHECKSUM_SIPHASH && this.ChecksumKey == nil {
+			return invalidOption("ChecksumKey", "a key is required by the SIPHASH checksum")
+		}
+	}
+
+	return nil
+}
+
+func checkName(f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, isErr := r.(error); isErr == true {
+				err = e
+			} else {
+				err = fmt.Errorf("%v", r)
+			}
+		}
+	}()
+
+	f()
+	return nil
+}
+
+// Write the (validated) options to a context map, as expected by the
+// transform and entropy codec factories
+func (this *Options) updateContext(ctx map[string]interface{}, decoding bool) {
+	ctx["jobs"] = this.Jobs
+
+	if this.ChecksumKey != nil {
+		ctx["checksumKey"] = this.ChecksumKey
+	}
+
+	if len(this.Dictionary) > 0 {
+		ctx["dictionary"] = this.Dictionary
+	}
+
+	if len(this.Passphrase) > 0 {
+		ctx["passphrase"] = this.Passphrase
+	}
+
+	if this.EncryptionKey != nil {
+		ctx["encryptionKey"] = this.EncryptionKey
+	}
+
+	if decoding == true {
+		ctx["singleFrame"] = this.SingleFrame
+		ctx["recover"] = this.Recover
+		ctx["maxBlockSize"] = this.MaxBlockSize
+		ctx["maxMemory"] = this.MaxMemory
+		ctx["maxOutputSize"] = this.MaxOutputSize
+		return
+	}
+
+	ctx["codec"] = this.Codec
+	ctx["transform"] = this.Transform
+	ctx["blockSize"] = this.BlockSize
+	ctx["checksum"] = this.Checksum
+	ctx["checksumType"] = this.ChecksumType
+	ctx["skipBlocks"] = this.SkipBlocks
+	ctx["fileSize"] = this.FileSize
+	ctx["blockIndex"] = this.BlockIndex
+	ctx["independentBlocks"] = this.IndependentBlocks
+	ctx["parityGroupSize"] = this.ParityGroupSize
+	ctx["parityBlocks"] = this.ParityBlocks
+	ctx["chunkSize"] = this.ChunkSize
+	ctx["minChunkSize"] = this.MinChunkSize
+	ctx["maxChunkSize"] = this.MaxChunkSize
+
+	if _, prst := ctx["extra"]; prst == false {
+		ctx["extra"] = this.Codec == "TPAQX"
+	}
+}
+
+// Build options from a context map. Missing keys take default values and
+// values of the wrong type are reported with the name of the key.
+func newOptionsFromContext(ctx map[string]interface{}, decoding bool) (*Options, error) {
+	opts := &Options{}
+	var err error
+	var val uint64
+
+	if val, err = contextUint(ctx, "jobs"); err != nil {
+		return nil, err
+	}
+
+	opts.Jobs = uint(val)
+
+	if key, prst := ctx["checksumKey"]; prst == true {
+		var isBytes bool
+
+		if opts.ChecksumKey, isBytes = key.([]byte); isBytes == false {
+			return nil, invalidContextValue("checksumKey", "[]byte", key)
+		}
+	}
+
+	if dict, prst := ctx["dictionary"]; prst == true {
+		var isBytes bool
+
+		if opts.Dictionary, isBytes = dict.([]byte); isBytes == false {
+			return nil, invalidContextValue("dictionary", "[]byte", dict)
+		}
+	}
+
+	// Not trimmed (see contextString)
+	if pass, prst := ctx["passphrase"]; prst == true {
+		var isString bool
+
+		if opts.Passphrase, isString = pass.(string); isString == false {
+			return nil, invalidContextValue("passphrase", "string", pass)
+		}
+	}
+
+	if key, prst := ctx["encryptionKey"]; prst == true {
+		var isBytes bool
+
+		if opts.EncryptionKey, isBytes = key.([]byte); isBytes == false {
+			return nil, invalidContextValue("encryptionKey", "[]byte", key)
+		}
+	}
+
+	if decoding == true {
+		if opts.SingleFrame, err = contextBool(ctx, "singleFrame"); err != nil {
+			return nil, err
+		}
+
+		if opts.Recover, err = contextBool(ctx, "recover"); err != nil {
+			return nil, err
+		}
+
+		if val, err = contextUint(ctx, "maxBlockSize"); err != nil {
+			return nil, err
+		}
+
+		opts.MaxBlockSize = uint(val)
+
+		if opts.MaxMemory, err = contextUint(ctx, "maxMemory"); err != nil {
+			return nil, err
+		}
+
+		if opts.MaxOutputSize, err = contextUint(ctx, "maxOutputSize"); err != nil {
+			return nil, err
+		}
+
+		return opts, nil
+	}
+
+	if opts.Codec, err = contextString(ctx, "codec"); err != nil {
+		return nil, err
+	}
+
+	if opts.Transform, err = contextString(ctx, "transform"); err != nil {
+		return nil, err
+	}
+
+	if len(opts.Codec) == 0 && len(opts.Transform) == 0 {
+		if _, prst := ctx["level"]; prst == false {
+			return nil, NewIOError("Missing context value for 'codec'", kanzi.ERR_MISSING_PARAM)
+		}
+
+		if val, err = contextUint(ctx, "level"); err != nil {
+			return nil, err
+		}
+
+		opts.Level = int(val)
+	}
+
+	if val, err = contextUint(ctx, "blockSize"); err != nil {
+		return nil, err
+	}
+
+	opts.BlockSize = uint(val)
+
+	if val, err = contextUint(ctx, "fileSize"); err != nil {
+		return nil, err
+	}
+
+	opts.FileSize = int64(val)
+
+	if opts.Checksum, err = contextBool(ctx, "checksum"); err != nil {
+		return nil, err
+	}
+
+	if opts.ChecksumType, err = contextString(ctx, "checksumType"); err != nil {
+		return nil, err
+	}
+
+	if opts.SkipBlocks, err = contextBool(ctx, "skipBlocks"); err != nil {
+		return nil, err
+	}
+
+	if opts.BlockIndex, err = contextBool(ctx, "blockIndex"); err != nil {
+		return nil, err
+	}
+
+	if opts.IndependentBlocks, err = contextBool(ctx, "independentBlocks"); err != nil {
+		return nil, err
+	}
+
+	if val, err = contextUint(ctx, "parityGroupSize"); err != nil {
+		return nil, err
+	}
+
+	opts.ParityGroupSize = uint(val)
+
+	if val, err = contextUint(ctx, "parityBlocks"); err != nil {
+		return nil, err
+	}
+
+	opts.ParityBlocks = uint(val)
+
+	if val, err = contextUint(ctx, "chunkSize"); err != nil {
+		return nil, err
+	}
+
+	opts.ChunkSize = uint(val)
+
+	if val, err = contextUint(ctx, "minChunkSize"); err != nil {
+		return nil, err
+	}
+
+	opts.MinChunkSize = uint(val)
+
+	if val, err = contextUint(ctx, "maxChunkSize"); err != nil {
+		return nil, err
+	}
+
+	opts.MaxChunkSize = uint(val)
+
+	return opts, nil
+}
+
+func invalidContextValue(key string, expected string, val interface{}) *IOError {
+	errMsg := fmt.Sprintf("Invalid context value for '%s': %s expected, got %T", key, expected, val)
+	return NewIOError(errMsg, kanzi.ERR_INVALID_PARAM)
+}
+
+// Return the value of a key of any integer type (0 if missing)
+func contextUint(ctx map[string]interface{}, key string) (uint64, error) {
+	val, prst := ctx[key]
+
+	if prst == false {
+		return 0, nil
+	}
+
+	var res int64
+
+	switch v := val.(type) {
+	case uint:
+		return uint64(v), nil
+	case uint64:
+		return v, nil
+	case uint32:
+		return uint64(v), nil
+	case uint16:
+		return uint64(v), nil
+	case uint8:
+		return uint64(v), nil
+	case int:
+		res = int64(v)
+	case int64:
+		res = v
+	case int32:
+		res = int64(v)
+	case int16:
+		res = int64(v)
+	case int8:
+		res = int64(v)
+	default:
+		return 0, invalidContextValue(key, "integer", val)
+	}
+
+	if res < 0 {
+		errMsg := fmt.Sprintf("Invalid context value for '%s': %d (must be positive)", key, res)
+		return 0, NewIOError(errMsg, kanzi.ERR_INVALID_PARAM)
+	}
+
+	return uint64(res), nil
+}
+
+// Return the value of a boolean key (false if missing)
+func contextBool(ctx map[string]interface{}, key string) (bool, error) {
+	val, prst := ctx[key]
+
+	if prst == false {
+		return false, nil
+	}
+
+	if b, isBool := val.(bool); isBool == true {
+		return b, nil
+	}
+
+	return false, invalidContextValue(key, "bool", val)
+}
+
+// Return the value of a string key (empty if missing)
+func contextString(ctx map[string]interface{}, key string) (string, error) {
+	val, prst := ctx[key]
+
+	if prst == false {
+		return "", nil
+	}
+
+	if s, isString := val.(string); isString == true {
+		return strings.TrimSpace(s), nil
+	}
+
+	return "", invalidContextValue(key, "string", val)
+}
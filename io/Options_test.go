@@ -0,0 +1,150 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	kanzi "github.com/flanglet/kanzi-go"
+)
+
+func TestOptionsDefaults(t *testing.T) {
+	opts := Options{Transform: "bwt+none", Codec: "ans0"}
+
+	if err := opts.Validate(); err != nil {
+		t.Fatalf("Validation error: %v", err)
+	}
+
+	if opts.Jobs != 1 || opts.BlockSize != DEFAULT_BLOCK_SIZE || opts.Transform != "BWT" || opts.Codec != "ANS0" {
+		t.Fatalf("Invalid default values: %+v", opts)
+	}
+
+	for level := 0; level <= MAX_LEVEL; level++ {
+		opts = Options{Level: level, Checksum: true}
+
+		if err := opts.Validate(); err != nil {
+			t.Fatalf("Level %d: validation error: %v", level, err)
+		}
+
+		transform, codec, _ := GetTransformAndCodec(level)
+
+		if opts.Transform != transform || opts.Codec != codec || opts.ChecksumType != "XXH32" {
+			t.Fatalf("Level %d: invalid values: %+v", level, opts)
+		}
+
+		// The values of the level are accepted with the level
+		if err := opts.Validate(); err != nil {
+			t.Fatalf("Level %d: second validation error: %v", level, err)
+		}
+	}
+
+	opts = Options{ChunkSize: 8192, BlockSize: 16384}
+
+	if err := opts.Validate(); err != nil || opts.MinChunkSize != 2048 || opts.MaxChunkSize != 16384 {
+		t.Fatalf("Invalid chunk sizes: %+v, %v", opts, err)
+	}
+}
+
+func TestOptionsErrors(t *testing.T) {
+	tests := []struct {
+		opts  Options
+		field string
+	}{
+		{Options{Jobs: MAX_CONCURRENCY + 1}, "Jobs"},
+		{Options{Level: MAX_LEVEL + 1}, "Level"},
+		{Options{Level: 2, Codec: "HUFFMAN"}, "Level"},
+		{Options{Codec: "FOO"}, "Codec"},
+		{Options{Transform: "BWT+FOO"}, "Transform"},
+		{Options{BlockSize: 1000}, "BlockSize"},
+		{Options{BlockSize: 1024*1024 + 8}, "BlockSize"},
+		{Options{ChecksumKey: []byte("short")}, "ChecksumKey"},
+		{Options{Checksum: true, ChecksumType: "CRC99"}, "ChecksumType"},
+		{Options{Checksum: true, ChecksumType: "SIPHASH"}, "ChecksumKey"},
+		{Options{FileSize: -1}, "FileSize"},
+		{Options{ChunkSize: 5000}, "ChunkSize"},
+		{Options{ChunkSize: 8192, MinChunkSize: 8192}, "MinChunkSize"},
+		{Options{ChunkSize: 8192, MaxChunkSize: 8192}, "MaxChunkSize"},
+		{Options{ParityBlocks: 1, ParityGroupSize: MAX_PARITY_GROUP_SIZE + 1}, "ParityGroupSize"},
+		{Options{ParityBlocks: MAX_PARITY_BLOCKS + 1}, "ParityBlocks"},
+		{Options{Passphrase: "abc", EncryptionKey: make([]byte, ENCRYPTION_KEY_SIZE)}, "EncryptionKey"},
+		{Options{EncryptionKey: make([]byte, 5)}, "EncryptionKey"},
+		{Options{Headerless: true, Passphrase: "abc"}, "Headerless"},
+		{Options{Dictionary: make([]byte, MAX_DICTIONARY_SIZE+1)}, "Dictionary"},
+	}
+
+	for i, test := range tests {
+		err := test.opts.Validate()
+
+		if kanzi.ErrorCode(err) != kanzi.ERR_INVALID_PARAM {
+			t.Fatalf("Test %d: expected ERR_INVALID_PARAM, got %v", i, err)
+		}
+
+		if strings.Contains(err.Error(), "option "+test.field+":") == false {
+			t.Fatalf("Test %d: the field %s is not reported: %v", i, test.field, err)
+		}
+
+		// The constructors validate the options
+		if _, err = NewCompressedOutputStreamWithOptions(&testBuffer{}, test.opts); kanzi.ErrorCode(err) != kanzi.ERR_INVALID_PARAM {
+			t.Fatalf("Test %d: expected ERR_INVALID_PARAM, got %v", i, err)
+		}
+	}
+}
+
+func TestOptionsContext(t *testing.T) {
+	data := testData(100000, 71)
+	opts := Options{Transform: "LZ4", Codec: "HUFFMAN", BlockSize: 32 * 1024, Checksum: true, BlockIndex: true}
+	expected := compressTest(t, data, opts)
+
+	// A context map and the equivalent options produce the same stream
+	ctx := map[string]interface{}{"transform": "LZ4", "codec": "HUFFMAN", "blockSize": uint(32 * 1024),
+		"jobs": uint(1), "checksum": true, "blockIndex": true}
+	out := &testBuffer{}
+	cos, err := NewCompressedOutputStream(out, ctx)
+
+	if err != nil {
+		t.Fatalf("Cannot create compressed stream: %v", err)
+	}
+
+	if _, err = cos.Write(data); err != nil {
+		t.Fatalf("Write error: %v", err)
+	}
+
+	if err = cos.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+
+	if bytes.Equal(out.Bytes(), expected) == false {
+		t.Fatalf("Different streams with a context map and options")
+	}
+
+	// Values of the wrong type are reported with the name of the key
+	for key, val := range map[string]interface{}{"blockSize": "32K", "checksum": 1, "transform": 3, "jobs": -2} {
+		badCtx := map[string]interface{}{}
+
+		for k, v := range ctx {
+			badCtx[k] = v
+		}
+
+		badCtx[key] = val
+
+		if _, err = NewCompressedOutputStream(&testBuffer{}, badCtx); kanzi.ErrorCode(err) != kanzi.ERR_INVALID_PARAM ||
+			strings.Contains(err.Error(), "'"+key+"'") == false {
+			t.Fatalf("Invalid value for %s: expected ERR_INVALID_PARAM, got %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,514 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"encoding/binary"
+	"fmt"
+	kanzi "github.com/flanglet/kanzi-go"
+	"github.com/flanglet/kanzi-go/util/hash"
+)
+
+// Parity blocks (HEADER_FLAG_PARITY) protect the stream against corruption
+// (EG. archival on tape). The encoded blocks (independent block layout) are
+// written by group of N data blocks followed by K parity blocks computed with
+// a Reed-Solomon (Cauchy) code over GF(256). The decoder detects the damaged
+// blocks of a group with their hash and rebuilds up to K of them.
+//
+// Group (replaces the size prefix of each independent block):
+//   header, written twice (the first valid copy is used):
+//     32 bits         PARITY_GROUP_TYPE
+//     8 bits          number of data blocks M (M <= N, less for the last group)
+//     32 bits         size S of the parity blocks (largest data block)
+//     N x 32 bits     size of the data blocks (0 after M)
+//     (N+K) x 32 bits hash of the data and parity blocks (0 after M)
+//     32 bits         hash of the header
+//   M data blocks
+//   K parity blocks of S bytes
+// The end block (32 bits 0) and the flush blocks (32 bits FLUSH_BLOCK_SIZE)
+// are written between groups. A group with a damaged header cannot be
+// repaired.
+
+const (
+	PARITY_GROUP_TYPE         = 0x4B475250 // "KGRP"
+	DEFAULT_PARITY_GROUP_SIZE = 8          // data blocks per group
+	DEFAULT_PARITY_BLOCKS     = 2          // parity blocks per group
+	MAX_PARITY_GROUP_SIZE     = 128
+	MAX_PARITY_BLOCKS         = 32
+)
+
+var (
+	gfExp [512]byte // GF(256) with polynomial 0x11D
+	gfLog [256]byte
+)
+
+func init() {
+	x := 1
+
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = byte(i)
+		x <<= 1
+
+		if x&0x100 != 0 {
+			x ^= 0x11D
+		}
+	}
+
+	for i := 255; i < len(gfExp); i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfInv(a byte) byte {
+	return gfExp[255-int(gfLog[a])]
+}
+
+// dst ^= c * src
+func gfMulAdd(dst, src []byte, c byte) {
+	if c == 0 {
+		return
+	}
+
+	var table [256]byte
+	lc := int(gfLog[c])
+
+	for i := 1; i < 256; i++ {
+		table[i] = gfExp[lc+int(gfLog[i])]
+	}
+
+	for i, b := range src {
+		dst[i] ^= table[b]
+	}
+}
+
+// Coefficient of data block i in parity block j: Cauchy matrix 1/(x_j+y_i)
+// with x_j = j and y_i = nbParity+i. Any square sub-matrix is invertible.
+func parityCoef(j, i, nbParity int) byte {
+	return gfInv(byte(j) ^ byte(nbParity+i))
+}
+
+// Size in bytes of one copy of the group header
+func parityHeaderSize(groupSize, nbParity int) int {
+	return 4 + 1 + 4 + 4*groupSize + 4*(groupSize+nbParity) + 4
+}
+
+// Collect the encoded blocks of a group and write the group with its parity
+// blocks. Used in the sequential part of the encoding tasks.
+type parityWriter struct {
+	groupSize int
+	nbParity  int
+	blocks    [][]byte
+	offsets   []uint64 // position of the group of each block (block index)
+	hasher    *hash.XXHash32
+}
+
+func newParityWriter(groupSize, nbParity int) (*parityWriter, error) {
+	this := &parityWriter{groupSize: groupSize, nbParity: nbParity}
+	this.blocks = make([][]byte, 0, groupSize)
+	this.offsets = make([]uint64, 0)
+	var err error
+
+	if this.hasher, err = hash.NewXXHash32(BITSTREAM_TYPE); err != nil {
+		return nil, err
+	}
+
+	return this, nil
+}
+
+// Add an encoded block, the group is written once complete
+func (this *parityWriter) add(obs kanzi.OutputBitStream, block []byte) *IOError {
+	this.blocks = append(this.blocks, block)
+
+	if len(this.blocks) < this.groupSize {
+		return nil
+	}
+
+	return this.flush(obs)
+}
+
+// Write the pending blocks (if any) as a group
+func (this *parityWriter) flush(obs kanzi.OutputBitStream) *IOError {
+	m := len(this.blocks)
+
+	if m == 0 {
+		return nil
+	}
+
+	shardSize := 0
+
+	for _, b := range this.blocks {
+		if len(b) > shardSize {
+			shardSize = len(b)
+		}
+	}
+
+	// The data blocks are padded with zeros
+	parity := make([][]byte, this.nbParity)
+
+	for j := range parity {
+		parity[j] = make([]byte, shardSize)
+
+		for i, b := range this.blocks {
+			gfMulAdd(parity[j], b, parityCoef(j, i, this.nbParity))
+		}
+	}
+
+	header := make([]byte, parityHeaderSize(this.groupSize, this.nbParity))
+	binary.BigEndian.PutUint32(header[0:], PARITY_GROUP_TYPE)
+	header[4] = byte(m)
+	binary.BigEndian.PutUint32(header[5:], uint32(shardSize))
+	idx := 9
+
+	for i := 0; i < this.groupSize; i++ {
+		if i < m {
+			binary.BigEndian.PutUint32(header[idx:], uint32(len(this.blocks[i])))
+		}
+
+		idx += 4
+	}
+
+	for i := 0; i < this.groupSize+this.nbParity; i++ {
+		if i < m {
+			binary.BigEndian.PutUint32(header[idx:], this.hasher.Hash(this.blocks[i]))
+		} else if i >= this.groupSize {
+			binary.BigEndian.PutUint32(header[idx:], this.hasher.Hash(parity[i-this.groupSize]))
+		}
+
+		idx += 4
+	}
+
+	binary.BigEndian.PutUint32(header[idx:], this.hasher.Hash(header[0:idx]))
+	offset := obs.Written()
+
+	for i := 0; i < 2; i++ {
+		if obs.WriteArray(header, uint(8*len(header))) != uint(8*len(header)) {
+			return NewIOError("Cannot write parity group header", kanzi.ERR_WRITE_FILE)
+		}
+	}
+
+	for _, b := range this.blocks {
+		obs.WriteArray(b, uint(8*len(b)))
+		this.offsets = append(this.offsets, offset)
+	}
+
+	for _, p := range parity {
+		obs.WriteArray(p, uint(8*len(p)))
+	}
+
+	this.blocks = this.blocks[:0]
+	return nil
+}
+
+// Set the position of the blocks in the block index: the position of their
+// group (the decoder reads the whole group)
+func (this *parityWriter) updateIndex(index []blockIndexEntry) {
+	for i := range index {
+		if i < len(this.offsets) {
+			index[i].offset = this.offsets[i]
+		}
+	}
+}
+
+// A block read from a group
+type parityBlock struct {
+	data        []byte
+	err         *IOError // damaged block that could not be repaired
+	repaired    bool
+	groupOffset uint64 // position of the group in the bitstream (in bits)
+	groupIdx    int    // position of the block in the group
+}
+
+// Read the groups and return their blocks one at a time. Used in the
+// sequential part of the decoding tasks.
+type parityReader struct {
+	groupSize int
+	nbParity  int
+	blocks    []parityBlock // remaining blocks of the current group
+	skip      int           // number of blocks to drop from the next group (random access)
+	hasher    *hash.XXHash32
+}
+
+func newParityReader(groupSize, nbParity int) (*parityReader, error) {
+	this := &parityReader{groupSize: groupSize, nbParity: nbParity}
+	var err error
+
+	if this.hasher, err = hash.NewXXHash32(BITSTREAM_TYPE); err != nil {
+		return nil, err
+	}
+
+	return this, nil
+}
+
+// Drop the current group. The next group is read from a new position and
+// the first blocks (skip) are dropped.
+func (this *parityReader) reset(skip int) {
+	this.blocks = nil
+	this.skip = skip
+}
+
+// Return the next block or the end block / flush block marker (size 0 or
+// FLUSH_BLOCK_SIZE, nil block). A damaged block that cannot be repaired is
+// returned with an error. The max size of a block is provided.
+func (this *parityReader) next(ibs kanzi.InputBitStream, maxSize uint) (uint, *parityBlock, *IOError) {
+	for len(this.blocks) == 0 {
+		offset := ibs.Read()
+		marker := uint(ibs.ReadBits(32))
+
+		if marker == 0 || marker == FLUSH_BLOCK_SIZE {
+			return marker, nil, nil
+		}
+
+		if err := this.readGroup(ibs, offset, marker, maxSize); err != nil {
+			return 0, nil, err
+		}
+
+		if this.skip > 0 {
+			n := this.skip
+
+			if n > len(this.blocks) {
+				n = len(this.blocks)
+			}
+
+			this.blocks = this.blocks[n:]
+			this.skip -= n
+		}
+	}
+
+	pb := &this.blocks[0]
+	this.blocks = this.blocks[1:]
+	return uint(len(pb.data)), pb, nil
+}
+
+// Read a group (the first 32 bits of the header have been read), check the
+// blocks and rebuild the damaged ones
+func (this *parityReader) readGroup(ibs kanzi.InputBitStream, offset uint64, first uint, maxSize uint) *IOError {
+	size := parityHeaderSize(this.groupSize, this.nbParity)
+	headers := make([]byte, 2*size)
+	binary.BigEndian.PutUint32(headers, uint32(first))
+	ibs.ReadArray(headers[4:], uint(8*(2*size-4)))
+	var header []byte
+
+	for i := 0; i < 2; i++ {
+		h := headers[i*size : (i+1)*size]
+
+		if binary.BigEndian.Uint32(h[0:]) == PARITY_GROUP_TYPE &&
+			binary.BigEndian.Uint32(h[size-4:]) == this.hasher.Hash(h[0:size-4]) {
+			header = h
+			break
+		}
+	}
+
+	if header == nil {
+		return NewIOError("Corrupted bitstream: invalid parity group header", kanzi.ERR_CRC_CHECK)
+	}
+
+	m := int(header[4])
+	shardSize := uint(binary.BigEndian.Uint32(header[5:]))
+
+	if m == 0 || m > this.groupSize || shardSize == 0 || shardSize > maxSize {
+		return NewIOError("Invalid bitstream: incorrect parity group header", kanzi.ERR_INVALID_FILE)
+	}
+
+	shards := make([][]byte, m+this.nbParity)
+	damaged := make([]bool, len(shards))
+	sizes := make([]int, m)
+	nbDamaged := 0
+
+	for i := range shards {
+		n := shardSize
+
+		if i < m {
+			n = uint(binary.BigEndian.Uint32(header[9+4*i:]))
+
+			if n == 0 || n > shardSize {
+				return NewIOError("Invalid bitstream: incorrect parity group header", kanzi.ERR_INVALID_FILE)
+			}
+
+			sizes[i] = int(n)
+		}
+
+		// Allocate full size shards to rebuild the data blocks in place
+		shards[i] = make([]byte, shardSize)
+		ibs.ReadArray(shards[i], 8*n)
+		h := 9 + 4*this.groupSize
+
+		if i < m {
+			h += 4 * i
+		} else {
+			h += 4 * (this.groupSize + i - m)
+		}
+
+		if this.hasher.Hash(shards[i][0:n]) != binary.BigEndian.Uint32(header[h:]) {
+			damaged[i] = true
+
+			if i < m {
+				nbDamaged++
+			}
+		}
+	}
+
+	this.blocks = make([]parityBlock, m)
+
+	for i := range this.blocks {
+		this.blocks[i] = parityBlock{groupOffset: offset, groupIdx: i}
+	}
+
+	if nbDamaged > 0 {
+		this.repair(shards, damaged, m)
+	}
+
+	for i := range this.blocks {
+		pb := &this.blocks[i]
+		pb.data = shards[i][0:sizes[i]]
+
+		if damaged[i] == true {
+			if this.hasher.Hash(pb.data) == binary.BigEndian.Uint32(header[9+4*this.groupSize+4*i:]) {
+				pb.repaired = true
+			} else {
+				errMsg := fmt.Sprintf("Corrupted bitstream: %d damaged block(s) in a group with %d valid parity block(s)",
+					nbDamaged, this.validParity(damaged, m))
+				pb.err = NewIOError(errMsg, kanzi.ERR_CRC_CHECK)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (this *parityReader) validParity(damaged []bool, m int) int {
+	res := 0
+
+	for _, d := range damaged[m:] {
+		if d == false {
+			res++
+		}
+	}
+
+	return res
+}
+
+// Rebuild the damaged data blocks (erasures) with the valid parity blocks.
+// Nothing is done if there are not enough valid parity blocks.
+func (this *parityReader) repair(shards [][]byte, damaged []bool, m int) {
+	lost := make([]int, 0, m)
+
+	for i := 0; i < m; i++ {
+		if damaged[i] == true {
+			lost = append(lost, i)
+		}
+	}
+
+	rows := make([]int, 0, len(lost))
+
+	for j := 0; j < this.nbParity && len(rows) < len(lost); j++ {
+		if damaged[m+j] == false {
+			rows = append(rows, j)
+		}
+	}
+
+	if len(rows) < len(lost) {
+		return
+	}
+
+	// Remove the contribution of the valid data blocks from the parity blocks
+	e := len(lost)
+	syndromes := make([][]byte, e)
+
+	for r, j := range rows {
+		syndromes[r] = shards[m+j]
+
+		for i := 0; i < m; i++ {
+			if damaged[i] == false {
+				gfMulAdd(syndromes[r], shards[i], parityCoef(j, i, this.nbParity))
+			}
+		}
+	}
+
+	// Invert the e x e sub-matrix (Gauss-Jordan elimination)
+	a := make([][]byte, e)
+	inv := make([][]byte, e)
+
+	for r, j := range rows {
+		a[r] = make([]byte, e)
+		inv[r] = make([]byte, e)
+		inv[r][r] = 1
+
+		for c, i := range lost {
+			a[r][c] = parityCoef(j, i, this.nbParity)
+		}
+	}
+
+	for c := 0; c < e; c++ {
+		p := c
+
+		for p < e && a[p][c] == 0 {
+			p++
+		}
+
+		if p == e {
+			return
+		}
+
+		a[c], a[p] = a[p], a[c]
+		inv[c], inv[p] = inv[p], inv[c]
+		f := gfInv(a[c][c])
+
+		for k := 0; k < e; k++ {
+			a[c][k] = gfMul(a[c][k], f)
+			inv[c][k] = gfMul(inv[c][k], f)
+		}
+
+		for r := 0; r < e; r++ {
+			if r != c && a[r][c] != 0 {
+				f = a[r][c]
+
+				for k := 0; k < e; k++ {
+					a[r][k] ^= gfMul(a[c][k], f)
+					inv[r][k] ^= gfMul(inv[c][k], f)
+				}
+			}
+		}
+	}
+
+	for c, i := range lost {
+		for k := range shards[i] {
+			shards[i][k] = 0
+		}
+
+		for r := 0; r < e; r++ {
+			gfMulAdd(shards[i], syndromes[r], inv[c][r])
+		}
+	}
+}
+
+// Return the first block of the parity group of block n in the block index
+// (the blocks of a group share the position of the group)
+func parityGroupStart(index []blockIndexEntry, n int) int {
+	for n > 0 && index[n-1].offset == index[n].offset {
+		n--
+	}
+
+	return n
+}
@@ -0,0 +1,230 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	kanzi "github.com/flanglet/kanzi-go"
+	"github.com/flanglet/kanzi-go/bitstream"
+	"github.com/flanglet/kanzi-go/util"
+)
+
+// Collect the repaired block events
+type repairListener struct {
+	events []*kanzi.Event
+}
+
+func (this *repairListener) ProcessEvent(evt *kanzi.Event) {
+	if evt.Type() == kanzi.EVT_BLOCK_REPAIRED {
+		this.events = append(this.events, evt)
+	}
+}
+
+// Read n bits at a bit position of a buffer
+func readBitsAt(buf []byte, pos uint64, n uint) uint64 {
+	res := uint64(0)
+
+	for i := uint64(0); i < uint64(n); i++ {
+		bit := (buf[(pos+i)>>3] >> (7 - ((pos + i) & 7))) & 1
+		res = (res << 1) | uint64(bit)
+	}
+
+	return res
+}
+
+// Overwrite a few bytes in the middle of a block of a parity group located
+// at a bit position
+func damageGroupBlock(buf []byte, offset uint64, groupSize, nbParity, block int) {
+	headerSize := parityHeaderSize(groupSize, nbParity)
+	shardSize := readBitsAt(buf, offset+40, 32)
+	m := int(readBitsAt(buf, offset+32, 8))
+	pos := offset + uint64(16*headerSize)
+	var size uint64
+
+	for i := 0; i <= block; i++ {
+		if i < m {
+			size = readBitsAt(buf, offset+72+32*uint64(i), 32)
+		} else {
+			size = shardSize
+		}
+
+		if i < block {
+			pos += 8 * size
+		}
+	}
+
+	start := int(pos>>3) + int(size/2)
+
+	for i := start; i < start+16; i++ {
+		buf[i] ^= 0xA5
+	}
+}
+
+// Write blocks by groups with their parity blocks followed by an end block
+func writeParityGroups(t *testing.T, blocks [][]byte, groupSize, nbParity int) []byte {
+	t.Helper()
+	out := &testBuffer{}
+	obs, _ := bitstream.NewDefaultOutputBitStream(out, 16384)
+	pw, err := newParityWriter(groupSize, nbParity)
+
+	if err != nil {
+		t.Fatalf("Cannot create parity writer: %v", err)
+	}
+
+	for _, b := range blocks {
+		if err := pw.add(obs, b); err != nil {
+			t.Fatalf("Cannot write block: %v", err)
+		}
+	}
+
+	if err := pw.flush(obs); err != nil {
+		t.Fatalf("Cannot write group: %v", err)
+	}
+
+	obs.WriteBits(0, 32)
+	obs.Close()
+	return out.Bytes()
+}
+
+func TestParityCode(t *testing.T) {
+	const groupSize = 5
+	const nbParity = 3
+	rnd := rand.New(rand.NewSource(121))
+	blocks := make([][]byte, groupSize)
+
+	for i := range blocks {
+		blocks[i] = make([]byte, 500+rnd.Intn(1500))
+		rnd.Read(blocks[i])
+	}
+
+	stream := writeParityGroups(t, blocks, groupSize, nbParity)
+
+	// Up to nbParity damaged blocks (data or parity) can be repaired
+	for nbDamaged := 0; nbDamaged <= nbParity+1; nbDamaged++ {
+		for test := 0; test < 20; test++ {
+			damaged := append([]byte(nil), stream...)
+			lost := make(map[int]bool)
+
+			for _, idx := range rnd.Perm(groupSize + nbParity)[0:nbDamaged] {
+				damageGroupBlock(damaged, 0, groupSize, nbParity, idx)
+				lost[idx] = true
+			}
+
+			ibs, _ := bitstream.NewDefaultInputBitStream(util.NewBufferStream(damaged), 16384)
+			pr, err := newParityReader(groupSize, nbParity)
+
+			if err != nil {
+				t.Fatalf("Cannot create parity reader: %v", err)
+			}
+
+			for i := range blocks {
+				size, pb, err := pr.next(ibs, 1<<20)
+
+				if err != nil || pb == nil || size != uint(len(blocks[i])) {
+					t.Fatalf("%d damaged block(s), block %d: cannot read block: %v", nbDamaged, i, err)
+				}
+
+				if nbDamaged > nbParity && lost[i] == true {
+					if kanzi.ErrorCode(pb.err) != kanzi.ERR_CRC_CHECK {
+						t.Fatalf("%d damaged block(s), block %d: expected ERR_CRC_CHECK, got %v", nbDamaged, i, pb.err)
+					}
+
+					continue
+				}
+
+				if pb.err != nil || bytes.Equal(pb.data, blocks[i]) == false || pb.repaired != lost[i] {
+					t.Fatalf("%d damaged block(s), block %d: block not repaired: %v", nbDamaged, i, pb.err)
+				}
+			}
+
+			if size, pb, err := pr.next(ibs, 1<<20); size != 0 || pb != nil || err != nil {
+				t.Fatalf("%d damaged block(s): end block expected", nbDamaged)
+			}
+		}
+	}
+
+	// Both copies of the group header damaged
+	damaged := append([]byte(nil), stream...)
+	damaged[10] ^= 1
+	damaged[10+parityHeaderSize(groupSize, nbParity)] ^= 1
+	ibs, _ := bitstream.NewDefaultInputBitStream(util.NewBufferStream(damaged), 16384)
+	pr, _ := newParityReader(groupSize, nbParity)
+
+	if _, _, err := pr.next(ibs, 1<<20); kanzi.ErrorCode(err) != kanzi.ERR_CRC_CHECK {
+		t.Fatalf("Damaged headers: expected ERR_CRC_CHECK, got %v", err)
+	}
+}
+
+func TestParityRepair(t *testing.T) {
+	const groupSize = 4
+	const nbParity = 2
+	data := testData(10*TEST_RECOVERY_BLOCK_SIZE, 122)
+	stream := compressTest(t, data, Options{Level: 3, BlockSize: TEST_RECOVERY_BLOCK_SIZE, Checksum: true,
+		BlockIndex: true, ParityGroupSize: groupSize, ParityBlocks: nbParity})
+	index, err := readBlockIndex(newTestReader(stream), 0, BITSTREAM_FORMAT_VERSION, nil)
+
+	if err != nil {
+		t.Fatalf("Cannot read block index: %v", err)
+	}
+
+	// Groups of blocks 0-3, 4-7 and 8-9
+	damaged := append([]byte(nil), stream...)
+	damageGroupBlock(damaged, index[0].offset, groupSize, nbParity, 1)
+	damageGroupBlock(damaged, index[4].offset, groupSize, nbParity, 0)
+	damageGroupBlock(damaged, index[4].offset, groupSize, nbParity, 3)
+	damageGroupBlock(damaged, index[8].offset, groupSize, nbParity, 1)
+	damageGroupBlock(damaged, index[8].offset, groupSize, nbParity, 2) // parity block
+
+	for _, jobs := range []uint{1, 4} {
+		cis, err := NewCompressedInputStreamWithOptions(newTestReader(damaged), Options{Jobs: jobs})
+
+		if err != nil {
+			t.Fatalf("Cannot create compressed stream: %v", err)
+		}
+
+		listener := &repairListener{}
+		cis.AddListener(listener)
+		output := make([]byte, len(data))
+
+		if n, err := cis.Read(output); err != nil || n != len(data) || bytes.Equal(output, data) == false {
+			t.Fatalf("%d job(s): damaged blocks not repaired: %v", jobs, err)
+		}
+
+		if len(listener.events) != 4 {
+			t.Fatalf("%d job(s): expected 4 repaired blocks, got %d", jobs, len(listener.events))
+		}
+
+		// Random access in a group with damaged blocks
+		buf := make([]byte, 5000)
+		pos := 7*TEST_RECOVERY_BLOCK_SIZE - 1000
+
+		if n, err := cis.ReadAt(buf, int64(pos)); err != nil || n != len(buf) || bytes.Equal(buf, data[pos:pos+len(buf)]) == false {
+			t.Fatalf("%d job(s): ReadAt %d failed: %v", jobs, pos, err)
+		}
+
+		cis.Close()
+	}
+
+	// Too many damaged blocks in a group
+	damageGroupBlock(damaged, index[4].offset, groupSize, nbParity, 2)
+
+	if _, err = decompressTest(damaged, Options{}); kanzi.ErrorCode(err) != kanzi.ERR_CRC_CHECK {
+		t.Fatalf("Expected ERR_CRC_CHECK, got %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	kanzi "github.com/flanglet/kanzi-go"
+)
+
+// Collect the progress events
+type progressListener struct {
+	events []*kanzi.Event
+}
+
+func (this *progressListener) ProcessEvent(evt *kanzi.Event) {
+	if evt.Type() == kanzi.EVT_PROGRESS {
+		this.events = append(this.events, evt)
+	}
+}
+
+// Check that the progress events are cumulative and end with the final sizes
+func checkProgress(t *testing.T, name string, events []*kanzi.Event, input, output, total int64) {
+	t.Helper()
+
+	if len(events) == 0 {
+		t.Fatalf("%s: no progress event", name)
+	}
+
+	for i := 1; i < len(events); i++ {
+		if events[i].Size() < events[i-1].Size() || events[i].Output() < events[i-1].Output() {
+			t.Fatalf("%s: decreasing progress at event %d", name, i)
+		}
+	}
+
+	last := events[len(events)-1]
+
+	if last.Size() != input || last.Output() != output || last.Total() != total {
+		t.Fatalf("%s: expected input=%d, output=%d, total=%d, got %v", name, input, output, total, last)
+	}
+}
+
+func TestProgressEvents(t *testing.T) {
+	data := testData(300000, 131)
+
+	for _, jobs := range []uint{1, 4} {
+		for _, fileSize := range []int64{0, int64(len(data))} {
+			out := &testBuffer{}
+			cos, err := NewCompressedOutputStreamWithOptions(out, Options{Level: 2, BlockSize: 32 * 1024,
+				Jobs: jobs, FileSize: fileSize})
+
+			if err != nil {
+				t.Fatalf("Cannot create compressed stream: %v", err)
+			}
+
+			listener := &progressListener{}
+			cos.AddListener(listener)
+
+			for i := 0; i < len(data); i += 50000 {
+				end := i + 50000
+
+				if end > len(data) {
+					end = len(data)
+				}
+
+				if _, err = cos.Write(data[i:end]); err != nil {
+					t.Fatalf("%d job(s): write error: %v", jobs, err)
+				}
+			}
+
+			if err = cos.Close(); err != nil {
+				t.Fatalf("%d job(s): close error: %v", jobs, err)
+			}
+
+			// One event per batch of blocks (10 blocks)
+			if len(listener.events) < 10/int(jobs) {
+				t.Fatalf("%d job(s): expected at least %d events, got %d", jobs, 10/int(jobs), len(listener.events))
+			}
+
+			// The output does not include the end of the stream written by Close
+			last := listener.events[len(listener.events)-1]
+			checkProgress(t, "Compression", listener.events, int64(len(data)), last.Output(), fileSize)
+
+			if last.Output() == 0 || last.Output() > int64(out.Len()) {
+				t.Fatalf("%d job(s): invalid output size: %d (stream of %d bytes)", jobs, last.Output(), out.Len())
+			}
+
+			if fileSize > 0 && last.ETA() != 0 {
+				t.Fatalf("%d job(s): expected ETA 0 at the end, got %v", jobs, last.ETA())
+			}
+		}
+
+		// The size of a seekable input is known when decoding
+		compressed := compressTest(t, data, Options{Level: 2, BlockSize: 32 * 1024})
+		cis, err := NewCompressedInputStreamWithOptions(newTestReader(compressed), Options{Jobs: jobs})
+
+		if err != nil {
+			t.Fatalf("Cannot create compressed stream: %v", err)
+		}
+
+		listener := &progressListener{}
+		cis.AddListener(listener)
+		output := make([]byte, len(data))
+
+		if n, err := cis.Read(output); err != nil || n != len(data) || bytes.Equal(output, data) == false {
+			t.Fatalf("%d job(s): round trip failed: %v", jobs, err)
+		}
+
+		cis.Close()
+		last := listener.events[len(listener.events)-1]
+		checkProgress(t, "Decompression", listener.events, last.Size(), int64(len(data)), int64(len(compressed)))
+
+		if last.Size() == 0 || last.Size() > int64(len(compressed)) {
+			t.Fatalf("%d job(s): invalid input size: %d (stream of %d bytes)", jobs, last.Size(), len(compressed))
+		}
+	}
+}
+
+func TestProgressETA(t *testing.T) {
+	evt := kanzi.NewProgressEvent(0, 1000, 400, 4000, 2*time.Second, time.Time{})
+
+	if evt.Throughput() != 500 || evt.ETA() != 6*time.Second {
+		t.Fatalf("Invalid throughput or ETA: %v, %v", evt.Throughput(), evt.ETA())
+	}
+
+	// Unknown total or no time elapsed
+	if evt = kanzi.NewProgressEvent(0, 1000, 400, 0, time.Second, time.Time{}); evt.ETA() != -1 {
+		t.Fatalf("Expected ETA -1 for an unknown total, got %v", evt.ETA())
+	}
+
+	if evt = kanzi.NewProgressEvent(0, 0, 0, 4000, 0, time.Time{}); evt.Throughput() != 0 || evt.ETA() != -1 {
+		t.Fatalf("Expected no throughput and ETA -1, got %v, %v", evt.Throughput(), evt.ETA())
+	}
+}
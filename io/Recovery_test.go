@@ -0,0 +1,283 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"bytes"
+	"testing"
+
+	kanzi "github.com/flanglet/kanzi-go"
+)
+
+const (
+	TEST_RECOVERY_BLOCK_SIZE = 16 * 1024
+)
+
+// Collect the damaged block events
+type damageListener struct {
+	events []*kanzi.Event
+}
+
+func (this *damageListener) ProcessEvent(evt *kanzi.Event) {
+	if evt.Type() == kanzi.EVT_BLOCK_DAMAGED {
+		this.events = append(this.events, evt)
+	}
+}
+
+// Decompress a damaged stream in recovery mode
+func recoverTest(data []byte, jobs uint) ([]byte, *damageListener, error) {
+	cis, err := NewCompressedInputStreamWithOptions(newTestReader(data), Options{Jobs: jobs, Recover: true})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	listener := &damageListener{}
+	cis.AddListener(listener)
+	var res []byte
+	buf := make([]byte, 32768)
+
+	for {
+		n, err := cis.Read(buf)
+		res = append(res, buf[0:n]...)
+
+		if err != nil {
+			return res, listener, err
+		}
+
+		if n == 0 {
+			break
+		}
+	}
+
+	return res, listener, cis.Close()
+}
+
+// Overwrite a few bytes in the middle of a block (located with the block index)
+func damageBlock(t *testing.T, stream []byte, block int) []byte {
+	t.Helper()
+	index, err := readBlockIndex(newTestReader(stream), 0, BITSTREAM_FORMAT_VERSION, nil)
+
+	if err != nil || block+1 >= len(index) {
+		t.Fatalf("Cannot locate block %d: %v", block, err)
+	}
+
+	start := int(index[block].offset >> 3)
+	end := int(index[block+1].offset >> 3)
+	res := append([]byte(nil), stream...)
+
+	for i := start + (end-start)/2; i < start+(end-start)/2+16; i++ {
+		res[i] ^= 0x5A
+	}
+
+	return res
+}
+
+// Return the number of blocks of the output that match the original data
+func matchingBlocks(output, data []byte) int {
+	res := 0
+
+	for i := 0; i < len(data) && i < len(output); i += TEST_RECOVERY_BLOCK_SIZE {
+		end := i + TEST_RECOVERY_BLOCK_SIZE
+
+		if end > len(data) {
+			end = len(data)
+		}
+
+		if end <= len(output) && bytes.Equal(output[i:end], data[i:end]) == true {
+			res++
+		}
+	}
+
+	return res
+}
+
+func TestRecoveryWithIndex(t *testing.T) {
+	data := testData(12*TEST_RECOVERY_BLOCK_SIZE, 21)
+
+	for _, independent := range []bool{false, true} {
+		stream := compressTest(t, data, Options{Level: 3, BlockSize: TEST_RECOVERY_BLOCK_SIZE,
+			BlockIndex: true, IndependentBlocks: independent, Checksum: true})
+		damaged := damageBlock(t, stream, 4)
+
+		// Without recovery mode, the first damaged block is an error
+		if _, err := decompressTest(damaged, Options{}); err == nil {
+			t.Fatalf("Independent=%v: no error for a damaged stream", independent)
+		}
+
+		var output1 []byte
+
+		for _, jobs := range []uint{1, 4} {
+			output, listener, err := recoverTest(damaged, jobs)
+
+			if err != nil {
+				t.Fatalf("Independent=%v, %d job(s): recovery failed: %v", independent, jobs, err)
+			}
+
+			// The damaged block is zero filled, the other blocks are decoded
+			if len(output) != len(data) {
+				t.Fatalf("Independent=%v, %d job(s): invalid size: expected %d, got %d", independent,
+					jobs, len(data), len(output))
+			}
+
+			if n := matchingBlocks(output, data); n != 11 {
+				t.Fatalf("Independent=%v, %d job(s): expected 11 valid blocks, got %d", independent, jobs, n)
+			}
+
+			if bytes.Equal(output[4*TEST_RECOVERY_BLOCK_SIZE:5*TEST_RECOVERY_BLOCK_SIZE],
+				make([]byte, TEST_RECOVERY_BLOCK_SIZE)) == false {
+				t.Fatalf("Independent=%v, %d job(s): the damaged block is not zero filled", independent, jobs)
+			}
+
+			if len(listener.events) == 0 {
+				t.Fatalf("Independent=%v, %d job(s): no damaged block event", independent, jobs)
+			}
+
+			if evt := listener.events[0]; evt.Offset() != 4*TEST_RECOVERY_BLOCK_SIZE || evt.InputSize() <= 0 {
+				t.Fatalf("Independent=%v, %d job(s): invalid damaged block event: %v", independent, jobs, evt)
+			}
+
+			if output1 == nil {
+				output1 = output
+			} else if bytes.Equal(output, output1) == false {
+				t.Fatalf("Independent=%v: different outputs with 1 and %d jobs", independent, jobs)
+			}
+		}
+	}
+}
+
+func TestRecoveryMisaligned(t *testing.T) {
+	data := testData(12*TEST_RECOVERY_BLOCK_SIZE, 21)
+	stream := compressTest(t, data, Options{Level: 3, BlockSize: TEST_RECOVERY_BLOCK_SIZE, BlockIndex: true})
+	index, err := readBlockIndex(newTestReader(stream), 0, BITSTREAM_FORMAT_VERSION, nil)
+
+	if err != nil {
+		t.Fatalf("Cannot read block index: %v", err)
+	}
+
+	// Damage the mode of block 5: the block consumes the wrong number of bits
+	// and the header of the next block is read at the wrong position
+	damaged := append([]byte(nil), stream...)
+	damaged[index[4].offset>>3] ^= 0x33
+
+	for _, jobs := range []uint{1, 4} {
+		output, _, err := recoverTest(damaged, jobs)
+
+		if err != nil {
+			t.Fatalf("%d job(s): recovery failed: %v", jobs, err)
+		}
+
+		if len(output) != len(data) || matchingBlocks(output, data) != 11 {
+			t.Fatalf("%d job(s): expected 11 valid blocks, got %d (%d bytes)", jobs,
+				matchingBlocks(output, data), len(output))
+		}
+	}
+}
+
+func TestRecoveryWithoutIndex(t *testing.T) {
+	data := testData(12*TEST_RECOVERY_BLOCK_SIZE, 22)
+
+	// The block index is only used to locate the damaged block
+	indexed := compressTest(t, data, Options{Level: 3, BlockSize: TEST_RECOVERY_BLOCK_SIZE,
+		BlockIndex: true, IndependentBlocks: true, Checksum: true})
+	stream := compressTest(t, data, Options{Level: 3, BlockSize: TEST_RECOVERY_BLOCK_SIZE,
+		IndependentBlocks: true, Checksum: true})
+
+	// Same blocks in both streams (except for the header flags and the index)
+	index, err := readBlockIndex(newTestReader(indexed), 0, BITSTREAM_FORMAT_VERSION, nil)
+
+	if err != nil {
+		t.Fatalf("Cannot read block index: %v", err)
+	}
+
+	start := int(index[5].offset>>3) + 100
+	damaged := append([]byte(nil), stream...)
+
+	for i := start; i < start+16; i++ {
+		damaged[i] ^= 0x5A
+	}
+
+	for _, jobs := range []uint{1, 4} {
+		output, listener, err := recoverTest(damaged, jobs)
+
+		if err != nil {
+			t.Fatalf("%d job(s): recovery failed: %v", jobs, err)
+		}
+
+		// Without block index, the size of the damaged block is unknown: the
+		// block is skipped and the compressed range is reported
+		if len(output) != len(data)-TEST_RECOVERY_BLOCK_SIZE {
+			t.Fatalf("%d job(s): invalid size: expected %d, got %d", jobs, len(data)-TEST_RECOVERY_BLOCK_SIZE, len(output))
+		}
+
+		if bytes.Equal(output, append(append([]byte(nil), data[0:5*TEST_RECOVERY_BLOCK_SIZE]...),
+			data[6*TEST_RECOVERY_BLOCK_SIZE:]...)) == false {
+			t.Fatalf("%d job(s): invalid output", jobs)
+		}
+
+		if len(listener.events) != 1 {
+			t.Fatalf("%d job(s): expected 1 damaged block event, got %d", jobs, len(listener.events))
+		}
+
+		evt := listener.events[0]
+
+		if evt.Size() != 0 || evt.InputOffset() > int64(start) || evt.InputOffset()+evt.InputSize() <= int64(start+16) {
+			t.Fatalf("%d job(s): invalid damaged block event: %v", jobs, evt)
+		}
+	}
+}
+
+func TestRecoveryResyncFailure(t *testing.T) {
+	data := testData(12*TEST_RECOVERY_BLOCK_SIZE, 23)
+	indexed := compressTest(t, data, Options{Level: 3, BlockSize: TEST_RECOVERY_BLOCK_SIZE, BlockIndex: true})
+	stream := compressTest(t, data, Options{Level: 3, BlockSize: TEST_RECOVERY_BLOCK_SIZE})
+	index, err := readBlockIndex(newTestReader(indexed), 0, BITSTREAM_FORMAT_VERSION, nil)
+
+	if err != nil {
+		t.Fatalf("Cannot read block index: %v", err)
+	}
+
+	// Damage the header of block 6: the block cannot be decoded and there
+	// is no block index to resynchronize
+	damaged := append([]byte(nil), stream...)
+	pos := int(index[5].offset >> 3)
+
+	for i := pos; i < pos+8; i++ {
+		damaged[i] ^= 0xFF
+	}
+
+	var output1 []byte
+
+	for _, jobs := range []uint{1, 4} {
+		output, _, err := recoverTest(damaged, jobs)
+
+		if err == nil {
+			t.Fatalf("%d job(s): no error for a damaged stream without block index", jobs)
+		}
+
+		// The blocks decoded before the damaged one are returned first
+		if len(output) < 4*TEST_RECOVERY_BLOCK_SIZE || bytes.Equal(output[0:4*TEST_RECOVERY_BLOCK_SIZE],
+			data[0:4*TEST_RECOVERY_BLOCK_SIZE]) == false {
+			t.Fatalf("%d job(s): the blocks before the damaged one are missing (%d bytes)", jobs, len(output))
+		}
+
+		if output1 == nil {
+			output1 = output
+		} else if len(output) != len(output1) {
+			t.Fatalf("Different outputs with 1 and %d jobs: %d and %d bytes", jobs, len(output1), len(output))
+		}
+	}
+}
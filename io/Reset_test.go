@@ -0,0 +1,130 @@
+/*
+Copyright 2011-2017 Frederic Langlet
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+you may obtain a copy of the License at
+
+                http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package io
+
+import (
+	"bytes"
+	"testing"
+
+	kanzi "github.com/flanglet/kanzi-go"
+)
+
+func TestOutputStreamReset(t *testing.T) {
+	inputs := [][]byte{testData(100000, 91), nil, testData(10, 92), testData(250000, 93)}
+
+	for _, level := range []int{2, 6, 7} {
+		opts := Options{Level: level, BlockSize: 64 * 1024, Jobs: 2, Checksum: true}
+		out := &testBuffer{}
+		cos, err := NewCompressedOutputStreamWithOptions(out, opts)
+
+		if err != nil {
+			t.Fatalf("Cannot create compressed stream: %v", err)
+		}
+
+		for i, data := range inputs {
+			if i > 0 {
+				out = &testBuffer{}
+
+				if err = cos.Reset(out); err != nil {
+					t.Fatalf("Level %d, input %d: reset error: %v", level, i, err)
+				}
+			}
+
+			if _, err = cos.Write(data); err != nil {
+				t.Fatalf("Level %d, input %d: write error: %v", level, i, err)
+			}
+
+			if err = cos.Close(); err != nil {
+				t.Fatalf("Level %d, input %d: close error: %v", level, i, err)
+			}
+
+			// The models are reset between streams: same output as a new stream
+			if bytes.Equal(out.Bytes(), compressTest(t, data, opts)) == false {
+				t.Fatalf("Level %d, input %d: the output differs from the output of a new stream", level, i)
+			}
+		}
+
+		// Pending data is discarded by Reset
+		cos.Write(inputs[0])
+		out = &testBuffer{}
+		cos.Reset(out)
+		cos.Write(inputs[2])
+		cos.Close()
+
+		if output, err := decompressTest(out.Bytes(), Options{}); err != nil || bytes.Equal(output, inputs[2]) == false {
+			t.Fatalf("Level %d: pending data not discarded: %v", level, err)
+		}
+
+		if err = cos.Reset(nil); kanzi.ErrorCode(err) != kanzi.ERR_CREATE_STREAM {
+			t.Fatalf("Level %d: nil writer: expected ERR_CREATE_STREAM, got %v", level, err)
+		}
+
+		// Unusable until the next successful reset
+		if _, err = cos.Write(inputs[0]); kanzi.ErrorCode(err) != kanzi.ERR_WRITE_FILE {
+			t.Fatalf("Level %d: expected ERR_WRITE_FILE, got %v", level, err)
+		}
+	}
+}
+
+func TestInputStreamReset(t *testing.T) {
+	data := [][]byte{testData(150000, 94), testData(80000, 95), testData(5000, 96), testData(120000, 97)}
+	streams := [][]byte{
+		compressTest(t, data[0], Options{Level: 7, BlockSize: 32 * 1024}),
+		compressTest(t, data[1], Options{Level: 3, BlockSize: 64 * 1024, Checksum: true}),
+		compressTest(t, data[2], Options{Level: 0}),
+		compressTest(t, data[3], Options{Level: 7, BlockSize: 64 * 1024, IndependentBlocks: true}),
+	}
+
+	for _, jobs := range []uint{1, 4} {
+		cis, err := NewCompressedInputStreamWithOptions(newTestReader(nil), Options{Jobs: jobs})
+
+		if err != nil {
+			t.Fatalf("Cannot create compressed stream: %v", err)
+		}
+
+		// Streams with different transforms and codecs, with a corrupted
+		// stream in the middle
+		for i := range streams {
+			corrupted := append([]byte(nil), streams[i]...)
+			corrupted[len(corrupted)/2] ^= 0x11
+
+			for j, buf := range [][]byte{corrupted, streams[i]} {
+				if err = cis.Reset(newTestReader(buf)); err != nil {
+					t.Fatalf("%d job(s), stream %d: reset error: %v", jobs, i, err)
+				}
+
+				output := make([]byte, 0, len(data[i]))
+				chunk := make([]byte, 20000)
+
+				for {
+					n, err := cis.Read(chunk)
+					output = append(output, chunk[0:n]...)
+
+					if err != nil || n == 0 {
+						break
+					}
+				}
+
+				// The stream decoded after a failure is valid
+				if j == 1 && bytes.Equal(output, data[i]) == false {
+					t.Fatalf("%d job(s), stream %d: round trip failed after reset", jobs, i)
+				}
+			}
+		}
+
+		cis.Close()
+	}
+}
@@ -25,6 +25,11 @@ type BufferStream struct {
 	closed bool
 }
 
+// Create a stream that reads the provided bytes first
+func NewBufferStream(buf []byte) *BufferStream {
+	return &BufferStream{buf: *bytes.NewBuffer(buf)}
+}
+
 func (this *BufferStream) Write(b []byte) (int, error) {
 	if this.closed {
 		return 0, errors.New("Stream closed")
@@ -49,3 +54,8 @@ func (this *BufferStream) Close() error {
 func (this *BufferStream) Len() int {
 	return this.buf.Len()
 }
+
+// Return the unread bytes (not a copy)
+func (this *BufferStream) Bytes() []byte {
+	return this.buf.Bytes()
+}